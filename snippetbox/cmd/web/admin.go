@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"snippetbox/internal/models"
+	"snippetbox/internal/validator"
+	"strings"
+)
+
+// adminUsersPerPage is the number of users shown on each page of the admin
+// user list.
+const adminUsersPerPage = 50
+
+// The bootstrapAdmin() method gives the user with the given email address the
+// admin role. It's called on startup when the -admin-email flag is set, so
+// that the first admin can be created without touching the database by hand.
+func (app *application) bootstrapAdmin(email string) error {
+	user, err := app.users.GetByEmail(context.Background(), email)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return fmt.Errorf("no user with email %q to make admin", email)
+		}
+		return err
+	}
+	if user.Role == models.RoleAdmin {
+		return nil
+	}
+	err = app.users.SetRole(context.Background(), user.ID, models.RoleAdmin)
+	if err != nil {
+		return err
+	}
+	app.logger.Info("promoted user to admin", "user_id", user.ID)
+	return nil
+}
+
+// The createAdmin() function creates a new user with the admin role, for the
+// -create-admin command. Any of the name, username, email address and password
+// which weren't given as flags are asked for, reading the answers from in and
+// writing the questions to out. The user is checked and inserted in exactly
+// the same way as somebody signing up, so the password is hashed by
+// UserModel.Insert() as usual, but they're activated straight away rather than
+// being sent an activation email. It returns the new user's ID.
+func createAdmin(ctx context.Context, users models.UserModelInterface, form userSignupForm, in io.Reader, out io.Writer) (int, error) {
+	scanner := bufio.NewScanner(in)
+	fields := []struct {
+		prompt string
+		value  *string
+	}{
+		{"Name", &form.Name},
+		{"Username", &form.Username},
+		{"Email", &form.Email},
+		{"Password", &form.Password},
+	}
+	for _, field := range fields {
+		if *field.value != "" {
+			continue
+		}
+		fmt.Fprintf(out, "%s: ", field.prompt)
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				return 0, err
+			}
+			return 0, fmt.Errorf("no %s given for the admin user", strings.ToLower(field.prompt))
+		}
+		*field.value = scanner.Text()
+	}
+	// Tidy the fields up like the form decoder does for the signup form.
+	form.Name = strings.TrimSpace(form.Name)
+	form.Username = strings.ToLower(strings.TrimSpace(form.Username))
+	form.Email = strings.ToLower(strings.TrimSpace(form.Email))
+
+	form.validate()
+	if !form.Valid() {
+		var problems []string
+		for _, key := range slices.Sorted(maps.Keys(form.FieldErrors)) {
+			problems = append(problems, key+": "+form.FieldErrors[key])
+		}
+		return 0, fmt.Errorf("invalid admin user: %s", strings.Join(problems, "; "))
+	}
+
+	token, err := users.Insert(ctx, form.Name, form.Username, form.Email, form.Password)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrDuplicateEmail):
+			return 0, fmt.Errorf("email address %q is already in use", form.Email)
+		case errors.Is(err, models.ErrDuplicateUsername):
+			return 0, fmt.Errorf("username %q is already taken", form.Username)
+		default:
+			return 0, err
+		}
+	}
+	err = users.Activate(ctx, token)
+	if err != nil {
+		return 0, err
+	}
+	user, err := users.GetByEmail(ctx, form.Email)
+	if err != nil {
+		return 0, err
+	}
+	err = users.SetRole(ctx, user.ID, models.RoleAdmin)
+	if err != nil {
+		return 0, err
+	}
+	return user.ID, nil
+}
+
+// The adminStats() helper adds the user and snippet counts, and whether
+// maintenance mode is on, shown on the admin dashboard to the template data.
+func (app *application) adminStats(ctx context.Context, data *templateData) error {
+	data.Maintenance = app.maintenance.Load()
+	var err error
+	data.UserCount, err = app.users.Count(ctx)
+	if err != nil {
+		return err
+	}
+	data.SnippetCount, err = app.snippets.Count(ctx)
+	return err
+}
+
+func (app *application) adminDashboard(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	err := app.adminStats(r.Context(), data)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	data.Form = adminSnippetDeleteForm{}
+	app.render(w, r, http.StatusOK, "admin.html", data)
+}
+
+// The adminMaintenancePost() handler turns maintenance mode on or off. Admins
+// are let through while it's on, so they can still reach this to turn it off
+// again.
+func (app *application) adminMaintenancePost(w http.ResponseWriter, r *http.Request) {
+	enable := !app.maintenance.Load()
+	app.setMaintenance(enable)
+	if enable {
+		app.addFlash(r, flashWarning, "Maintenance mode is on. Only admins can use the site.")
+	} else {
+		app.addFlash(r, flashSuccess, "Maintenance mode is off.")
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+func (app *application) adminUsers(w http.ResponseWriter, r *http.Request) {
+	page, err := app.readPageParam(r)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+	total, err := app.users.Count(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	pagination := newPagination(total, page, adminUsersPerPage).withURL(r.URL)
+	users, err := app.users.All(r.Context(), pagination.offset(), adminUsersPerPage)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	data := app.newTemplateData(r)
+	data.Users = users
+	data.Pagination = &pagination
+	app.render(w, r, http.StatusOK, "admin_users.html", data)
+}
+
+func (app *application) adminUserDeactivatePost(w http.ResponseWriter, r *http.Request) {
+	app.adminSetActivated(w, r, false)
+}
+
+func (app *application) adminUserActivatePost(w http.ResponseWriter, r *http.Request) {
+	app.adminSetActivated(w, r, true)
+}
+
+// The adminSetActivated() helper activates or deactivates the user with the id
+// given in the URL. Admins can't deactivate themselves, otherwise they could
+// lock out the last admin by accident.
+func (app *application) adminSetActivated(w http.ResponseWriter, r *http.Request, active bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+	if id == app.sessionManager.GetInt(r.Context(), "authenticatedUserID") {
+		app.addFlash(r, flashWarning, "You can't change the status of your own account.")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+	user, err := app.users.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	err = app.users.SetActivated(r.Context(), user.ID, active)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if active {
+		app.addFlash(r, flashSuccess, fmt.Sprintf("%s has been activated.", user.Name))
+	} else {
+		app.addFlash(r, flashSuccess, fmt.Sprintf("%s has been deactivated.", user.Name))
+	}
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// adminSnippetDeleteForm is the form on the admin dashboard for deleting any
+// snippet by its ID.
+type adminSnippetDeleteForm struct {
+	ID                  int `form:"id"`
+	validator.Validator `form:"-"`
+}
+
+func (app *application) adminSnippetDeletePost(w http.ResponseWriter, r *http.Request) {
+	var form adminSnippetDeleteForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	form.CheckField(form.ID > 0, "id", "This field must be a snippet ID")
+	if form.Valid() {
+		// Like the owner's delete, this is a soft-delete, so the snippet can
+		// still be restored from its owner's trash.
+		err = app.snippets.Delete(r.Context(), form.ID)
+		if err != nil {
+			if !errors.Is(err, models.ErrNoRecord) {
+				app.serverError(w, r, err)
+				return
+			}
+			form.AddFieldError("id", "There is no snippet with this ID")
+		}
+	}
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		err = app.adminStats(r.Context(), data)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "admin.html", data)
+		return
+	}
+	app.logger.InfoContext(r.Context(), "admin deleted snippet", "snippet_id", form.ID)
+	app.addFlash(r, flashSuccess, fmt.Sprintf("Snippet #%d has been deleted.", form.ID))
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+func (app *application) adminReports(w http.ResponseWriter, r *http.Request) {
+	reports, err := app.reports.Open(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	data := app.newTemplateData(r)
+	data.Reports = reports
+	app.render(w, r, http.StatusOK, "admin_reports.html", data)
+}
+
+func (app *application) adminReportResolvePost(w http.ResponseWriter, r *http.Request) {
+	app.adminSetReportStatus(w, r, models.ReportResolved)
+}
+
+func (app *application) adminReportDismissPost(w http.ResponseWriter, r *http.Request) {
+	app.adminSetReportStatus(w, r, models.ReportDismissed)
+}
+
+// The adminSetReportStatus() helper closes the report with the id given in the
+// URL. Resolving a report doesn't do anything to the snippet itself; the admin
+// deletes it (or not) separately, so that a mistaken click can't remove it.
+func (app *application) adminSetReportStatus(w http.ResponseWriter, r *http.Request, status string) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+	err = app.reports.SetStatus(r.Context(), id, status)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	app.logger.InfoContext(r.Context(), "admin closed report", "report_id", id, "status", status)
+	app.addFlash(r, flashSuccess, fmt.Sprintf("Report #%d has been %s.", id, status))
+	http.Redirect(w, r, "/admin/reports", http.StatusSeeOther)
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"snippetbox/internal/assert"
+	"snippetbox/internal/models"
+	"snippetbox/internal/models/mocks"
+)
+
+func TestAdminForbidden(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Anonymous", func(t *testing.T) {
+		code, header, _ := ts.get(t, "/admin")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/login")
+	})
+
+	csrfToken := ts.login(t)
+
+	for _, urlPath := range []string{"/admin", "/admin/users", "/admin/reports"} {
+		t.Run("GET "+urlPath, func(t *testing.T) {
+			code, _, _ := ts.get(t, urlPath)
+			assert.Equal(t, code, http.StatusForbidden)
+		})
+	}
+
+	for _, urlPath := range []string{"/admin/users/deactivate/9", "/admin/users/activate/9", "/admin/snippets/delete", "/admin/reports/resolve/1", "/admin/reports/dismiss/1", "/admin/maintenance"} {
+		t.Run("POST "+urlPath, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("id", "3")
+			form.Add("csrf_token", csrfToken)
+			code, _, _ := ts.postForm(t, urlPath, form)
+			assert.Equal(t, code, http.StatusForbidden)
+		})
+	}
+
+	// Normal users don't get a link to the dashboard either.
+	_, _, body := ts.get(t, "/account/view")
+	if strings.Contains(body, "/admin") {
+		t.Error("account page links to the admin dashboard")
+	}
+}
+
+func TestAdminDashboard(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	csrfToken := ts.loginAs(t, "admin@example.com")
+
+	code, _, body := ts.get(t, "/admin")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "<td>2 (<a href='/admin/users'>manage</a>)</td>")
+	assert.StringContains(t, body, "<td>5</td>")
+
+	_, _, body = ts.get(t, "/account/view")
+	assert.StringContains(t, body, `<a href="/admin">Admin dashboard</a>`)
+
+	tests := []struct {
+		name      string
+		id        string
+		wantCode  int
+		wantFlash string
+		wantBody  string
+	}{
+		{
+			name:      "Valid",
+			id:        "3",
+			wantCode:  http.StatusSeeOther,
+			wantFlash: "Snippet #3 has been deleted.",
+		},
+		{
+			name:     "Missing snippet",
+			id:       "99",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "There is no snippet with this ID",
+		},
+		{
+			name:     "Zero",
+			id:       "0",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field must be a snippet ID",
+		},
+		{
+			name:     "Not a number",
+			id:       "abc",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("id", tt.id)
+			form.Add("csrf_token", csrfToken)
+			code, _, body := ts.postForm(t, "/admin/snippets/delete", form)
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+			if tt.wantFlash != "" {
+				_, _, body = ts.get(t, "/admin")
+				assert.StringContains(t, body, tt.wantFlash)
+			}
+		})
+	}
+}
+
+func TestAdminUsers(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	csrfToken := ts.loginAs(t, "admin@example.com")
+
+	code, _, body := ts.get(t, "/admin/users")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "alice@example.com")
+	assert.StringContains(t, body, "<form action='/admin/users/deactivate/1' method='POST'>")
+	// Admins can't deactivate themselves.
+	if strings.Contains(body, "/admin/users/deactivate/9") {
+		t.Error("admin can deactivate their own account")
+	}
+	if strings.Contains(body, "rel='next'") {
+		t.Error("next page link shown on the last page")
+	}
+
+	t.Run("Bad page", func(t *testing.T) {
+		code, _, _ := ts.get(t, "/admin/users?page=0")
+		assert.Equal(t, code, http.StatusBadRequest)
+	})
+
+	tests := []struct {
+		name      string
+		urlPath   string
+		wantCode  int
+		wantFlash string
+	}{
+		{
+			name:      "Deactivate",
+			urlPath:   "/admin/users/deactivate/1",
+			wantCode:  http.StatusSeeOther,
+			wantFlash: "Alice has been deactivated.",
+		},
+		{
+			name:      "Activate",
+			urlPath:   "/admin/users/activate/1",
+			wantCode:  http.StatusSeeOther,
+			wantFlash: "Alice has been activated.",
+		},
+		{
+			name:      "Self",
+			urlPath:   "/admin/users/deactivate/9",
+			wantCode:  http.StatusSeeOther,
+			wantFlash: "You can&#39;t change the status of your own account.",
+		},
+		{
+			name:     "Missing user",
+			urlPath:  "/admin/users/deactivate/99",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("csrf_token", csrfToken)
+			code, _, _ := ts.postForm(t, tt.urlPath, form)
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantFlash != "" {
+				_, _, body := ts.get(t, "/admin/users")
+				assert.StringContains(t, body, tt.wantFlash)
+			}
+		})
+	}
+}
+
+func TestAdminReports(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	csrfToken := ts.loginAs(t, "admin@example.com")
+
+	code, _, body := ts.get(t, "/admin/reports")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "<a href='/snippet/view/3'>#3 Over the wintry</a>")
+	assert.StringContains(t, body, "<td>spam: Posted over and over again</td>")
+	assert.StringContains(t, body, "<form action='/admin/reports/resolve/1' method='POST'>")
+
+	tests := []struct {
+		name      string
+		urlPath   string
+		wantCode  int
+		wantFlash string
+	}{
+		{
+			name:      "Resolve",
+			urlPath:   "/admin/reports/resolve/1",
+			wantCode:  http.StatusSeeOther,
+			wantFlash: "Report #1 has been resolved.",
+		},
+		{
+			name:      "Dismiss",
+			urlPath:   "/admin/reports/dismiss/1",
+			wantCode:  http.StatusSeeOther,
+			wantFlash: "Report #1 has been dismissed.",
+		},
+		{
+			name:     "Missing report",
+			urlPath:  "/admin/reports/resolve/99",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("csrf_token", csrfToken)
+			code, _, _ := ts.postForm(t, tt.urlPath, form)
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantFlash != "" {
+				_, _, body := ts.get(t, "/admin/reports")
+				assert.StringContains(t, body, tt.wantFlash)
+			}
+		})
+	}
+}
+
+func TestBootstrapAdmin(t *testing.T) {
+	app := newTestApplication(t)
+
+	assert.NilError(t, app.bootstrapAdmin("alice@example.com"))
+	assert.NilError(t, app.bootstrapAdmin("admin@example.com"))
+
+	err := app.bootstrapAdmin("nobody@example.com")
+	if err == nil {
+		t.Error("expected an error for an unknown email address")
+	}
+}
+
+// adminUserModel is a mock UserModel which keeps the users inserted into it,
+// so that the createAdmin() test can check the row which was written.
+type adminUserModel struct {
+	mocks.UserModel
+	users map[string]*models.User
+}
+
+func (m *adminUserModel) Insert(ctx context.Context, name, username, email, password string) (string, error) {
+	token, err := m.UserModel.Insert(ctx, name, username, email, password)
+	if err != nil {
+		return "", err
+	}
+	m.users[email] = &models.User{ID: len(m.users) + 100, Name: name, Username: username, Email: email, Role: models.RoleUser}
+	return token, nil
+}
+
+func (m *adminUserModel) Activate(ctx context.Context, token string) error {
+	err := m.UserModel.Activate(ctx, token)
+	if err != nil {
+		return err
+	}
+	for _, user := range m.users {
+		user.Activated = true
+	}
+	return nil
+}
+
+func (m *adminUserModel) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	if user, ok := m.users[email]; ok {
+		return user, nil
+	}
+	return m.UserModel.GetByEmail(ctx, email)
+}
+
+func (m *adminUserModel) SetRole(ctx context.Context, userID int, role string) error {
+	for _, user := range m.users {
+		if user.ID == userID {
+			user.Role = role
+		}
+	}
+	return nil
+}
+
+func TestCreateAdmin(t *testing.T) {
+	form := userSignupForm{
+		Name:     "Erin",
+		Username: "erin",
+		Email:    "erin@example.com",
+		Password: "validPa$$w0rd",
+	}
+
+	t.Run("From flags", func(t *testing.T) {
+		users := &adminUserModel{users: map[string]*models.User{}}
+		var out strings.Builder
+		id, err := createAdmin(context.Background(), users, form, strings.NewReader(""), &out)
+		assert.NilError(t, err)
+
+		user := users.users["erin@example.com"]
+		if user == nil {
+			t.Fatal("expected a user to be inserted")
+		}
+		assert.Equal(t, id, user.ID)
+		assert.Equal(t, user.Role, models.RoleAdmin)
+		assert.Equal(t, user.Activated, true)
+		assert.Equal(t, out.String(), "")
+	})
+
+	t.Run("Prompts for missing fields", func(t *testing.T) {
+		users := &adminUserModel{users: map[string]*models.User{}}
+		var out strings.Builder
+		in := strings.NewReader("  Erin  \nErin\nvalidPa$$w0rd\n")
+		_, err := createAdmin(context.Background(), users, userSignupForm{Email: "Erin@Example.com"}, in, &out)
+		assert.NilError(t, err)
+
+		user := users.users["erin@example.com"]
+		if user == nil {
+			t.Fatal("expected a user to be inserted")
+		}
+		assert.Equal(t, user.Name, "Erin")
+		assert.Equal(t, user.Username, "erin")
+		assert.Equal(t, user.Role, models.RoleAdmin)
+		assert.Equal(t, out.String(), "Name: Username: Password: ")
+	})
+
+	tests := []struct {
+		name    string
+		form    userSignupForm
+		in      string
+		wantErr string
+	}{
+		{
+			name:    "Invalid email",
+			form:    userSignupForm{Name: "Erin", Username: "erin", Email: "erin", Password: form.Password},
+			wantErr: "email: This field must be a valid email address",
+		},
+		{
+			name:    "Weak password",
+			form:    userSignupForm{Name: "Erin", Username: "erin", Email: form.Email, Password: "password"},
+			wantErr: "password: This field must contain",
+		},
+		{
+			name:    "Duplicate email",
+			form:    userSignupForm{Name: "Erin", Username: "erin", Email: "dupe@example.com", Password: form.Password},
+			wantErr: "is already in use",
+		},
+		{
+			name:    "Duplicate username",
+			form:    userSignupForm{Name: "Alice", Username: "alice", Email: form.Email, Password: form.Password},
+			wantErr: "is already taken",
+		},
+		{
+			name:    "No answer",
+			form:    userSignupForm{Name: "Erin", Username: "erin", Email: form.Email},
+			wantErr: "no password given",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := &adminUserModel{users: map[string]*models.User{}}
+			_, err := createAdmin(context.Background(), users, tt.form, strings.NewReader(tt.in), io.Discard)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			assert.StringContains(t, err.Error(), tt.wantErr)
+			assert.Equal(t, len(users.users), 0)
+		})
+	}
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"snippetbox/internal/models"
+	"snippetbox/internal/validator"
+	"strconv"
+	"strings"
+)
+
+// Define an envelope type. We wrap all of our JSON responses in a top-level
+// object, like {"token": "..."}, which makes the responses self-documenting
+// and leaves room to add other keys later.
+type envelope map[string]any
+
+// The writeJSON() helper encodes data to JSON and sends it with the given
+// status code and any additional headers.
+func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
+	js, err := json.MarshalIndent(data, "", "\t")
+	if err != nil {
+		return err
+	}
+	js = append(js, '\n')
+	for key, value := range headers {
+		w.Header()[key] = value
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(js)
+	return nil
+}
+
+// The readJSON() helper decodes a JSON request body into dst. Unknown fields
+// are rejected, and the body must contain only a single JSON value. The size of
+// the body is limited by the limitBody middleware; if it's too big then
+// errBodyTooLarge is returned.
+func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(dst)
+	if err != nil {
+		var syntaxError *json.SyntaxError
+		var unmarshalTypeError *json.UnmarshalTypeError
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &syntaxError):
+			return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			return errors.New("body contains badly-formed JSON")
+		case errors.As(err, &unmarshalTypeError):
+			return fmt.Errorf("body contains incorrect JSON type for field %q", unmarshalTypeError.Field)
+		case errors.Is(err, io.EOF):
+			return errors.New("body must not be empty")
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
+			return fmt.Errorf("body contains unknown key %s", fieldName)
+		case errors.As(err, &maxBytesError):
+			return errBodyTooLarge
+		default:
+			return err
+		}
+	}
+	err = dec.Decode(&struct{}{})
+	if !errors.Is(err, io.EOF) {
+		return errors.New("body must only contain a single JSON value")
+	}
+	return nil
+}
+
+// The apiError() helper sends a JSON-formatted error message with the given
+// status code. If the response can't be written we log the error and fall
+// back to sending an empty 500 response.
+func (app *application) apiError(w http.ResponseWriter, status int, message any) {
+	err := app.writeJSON(w, status, envelope{"error": message}, nil)
+	if err != nil {
+		app.logger.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// The apiBadRequest() helper sends a 400 Bad Request response with the error
+// from readJSON(), or a 413 Request Entity Too Large response if the body was
+// too big.
+func (app *application) apiBadRequest(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errBodyTooLarge) {
+		app.bodyTooLarge(w, r)
+		return
+	}
+	app.apiError(w, http.StatusBadRequest, err.Error())
+}
+
+// The apiServerError() helper logs the error in the same way as serverError()
+// but sends a JSON response rather than plain text.
+func (app *application) apiServerError(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.ErrorContext(r.Context(), err.Error(), "method", r.Method, "uri", r.URL.RequestURI())
+	app.apiError(w, http.StatusInternalServerError, "the server encountered a problem and could not process your request")
+}
+
+// The apiInvalidToken() helper sends a 401 response with a WWW-Authenticate
+// header, letting the client know that it should send a bearer token.
+func (app *application) apiInvalidToken(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	app.apiError(w, http.StatusUnauthorized, "invalid or missing authentication token")
+}
+
+// apiTokenCreate mints a new API bearer token for the user with the given
+// email address and password. Failed attempts count against the same login
+// limiter as the HTML login form, so the API can't be used to get around it.
+// Users who have turned on two-factor authentication must also send a code
+// from their authenticator app (or a recovery code) as totp_code, which is
+// checked and limited in the same way as on the web.
+func (app *application) apiTokenCreate(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+		TOTPCode string `json:"totp_code"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.apiBadRequest(w, r, err)
+		return
+	}
+
+	var v validator.Validator
+	v.CheckField(validator.NotBlank(input.Email), "email", "must be provided")
+	v.CheckField(validator.Matches(input.Email, validator.EmailRX), "email", "must be a valid email address")
+	v.CheckField(validator.NotBlank(input.Password), "password", "must be provided")
+	if !v.Valid() {
+		app.apiError(w, http.StatusUnprocessableEntity, v.FieldErrors)
+		return
+	}
+
+	ipKey, accountKey := "ip:"+app.clientIP(r), "email:"+strings.ToLower(input.Email)
+	if app.loginLimiter.blocked(ipKey, accountKey) {
+		app.apiError(w, http.StatusTooManyRequests, "too many failed login attempts, please try again later")
+		return
+	}
+
+	id, err := app.users.Authenticate(r.Context(), input.Email, input.Password)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrInvalidCredentials):
+			app.loginLimiter.fail(ipKey, accountKey)
+			app.apiError(w, http.StatusUnauthorized, "invalid authentication credentials")
+		case errors.Is(err, models.ErrAccountNotActivated):
+			app.apiError(w, http.StatusForbidden, "your account must be activated to access this resource")
+		default:
+			app.apiServerError(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.users.Get(r.Context(), id)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+	if user.TOTPEnabled {
+		if strings.TrimSpace(input.TOTPCode) == "" {
+			app.apiError(w, http.StatusForbidden, "two-factor authentication required")
+			return
+		}
+		limiterKey := "2fa:" + strconv.Itoa(id)
+		if app.loginLimiter.blocked(limiterKey) {
+			app.apiError(w, http.StatusTooManyRequests, "too many failed login attempts, please try again later")
+			return
+		}
+		ok, err := app.checkSecondFactor(r.Context(), id, input.TOTPCode)
+		if err != nil {
+			app.apiServerError(w, r, err)
+			return
+		}
+		if !ok {
+			app.loginLimiter.fail(limiterKey)
+			app.apiError(w, http.StatusUnauthorized, "invalid two-factor authentication code")
+			return
+		}
+		app.loginLimiter.reset(limiterKey)
+	}
+	app.loginLimiter.reset(accountKey)
+
+	token, err := app.users.CreateAPIToken(r.Context(), id)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusCreated, envelope{"token": token}, nil)
+	if err != nil {
+		app.apiServerError(w, r, err)
+	}
+}
+
+// apiAccountView returns the details of the user that the bearer token
+// belongs to.
+func (app *application) apiAccountView(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	data := envelope{"user": map[string]any{
+		"id":      user.ID,
+		"name":    user.Name,
+		"email":   user.Email,
+		"created": user.Created,
+	}}
+	err := app.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		app.apiServerError(w, r, err)
+	}
+}
+
+// apiSnippetContent returns just the content of a snippet, for the copy
+// button on the snippet page to put on the clipboard. Fetching it when the
+// button is clicked means that we don't have to send big snippets twice, once
+// highlighted and once as they are. The page's script sends the session
+// cookie with the request, so this follows the same rules as snippetViewRaw()
+// about who can see which snippets. Errors are sent as JSON by the usual
+// helpers, as the path is under /api/.
+func (app *application) apiSnippetContent(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+	viewerID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	snippet, err := app.snippets.Get(r.Context(), id, viewerID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"content": snippet.Content}, nil)
+	if err != nil {
+		app.apiServerError(w, r, err)
+	}
+}
+
+// maxBulkSnippets is the most snippets which can be created with one request
+// to apiSnippetBulkCreate.
+const maxBulkSnippets = 100
+
+// bulkSnippetInput is one snippet in the body of a bulk create request.
+type bulkSnippetInput struct {
+	Title   string `json:"title"`
+	Content string `json:"content"`
+	// Expires is the number of days until the snippet expires, or 0 for a
+	// snippet which never expires. If it's left out the snippet expires in a
+	// year, like the default option on the create form.
+	Expires    *int     `json:"expires"`
+	Tags       []string `json:"tags"`
+	Visibility string   `json:"visibility"`
+	Language   string   `json:"language"`
+}
+
+// The form() method converts the input into a snippetCreateForm, so that it
+// can be checked by exactly the same rules as snippets created in the browser.
+// The visibility defaults to public.
+func (input bulkSnippetInput) form() snippetCreateForm {
+	form := snippetCreateForm{
+		Title:      strings.TrimSpace(input.Title),
+		Content:    input.Content,
+		Expires:    "365",
+		Tags:       strings.Join(input.Tags, ","),
+		Visibility: cmp.Or(input.Visibility, models.VisibilityPublic),
+		Language:   input.Language,
+	}
+	switch {
+	case input.Expires == nil:
+	case *input.Expires == 0:
+		form.Expires = "never"
+	default:
+		form.Expires = "custom"
+		form.CustomDays = *input.Expires
+	}
+	return form
+}
+
+// bulkSnippetResult reports what happened to one snippet in a bulk create
+// request. Status is the HTTP status code which the snippet would have got if
+// it had been created on its own.
+type bulkSnippetResult struct {
+	Index  int               `json:"index"`
+	Status int               `json:"status"`
+	ID     int               `json:"id,omitempty"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// apiSnippetBulkCreate creates several snippets at once from a JSON array.
+// Each snippet is validated on its own, and the valid ones are inserted in a
+// single transaction, so a database error means that none of them are. The
+// response is a 207 Multi-Status, with a result for each snippet in the same
+// order as the request.
+func (app *application) apiSnippetBulkCreate(w http.ResponseWriter, r *http.Request) {
+	var input []bulkSnippetInput
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.apiBadRequest(w, r, err)
+		return
+	}
+	if len(input) == 0 {
+		app.apiError(w, http.StatusUnprocessableEntity, "body must contain at least one snippet")
+		return
+	}
+	if len(input) > maxBulkSnippets {
+		app.apiError(w, http.StatusUnprocessableEntity, fmt.Sprintf("body must not contain more than %d snippets", maxBulkSnippets))
+		return
+	}
+
+	user := app.contextGetUser(r)
+	results := make([]bulkSnippetResult, len(input))
+	var params []models.InsertParams
+	var valid []int
+	for i, item := range input {
+		form := item.form()
+		form.validate(app.snippetLimits)
+		results[i].Index = i
+		if !form.Valid() {
+			results[i].Status = http.StatusUnprocessableEntity
+			results[i].Errors = form.FieldErrors
+			continue
+		}
+		params = append(params, models.InsertParams{
+			Title:      form.Title,
+			Content:    form.Content,
+			Expires:    form.ExpiresDays(),
+			UserID:     user.ID,
+			Tags:       form.TagList(),
+			Visibility: form.Visibility,
+			Language:   form.Language,
+		})
+		valid = append(valid, i)
+	}
+
+	if len(params) > 0 {
+		ids, err := app.snippets.BulkInsert(r.Context(), params)
+		if err != nil {
+			app.apiServerError(w, r, err)
+			return
+		}
+		for j, i := range valid {
+			results[i].Status = http.StatusCreated
+			results[i].ID = ids[j]
+		}
+	}
+	err = app.writeJSON(w, http.StatusMultiStatus, envelope{"results": results}, nil)
+	if err != nil {
+		app.apiServerError(w, r, err)
+	}
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"snippetbox/internal/assert"
+	"snippetbox/internal/models/mocks"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+)
+
+func TestAPITokenCreate(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Valid credentials",
+			body:     `{"email": "alice@example.com", "password": "pa$$word"}`,
+			wantCode: http.StatusCreated,
+			wantBody: `"token": "VALIDAPITOKEN"`,
+		},
+		{
+			name:     "Wrong password",
+			body:     `{"email": "alice@example.com", "password": "wrongPa$$word"}`,
+			wantCode: http.StatusUnauthorized,
+			wantBody: "invalid authentication credentials",
+		},
+		{
+			name:     "Not activated",
+			body:     `{"email": "bob@example.com", "password": "pa$$word"}`,
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Missing email",
+			body:     `{"password": "pa$$word"}`,
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: `"email": "must be provided"`,
+		},
+		{
+			name:     "Badly-formed JSON",
+			body:     `{"email": "alice@example.com",`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Unknown field",
+			body:     `{"email": "alice@example.com", "password": "pa$$word", "admin": true}`,
+			wantCode: http.StatusBadRequest,
+			wantBody: `body contains unknown key \"admin\"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, header, body := ts.do(t, http.MethodPost, "/api/v1/tokens", strings.NewReader(tt.body), nil)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, header.Get("Content-Type"), "application/json")
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+// Deactivating a user locks them out of the API straight away, as well as
+// the web site.
+func TestAPIDeactivatedUser(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	header := http.Header{"Authorization": {"Bearer VALIDAPITOKEN"}}
+	code, _, _ := ts.do(t, http.MethodGet, "/api/v1/account", nil, header)
+	assert.Equal(t, code, http.StatusOK)
+
+	form := url.Values{}
+	form.Add("csrf_token", ts.loginAs(t, "admin@example.com"))
+	code, _, _ = ts.postForm(t, "/admin/users/deactivate/1", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	code, _, _ = ts.do(t, http.MethodGet, "/api/v1/account", nil, header)
+	assert.Equal(t, code, http.StatusUnauthorized)
+}
+
+func TestAPITokenCreateTOTP(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, err := totp.GenerateCode(mocks.MockTOTPSecret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := func(totpCode string) string {
+		return fmt.Sprintf(`{"email": "dave@example.com", "password": "pa$$word", "totp_code": %q}`, totpCode)
+	}
+
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "No code",
+			body:     `{"email": "dave@example.com", "password": "pa$$word"}`,
+			wantCode: http.StatusForbidden,
+			wantBody: "two-factor authentication required",
+		},
+		{
+			name:     "Wrong code",
+			body:     body("000000"),
+			wantCode: http.StatusUnauthorized,
+			wantBody: "invalid two-factor authentication code",
+		},
+		{
+			name:     "Valid code",
+			body:     body(code),
+			wantCode: http.StatusCreated,
+			wantBody: `"token": "VALIDAPITOKEN"`,
+		},
+		{
+			name:     "Recovery code",
+			body:     body(mocks.MockRecoveryCode),
+			wantCode: http.StatusCreated,
+			wantBody: `"token": "VALIDAPITOKEN"`,
+		},
+		{
+			name:     "Wrong password",
+			body:     `{"email": "dave@example.com", "password": "wrongPa$$word", "totp_code": "000000"}`,
+			wantCode: http.StatusUnauthorized,
+			wantBody: "invalid authentication credentials",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.do(t, http.MethodPost, "/api/v1/tokens", strings.NewReader(tt.body), nil)
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+
+	// Wrong codes count against the same limiter as the web form, so that the
+	// API can't be used to brute force them.
+	t.Run("Rate limited", func(t *testing.T) {
+		for range 5 {
+			ts.do(t, http.MethodPost, "/api/v1/tokens", strings.NewReader(body("000000")), nil)
+		}
+		code, _, _ := ts.do(t, http.MethodPost, "/api/v1/tokens", strings.NewReader(body(code)), nil)
+		assert.Equal(t, code, http.StatusTooManyRequests)
+	})
+}
+
+func TestAPIAccountView(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantCode      int
+		wantBody      string
+	}{
+		{
+			name:          "Valid token",
+			authorization: "Bearer VALIDAPITOKEN",
+			wantCode:      http.StatusOK,
+			wantBody:      `"email": "alice@example.com"`,
+		},
+		{
+			name:     "Missing header",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:          "Wrong scheme",
+			authorization: "Basic VALIDAPITOKEN",
+			wantCode:      http.StatusUnauthorized,
+		},
+		{
+			name:          "Unknown token",
+			authorization: "Bearer INVALIDAPITOKEN",
+			wantCode:      http.StatusUnauthorized,
+			wantBody:      "invalid or missing authentication token",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.authorization != "" {
+				header.Set("Authorization", tt.authorization)
+			}
+			code, rsHeader, body := ts.do(t, http.MethodGet, "/api/v1/account", nil, header)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, rsHeader.Get("Vary"), "Authorization")
+			if code == http.StatusUnauthorized {
+				assert.Equal(t, rsHeader.Get("WWW-Authenticate"), "Bearer")
+			}
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestAPISnippetBulkCreate(t *testing.T) {
+	header := http.Header{}
+	header.Set("Authorization", "Bearer VALIDAPITOKEN")
+
+	t.Run("Mixed batch", func(t *testing.T) {
+		app := newTestApplication(t)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		body := `[
+			{"title": "An old silent pond", "content": "A frog jumps into the pond", "tags": ["haiku"]},
+			{"title": "", "content": "No title"},
+			{"title": "Over the wintry", "content": "Over the wintry forest", "expires": 0, "visibility": "unlisted"},
+			{"title": "Too long", "content": "Expires far too late", "expires": 5000, "visibility": "secret"}
+		]`
+		code, rsHeader, rsBody := ts.do(t, http.MethodPost, "/api/v1/snippets/bulk", strings.NewReader(body), header)
+		assert.Equal(t, code, http.StatusMultiStatus)
+		assert.Equal(t, rsHeader.Get("Content-Type"), "application/json")
+
+		var rs struct {
+			Results []bulkSnippetResult `json:"results"`
+		}
+		err := json.Unmarshal([]byte(rsBody), &rs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, len(rs.Results), 4)
+		for i, result := range rs.Results {
+			assert.Equal(t, result.Index, i)
+		}
+		assert.Equal(t, rs.Results[0].Status, http.StatusCreated)
+		assert.Equal(t, rs.Results[0].ID, 100)
+		assert.Equal(t, rs.Results[1].Status, http.StatusUnprocessableEntity)
+		assert.Equal(t, rs.Results[1].Errors["title"], "This field cannot be blank")
+		assert.Equal(t, rs.Results[2].Status, http.StatusCreated)
+		assert.Equal(t, rs.Results[2].ID, 101)
+		assert.Equal(t, rs.Results[3].Status, http.StatusUnprocessableEntity)
+		assert.Equal(t, rs.Results[3].Errors["expires"], "Custom expiry must be between 1 and 3650 days")
+		assert.Equal(t, rs.Results[3].Errors["visibility"], "This field must equal public, unlisted or private")
+
+		// Only the valid snippets were inserted, owned by the token's user.
+		inserted := app.snippets.(*mocks.SnippetModel).Inserted()
+		assert.Equal(t, len(inserted), 2)
+		assert.Equal(t, inserted[0].Title, "An old silent pond")
+		assert.Equal(t, inserted[0].Visibility, "public")
+		assert.Equal(t, inserted[1].Title, "Over the wintry")
+		assert.Equal(t, inserted[1].Visibility, "unlisted")
+		for _, s := range inserted {
+			assert.Equal(t, s.UserID, 1)
+		}
+	})
+
+	tests := []struct {
+		name     string
+		body     string
+		header   http.Header
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "No token",
+			body:     `[{"title": "Title", "content": "Content"}]`,
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "Not an array",
+			body:     `{"title": "Title", "content": "Content"}`,
+			header:   header,
+			wantCode: http.StatusBadRequest,
+			wantBody: "body contains incorrect JSON type",
+		},
+		{
+			name:     "Unknown field",
+			body:     `[{"title": "Title", "content": "Content", "user_id": 2}]`,
+			header:   header,
+			wantCode: http.StatusBadRequest,
+			wantBody: `body contains unknown key \"user_id\"`,
+		},
+		{
+			name:     "Empty array",
+			body:     `[]`,
+			header:   header,
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "body must contain at least one snippet",
+		},
+		{
+			name:     "Too many snippets",
+			body:     "[" + strings.Repeat(`{"title": "Title", "content": "Content"},`, 100) + `{"title": "Title", "content": "Content"}]`,
+			header:   header,
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "body must not contain more than 100 snippets",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			code, _, body := ts.do(t, http.MethodPost, "/api/v1/snippets/bulk", strings.NewReader(tt.body), tt.header)
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+			assert.Equal(t, len(app.snippets.(*mocks.SnippetModel).Inserted()), 0)
+		})
+	}
+}
+
+func TestAPISnippetContent(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// The response should be an object with just a "content" key.
+	readContent := func(t *testing.T, body string) map[string]string {
+		var rs map[string]string
+		err := json.Unmarshal([]byte(body), &rs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rs
+	}
+
+	t.Run("Public snippet", func(t *testing.T) {
+		snippet, err := app.snippets.Get(context.Background(), 1, 0)
+		assert.NilError(t, err)
+
+		code, header, body := ts.get(t, "/api/v1/snippets/1/content")
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, header.Get("Content-Type"), "application/json")
+		rs := readContent(t, body)
+		assert.Equal(t, len(rs), 1)
+		assert.Equal(t, rs["content"], snippet.Content)
+	})
+
+	// Missing, private, scheduled and malformed IDs all get a JSON 404.
+	for _, urlPath := range []string{"/api/v1/snippets/2/content", "/api/v1/snippets/6/content", "/api/v1/snippets/8/content", "/api/v1/snippets/foo/content"} {
+		t.Run("Not found "+urlPath, func(t *testing.T) {
+			code, header, body := ts.get(t, urlPath)
+			assert.Equal(t, code, http.StatusNotFound)
+			assert.Equal(t, header.Get("Content-Type"), "application/json")
+			rs := readContent(t, body)
+			assert.Equal(t, rs["error"], "Not Found")
+		})
+	}
+
+	// Private snippets can be fetched by their owner.
+	t.Run("Private snippet", func(t *testing.T) {
+		ts.login(t)
+		code, _, body := ts.get(t, "/api/v1/snippets/6/content")
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, readContent(t, body)["content"], "For my eyes only...")
+	})
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"net/http"
+
+	"snippetbox/ui"
+)
+
+// assetHashes maps the URL path of each static file (like
+// "/static/css/main.css") to a short hash of its contents. The files are
+// embedded in the binary, so they can't change while the application is
+// running and we only need to hash them once, at startup.
+var assetHashes = func() map[string]string {
+	hashes, err := hashAssets(ui.Files)
+	if err != nil {
+		panic(err)
+	}
+	return hashes
+}()
+
+// The hashAssets() function hashes every file under the "static" directory of
+// fsys. We only keep the first 8 bytes of each SHA-256 hash, which is plenty
+// to tell versions of a file apart and keeps the URLs short.
+func hashAssets(fsys fs.FS) (map[string]string, error) {
+	hashes := map[string]string{}
+	err := fs.WalkDir(fsys, "static", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		b, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(b)
+		hashes["/"+path] = hex.EncodeToString(sum[:8])
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hashes, nil
+}
+
+// The fingerprint() template function adds the hash of a static file's
+// contents to its URL, like "/static/css/main.css?v=1a2b3c4d5e6f7a8b". When
+// the file changes so does its URL, so browsers can cache each version
+// forever. Paths which aren't static files are returned unchanged.
+func fingerprint(path string) string {
+	hash, ok := assetHashes[path]
+	if !ok {
+		return path
+	}
+	return path + "?v=" + hash
+}
+
+// The cacheStatic middleware sets the caching headers for static files. A
+// request for the current fingerprinted URL can be cached for a year (the
+// longest time allowed) without checking back, as the contents of that URL
+// will never change. Anything else, including a URL with an out of date
+// hash, must be revalidated every time, which the ETag makes cheap:
+// http.FileServer replies to a matching If-None-Match with a 304 response.
+func cacheStatic(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hash, ok := assetHashes[r.URL.Path]
+		if ok {
+			w.Header().Set("ETag", `"`+hash+`"`)
+			if r.URL.Query().Get("v") == hash {
+				w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+			} else {
+				w.Header().Set("Cache-Control", "no-cache")
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"testing"
+	"testing/fstest"
+
+	"snippetbox/internal/assert"
+)
+
+func TestHashAssets(t *testing.T) {
+	fsys := fstest.MapFS{
+		"static/css/main.css": {Data: []byte("body { color: red; }")},
+		"static/js/main.js":   {Data: []byte("console.log('hi');")},
+		"html/base.html":      {Data: []byte("<html></html>")},
+	}
+	hashes, err := hashAssets(fsys)
+	assert.NilError(t, err)
+	assert.Equal(t, len(hashes), 2)
+
+	sum := sha256.Sum256([]byte("body { color: red; }"))
+	assert.Equal(t, hashes["/static/css/main.css"], hex.EncodeToString(sum[:8]))
+
+	// Hashing the same contents again gives the same hashes, and changing a
+	// file changes its hash.
+	again, err := hashAssets(fsys)
+	assert.NilError(t, err)
+	assert.Equal(t, again["/static/js/main.js"], hashes["/static/js/main.js"])
+	fsys["static/css/main.css"] = &fstest.MapFile{Data: []byte("body { color: blue; }")}
+	changed, err := hashAssets(fsys)
+	assert.NilError(t, err)
+	if changed["/static/css/main.css"] == hashes["/static/css/main.css"] {
+		t.Error("changing a file didn't change its hash")
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	url := fingerprint("/static/css/main.css")
+	assert.Equal(t, url, "/static/css/main.css?v="+assetHashes["/static/css/main.css"])
+	assert.Equal(t, len(assetHashes["/static/css/main.css"]), 16)
+	assert.Equal(t, fingerprint("/static/css/main.css"), url)
+	assert.Equal(t, fingerprint("/static/missing.css"), "/static/missing.css")
+}
+
+func TestCacheStatic(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// The pages link to the fingerprinted URLs.
+	_, _, body := ts.get(t, "/")
+	assert.StringContains(t, body, "href='"+fingerprint("/static/css/main.css")+"'")
+
+	hash := assetHashes["/static/css/main.css"]
+
+	tests := []struct {
+		name             string
+		urlPath          string
+		wantCacheControl string
+		wantETag         string
+	}{
+		{
+			name:             "Fingerprinted",
+			urlPath:          "/static/css/main.css?v=" + hash,
+			wantCacheControl: "public, max-age=31536000, immutable",
+			wantETag:         `"` + hash + `"`,
+		},
+		{
+			name:             "Plain",
+			urlPath:          "/static/css/main.css",
+			wantCacheControl: "no-cache",
+			wantETag:         `"` + hash + `"`,
+		},
+		{
+			name:             "Out of date",
+			urlPath:          "/static/css/main.css?v=0123456789abcdef",
+			wantCacheControl: "no-cache",
+			wantETag:         `"` + hash + `"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, header, _ := ts.get(t, tt.urlPath)
+			assert.Equal(t, code, http.StatusOK)
+			assert.Equal(t, header.Get("Cache-Control"), tt.wantCacheControl)
+			assert.Equal(t, header.Get("ETag"), tt.wantETag)
+		})
+	}
+
+	t.Run("Not modified", func(t *testing.T) {
+		code, _, _ := ts.do(t, http.MethodGet, "/static/css/main.css", nil, http.Header{
+			"If-None-Match": {`"` + hash + `"`},
+		})
+		assert.Equal(t, code, http.StatusNotModified)
+	})
+
+	t.Run("Missing file", func(t *testing.T) {
+		code, header, _ := ts.get(t, "/static/missing.css")
+		assert.Equal(t, code, http.StatusNotFound)
+		assert.Equal(t, header.Get("Cache-Control"), "")
+	})
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+)
+
+// activityLimit is how many of a user's most recent events the account
+// activity page shows.
+const activityLimit = 50
+
+// The recordAudit() helper adds an event to a user's audit log, along with the
+// IP address and user agent of the client which made the request. By the time
+// we record an event the user has already done whatever it was, so if it can't
+// be recorded we log the error rather than showing them an error page.
+func (app *application) recordAudit(r *http.Request, userID int, action, detail string) {
+	err := app.audit.Record(r.Context(), userID, action, detail, app.clientIP(r), r.UserAgent())
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error(), "audit_action", action, "user_id", userID)
+	}
+}
+
+// accountActivity shows the current user's recent security-relevant events,
+// like logins and password changes, so that they can spot anything which
+// they didn't do themselves.
+func (app *application) accountActivity(w http.ResponseWriter, r *http.Request) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	events, err := app.audit.Recent(r.Context(), userID, activityLimit)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	data := app.newTemplateData(r)
+	data.AuditEvents = events
+	app.render(w, r, http.StatusOK, "activity.html", data)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"snippetbox/internal/assert"
+	"snippetbox/internal/models"
+	"snippetbox/internal/models/mocks"
+)
+
+func TestAudit(t *testing.T) {
+	app := newTestApplication(t)
+	audit := app.audit.(*mocks.AuditModel)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// The lastEvent() helper returns the most recently recorded event.
+	lastEvent := func(t *testing.T) *models.AuditEvent {
+		events := audit.Events()
+		if len(events) == 0 {
+			t.Fatal("no audit events recorded")
+		}
+		return events[len(events)-1]
+	}
+
+	t.Run("Anonymous", func(t *testing.T) {
+		code, header, _ := ts.get(t, "/account/activity")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/login")
+	})
+
+	csrfToken := ts.login(t)
+
+	t.Run("Login", func(t *testing.T) {
+		e := lastEvent(t)
+		assert.Equal(t, e.UserID, 1)
+		assert.Equal(t, e.Action, models.AuditLogin)
+		assert.Equal(t, e.IP, "127.0.0.1")
+		assert.Equal(t, e.UserAgent, "Go-http-client/1.1")
+	})
+
+	t.Run("Failed login", func(t *testing.T) {
+		// Wrong passwords aren't recorded as logins.
+		before := len(audit.Events())
+		_, _, body := ts.get(t, "/user/login")
+		form := url.Values{}
+		form.Add("email", "alice@example.com")
+		form.Add("password", "wrong")
+		form.Add("csrf_token", extractCSRFToken(t, body))
+		code, _, _ := ts.postForm(t, "/user/login", form)
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+		assert.Equal(t, len(audit.Events()), before)
+	})
+
+	t.Run("Password change", func(t *testing.T) {
+		form := url.Values{}
+		form.Add("currentPassword", "pa$$word")
+		form.Add("newPassword", "newPa$$word1")
+		form.Add("newPasswordConfirmation", "newPa$$word1")
+		form.Add("csrf_token", csrfToken)
+		code, _, _ := ts.postForm(t, "/account/password/update", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		e := lastEvent(t)
+		assert.Equal(t, e.UserID, 1)
+		assert.Equal(t, e.Action, models.AuditPasswordChange)
+	})
+
+	t.Run("Activity", func(t *testing.T) {
+		code, _, body := ts.get(t, "/account/activity")
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "Logged in")
+		assert.StringContains(t, body, "Password changed")
+		assert.StringContains(t, body, "<td>127.0.0.1</td>")
+	})
+
+	t.Run("Logout", func(t *testing.T) {
+		form := url.Values{}
+		form.Add("csrf_token", csrfToken)
+		code, _, _ := ts.postForm(t, "/user/logout", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		e := lastEvent(t)
+		assert.Equal(t, e.UserID, 1)
+		assert.Equal(t, e.Action, models.AuditLogout)
+	})
+
+	t.Run("Email change", func(t *testing.T) {
+		code, _, _ := ts.get(t, "/account/email/confirm/VALIDEMAILTOKEN")
+		assert.Equal(t, code, http.StatusSeeOther)
+		e := lastEvent(t)
+		assert.Equal(t, e.UserID, 1)
+		assert.Equal(t, e.Action, models.AuditEmailChange)
+	})
+
+	t.Run("Password reset", func(t *testing.T) {
+		_, _, body := ts.get(t, "/user/password/reset?token=VALIDRESETTOKEN")
+		form := url.Values{}
+		form.Add("token", "VALIDRESETTOKEN")
+		form.Add("newPassword", "newPa$$word1")
+		form.Add("newPasswordConfirmation", "newPa$$word1")
+		form.Add("csrf_token", extractCSRFToken(t, body))
+		code, _, _ := ts.postForm(t, "/user/password/reset", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		e := lastEvent(t)
+		assert.Equal(t, e.UserID, 1)
+		assert.Equal(t, e.Action, models.AuditPasswordChange)
+		assert.Equal(t, e.Detail, "reset by email")
+	})
+
+	t.Run("Account deletion", func(t *testing.T) {
+		csrfToken := ts.login(t)
+		form := url.Values{}
+		form.Add("password", "pa$$word")
+		form.Add("csrf_token", csrfToken)
+		code, _, _ := ts.postForm(t, "/account/delete", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		e := lastEvent(t)
+		assert.Equal(t, e.UserID, 1)
+		assert.Equal(t, e.Action, models.AuditAccountDelete)
+	})
+}
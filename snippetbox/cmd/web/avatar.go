@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	// Register the GIF and JPEG decoders with the image package, so that
+	// image.Decode() understands them as well as PNG.
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// avatarSize is the width and height, in pixels, of the avatar images which
+// we store.
+const avatarSize = 128
+
+// maxAvatarPixels limits the dimensions of an uploaded image. A small file can
+// decode to an enormous image, so we check the dimensions in the image header
+// before decoding the whole thing.
+const maxAvatarPixels = 4096 * 4096
+
+var (
+	errNotImage      = errors.New("not a PNG, JPEG or GIF image")
+	errImageTooLarge = errors.New("image dimensions too large")
+)
+
+// avatarNameRX matches the file names which we give avatars. Checking the name
+// before opening the file means a request can never reach outside the avatar
+// directory.
+var avatarNameRX = regexp.MustCompile(`^[0-9]+-[A-Za-z0-9_-]+\.png$`)
+
+// The makeAvatar() function turns an uploaded image into an avatar: it's
+// cropped to a square from the middle of the image, scaled to avatarSize and
+// encoded as a PNG. Re-encoding the image also means that we never serve the
+// uploaded bytes themselves, so anything hidden in them is thrown away.
+func makeAvatar(data []byte) ([]byte, error) {
+	// Check the content type from the first bytes of the file, rather than
+	// trusting the Content-Type which the browser sent.
+	switch http.DetectContentType(data) {
+	case "image/png", "image/jpeg", "image/gif":
+	default:
+		return nil, errNotImage
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, errNotImage
+	}
+	if config.Width*config.Height > maxAvatarPixels {
+		return nil, errImageTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, errNotImage
+	}
+	var buf bytes.Buffer
+	err = png.Encode(&buf, squareThumbnail(img, avatarSize))
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// The squareThumbnail() function crops the largest square it can from the
+// middle of src and scales it to size x size pixels. Each pixel of the
+// thumbnail is the average of the block of source pixels which it covers,
+// which gives a much smoother result than just picking one of them.
+func squareThumbnail(src image.Image, size int) *image.RGBA {
+	b := src.Bounds()
+	side := min(b.Dx(), b.Dy())
+	x0 := b.Min.X + (b.Dx()-side)/2
+	y0 := b.Min.Y + (b.Dy()-side)/2
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := range size {
+		// When the source is smaller than the thumbnail a block can be
+		// empty, so every block covers at least one pixel.
+		sy0 := y0 + y*side/size
+		sy1 := max(y0+(y+1)*side/size, sy0+1)
+		for x := range size {
+			sx0 := x0 + x*side/size
+			sx1 := max(x0+(x+1)*side/size, sx0+1)
+			var r, g, b, a, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			dst.Set(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(b / n), uint16(a / n)})
+		}
+	}
+	return dst
+}
+
+// accountAvatarPost handles the avatar upload form on the account page. The
+// image is processed by makeAvatar() and saved in the avatar directory under
+// a new random name, and the user's old avatar (if any) is removed.
+func (app *application) accountAvatarPost(w http.ResponseWriter, r *http.Request) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	user, err := app.users.Get(r.Context(), userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	// Any problem with the upload is shown next to the avatar field. The
+	// account page also has the time zone form on it, so that's filled in
+	// too.
+	form := timezoneForm{Timezone: user.Timezone}
+	rejectUpload := func(message string) {
+		form.AddFieldError("avatar", message)
+		data := app.newTemplateData(r)
+		data.User = user
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "account.html", data)
+	}
+
+	file, header, err := r.FormFile("avatar")
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.bodyTooLarge(w, r)
+		case errors.Is(err, http.ErrMissingFile):
+			rejectUpload("Please choose an image to upload")
+		default:
+			app.clientError(w, r, http.StatusBadRequest)
+		}
+		return
+	}
+	defer file.Close()
+	if header.Size > app.avatarMaxBytes {
+		rejectUpload(fmt.Sprintf("The image must be no larger than %d KB", app.avatarMaxBytes/1024))
+		return
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	avatar, err := makeAvatar(data)
+	if err != nil {
+		switch {
+		case errors.Is(err, errNotImage):
+			rejectUpload("The file must be a PNG, JPEG or GIF image")
+		case errors.Is(err, errImageTooLarge):
+			rejectUpload("The image must be no more than 4096x4096 pixels")
+		default:
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	// Each avatar gets a new name, so that browsers can cache them forever
+	// (see the avatar handler below).
+	token, err := generateNonce()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	name := fmt.Sprintf("%d-%s.png", userID, token)
+	err = os.WriteFile(filepath.Join(app.avatarDir, name), avatar, 0o644)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	err = app.users.SetAvatar(r.Context(), userID, name)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	// The old avatar isn't needed any more. If we can't remove it then it's
+	// only wasting a little disk space, so we just log the error.
+	if user.Avatar != "" && avatarNameRX.MatchString(user.Avatar) {
+		err = os.Remove(filepath.Join(app.avatarDir, user.Avatar))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			app.logger.ErrorContext(r.Context(), err.Error())
+		}
+	}
+	app.addFlash(r, flashSuccess, "Your avatar has been updated!")
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+// The avatar handler serves an avatar image from the avatar directory. An
+// avatar's name changes whenever it's replaced, so the contents of each URL
+// never change and browsers (and any caches in between) can keep them for a
+// year without checking back, just like our fingerprinted static files.
+func (app *application) avatar(w http.ResponseWriter, r *http.Request) {
+	name := httprouter.ParamsFromContext(r.Context()).ByName("name")
+	if !avatarNameRX.MatchString(name) {
+		app.notFound(w, r)
+		return
+	}
+	f, err := os.Open(filepath.Join(app.avatarDir, name))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
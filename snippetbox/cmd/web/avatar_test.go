@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"testing"
+
+	"snippetbox/internal/assert"
+)
+
+// The uploadAvatar() helper posts data to /account/avatar as a multipart form,
+// in the same way as a browser would, under the given file name. If fileName
+// is empty the form is sent without a file.
+func (ts *testServer) uploadAvatar(t *testing.T, csrfToken, fileName string, data []byte) (int, http.Header, string) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	err := mw.WriteField("csrf_token", csrfToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileName != "" {
+		fw, err := mw.CreateFormFile("avatar", fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(data)
+	}
+	err = mw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ts.do(t, http.MethodPost, "/account/avatar", &body, http.Header{"Content-Type": {mw.FormDataContentType()}})
+}
+
+func testPNG(t *testing.T, width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 0, 255})
+		}
+	}
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestAccountAvatarPost(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, _, _ := ts.get(t, "/avatar/1-abc.png")
+	assert.Equal(t, code, http.StatusNotFound)
+
+	csrfToken := ts.login(t)
+
+	t.Run("Valid", func(t *testing.T) {
+		code, header, _ := ts.uploadAvatar(t, csrfToken, "me.png", testPNG(t, 300, 200))
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/account/view")
+
+		entries, err := os.ReadDir(app.avatarDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, len(entries), 1)
+		name := entries[0].Name()
+		assert.Equal(t, avatarNameRX.MatchString(name), true)
+
+		code, header, body := ts.get(t, "/avatar/"+name)
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, header.Get("Content-Type"), "image/png")
+		assert.Equal(t, header.Get("Cache-Control"), "public, max-age=31536000, immutable")
+		config, err := png.DecodeConfig(bytes.NewReader([]byte(body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, config.Width, avatarSize)
+		assert.Equal(t, config.Height, avatarSize)
+	})
+
+	tests := []struct {
+		name     string
+		fileName string
+		data     []byte
+		wantBody string
+	}{
+		{
+			name:     "No file",
+			wantBody: "Please choose an image to upload",
+		},
+		{
+			name:     "Not an image",
+			fileName: "me.png",
+			data:     []byte("this is not an image"),
+			wantBody: "The file must be a PNG, JPEG or GIF image",
+		},
+		{
+			name:     "Corrupt image",
+			fileName: "me.png",
+			data:     testPNG(t, 10, 10)[:40],
+			wantBody: "The file must be a PNG, JPEG or GIF image",
+		},
+		{
+			name:     "Too many pixels",
+			fileName: "me.png",
+			data:     testPNG(t, 5000, 4000),
+			wantBody: "The image must be no more than 4096x4096 pixels",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.uploadAvatar(t, csrfToken, tt.fileName, tt.data)
+			assert.Equal(t, code, http.StatusUnprocessableEntity)
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+
+	t.Run("Too large", func(t *testing.T) {
+		app.avatarMaxBytes = 2048
+		defer func() { app.avatarMaxBytes = 524_288 }()
+
+		data := append(testPNG(t, 8, 8), make([]byte, 4096)...)
+		code, _, body := ts.uploadAvatar(t, csrfToken, "me.png", data)
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+		assert.StringContains(t, body, "The image must be no larger than 2 KB")
+	})
+}
+
+func TestAvatar(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	err := os.WriteFile(app.avatarDir+"/1-abc.png", testPNG(t, 8, 8), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(app.avatarDir+"/secret.txt", []byte("secret"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+	}{
+		{"Valid", "/avatar/1-abc.png", http.StatusOK},
+		{"Missing", "/avatar/1-xyz.png", http.StatusNotFound},
+		{"Not an avatar name", "/avatar/secret.txt", http.StatusNotFound},
+		{"Traversal", "/avatar/..%2fsecret.txt", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, _ := ts.get(t, tt.urlPath)
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+}
+
+func TestSquareThumbnail(t *testing.T) {
+	// A 4x2 image with a red left quarter, white middle half and blue right
+	// quarter. The middle square is the white part.
+	src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for y := range 2 {
+		src.Set(0, y, color.RGBA{255, 0, 0, 255})
+		src.Set(1, y, color.White)
+		src.Set(2, y, color.White)
+		src.Set(3, y, color.RGBA{0, 0, 255, 255})
+	}
+
+	t.Run("Crops the middle", func(t *testing.T) {
+		dst := squareThumbnail(src, 1)
+		assert.Equal(t, dst.Bounds(), image.Rect(0, 0, 1, 1))
+		assert.Equal(t, dst.RGBAAt(0, 0), color.RGBA{255, 255, 255, 255})
+	})
+
+	t.Run("Scales up", func(t *testing.T) {
+		dst := squareThumbnail(src, 4)
+		for y := range 4 {
+			for x := range 4 {
+				assert.Equal(t, dst.RGBAAt(x, y), color.RGBA{255, 255, 255, 255})
+			}
+		}
+	})
+
+	t.Run("Averages", func(t *testing.T) {
+		// The whole 2x2 image averages to grey.
+		half := image.NewRGBA(image.Rect(0, 0, 2, 2))
+		half.Set(0, 0, color.White)
+		half.Set(1, 1, color.White)
+		half.Set(0, 1, color.Black)
+		half.Set(1, 0, color.Black)
+		dst := squareThumbnail(half, 1)
+		assert.Equal(t, dst.RGBAAt(0, 0), color.RGBA{127, 127, 127, 255})
+	})
+}
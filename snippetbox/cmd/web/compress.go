@@ -0,0 +1,248 @@
+package main
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// minCompressSize is the smallest response body that we bother compressing.
+// Below this the gzip header and footer can make the response bigger, and
+// it's not worth the CPU time anyway.
+const minCompressSize = 1024
+
+// Creating a gzip or flate writer allocates quite a lot of memory, so we keep
+// pools of them to reuse between responses.
+var (
+	gzipWriters = sync.Pool{New: func() any {
+		return gzip.NewWriter(io.Discard)
+	}}
+	flateWriters = sync.Pool{New: func() any {
+		fw, _ := flate.NewWriter(io.Discard, flate.DefaultCompression)
+		return fw
+	}}
+)
+
+// The compressResponse middleware compresses response bodies with gzip or
+// deflate, if the client says that it accepts one of them. The decision about
+// whether to compress is put off until the first minCompressSize bytes of the
+// body have been written (or the handler flushes or returns), so that tiny
+// responses and content types which are already compressed can be sent as
+// they are.
+func compressResponse(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Whatever happens the response depends on the Accept-Encoding
+		// header, so caches need to know not to send a compressed response
+		// to a client which can't read it.
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding := negotiateEncoding(strings.Join(r.Header.Values("Accept-Encoding"), ","))
+		// Compressing a partial response to a Range request would make the
+		// byte offsets meaningless, and HEAD responses have no body.
+		if encoding == "" || r.Method == http.MethodHead || r.Header.Get("Range") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding, status: http.StatusOK}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// The negotiateEncoding() function returns the encoding to use for a response
+// given the value of the Accept-Encoding header, or "" if the client doesn't
+// accept gzip or deflate. We prefer gzip when the client is equally happy
+// with both, and an encoding with a q-value of 0 is refused.
+func negotiateEncoding(header string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if name == "*" {
+			name = "gzip"
+		}
+		if (name != "gzip" && name != "deflate") || q <= 0 {
+			continue
+		}
+		if q > bestQ || (q == bestQ && name == "gzip") {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// The compressible() function reports whether a response with the given
+// Content-Type is worth compressing. Most image, audio and video formats and
+// archives are compressed already, and compressing them again only wastes
+// time. SVG images are text though, so they're the exception.
+func compressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	switch {
+	case mediaType == "image/svg+xml":
+		return true
+	case strings.HasPrefix(mediaType, "image/"),
+		strings.HasPrefix(mediaType, "audio/"),
+		strings.HasPrefix(mediaType, "video/"),
+		strings.HasPrefix(mediaType, "font/woff"):
+		return false
+	}
+	switch mediaType {
+	case "application/zip", "application/gzip", "application/x-gzip", "application/pdf":
+		return false
+	}
+	return true
+}
+
+// compressWriter wraps a http.ResponseWriter to compress the response body.
+// Until it has decided whether to compress, the status code and the start of
+// the body are held back.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding    string
+	status      int
+	wroteHeader bool
+	buf         []byte
+	// decided is set once we know whether to compress. If we are compressing
+	// then writer is the gzip or flate writer; otherwise it's nil and writes
+	// go straight through.
+	decided bool
+	writer  interface {
+		io.WriteCloser
+		Flush() error
+		Reset(io.Writer)
+	}
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	// Informational responses (like 103 Early Hints) can be sent any number
+	// of times, so they go straight through.
+	if status >= 100 && status < 200 {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if cw.wroteHeader {
+		return
+	}
+	cw.status = status
+	cw.wroteHeader = true
+	// Responses which don't have a body can be sent straight away.
+	if status == http.StatusNoContent || status == http.StatusNotModified {
+		cw.decide(false)
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	cw.wroteHeader = true
+	if !cw.decided {
+		cw.buf = append(cw.buf, b...)
+		if len(cw.buf) < minCompressSize {
+			return len(b), nil
+		}
+		err := cw.decide(true)
+		return len(b), err
+	}
+	if cw.writer != nil {
+		return cw.writer.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// The decide() method works out whether to compress the response, sends the
+// header and then writes out anything which has been held back. Passing big as
+// false means the whole body is in the buffer and it's too small to compress.
+func (cw *compressWriter) decide(big bool) error {
+	cw.decided = true
+	h := cw.Header()
+	// If the handler didn't set a Content-Type then net/http would sniff it
+	// from the body, but it can't do that once the body is compressed, so we
+	// do the same thing here first.
+	if h.Get("Content-Type") == "" && len(cw.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+	// A handler which has compressed the body itself sets Content-Encoding,
+	// and we mustn't compress it a second time.
+	if big && h.Get("Content-Encoding") == "" && cw.status != http.StatusPartialContent && compressible(h.Get("Content-Type")) {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", cw.encoding)
+		if cw.encoding == "gzip" {
+			cw.writer = gzipWriters.Get().(*gzip.Writer)
+		} else {
+			cw.writer = flateWriters.Get().(*flate.Writer)
+		}
+		cw.writer.Reset(cw.ResponseWriter)
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.writer != nil {
+		_, err = cw.writer.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// Flush() sends everything written so far to the client. A handler only
+// flushes when it wants the client to see the data straight away, so if we
+// haven't decided yet we assume there's more to come and compress if we
+// would for a big response.
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		cw.decide(true)
+	}
+	if cw.writer != nil {
+		cw.writer.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// The close() method is called once the handler has returned. It sends any
+// response which is still held back and finishes the compressed stream,
+// returning the writer to its pool.
+func (cw *compressWriter) close() {
+	if !cw.decided {
+		if !cw.wroteHeader {
+			// The handler didn't write anything at all, so let the
+			// ResponseWriter send its usual empty 200 response.
+			return
+		}
+		cw.decide(false)
+	}
+	if cw.writer == nil {
+		return
+	}
+	cw.writer.Close()
+	switch fw := cw.writer.(type) {
+	case *gzip.Writer:
+		fw.Reset(io.Discard)
+		gzipWriters.Put(fw)
+	case *flate.Writer:
+		fw.Reset(io.Discard)
+		flateWriters.Put(fw)
+	}
+	cw.writer = nil
+}
+
+// Unwrap() lets http.ResponseController reach the underlying ResponseWriter.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
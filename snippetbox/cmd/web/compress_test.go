@@ -0,0 +1,249 @@
+package main
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"snippetbox/internal/assert"
+)
+
+// The decompress() helper returns the body of a response, decompressing it
+// according to its Content-Encoding header.
+func decompress(t *testing.T, header http.Header, body io.Reader) string {
+	var r io.Reader
+	switch header.Get("Content-Encoding") {
+	case "gzip":
+		gr, err := gzip.NewReader(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer gr.Close()
+		r = gr
+	case "deflate":
+		fr := flate.NewReader(body)
+		defer fr.Close()
+		r = fr
+	default:
+		r = body
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"gzip, deflate, br", "gzip"},
+		{"deflate, gzip", "gzip"},
+		{"GZIP", "gzip"},
+		{"gzip;q=0.5, deflate", "deflate"},
+		{"gzip;q=0, deflate;q=0", ""},
+		{"gzip;q=abc", ""},
+		{"br", ""},
+		{"*", "gzip"},
+		{"identity", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			assert.Equal(t, negotiateEncoding(tt.header), tt.want)
+		})
+	}
+}
+
+func TestCompressResponse(t *testing.T) {
+	html := "<!doctype html>" + strings.Repeat("<p>An old silent pond...</p>", 100)
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		contentType    string
+		encoding       string
+		body           string
+		wantEncoding   string
+	}{
+		{
+			name:           "Gzip",
+			acceptEncoding: "gzip, deflate",
+			contentType:    "text/html; charset=utf-8",
+			body:           html,
+			wantEncoding:   "gzip",
+		},
+		{
+			name:           "Deflate",
+			acceptEncoding: "deflate",
+			contentType:    "application/json",
+			body:           html,
+			wantEncoding:   "deflate",
+		},
+		{
+			name:         "Not accepted",
+			contentType:  "text/html; charset=utf-8",
+			body:         html,
+			wantEncoding: "",
+		},
+		{
+			name:           "Tiny response",
+			acceptEncoding: "gzip",
+			contentType:    "text/plain; charset=utf-8",
+			body:           "Not Found",
+			wantEncoding:   "",
+		},
+		{
+			name:           "Already compressed type",
+			acceptEncoding: "gzip",
+			contentType:    "image/png",
+			body:           html,
+			wantEncoding:   "",
+		},
+		{
+			name:           "Already compressed body",
+			acceptEncoding: "gzip",
+			contentType:    "text/html; charset=utf-8",
+			encoding:       "br",
+			body:           html,
+			wantEncoding:   "br",
+		},
+		{
+			name:           "Sniffed content type",
+			acceptEncoding: "gzip",
+			body:           html,
+			wantEncoding:   "gzip",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.contentType != "" {
+					w.Header().Set("Content-Type", tt.contentType)
+				}
+				if tt.encoding != "" {
+					w.Header().Set("Content-Encoding", tt.encoding)
+				}
+				w.WriteHeader(http.StatusTeapot)
+				// Write the body in a few pieces, as templates do.
+				for i := 0; i < len(tt.body); i += 500 {
+					w.Write([]byte(tt.body[i:min(i+500, len(tt.body))]))
+				}
+			})
+
+			r, err := http.NewRequest(http.MethodGet, "/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.acceptEncoding != "" {
+				r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			rr := httptest.NewRecorder()
+			compressResponse(next).ServeHTTP(rr, r)
+
+			rs := rr.Result()
+			assert.Equal(t, rs.StatusCode, http.StatusTeapot)
+			assert.Equal(t, rs.Header.Get("Vary"), "Accept-Encoding")
+			assert.Equal(t, rs.Header.Get("Content-Encoding"), tt.wantEncoding)
+			if tt.contentType == "" {
+				assert.Equal(t, rs.Header.Get("Content-Type"), "text/html; charset=utf-8")
+			}
+			if tt.encoding != "" {
+				// We didn't compress it, so it's left as it was.
+				body, _ := io.ReadAll(rs.Body)
+				assert.Equal(t, string(body), tt.body)
+				return
+			}
+			if tt.wantEncoding != "" && rr.Body.Len() >= len(tt.body) {
+				t.Errorf("compressed body is %d bytes; uncompressed is %d", rr.Body.Len(), len(tt.body))
+			}
+			assert.Equal(t, decompress(t, rs.Header, rs.Body), tt.body)
+		})
+	}
+
+	t.Run("Flush", func(t *testing.T) {
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Write([]byte("Hello, "))
+			err := http.NewResponseController(w).Flush()
+			if err != nil {
+				t.Error(err)
+			}
+			w.Write([]byte("world!"))
+		})
+
+		r, err := http.NewRequest(http.MethodGet, "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Accept-Encoding", "gzip")
+		rr := httptest.NewRecorder()
+		compressResponse(next).ServeHTTP(rr, r)
+
+		// Even though the body is tiny, flushing means the handler wants to
+		// stream it, so it's compressed.
+		assert.Equal(t, rr.Flushed, true)
+		assert.Equal(t, rr.Header().Get("Content-Encoding"), "gzip")
+		assert.Equal(t, decompress(t, rr.Header(), rr.Body), "Hello, world!")
+	})
+
+	t.Run("No body", func(t *testing.T) {
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		})
+
+		r, err := http.NewRequest(http.MethodGet, "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Accept-Encoding", "gzip")
+		rr := httptest.NewRecorder()
+		compressResponse(next).ServeHTTP(rr, r)
+
+		assert.Equal(t, rr.Code, http.StatusNoContent)
+		assert.Equal(t, rr.Header().Get("Content-Encoding"), "")
+		assert.Equal(t, rr.Body.Len(), 0)
+	})
+}
+
+func TestCompressRoutes(t *testing.T) {
+	app := newTestApplication(t)
+	app.compress = true
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Setting Accept-Encoding ourselves stops the client from transparently
+	// decompressing the response, so we can check what was really sent.
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept-Encoding", "gzip")
+	rs, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Body.Close()
+
+	assert.Equal(t, rs.StatusCode, http.StatusOK)
+	assert.Equal(t, rs.Header.Get("Content-Encoding"), "gzip")
+	assert.StringContains(t, decompress(t, rs.Header, rs.Body), "An old silent pond")
+
+	// The flag turns it off.
+	app.compress = false
+	ts = newTestServer(t, app.routes())
+	defer ts.Close()
+	code, header, _ := ts.do(t, http.MethodGet, "/", nil, http.Header{"Accept-Encoding": {"gzip"}})
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, header.Get("Content-Encoding"), "")
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"snippetbox/internal/models"
+
+	"github.com/go-sql-driver/mysql"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix is prepended to the names of the environment variables which can
+// be used to set each setting. The rest of the name is the flag name in upper
+// case, with dashes replaced by underscores, so -db-max-open-conns can be set
+// with SNIPPETBOX_DB_MAX_OPEN_CONNS.
+const envPrefix = "SNIPPETBOX_"
+
+// Define a config struct to hold all the settings for the application. These
+// come from (in increasing order of precedence) the defaults, an optional
+// config file, environment variables and command-line flags.
+type config struct {
+	addr     string
+	db       dbConfig
+	debug    bool
+	env      string
+	metrics  bool
+	cacheTTL time.Duration
+	logLevel slog.Level
+	baseURL  string
+	smtp     struct {
+		host     string
+		port     int
+		username string
+		password string
+		sender   string
+	}
+	login struct {
+		maxAttempts int
+		window      time.Duration
+	}
+	bcryptCost      int
+	limiter         limiterConfig
+	proxyHeader     string
+	trustedProxies  prefixList
+	shutdownTimeout time.Duration
+	requestTimeout  time.Duration
+	session         sessionConfig
+	snippet         snippetLimits
+	robots          robotsConfig
+	home            homeConfig
+	purgeInterval   time.Duration
+	maxBodyBytes    int64
+	compress        bool
+	maintenance     bool
+	avatarDir       string
+	avatarMaxBytes  int64
+	adminEmail      string
+	createAdmin     bool
+	adminName       string
+	adminUsername   string
+	adminPassword   string
+	github          oauthClientConfig
+	google          oauthClientConfig
+	migrate         string
+	autoMigrate     bool
+}
+
+// oauthClientConfig holds the credentials for an app registered with an OAuth
+// provider.
+type oauthClientConfig struct {
+	clientID     string
+	clientSecret string
+}
+
+// The loadConfig() function reads the application's settings. Every setting is
+// defined as a flag, and the config file and environment variables are applied
+// by setting the flags which weren't given on the command line, so values in
+// all three places are parsed in exactly the same way.
+func loadConfig(args []string) (config, error) {
+	var cfg config
+	fs := flag.NewFlagSet("snippetbox", flag.ContinueOnError)
+
+	configFile := fs.String("config", "", "Path to a JSON or YAML config file")
+	fs.StringVar(&cfg.addr, "addr", ":4000", "HTTP network address")
+	// Read the database connection pool settings into a dbConfig struct.
+	fs.StringVar(&cfg.db.dsn, "dsn", "web:pass@/snippetbox?parseTime=true", "MySQL data source name")
+	fs.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "MySQL max open connections")
+	fs.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "MySQL max idle connections")
+	fs.DurationVar(&cfg.db.connMaxLifetime, "db-conn-max-lifetime", 5*time.Minute, "MySQL max connection lifetime")
+	fs.IntVar(&cfg.db.retries, "db-retries", 2, "Retry database operations which fail with a deadlock or dropped connection this many times")
+	fs.BoolVar(&cfg.debug, "debug", false, "Enable debug logging")
+	fs.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
+	fs.BoolVar(&cfg.metrics, "metrics", false, "Expose Prometheus metrics at /metrics")
+	// How long to cache the list of latest snippets for. A value of 0 turns
+	// the cache off.
+	fs.DurationVar(&cfg.cacheTTL, "cache-ttl", 5*time.Second, "Cache the latest snippets for this long (0 to disable)")
+	// The minimum level of log entries to write. slog.Level implements
+	// encoding.TextUnmarshaler, so fs.TextVar() accepts values like "debug",
+	// "info", "warn" or "error" for us.
+	fs.TextVar(&cfg.logLevel, "log-level", slog.LevelInfo, "Minimum log level (debug|info|warn|error)")
+	fs.StringVar(&cfg.baseURL, "base-url", "https://localhost:4000", "Public base URL used in email links")
+	// The SMTP server settings, using the Mailtrap sandbox as the default host.
+	fs.StringVar(&cfg.smtp.host, "smtp-host", "sandbox.smtp.mailtrap.io", "SMTP host")
+	fs.IntVar(&cfg.smtp.port, "smtp-port", 25, "SMTP port")
+	fs.StringVar(&cfg.smtp.username, "smtp-username", "", "SMTP username")
+	fs.StringVar(&cfg.smtp.password, "smtp-password", "", "SMTP password")
+	fs.StringVar(&cfg.smtp.sender, "smtp-sender", "Snippetbox <no-reply@snippetbox.example.com>", "SMTP sender")
+	// Brute-force protection settings for the login form.
+	fs.IntVar(&cfg.login.maxAttempts, "login-max-attempts", 5, "Maximum failed logins per IP or account within the window")
+	fs.DurationVar(&cfg.login.window, "login-window", 15*time.Minute, "Window for counting failed logins")
+	// Raising the bcrypt cost applies to new passwords straight away, and to
+	// existing ones the next time their users log in.
+	fs.IntVar(&cfg.bcryptCost, "bcrypt-cost", models.DefaultBcryptCost, "Cost used when hashing passwords with bcrypt")
+	// Settings for the per-IP request rate limiter.
+	fs.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
+	fs.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
+	fs.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+	// The addresses of the reverse proxies which we trust to tell us the real
+	// client IP address, and optionally the name of the header which they
+	// put it in. Without a header name, X-Forwarded-For and X-Real-IP are
+	// used.
+	fs.Var(&cfg.trustedProxies, "trusted-proxies", "Comma-separated IP addresses or CIDR ranges of trusted reverse proxies")
+	fs.StringVar(&cfg.proxyHeader, "trusted-proxy-header", "", "Header holding the client IP set by a trusted proxy (default X-Forwarded-For or X-Real-IP)")
+	fs.DurationVar(&cfg.shutdownTimeout, "shutdown-timeout", 30*time.Second, "Maximum time to wait for in-flight requests on shutdown")
+	// The request timeout should be shorter than the server's 10 second write
+	// timeout, otherwise the connection is closed before the 503 response
+	// can be sent.
+	fs.DurationVar(&cfg.requestTimeout, "request-timeout", 8*time.Second, "Maximum time to spend on a request before its response starts, after which a 503 response is sent")
+	// Sessions expire a fixed time after they were created, however active the
+	// user is. An idle timeout of 0 means that inactivity alone never logs
+	// anybody out.
+	fs.DurationVar(&cfg.session.lifetime, "session-lifetime", 12*time.Hour, "Maximum time a session lasts for")
+	fs.DurationVar(&cfg.session.idleTimeout, "session-idle-timeout", 0, "Expire sessions which haven't been used for this long (0 to disable)")
+	// The session cookie's attributes default to the most secure values. The
+	// Secure attribute can be turned off for local development over plain
+	// HTTP, and a different name or path is handy when several applications
+	// share a domain.
+	fs.StringVar(&cfg.session.cookie.name, "session-cookie-name", "session", "Name of the session cookie")
+	fs.StringVar(&cfg.session.cookie.path, "session-cookie-path", "/", "Path attribute of the session cookie")
+	fs.BoolVar(&cfg.session.cookie.secure, "session-cookie-secure", true, "Only send the session cookie over HTTPS")
+	fs.BoolVar(&cfg.session.cookie.httpOnly, "session-cookie-http-only", true, "Hide the session cookie from JavaScript")
+	// sameSite implements encoding.TextUnmarshaler, just like slog.Level, so
+	// anything other than "lax", "strict" or "none" is rejected while parsing.
+	fs.TextVar(&cfg.session.cookie.sameSite, "session-cookie-samesite", sameSite(http.SameSiteLaxMode), "SameSite attribute of the session cookie (lax|strict|none)")
+	// The longest title and content which a snippet can have, in characters.
+	fs.IntVar(&cfg.snippet.titleMax, "snippet-title-max", 100, "Maximum length of a snippet title in characters")
+	fs.IntVar(&cfg.snippet.contentMax, "snippet-content-max", 10_000, "Maximum length of a snippet's content in characters")
+	// How many snippets each user can pin to the top of their lists.
+	fs.IntVar(&cfg.snippet.maxPins, "snippet-max-pins", 5, "Maximum number of snippets each user can pin")
+	// The paths listed in robots.txt. By default crawlers are kept out of
+	// account pages, forms and the API, but can still find public profiles.
+	cfg.robots.allow = pathList{"/user/profile/"}
+	cfg.robots.disallow = pathList{"/account/", "/admin", "/api/", "/notifications", "/snippet/create", "/snippet/update/", "/snippet/report/", "/user/"}
+	fs.Var(&cfg.robots.allow, "robots-allow", "Comma-separated paths which robots.txt allows crawlers to visit")
+	fs.Var(&cfg.robots.disallow, "robots-disallow", "Comma-separated paths which robots.txt asks crawlers not to visit")
+	// The home page lists the newest snippets by default, but visitors can
+	// sort them another way with the sort query string parameter.
+	fs.IntVar(&cfg.home.perPage, "home-per-page", homeSnippetsPerPage, "Number of snippets on each page of the home page")
+	fs.StringVar(&cfg.home.sort, "home-sort", models.OrderNewest, "Default order of the snippets on the home page (newest|views|favourites)")
+	// Expired snippets are hidden straight away, and permanently deleted by a
+	// background job which runs this often.
+	fs.DurationVar(&cfg.purgeInterval, "purge-interval", time.Hour, "How often to delete expired snippets (0 to disable)")
+	fs.Int64Var(&cfg.maxBodyBytes, "max-body-bytes", 1_048_576, "Maximum size of a request body in bytes")
+	fs.BoolVar(&cfg.compress, "compress", true, "Compress responses with gzip or deflate for clients which accept it")
+	// Maintenance mode can also be toggled while the application is running,
+	// by an admin or by sending it a SIGHUP signal.
+	fs.BoolVar(&cfg.maintenance, "maintenance", false, "Start in maintenance mode, sending a 503 response to everyone except admins")
+	// Uploaded avatars are stored as files in this directory, which is
+	// created at startup if it doesn't exist.
+	fs.StringVar(&cfg.avatarDir, "avatar-dir", "./avatars", "Directory to store avatar images in")
+	fs.Int64Var(&cfg.avatarMaxBytes, "avatar-max-bytes", 524_288, "Maximum size of an uploaded avatar image in bytes")
+	fs.StringVar(&cfg.adminEmail, "admin-email", "", "Give the user with this email address the admin role on startup")
+	// With -create-admin the application creates a new admin user and then
+	// exits. Anything which isn't given here is asked for on the terminal,
+	// and the password is best set through the environment, so that it
+	// doesn't end up in the shell history.
+	fs.BoolVar(&cfg.createAdmin, "create-admin", false, "Create an admin user with -admin-name, -admin-username, -admin-email and -admin-password, then exit")
+	fs.StringVar(&cfg.adminName, "admin-name", "", "Name of the admin user for -create-admin")
+	fs.StringVar(&cfg.adminUsername, "admin-username", "", "Username of the admin user for -create-admin")
+	fs.StringVar(&cfg.adminPassword, "admin-password", "", "Password of the admin user for -create-admin")
+	// Logging in with GitHub or Google is only offered if a client ID has been
+	// registered with the provider.
+	fs.StringVar(&cfg.github.clientID, "github-client-id", "", "GitHub OAuth client ID")
+	fs.StringVar(&cfg.github.clientSecret, "github-client-secret", "", "GitHub OAuth client secret")
+	fs.StringVar(&cfg.google.clientID, "google-client-id", "", "Google OAuth client ID")
+	fs.StringVar(&cfg.google.clientSecret, "google-client-secret", "", "Google OAuth client secret")
+	// With -migrate the application migrates the database and then exits,
+	// instead of starting the server. Rolling back is only for development.
+	fs.StringVar(&cfg.migrate, "migrate", "", "Apply all database migrations (up) or roll back the latest one (down), then exit")
+	fs.BoolVar(&cfg.autoMigrate, "auto-migrate", false, "Apply any pending database migrations at startup")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return config{}, err
+	}
+	if fs.NArg() > 0 {
+		return config{}, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	// Make a note of the flags which were given on the command line, so that
+	// neither the config file nor the environment overrides them.
+	onCommandLine := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		onCommandLine[f.Name] = true
+	})
+
+	// The config file can be named in the environment too, which is handy
+	// when it's mounted into a container.
+	if !onCommandLine["config"] {
+		if path := os.Getenv(envName("config")); path != "" {
+			*configFile = path
+		}
+	}
+	if *configFile != "" {
+		values, err := readConfigFile(*configFile)
+		if err != nil {
+			return config{}, err
+		}
+		for name, value := range values {
+			if name == "config" || fs.Lookup(name) == nil {
+				return config{}, fmt.Errorf("config file %s: unknown setting %q", *configFile, name)
+			}
+			if onCommandLine[name] {
+				continue
+			}
+			err = fs.Set(name, value)
+			if err != nil {
+				return config{}, fmt.Errorf("config file %s: invalid value %q for %s: %w", *configFile, value, name, err)
+			}
+		}
+	}
+
+	// Environment variables override the config file. We check the
+	// environment for every flag, rather than looking for anything with our
+	// prefix, as the environment usually contains all sorts of other things.
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || f.Name == "config" || onCommandLine[f.Name] {
+			return
+		}
+		if value := os.Getenv(envName(f.Name)); value != "" {
+			if setErr := fs.Set(f.Name, value); setErr != nil {
+				err = fmt.Errorf("invalid value %q for %s: %w", value, envName(f.Name), setErr)
+			}
+		}
+	})
+	if err != nil {
+		return config{}, err
+	}
+
+	err = cfg.validate()
+	if err != nil {
+		return config{}, err
+	}
+	return cfg, nil
+}
+
+// The envName() function returns the name of the environment variable for a
+// flag.
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// The readConfigFile() function reads a config file, which holds a single
+// object mapping flag names to their values. Files ending in .yaml or .yml are
+// read as YAML, and anything else as JSON. The values are returned as strings
+// ready for passing to flag.Set(), so a duration can be written as "5m" just
+// like on the command line.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]any
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	default:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		err = dec.Decode(&raw)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	values := make(map[string]string, len(raw))
+	for name, value := range raw {
+		switch value.(type) {
+		case string, bool, int, float64, json.Number:
+			values[name] = fmt.Sprint(value)
+		default:
+			return nil, fmt.Errorf("config file %s: %s must be a string, number or boolean", path, name)
+		}
+	}
+	return values, nil
+}
+
+// The validate() method checks the settings which the application can't
+// start without, so that a mistake in the config is reported clearly straight
+// away rather than as a confusing error later on.
+func (cfg config) validate() error {
+	if cfg.db.dsn == "" {
+		return errors.New("a MySQL data source name is required: set -dsn or " + envName("dsn"))
+	}
+	_, err := mysql.ParseDSN(cfg.db.dsn)
+	if err != nil {
+		return fmt.Errorf("invalid MySQL data source name: %w", err)
+	}
+	err = cfg.db.validate()
+	if err != nil {
+		return err
+	}
+	switch cfg.env {
+	case "development", "staging", "production":
+	default:
+		return fmt.Errorf("env must be development, staging or production, not %q", cfg.env)
+	}
+	switch cfg.migrate {
+	case "", "up":
+	case "down":
+		if cfg.env != "development" {
+			return fmt.Errorf("migrate down is only allowed in development, not %s", cfg.env)
+		}
+	default:
+		return fmt.Errorf("migrate must be up or down, not %q", cfg.migrate)
+	}
+	if cfg.createAdmin && cfg.migrate != "" {
+		return errors.New("create-admin and migrate can't be used together")
+	}
+	if cfg.proxyHeader != "" && len(cfg.trustedProxies) == 0 {
+		return errors.New("trusted-proxy-header has no effect unless trusted-proxies is set")
+	}
+	if cfg.bcryptCost < bcrypt.MinCost || cfg.bcryptCost > bcrypt.MaxCost {
+		return fmt.Errorf("bcrypt-cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	if cfg.requestTimeout <= 0 {
+		return errors.New("request-timeout must be greater than zero")
+	}
+	err = cfg.session.validate()
+	if err != nil {
+		return err
+	}
+	err = cfg.snippet.validate()
+	if err != nil {
+		return err
+	}
+	err = cfg.robots.validate()
+	if err != nil {
+		return err
+	}
+	err = cfg.home.validate()
+	if err != nil {
+		return err
+	}
+	if cfg.purgeInterval < 0 {
+		return errors.New("purge-interval must not be negative")
+	}
+	if cfg.maxBodyBytes < 1 {
+		return errors.New("max-body-bytes must be at least 1")
+	}
+	if cfg.avatarMaxBytes < 1 {
+		return errors.New("avatar-max-bytes must be at least 1")
+	}
+	if cfg.avatarDir == "" {
+		return errors.New("avatar-dir must not be empty")
+	}
+	if cfg.baseURL == "" {
+		return errors.New("base-url must not be empty")
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"snippetbox/internal/assert"
+)
+
+// The writeConfigFile() helper writes a config file with the given name and
+// contents to a temporary directory, and returns its path.
+func writeConfigFile(t *testing.T, name, contents string) string {
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, []byte(contents), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Run("Defaults", func(t *testing.T) {
+		cfg, err := loadConfig(nil)
+		assert.NilError(t, err)
+		assert.Equal(t, cfg.addr, ":4000")
+		assert.Equal(t, cfg.db.dsn, "web:pass@/snippetbox?parseTime=true")
+		assert.Equal(t, cfg.db.maxOpenConns, 25)
+		assert.Equal(t, cfg.db.retries, 2)
+		assert.Equal(t, cfg.env, "development")
+		assert.Equal(t, cfg.logLevel, slog.LevelInfo)
+		assert.Equal(t, cfg.limiter.enabled, true)
+		assert.Equal(t, cfg.compress, true)
+		assert.Equal(t, cfg.session.lifetime, 12*time.Hour)
+		assert.Equal(t, cfg.session.idleTimeout, time.Duration(0))
+		assert.Equal(t, cfg.snippet.titleMax, 100)
+		assert.Equal(t, cfg.snippet.contentMax, 10_000)
+		assert.Equal(t, cfg.snippet.maxPins, 5)
+		assert.Equal(t, cfg.robots.allow.String(), "/user/profile/")
+		assert.Equal(t, cfg.robots.disallow.String(), "/account/,/admin,/api/,/notifications,/snippet/create,/snippet/update/,/snippet/report/,/user/")
+		assert.Equal(t, cfg.home.perPage, 10)
+		assert.Equal(t, cfg.home.sort, "newest")
+	})
+
+	t.Run("Trusted proxies", func(t *testing.T) {
+		// Single addresses are ranges of one address, and any host bits in a
+		// range are cleared.
+		cfg, err := loadConfig([]string{"-trusted-proxies", "10.1.2.3/8, 192.0.2.1,2001:db8::1"})
+		assert.NilError(t, err)
+		assert.Equal(t, cfg.trustedProxies.String(), "10.0.0.0/8,192.0.2.1/32,2001:db8::1/128")
+	})
+
+	t.Run("Precedence", func(t *testing.T) {
+		path := writeConfigFile(t, "config.json", `{
+			"addr": ":5000",
+			"smtp-port": 2525,
+			"cache-ttl": "1m",
+			"limiter-rps": 0.5,
+			"debug": true,
+			"env": "staging"
+		}`)
+		t.Setenv("SNIPPETBOX_SMTP_PORT", "587")
+		t.Setenv("SNIPPETBOX_ENV", "production")
+		t.Setenv("SNIPPETBOX_LOG_LEVEL", "warn")
+
+		cfg, err := loadConfig([]string{"-config", path, "-env", "development"})
+		assert.NilError(t, err)
+		// Only in the config file.
+		assert.Equal(t, cfg.addr, ":5000")
+		assert.Equal(t, cfg.cacheTTL, time.Minute)
+		assert.Equal(t, cfg.limiter.rps, 0.5)
+		assert.Equal(t, cfg.debug, true)
+		// The environment overrides the config file...
+		assert.Equal(t, cfg.smtp.port, 587)
+		assert.Equal(t, cfg.logLevel, slog.LevelWarn)
+		// ...and flags override both.
+		assert.Equal(t, cfg.env, "development")
+		// Settings which aren't set anywhere keep their defaults.
+		assert.Equal(t, cfg.smtp.host, "sandbox.smtp.mailtrap.io")
+	})
+
+	t.Run("YAML file from the environment", func(t *testing.T) {
+		path := writeConfigFile(t, "config.yaml", "dsn: app:secret@tcp(db:3306)/snippetbox?parseTime=true\nlimiter-enabled: false\nlimiter-burst: 10\n")
+		t.Setenv("SNIPPETBOX_CONFIG", path)
+
+		cfg, err := loadConfig(nil)
+		assert.NilError(t, err)
+		assert.Equal(t, cfg.db.dsn, "app:secret@tcp(db:3306)/snippetbox?parseTime=true")
+		assert.Equal(t, cfg.limiter.enabled, false)
+		assert.Equal(t, cfg.limiter.burst, 10)
+	})
+
+	tests := []struct {
+		name    string
+		file    string
+		env     map[string]string
+		args    []string
+		wantErr string
+	}{
+		{
+			name:    "Missing DSN",
+			args:    []string{"-dsn", ""},
+			wantErr: "a MySQL data source name is required: set -dsn or SNIPPETBOX_DSN",
+		},
+		{
+			name:    "Invalid DSN",
+			env:     map[string]string{"SNIPPETBOX_DSN": "not a dsn"},
+			wantErr: "invalid MySQL data source name",
+		},
+		{
+			name:    "Invalid environment value",
+			env:     map[string]string{"SNIPPETBOX_SMTP_PORT": "smtp"},
+			wantErr: `invalid value "smtp" for SNIPPETBOX_SMTP_PORT`,
+		},
+		{
+			name:    "Unknown env",
+			args:    []string{"-env", "testing"},
+			wantErr: `env must be development, staging or production, not "testing"`,
+		},
+		{
+			name:    "Idle greater than open",
+			args:    []string{"-db-max-open-conns", "5", "-db-max-idle-conns", "10"},
+			wantErr: "db-max-idle-conns (10) must not be greater than db-max-open-conns (5)",
+		},
+		{
+			name:    "Negative retries",
+			args:    []string{"-db-retries", "-1"},
+			wantErr: "db-retries must not be negative",
+		},
+		{
+			name:    "Unknown migrate direction",
+			args:    []string{"-migrate", "sideways"},
+			wantErr: `migrate must be up or down, not "sideways"`,
+		},
+		{
+			name:    "Migrate down in production",
+			env:     map[string]string{"SNIPPETBOX_ENV": "production"},
+			args:    []string{"-migrate", "down"},
+			wantErr: "migrate down is only allowed in development, not production",
+		},
+		{
+			name:    "Create admin while migrating",
+			args:    []string{"-create-admin", "-migrate", "up"},
+			wantErr: "create-admin and migrate can't be used together",
+		},
+		{
+			name:    "Bcrypt cost too low",
+			args:    []string{"-bcrypt-cost", "3"},
+			wantErr: "bcrypt-cost must be between 4 and 31",
+		},
+		{
+			name:    "Zero request timeout",
+			args:    []string{"-request-timeout", "0s"},
+			wantErr: "request-timeout must be greater than zero",
+		},
+		{
+			name:    "Zero session lifetime",
+			args:    []string{"-session-lifetime", "0s"},
+			wantErr: "session-lifetime must be greater than zero",
+		},
+		{
+			name:    "Negative session idle timeout",
+			args:    []string{"-session-idle-timeout", "-1m"},
+			wantErr: "session-idle-timeout must not be negative",
+		},
+		{
+			name:    "Session idle timeout longer than lifetime",
+			args:    []string{"-session-lifetime", "1h", "-session-idle-timeout", "2h"},
+			wantErr: "session-idle-timeout must not be longer than session-lifetime",
+		},
+		{
+			name:    "Unknown SameSite value",
+			args:    []string{"-session-cookie-samesite", "sometimes"},
+			wantErr: `must be lax, strict or none, not "sometimes"`,
+		},
+		{
+			name:    "SameSite none without Secure",
+			args:    []string{"-session-cookie-samesite", "none", "-session-cookie-secure=false"},
+			wantErr: "session-cookie-samesite none requires session-cookie-secure",
+		},
+		{
+			name:    "Empty session cookie name",
+			args:    []string{"-session-cookie-name", ""},
+			wantErr: "session-cookie-name must not be empty",
+		},
+		{
+			name:    "Relative session cookie path",
+			args:    []string{"-session-cookie-path", "app"},
+			wantErr: "session-cookie-path must start with /",
+		},
+		{
+			name:    "Snippet title max too long for the column",
+			args:    []string{"-snippet-title-max", "101"},
+			wantErr: "snippet-title-max must be between 1 and 100, not 101",
+		},
+		{
+			name:    "Zero snippet content max",
+			args:    []string{"-snippet-content-max", "0"},
+			wantErr: "snippet-content-max must be between 1 and 16383, not 0",
+		},
+		{
+			name:    "Zero snippet max pins",
+			args:    []string{"-snippet-max-pins", "0"},
+			wantErr: "snippet-max-pins must be at least 1, not 0",
+		},
+		{
+			name:    "Invalid trusted proxy",
+			args:    []string{"-trusted-proxies", "10.0.0.0/8,not-an-ip"},
+			wantErr: `invalid value "10.0.0.0/8,not-an-ip" for flag -trusted-proxies`,
+		},
+		{
+			name:    "Proxy header without trusted proxies",
+			args:    []string{"-trusted-proxy-header", "X-Forwarded-For"},
+			wantErr: "trusted-proxy-header has no effect unless trusted-proxies is set",
+		},
+		{
+			name:    "Relative robots path",
+			args:    []string{"-robots-disallow", "/account/, admin"},
+			wantErr: `robots-disallow paths must start with / and not contain spaces, not "admin"`,
+		},
+		{
+			name:    "Robots path with a space",
+			args:    []string{"-robots-allow", "/user/my profile"},
+			wantErr: `robots-allow paths must start with / and not contain spaces, not "/user/my profile"`,
+		},
+		{
+			name:    "Zero home page size",
+			args:    []string{"-home-per-page", "0"},
+			wantErr: "home-per-page must be between 1 and 100, not 0",
+		},
+		{
+			name:    "Unknown home sort",
+			args:    []string{"-home-sort", "title"},
+			wantErr: `home-sort must be one of newest, views, favourites, not "title"`,
+		},
+		{
+			name:    "Negative purge interval",
+			args:    []string{"-purge-interval", "-1h"},
+			wantErr: "purge-interval must not be negative",
+		},
+		{
+			name:    "Zero max body bytes",
+			args:    []string{"-max-body-bytes", "0"},
+			wantErr: "max-body-bytes must be at least 1",
+		},
+		{
+			name:    "Zero avatar max bytes",
+			args:    []string{"-avatar-max-bytes", "0"},
+			wantErr: "avatar-max-bytes must be at least 1",
+		},
+		{
+			name:    "Unknown setting in file",
+			file:    `{"adress": ":5000"}`,
+			wantErr: `unknown setting "adress"`,
+		},
+		{
+			name:    "Invalid value in file",
+			file:    `{"login-window": "fifteen minutes"}`,
+			wantErr: `invalid value "fifteen minutes" for login-window`,
+		},
+		{
+			name:    "Nested value in file",
+			file:    `{"smtp": {"host": "localhost"}}`,
+			wantErr: "smtp must be a string, number or boolean",
+		},
+		{
+			name:    "Malformed file",
+			file:    `{"addr": ":5000",}`,
+			wantErr: "invalid character",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeConfigFile(t, "config.json", tt.file)}, args...)
+			}
+			_, err := loadConfig(args)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			assert.StringContains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
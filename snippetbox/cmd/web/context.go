@@ -1,5 +1,80 @@
 package main
 
+import (
+	"context"
+	"net/http"
+	"snippetbox/internal/i18n"
+	"snippetbox/internal/models"
+)
+
 type contextKey string
 
 const isAuthenticatedContextKey = contextKey("isAuthenticated")
+
+// userContextKey is the key under which the authenticateAPIToken middleware
+// stores the user that an API request was made by.
+const userContextKey = contextKey("user")
+
+// requestIDContextKey is the key under which the requestID middleware stores
+// the unique ID that it generates for each request.
+const requestIDContextKey = contextKey("requestID")
+
+// clientIPContextKey is the key under which the realIP middleware stores the
+// IP address of the client which made the request.
+const clientIPContextKey = contextKey("clientIP")
+
+// cspNonceContextKey is the key under which the secureHeaders middleware
+// stores the nonce that it includes in the Content-Security-Policy header.
+const cspNonceContextKey = contextKey("cspNonce")
+
+// The contextGetCSPNonce() helper retrieves the Content-Security-Policy nonce
+// from a context. It returns an empty string if there isn't one (for example,
+// in tests which call a handler directly without the middleware).
+func contextGetCSPNonce(ctx context.Context) string {
+	nonce, _ := ctx.Value(cspNonceContextKey).(string)
+	return nonce
+}
+
+// localizerContextKey is the key under which the localize middleware stores
+// the i18n.Localizer for the request.
+const localizerContextKey = contextKey("localizer")
+
+// The contextGetLocalizer() helper retrieves the i18n.Localizer for the
+// request. If there isn't one (because the route doesn't use the localize
+// middleware) then we return a Localizer for the default language, so that
+// templates can always be rendered.
+func contextGetLocalizer(r *http.Request) *i18n.Localizer {
+	l, ok := r.Context().Value(localizerContextKey).(*i18n.Localizer)
+	if !ok {
+		return i18n.NewLocalizer()
+	}
+	return l
+}
+
+// The contextGetRequestID() helper retrieves the request ID from a context. It
+// takes a context.Context rather than a *http.Request so that it can also be
+// used by our slog handler, and returns false if there isn't an ID -- for
+// example, in log entries which aren't made during a request.
+func contextGetRequestID(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIDContextKey).(string)
+	return id, ok
+}
+
+// The contextSetUser() helper returns a new copy of the request with the
+// provided User struct added to the context.
+func (app *application) contextSetUser(r *http.Request, user *models.User) *http.Request {
+	ctx := context.WithValue(r.Context(), userContextKey, user)
+	return r.WithContext(ctx)
+}
+
+// The contextGetUser() helper retrieves the User struct from the request
+// context. We only call this from handlers behind the authenticateAPIToken
+// middleware, so if the value is missing it's an unexpected error and we
+// panic.
+func (app *application) contextGetUser(r *http.Request) *models.User {
+	user, ok := r.Context().Value(userContextKey).(*models.User)
+	if !ok {
+		panic("missing user value in request context")
+	}
+	return user
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"snippetbox/internal/models"
+)
+
+// The kinds of line in a diff.
+const (
+	diffContext = "context"
+	diffAdded   = "added"
+	diffRemoved = "removed"
+)
+
+// diffContextLines is how many unchanged lines are shown around each change,
+// the same as the default for diff -u and git diff.
+const diffContextLines = 3
+
+// maxDiffCells limits the size of the table which diffLines() fills in. Two
+// snippets of a few thousand completely different lines would otherwise need
+// hundreds of megabytes, so past this point we stop looking for lines which
+// the two versions have in common and show the whole of the middle as
+// replaced.
+const maxDiffCells = 4_000_000
+
+// diffLine is one line of a diff. Kind is diffContext, diffAdded or
+// diffRemoved.
+type diffLine struct {
+	Kind string
+	Text string
+}
+
+// diffHunk is a group of changed lines and the context around them, like the
+// hunks of a unified diff. Header is the "@@ -1,4 +1,5 @@" line which says
+// where the hunk starts and how long it is in each version.
+type diffHunk struct {
+	Header string
+	Lines  []diffLine
+}
+
+// revisionDiff holds what the diff page needs: the two revisions being
+// compared and the hunks of the diff between them.
+type revisionDiff struct {
+	From  *models.SnippetRevision
+	To    *models.SnippetRevision
+	Hunks []diffHunk
+}
+
+// splitLines splits text into lines. Browsers send form fields with CRLF line
+// endings, so those are treated the same as LF, and a trailing newline
+// doesn't count as an extra empty line.
+func splitLines(text string) []string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// diffLines works out which lines were removed from a and added in b, by
+// finding the longest common subsequence of their lines. Any lines which the
+// two start and end with are matched up first, as most edits only change a
+// small part of a snippet and this keeps the table small.
+func diffLines(a, b []string) []diffLine {
+	var prefix, suffix int
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	lines := make([]diffLine, 0, len(a)+len(b))
+	for _, text := range a[:prefix] {
+		lines = append(lines, diffLine{Kind: diffContext, Text: text})
+	}
+
+	common := b[len(b)-suffix:]
+	a, b = a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(a)*len(b) > maxDiffCells {
+		for _, text := range a {
+			lines = append(lines, diffLine{Kind: diffRemoved, Text: text})
+		}
+		for _, text := range b {
+			lines = append(lines, diffLine{Kind: diffAdded, Text: text})
+		}
+	} else {
+		// lcs[i][j] is the length of the longest common subsequence of a[i:]
+		// and b[j:].
+		lcs := make([][]int, len(a)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(b)+1)
+		}
+		for i := len(a) - 1; i >= 0; i-- {
+			for j := len(b) - 1; j >= 0; j-- {
+				if a[i] == b[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else {
+					lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+				}
+			}
+		}
+		// Walk through the table from the start, preferring removals over
+		// additions so that a changed line is shown as the old line followed
+		// by the new one.
+		i, j := 0, 0
+		for i < len(a) || j < len(b) {
+			switch {
+			case i < len(a) && j < len(b) && a[i] == b[j]:
+				lines = append(lines, diffLine{Kind: diffContext, Text: a[i]})
+				i++
+				j++
+			case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+				lines = append(lines, diffLine{Kind: diffRemoved, Text: a[i]})
+				i++
+			default:
+				lines = append(lines, diffLine{Kind: diffAdded, Text: b[j]})
+				j++
+			}
+		}
+	}
+
+	for _, text := range common {
+		lines = append(lines, diffLine{Kind: diffContext, Text: text})
+	}
+	return lines
+}
+
+// unifiedDiff returns the hunks of a unified diff between two texts, with
+// up to context unchanged lines around each change. Changes which are close
+// enough for their context to overlap go in the same hunk. If the texts are
+// the same there are no hunks.
+func unifiedDiff(a, b string, context int) []diffHunk {
+	lines := diffLines(splitLines(a), splitLines(b))
+
+	var hunks []diffHunk
+	// oldLine and newLine count the lines of each version before lines[i].
+	oldLine, newLine := 0, 0
+	for i := 0; i < len(lines); {
+		if lines[i].Kind == diffContext {
+			oldLine++
+			newLine++
+			i++
+			continue
+		}
+		// Start the hunk context lines before the change, and keep going
+		// until there's a run of more than 2*context unchanged lines or the
+		// end of the diff.
+		start := max(0, i-context)
+		oldStart, newStart := oldLine-(i-start), newLine-(i-start)
+		end, unchanged := i, 0
+		for ; end < len(lines) && unchanged <= 2*context; end++ {
+			if lines[end].Kind == diffContext {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+		}
+		end -= max(0, unchanged-context)
+
+		hunk := diffHunk{Lines: lines[start:end]}
+		var oldCount, newCount int
+		for _, line := range hunk.Lines {
+			if line.Kind != diffAdded {
+				oldCount++
+			}
+			if line.Kind != diffRemoved {
+				newCount++
+			}
+		}
+		hunk.Header = fmt.Sprintf("@@ -%s +%s @@", hunkRange(oldStart, oldCount), hunkRange(newStart, newCount))
+		hunks = append(hunks, hunk)
+
+		oldLine, newLine = oldStart+oldCount, newStart+newCount
+		i = end
+	}
+	return hunks
+}
+
+// hunkRange formats the start and length of one side of a hunk for its
+// header. Lines are numbered from 1, and like diff -u a hunk which has no
+// lines on one side gives the number of the line before it, with the length
+// left out when it's 1.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return strconv.Itoa(start + 1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// snippetDiff shows what changed between two revisions of one of the user's
+// snippets, chosen with the from and to query string parameters. Both
+// revisions have to belong to the snippet, so it's no use guessing the ID of
+// a revision of somebody else's snippet.
+func (app *application) snippetDiff(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.ownedSnippet(w, r)
+	if !ok {
+		return
+	}
+	qs := r.URL.Query()
+	fromID, err := strconv.Atoi(qs.Get("from"))
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	toID, err := strconv.Atoi(qs.Get("to"))
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	revisions, err := app.snippets.Revisions(r.Context(), snippet.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	from, to := findRevision(revisions, fromID), findRevision(revisions, toID)
+	if from == nil || to == nil {
+		app.notFound(w, r)
+		return
+	}
+	data := app.newTemplateData(r)
+	data.Snippet = snippet
+	data.Diff = &revisionDiff{
+		From:  from,
+		To:    to,
+		Hunks: unifiedDiff(from.Content, to.Content, diffContextLines),
+	}
+	app.render(w, r, http.StatusOK, "diff.html", data)
+}
+
+// findRevision returns the revision with the given ID, or nil if it isn't one
+// of revisions.
+func findRevision(revisions []*models.SnippetRevision, id int) *models.SnippetRevision {
+	i := slices.IndexFunc(revisions, func(r *models.SnippetRevision) bool { return r.ID == id })
+	if i < 0 {
+		return nil
+	}
+	return revisions[i]
+}
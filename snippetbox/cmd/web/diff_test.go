@@ -0,0 +1,258 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"snippetbox/internal/assert"
+	"snippetbox/internal/i18n"
+	"snippetbox/internal/models"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	before := "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"hello\")\n}\n"
+	after := "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"hello, world\")\n\tfmt.Println(\"goodbye\")\n}\n"
+
+	tests := []struct {
+		name string
+		a, b string
+		want []diffHunk
+	}{
+		{
+			name: "Changed line",
+			a:    before,
+			b:    after,
+			want: []diffHunk{{
+				Header: "@@ -3,5 +3,6 @@",
+				Lines: []diffLine{
+					{Kind: diffContext, Text: `import "fmt"`},
+					{Kind: diffContext, Text: ""},
+					{Kind: diffContext, Text: "func main() {"},
+					{Kind: diffRemoved, Text: "\tfmt.Println(\"hello\")"},
+					{Kind: diffAdded, Text: "\tfmt.Println(\"hello, world\")"},
+					{Kind: diffAdded, Text: "\tfmt.Println(\"goodbye\")"},
+					{Kind: diffContext, Text: "}"},
+				},
+			}},
+		},
+		{
+			name: "Same",
+			a:    before,
+			b:    before,
+			want: nil,
+		},
+		{
+			name: "CRLF line endings",
+			a:    "one\r\ntwo\r\n",
+			b:    "one\ntwo",
+			want: nil,
+		},
+		{
+			name: "From empty",
+			a:    "",
+			b:    "one\ntwo",
+			want: []diffHunk{{
+				Header: "@@ -0,0 +1,2 @@",
+				Lines: []diffLine{
+					{Kind: diffAdded, Text: "one"},
+					{Kind: diffAdded, Text: "two"},
+				},
+			}},
+		},
+		{
+			name: "Removed line",
+			a:    "one\ntwo\nthree",
+			b:    "one\nthree",
+			want: []diffHunk{{
+				Header: "@@ -1,3 +1,2 @@",
+				Lines: []diffLine{
+					{Kind: diffContext, Text: "one"},
+					{Kind: diffRemoved, Text: "two"},
+					{Kind: diffContext, Text: "three"},
+				},
+			}},
+		},
+		{
+			name: "Separate hunks",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12",
+			b:    "one\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\ntwelve",
+			want: []diffHunk{
+				{
+					Header: "@@ -1,4 +1,4 @@",
+					Lines: []diffLine{
+						{Kind: diffRemoved, Text: "1"},
+						{Kind: diffAdded, Text: "one"},
+						{Kind: diffContext, Text: "2"},
+						{Kind: diffContext, Text: "3"},
+						{Kind: diffContext, Text: "4"},
+					},
+				},
+				{
+					Header: "@@ -9,4 +9,4 @@",
+					Lines: []diffLine{
+						{Kind: diffContext, Text: "9"},
+						{Kind: diffContext, Text: "10"},
+						{Kind: diffContext, Text: "11"},
+						{Kind: diffRemoved, Text: "12"},
+						{Kind: diffAdded, Text: "twelve"},
+					},
+				},
+			},
+		},
+		{
+			name: "Nearby changes share a hunk",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8",
+			b:    "one\n2\n3\n4\n5\n6\n7\neight",
+			want: []diffHunk{{
+				Header: "@@ -1,8 +1,8 @@",
+				Lines: []diffLine{
+					{Kind: diffRemoved, Text: "1"},
+					{Kind: diffAdded, Text: "one"},
+					{Kind: diffContext, Text: "2"},
+					{Kind: diffContext, Text: "3"},
+					{Kind: diffContext, Text: "4"},
+					{Kind: diffContext, Text: "5"},
+					{Kind: diffContext, Text: "6"},
+					{Kind: diffContext, Text: "7"},
+					{Kind: diffRemoved, Text: "8"},
+					{Kind: diffAdded, Text: "eight"},
+				},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := unifiedDiff(tt.a, tt.b, diffContextLines)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got: %q; want: %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffLinesTooLarge(t *testing.T) {
+	// Past maxDiffCells the middle of the texts is shown as replaced, but
+	// the lines which they start and end with are still matched up.
+	n := 2_001
+	a := []string{"first"}
+	b := []string{"first"}
+	for i := 0; i < n; i++ {
+		a = append(a, "a")
+		b = append(b, "b")
+	}
+	a = append(a, "last")
+	b = append(b, "last")
+
+	lines := diffLines(a, b)
+	assert.Equal(t, len(lines), 2*n+2)
+	assert.Equal(t, lines[0], diffLine{Kind: diffContext, Text: "first"})
+	assert.Equal(t, lines[1], diffLine{Kind: diffRemoved, Text: "a"})
+	assert.Equal(t, lines[n+1], diffLine{Kind: diffAdded, Text: "b"})
+	assert.Equal(t, lines[2*n+1], diffLine{Kind: diffContext, Text: "last"})
+}
+
+func TestSnippetDiff(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		code, header, _ := ts.get(t, "/snippet/view/1/diff?from=1&to=2")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/login")
+	})
+
+	csrfToken := ts.login(t)
+
+	// Two edits of snippet 1 give it two revisions.
+	for _, title := range []string{"An updated pond", "A frog"} {
+		form := url.Values{}
+		form.Add("title", title)
+		form.Add("content", "A frog jumps into the pond")
+		form.Add("expires", "7")
+		form.Add("visibility", "public")
+		form.Add("csrf_token", csrfToken)
+		code, _, _ := ts.postForm(t, "/snippet/update/1", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+	}
+
+	t.Run("History links to the diff", func(t *testing.T) {
+		_, _, body := ts.get(t, "/snippet/view/1/history")
+		assert.StringContains(t, body, "<form class='compare' action='/snippet/view/1/diff' method='GET'>")
+	})
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Valid",
+			urlPath:  "/snippet/view/1/diff?from=1&to=2",
+			wantCode: http.StatusOK,
+			wantBody: "The content of these versions is the same.",
+		},
+		{
+			name:     "Missing revision",
+			urlPath:  "/snippet/view/1/diff?from=1",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Non-existent revision",
+			urlPath:  "/snippet/view/1/diff?from=1&to=99",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Revisions of another snippet",
+			urlPath:  "/snippet/view/6/diff?from=1&to=2",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Non-owner",
+			urlPath:  "/snippet/view/3/diff?from=1&to=2",
+			wantCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestRenderDiff(t *testing.T) {
+	app := newTestApplication(t)
+
+	// render() reads the time zone from the session, so the request needs a
+	// session in its context.
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	ctx, err := app.sessionManager.Load(r.Context(), "")
+	assert.NilError(t, err)
+	r = r.WithContext(ctx)
+
+	data := &templateData{
+		Snippet: &models.Snippet{ID: 1, Title: "An old silent pond"},
+		Diff: &revisionDiff{
+			From:  &models.SnippetRevision{Title: "An old pond"},
+			To:    &models.SnippetRevision{Title: "An old silent pond"},
+			Hunks: unifiedDiff("An old pond\n<frog>", "An old silent pond\n<frog>", diffContextLines),
+		},
+		Localizer: i18n.NewLocalizer(),
+	}
+
+	rr := httptest.NewRecorder()
+	app.render(rr, r, http.StatusOK, "diff.html", data)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	// The lines are escaped like any other text in a template.
+	assert.StringContains(t, rr.Body.String(), "<span class='hunk'>@@ -1,2 &#43;1,2 @@</span><span class='removed'>-An old pond</span><span class='added'>+An old silent pond</span><span class='context'> &lt;frog&gt;</span>")
+}
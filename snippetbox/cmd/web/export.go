@@ -0,0 +1,245 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"snippetbox/internal/models"
+)
+
+// exportVersion is the version of the JSON export format. It's written at the
+// top of every export, and an import is refused if it doesn't match, so that
+// the format can change later without old files being misread.
+const exportVersion = 1
+
+// accountExport is the JSON document which accountExportJSON writes and
+// accountImportPost reads back.
+type accountExport struct {
+	Version  int               `json:"version"`
+	Exported time.Time         `json:"exported"`
+	User     exportedUser      `json:"user"`
+	Snippets []exportedSnippet `json:"snippets"`
+}
+
+// exportedUser is the user's profile in an export. The password hash and the
+// two-factor secret are deliberately left out.
+type exportedUser struct {
+	Name         string    `json:"name"`
+	Username     string    `json:"username,omitempty"`
+	Email        string    `json:"email"`
+	Created      time.Time `json:"created"`
+	Timezone     string    `json:"timezone"`
+	Theme        string    `json:"theme"`
+	UniqueTitles bool      `json:"unique_titles"`
+}
+
+// exportedSnippet is one snippet in an export. Expires is null for a snippet
+// which never expires.
+type exportedSnippet struct {
+	Title      string     `json:"title"`
+	Content    string     `json:"content"`
+	Created    time.Time  `json:"created"`
+	Expires    *time.Time `json:"expires"`
+	Visibility string     `json:"visibility"`
+	Language   string     `json:"language"`
+	Tags       []string   `json:"tags"`
+}
+
+// accountExportJSON sends the user's profile and all of their snippets as a
+// single JSON document, for moving them to another account or another
+// Snippetbox. Like accountExport, the snippets are streamed as they're read
+// from the database rather than built up in memory, so we write the enclosing
+// object by hand and only encode one snippet at a time. Once the first bytes
+// have been sent an error can only be logged, and the document is left
+// unfinished, so that it can't be mistaken for a complete export.
+func (app *application) accountExportJSON(w http.ResponseWriter, r *http.Request) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	user, err := app.users.Get(r.Context(), userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	tags, err := app.snippets.TagsForUser(r.Context(), userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	profile, err := json.Marshal(exportedUser{
+		Name:         user.Name,
+		Username:     user.Username,
+		Email:        user.Email,
+		Created:      user.Created.UTC(),
+		Timezone:     user.Timezone,
+		Theme:        user.Theme,
+		UniqueTitles: user.UniqueTitles,
+	})
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="snippetbox.json"`)
+
+	// Each snippet is flushed to the client as soon as it's written, so the
+	// download makes progress even when the database is slow. Flushing also
+	// starts the response straight away, which stops the timeout middleware
+	// from cutting a long export short.
+	rc := http.NewResponseController(w)
+	fmt.Fprintf(w, `{"version":%d,"exported":"%s","user":%s,"snippets":[`, exportVersion, time.Now().UTC().Format(time.RFC3339), profile)
+	rc.Flush()
+	first := true
+	for s, err := range app.snippets.IterateFor(r.Context(), userID) {
+		if err != nil {
+			app.logger.ErrorContext(r.Context(), err.Error())
+			return
+		}
+		snippet := exportedSnippet{
+			Title:      s.Title,
+			Content:    s.Content,
+			Created:    s.Created.UTC(),
+			Visibility: s.Visibility,
+			Language:   s.Language,
+			Tags:       tags[s.ID],
+		}
+		if !s.Expires.IsZero() {
+			expires := s.Expires.UTC()
+			snippet.Expires = &expires
+		}
+		if snippet.Tags == nil {
+			snippet.Tags = []string{}
+		}
+		js, err := json.Marshal(snippet)
+		if err != nil {
+			app.logger.ErrorContext(r.Context(), err.Error())
+			return
+		}
+		if !first {
+			w.Write([]byte(","))
+		}
+		first = false
+		w.Write(js)
+		rc.Flush()
+	}
+	w.Write([]byte("]}\n"))
+}
+
+// The form() method converts an imported snippet into a snippetCreateForm, so
+// that it's checked by the same rules as a snippet created in the browser.
+// The snippet keeps whatever is left of its lifetime, rounded up to a whole
+// day; ok is false if it has already expired.
+func (s exportedSnippet) form(now time.Time) (form snippetCreateForm, ok bool) {
+	form = snippetCreateForm{
+		Title:      strings.TrimSpace(s.Title),
+		Content:    s.Content,
+		Expires:    "never",
+		Tags:       strings.Join(s.Tags, ","),
+		Visibility: cmp.Or(s.Visibility, models.VisibilityPublic),
+		Language:   s.Language,
+	}
+	if s.Expires != nil {
+		if !s.Expires.After(now) {
+			return form, false
+		}
+		form.Expires = "custom"
+		form.CustomDays = int(math.Ceil(s.Expires.Sub(now).Hours() / 24))
+	}
+	return form, true
+}
+
+// accountImportPost reads a file made by accountExportJSON and creates a new
+// copy of each snippet in it for the current user. Nothing which the user
+// already has is changed, even if it has the same title, and the profile in
+// the file is ignored. Each snippet is validated like one from the create
+// form, and any which aren't valid (or have expired) are skipped, as are any
+// whose titles are taken if the user has asked for unique titles. The valid
+// ones are inserted in a single transaction. The user is told how many
+// snippets were imported and how many were skipped.
+func (app *application) accountImportPost(w http.ResponseWriter, r *http.Request) {
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.bodyTooLarge(w, r)
+		case errors.Is(err, http.ErrMissingFile):
+			app.addFlash(r, flashError, "Please choose a file to import.")
+			http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+		default:
+			app.clientError(w, r, http.StatusBadRequest)
+		}
+		return
+	}
+	defer file.Close()
+
+	var doc accountExport
+	err = json.NewDecoder(file).Decode(&doc)
+	if err != nil || doc.Version != exportVersion {
+		app.addFlash(r, flashError, "That file isn't a Snippetbox export.")
+		http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+		return
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	user, err := app.users.Get(r.Context(), userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	now := time.Now()
+	var params []models.InsertParams
+	// If the user wants unique titles, the titles in the file mustn't clash
+	// with each other either.
+	titles := map[string]bool{}
+	for _, s := range doc.Snippets {
+		form, ok := s.form(now)
+		if !ok {
+			continue
+		}
+		form.validate(app.snippetLimits)
+		if !form.Valid() {
+			continue
+		}
+		if user.UniqueTitles {
+			exists, err := app.snippets.TitleExistsForUser(r.Context(), userID, form.Title)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+			if exists || titles[strings.ToLower(form.Title)] {
+				continue
+			}
+			titles[strings.ToLower(form.Title)] = true
+		}
+		params = append(params, models.InsertParams{
+			Title:      form.Title,
+			Content:    form.Content,
+			Expires:    form.ExpiresDays(),
+			UserID:     userID,
+			Tags:       form.TagList(),
+			Visibility: form.Visibility,
+			Language:   form.Language,
+		})
+	}
+	if len(params) > 0 {
+		_, err = app.snippets.BulkInsert(r.Context(), params)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	message := fmt.Sprintf("Imported %d of %d snippets.", len(params), len(doc.Snippets))
+	if skipped := len(doc.Snippets) - len(params); skipped > 0 {
+		message += fmt.Sprintf(" Skipped %d which had expired or weren't valid.", skipped)
+	}
+	app.addFlash(r, flashSuccess, message)
+
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"iter"
+	"mime/multipart"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"snippetbox/internal/assert"
+	"snippetbox/internal/models"
+	"snippetbox/internal/models/mocks"
+)
+
+// The importAccount() helper posts data to /account/import as a multipart
+// form, in the same way as a browser would. If data is nil the form is sent
+// without a file.
+func (ts *testServer) importAccount(t *testing.T, csrfToken string, data []byte) (int, http.Header, string) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	err := mw.WriteField("csrf_token", csrfToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data != nil {
+		fw, err := mw.CreateFormFile("file", "snippetbox.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(data)
+	}
+	err = mw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ts.do(t, http.MethodPost, "/account/import", &body, http.Header{"Content-Type": {mw.FormDataContentType()}})
+}
+
+func TestAccountExportJSON(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		code, header, _ := ts.get(t, "/account/export.json")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/login")
+	})
+
+	ts.login(t)
+
+	code, header, body := ts.get(t, "/account/export.json")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, header.Get("Content-Type"), "application/json")
+	assert.Equal(t, header.Get("Content-Disposition"), `attachment; filename="snippetbox.json"`)
+
+	var doc accountExport
+	err := json.Unmarshal([]byte(body), &doc)
+	assert.NilError(t, err)
+	assert.Equal(t, doc.Version, exportVersion)
+	assert.Equal(t, doc.User.Email, "alice@example.com")
+	assert.Equal(t, doc.User.Username, "alice")
+	assert.Equal(t, len(doc.Snippets), 2)
+
+	assert.Equal(t, doc.Snippets[0].Title, "An old silent pond")
+	assert.Equal(t, len(doc.Snippets[0].Tags), 2)
+	assert.Equal(t, doc.Snippets[0].Tags[0], "haiku")
+	assert.Equal(t, doc.Snippets[0].Expires != nil, true)
+
+	assert.Equal(t, doc.Snippets[1].Title, `Commas, "quotes"`)
+	assert.Equal(t, doc.Snippets[1].Content, "Line one,\nline two")
+	assert.Equal(t, doc.Snippets[1].Visibility, models.VisibilityPrivate)
+	assert.Equal(t, doc.Snippets[1].Language, "go")
+	assert.Equal(t, doc.Snippets[1].Expires == nil, true)
+	assert.Equal(t, len(doc.Snippets[1].Tags), 0)
+}
+
+// gatedSnippetModel is a mock SnippetModel whose IterateFor() yields the first
+// snippet straight away, but waits for gate to be closed before the rest.
+type gatedSnippetModel struct {
+	*mocks.SnippetModel
+	gate chan struct{}
+}
+
+func (m *gatedSnippetModel) IterateFor(ctx context.Context, userID int) iter.Seq2[*models.Snippet, error] {
+	return func(yield func(*models.Snippet, error) bool) {
+		first := true
+		for s, err := range m.SnippetModel.IterateFor(ctx, userID) {
+			if !first {
+				select {
+				case <-m.gate:
+				case <-ctx.Done():
+					yield(nil, context.Cause(ctx))
+					return
+				}
+			}
+			first = false
+			if !yield(s, err) {
+				return
+			}
+		}
+	}
+}
+
+// Both exports should send each snippet as soon as it has been read, rather
+// than holding the whole file back, and a slow export should be allowed to
+// carry on past the request timeout once it has started.
+func TestAccountExportStreaming(t *testing.T) {
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantLast string
+	}{
+		{
+			name:     "JSON",
+			urlPath:  "/account/export.json",
+			wantLast: "]}",
+		},
+		{
+			name:     "CSV",
+			urlPath:  "/account/export.csv",
+			wantLast: `line two"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.requestTimeout = 50 * time.Millisecond
+			gated := &gatedSnippetModel{SnippetModel: &mocks.SnippetModel{}, gate: make(chan struct{})}
+			app.snippets = gated
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+			ts.login(t)
+
+			// If the first snippet isn't sent by itself, we'd wait for it
+			// forever, so open the gate anyway after a while.
+			var once sync.Once
+			release := func() { once.Do(func() { close(gated.gate) }) }
+			guard := time.AfterFunc(5*time.Second, release)
+			defer guard.Stop()
+
+			rs, err := ts.Client().Get(ts.URL + tt.urlPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rs.Body.Close()
+			assert.Equal(t, rs.StatusCode, http.StatusOK)
+
+			var body []byte
+			buf := make([]byte, 512)
+			for !bytes.Contains(body, []byte("An old silent pond")) {
+				n, err := rs.Body.Read(buf)
+				body = append(body, buf[:n]...)
+				if err != nil {
+					t.Fatalf("got %q before the first snippet: %v", body, err)
+				}
+			}
+			if !guard.Stop() {
+				t.Fatal("the first snippet wasn't sent until the others were ready")
+			}
+
+			// Wait until well past the request timeout before letting the
+			// export finish.
+			time.Sleep(2 * app.requestTimeout)
+			release()
+			rest, err := io.ReadAll(rs.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body = append(body, rest...)
+			assert.StringContains(t, string(body), tt.wantLast)
+		})
+	}
+}
+
+func TestAccountImport(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Export Alice's account...
+	ts.login(t)
+	_, _, export := ts.get(t, "/account/export.json")
+
+	// ...and import it into another user's. Alice's first mock snippet has
+	// already expired, so only the second one is imported.
+	csrfToken := ts.loginAs(t, "admin@example.com")
+
+	t.Run("Round trip", func(t *testing.T) {
+		code, header, _ := ts.importAccount(t, csrfToken, []byte(export))
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/account/view")
+
+		_, _, body := ts.get(t, "/account/view")
+		assert.StringContains(t, body, "Imported 1 of 2 snippets. Skipped 1 which had expired or weren&#39;t valid.")
+
+		inserted := app.snippets.(*mocks.SnippetModel).Inserted()
+		assert.Equal(t, len(inserted), 1)
+		assert.Equal(t, inserted[0].Title, `Commas, "quotes"`)
+		assert.Equal(t, inserted[0].Content, "Line one,\nline two")
+		assert.Equal(t, inserted[0].UserID, 9)
+		assert.Equal(t, inserted[0].Visibility, models.VisibilityPrivate)
+		assert.Equal(t, inserted[0].Language, "go")
+	})
+
+	t.Run("Invalid snippets are skipped", func(t *testing.T) {
+		tomorrow := time.Now().Add(24 * time.Hour)
+		doc, err := json.Marshal(accountExport{
+			Version: exportVersion,
+			Snippets: []exportedSnippet{
+				{Title: "Valid", Content: "Kept", Expires: &tomorrow},
+				{Title: "", Content: "No title"},
+				{Title: "Bad language", Content: "Skipped", Language: "cobol"},
+			},
+		})
+		assert.NilError(t, err)
+
+		code, _, _ := ts.importAccount(t, csrfToken, doc)
+		assert.Equal(t, code, http.StatusSeeOther)
+		_, _, body := ts.get(t, "/account/view")
+		assert.StringContains(t, body, "Imported 1 of 3 snippets. Skipped 2 which had expired or weren&#39;t valid.")
+	})
+
+	tests := []struct {
+		name     string
+		data     []byte
+		wantBody string
+	}{
+		{
+			name:     "No file",
+			data:     nil,
+			wantBody: "Please choose a file to import.",
+		},
+		{
+			name:     "Not JSON",
+			data:     []byte("id,title,content\n"),
+			wantBody: "That file isn&#39;t a Snippetbox export.",
+		},
+		{
+			name:     "Unknown version",
+			data:     []byte(`{"version":2,"snippets":[]}`),
+			wantBody: "That file isn&#39;t a Snippetbox export.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, header, _ := ts.importAccount(t, csrfToken, tt.data)
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, header.Get("Location"), "/account/view")
+
+			_, _, body := ts.get(t, "/account/view")
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+}
+
+func TestExportedSnippetForm(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+
+	tests := []struct {
+		name        string
+		expires     *time.Time
+		wantOK      bool
+		wantExpires string
+		wantDays    int
+	}{
+		{
+			name:        "Never expires",
+			expires:     nil,
+			wantOK:      true,
+			wantExpires: "never",
+		},
+		{
+			name:        "Rounded up to a day",
+			expires:     at(90 * time.Minute),
+			wantOK:      true,
+			wantExpires: "custom",
+			wantDays:    1,
+		},
+		{
+			name:        "Days left",
+			expires:     at(30*24*time.Hour - time.Hour),
+			wantOK:      true,
+			wantExpires: "custom",
+			wantDays:    30,
+		},
+		{
+			name:    "Expired",
+			expires: at(-time.Minute),
+			wantOK:  false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form, ok := exportedSnippet{Title: "A", Content: "B", Expires: tt.expires}.form(now)
+			assert.Equal(t, ok, tt.wantOK)
+			if !ok {
+				return
+			}
+			assert.Equal(t, form.Expires, tt.wantExpires)
+			assert.Equal(t, form.CustomDays, tt.wantDays)
+			assert.Equal(t, form.Visibility, models.VisibilityPublic)
+		})
+	}
+}
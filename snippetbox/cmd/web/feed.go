@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// feedDescriptionLength is the maximum number of characters of a snippet's
+// content which is included in its feed item.
+const feedDescriptionLength = 200
+
+// The rssFeed, rssChannel and rssItem types describe an RSS 2.0 document. The
+// encoding/xml package takes care of escaping the snippet titles and content
+// for us.
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	GUID        string `xml:"guid"`
+	PubDate     string `xml:"pubDate"`
+	Description string `xml:"description"`
+}
+
+// feed serves an RSS 2.0 feed of the latest public snippets. The response is
+// cacheable: the ETag and Last-Modified headers are based on the newest
+// snippet, and http.ServeContent() takes care of replying to conditional
+// requests with a 304 Not Modified response.
+func (app *application) feed(w http.ResponseWriter, r *http.Request) {
+	snippets, err := app.snippets.LatestPublic(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	rss := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       "Snippetbox",
+			Link:        app.baseURL + "/",
+			Description: "The latest snippets on Snippetbox",
+		},
+	}
+	for _, s := range snippets {
+		link := app.baseURL + "/snippet/view/" + strconv.Itoa(s.ID)
+		rss.Channel.Items = append(rss.Channel.Items, rssItem{
+			Title:       s.Title,
+			Link:        link,
+			GUID:        link,
+			PubDate:     s.Created.UTC().Format(time.RFC1123Z),
+			Description: truncate(s.Content, feedDescriptionLength),
+		})
+	}
+
+	// LatestPublic() returns the newest snippet first.
+	var lastModified time.Time
+	if len(snippets) > 0 {
+		newest := snippets[0]
+		lastModified = newest.Created
+		rss.Channel.LastBuildDate = newest.Created.UTC().Format(time.RFC1123Z)
+		w.Header().Set("ETag", fmt.Sprintf(`"%d-%d"`, newest.ID, newest.Created.Unix()))
+	}
+
+	buf := new(bytes.Buffer)
+	buf.WriteString(xml.Header)
+	err = xml.NewEncoder(buf).Encode(rss)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	http.ServeContent(w, r, "", lastModified, bytes.NewReader(buf.Bytes()))
+}
+
+// The truncate() helper shortens s to at most n characters (not bytes),
+// adding an ellipsis if anything was cut off.
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n]) + "…"
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strings"
+	"testing"
+
+	"snippetbox/internal/assert"
+)
+
+func TestFeed(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, header, body := ts.get(t, "/feed.xml")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, header.Get("Content-Type"), "application/rss+xml; charset=utf-8")
+	assert.Equal(t, header.Get("ETag") != "", true)
+	assert.Equal(t, header.Get("Last-Modified") != "", true)
+
+	var feed rssFeed
+	err := xml.Unmarshal([]byte(body), &feed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, feed.Version, "2.0")
+
+	// The mock returns two snippets, newest first, and the feed should keep
+	// them in that order.
+	items := feed.Channel.Items
+	assert.Equal(t, len(items), 2)
+	assert.Equal(t, items[0].Title, "Over the wintry")
+	assert.Equal(t, items[0].Link, "https://localhost:4000/snippet/view/3")
+	assert.Equal(t, items[1].Title, "An old silent pond")
+	assert.Equal(t, items[1].Link, "https://localhost:4000/snippet/view/1")
+	assert.Equal(t, items[1].Description, "An old silent pond...")
+
+	t.Run("Not modified", func(t *testing.T) {
+		code, _, body := ts.do(t, http.MethodGet, "/feed.xml", nil, http.Header{
+			"If-None-Match": {header.Get("ETag")},
+		})
+		assert.Equal(t, code, http.StatusNotModified)
+		assert.Equal(t, body, "")
+	})
+}
+
+func TestTruncate(t *testing.T) {
+	assert.Equal(t, truncate("short", 10), "short")
+	assert.Equal(t, truncate("exactly10!", 10), "exactly10!")
+	assert.Equal(t, truncate(strings.Repeat("é", 12), 10), strings.Repeat("é", 10)+"…")
+	// Content containing markup is escaped in the XML, so it survives a round
+	// trip through the parser unchanged.
+	out, err := xml.Marshal(rssItem{Description: "<b>&</b>"})
+	assert.NilError(t, err)
+	var item rssItem
+	assert.NilError(t, xml.Unmarshal(out, &item))
+	assert.Equal(t, item.Description, "<b>&</b>")
+}
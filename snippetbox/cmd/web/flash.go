@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/gob"
+	"net/http"
+)
+
+// The levels of flash message. The base template uses the level in the CSS
+// class of each message, so that they can be styled differently.
+const (
+	flashSuccess = "success"
+	flashInfo    = "info"
+	flashWarning = "warning"
+	flashError   = "error"
+)
+
+// flash is a one-time message which is shown to the user on the next page
+// that they view, such as "Snippet successfully created!" after a redirect.
+type flash struct {
+	Level   string
+	Message string
+}
+
+func init() {
+	// The session data is gob-encoded, and gob needs to know about any
+	// concrete types that are stored in it as interface values.
+	gob.Register([]flash{})
+}
+
+// The addFlash() helper adds a flash message to the queue in the session.
+// Several messages can be added before they're shown, and they're shown in the
+// order that they were added.
+func (app *application) addFlash(r *http.Request, level, message string) {
+	flashes, _ := app.sessionManager.Get(r.Context(), "flashes").([]flash)
+	app.sessionManager.Put(r.Context(), "flashes", append(flashes, flash{Level: level, Message: message}))
+}
+
+// The popFlashes() helper removes and returns all of the queued flash
+// messages. It returns nil if there aren't any.
+func (app *application) popFlashes(r *http.Request) []flash {
+	flashes, _ := app.sessionManager.Pop(r.Context(), "flashes").([]flash)
+	return flashes
+}
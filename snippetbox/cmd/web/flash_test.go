@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"snippetbox/internal/assert"
+)
+
+func TestFlashes(t *testing.T) {
+	app := newTestApplication(t)
+
+	// Use a small router which queues some flashes on one request and pops
+	// them on the next, so that they go through the session store (and gob
+	// encoding) in between.
+	mux := http.NewServeMux()
+	mux.HandleFunc("/add", func(w http.ResponseWriter, r *http.Request) {
+		app.addFlash(r, flashSuccess, "Snippet successfully created!")
+		app.addFlash(r, flashWarning, "Your snippet expires tomorrow.")
+		app.addFlash(r, flashError, "Something went wrong.")
+	})
+	mux.HandleFunc("/pop", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(app.popFlashes(r))
+	})
+	ts := newTestServer(t, app.sessionManager.LoadAndSave(mux))
+	defer ts.Close()
+
+	ts.get(t, "/add")
+	_, _, body := ts.get(t, "/pop")
+
+	var flashes []flash
+	err := json.Unmarshal([]byte(body), &flashes)
+	assert.NilError(t, err)
+	assert.Equal(t, len(flashes), 3)
+	assert.Equal(t, flashes[0], flash{Level: flashSuccess, Message: "Snippet successfully created!"})
+	assert.Equal(t, flashes[1], flash{Level: flashWarning, Message: "Your snippet expires tomorrow."})
+	assert.Equal(t, flashes[2], flash{Level: flashError, Message: "Something went wrong."})
+
+	// Popping the flashes removes them from the session.
+	_, _, body = ts.get(t, "/pop")
+	assert.Equal(t, strings.TrimSpace(body), "null")
+}
+
+func TestFlashRendering(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	csrfToken := ts.login(t)
+
+	// Favourite and then unfavourite a snippet without viewing a page in
+	// between, so that both messages are queued up.
+	for _, urlPath := range []string{"/snippet/favourite/3", "/snippet/unfavourite/3"} {
+		form := url.Values{}
+		form.Add("csrf_token", csrfToken)
+		code, _, _ := ts.postForm(t, urlPath, form)
+		assert.Equal(t, code, http.StatusSeeOther)
+	}
+
+	_, _, body := ts.get(t, "/")
+	added := strings.Index(body, "<div class='flash flash-success'>Snippet added to your favourites!</div>")
+	removed := strings.Index(body, "<div class='flash flash-success'>Snippet removed from your favourites.</div>")
+	if added == -1 || removed == -1 || added > removed {
+		t.Errorf("want both flashes in the order they were added; got body %q", body)
+	}
+
+	// An error flash gets its own class.
+	ts.get(t, "/account/email/confirm/INVALIDTOKEN")
+	_, _, body = ts.get(t, "/")
+	assert.StringContains(t, body, "<div class='flash flash-error'>This email confirmation link is invalid or has expired.</div>")
+}
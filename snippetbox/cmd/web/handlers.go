@@ -1,348 +1,2099 @@
 package main
 
 import (
+	"cmp"
+	"context"
+	"encoding/csv"
 	"errors"
 	"fmt"
+	"maps"
 	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"snippetbox/internal/i18n"
 	"snippetbox/internal/models"
 	"snippetbox/internal/validator"
 	"strconv"
+	"strings"
+	"time"
+	"unicode"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/justinas/nosurf"
 )
 
 func ping(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("OK"))
 }
 
+// csrfToken returns the current CSRF token as JSON, so that fetch()-based
+// front ends can send it in the X-CSRF-Token header without having to parse
+// it out of a page of HTML.
+func (app *application) csrfToken(w http.ResponseWriter, r *http.Request) {
+	headers := http.Header{"Cache-Control": {"no-store"}}
+	data := envelope{"csrf_token": nosurf.Token(r), "header": csrfHeader}
+	err := app.writeJSON(w, http.StatusOK, data, headers)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// homeSnippetsPerPage is the default number of snippets shown on each page of
+// the home page. It matches the number returned by LatestPublic(), which we
+// use for the first page of the newest snippets because its results are
+// cached.
+const homeSnippetsPerPage = 10
+
 func (app *application) home(w http.ResponseWriter, r *http.Request) {
 	// Because httprouter matches the "/" path exactly, we can now remove the
 	// manual check of r.URL.Path != "/" from this handler.
-	snippets, err := app.snippets.Latest()
+	page, err := app.readPageParam(r)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+	// The sort parameter is checked against the allowed orders here, as well
+	// as by the model, so that the cached first page can't be reached with
+	// an unknown order either.
+	sort := cmp.Or(r.URL.Query().Get("sort"), app.homePage.sort)
+	if !slices.Contains(models.SnippetOrders, sort) {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+	total, err := app.snippets.CountPublic(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	pagination := newPagination(total, page, app.homePage.perPage).withURL(r.URL)
+	var snippets []*models.Snippet
+	if page == 1 && sort == models.OrderNewest && app.homePage.perPage == homeSnippetsPerPage {
+		snippets, err = app.snippets.LatestPublic(r.Context())
+	} else {
+		snippets, err = app.snippets.LatestPublicPage(r.Context(), pagination.offset(), app.homePage.perPage, sort)
+	}
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	data := app.newTemplateData(r)
+	data.Snippets = snippets
+	data.Pagination = &pagination
+	data.Sort = sort
+	app.render(w, r, http.StatusOK, "home.html", data)
+}
+
+func (app *application) snippetView(w http.ResponseWriter, r *http.Request) {
+	// When httprouter is parsing a request, the values of any named parameters
+	// will be stored in the request context. We'll talk about request context
+	// in detail later in the book, but for now it's enough to know that you can
+	// use the ParamsFromContext() function to retrieve a slice containing these
+	// parameter names and values like so:
+	params := httprouter.ParamsFromContext(r.Context())
+	// We can then use the ByName() method to get the value of the "id" named
+	// parameter from the slice and validate it as normal.
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w, r)
+		return
+	}
+	// Pass the ID of the logged in user (or 0 if nobody is logged in) to Get(),
+	// so that private snippets are only found for their owner. Anybody else
+	// gets a 404, so that we don't even reveal that the snippet exists.
+	viewerID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	snippet, err := app.snippets.Get(r.Context(), id, viewerID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	// Count the view in the background so that a slow UPDATE never delays
+	// rendering the page. Any error is logged rather than shown to the user.
+	// The request's context is cancelled as soon as the response has been
+	// sent, so the UPDATE uses a copy of it which isn't.
+	if app.firstViewInWindow(r, id) {
+		ctx := context.WithoutCancel(r.Context())
+		app.background(func() {
+			err := app.snippets.IncrementViews(ctx, id)
+			if err != nil {
+				app.logger.Error(err.Error())
+			}
+		})
+	}
+	data, err := app.snippetViewData(r, snippet)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	data.Form = commentForm{}
+	// Anonymous visitors all see the same page, so we let their browsers
+	// cache it and check back with If-None-Match. Logged in users see
+	// things like favourite and delete buttons which depend on who they
+	// are, and a flash message must only be shown once, so those responses
+	// are never cached.
+	if !data.IsAuthenticated && len(data.Flashes) == 0 {
+		etag := snippetETag(data)
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "private, no-cache")
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			// The browser updates the headers of its cached copy with the
+			// ones on a 304 response. The cached page carries the nonce
+			// from its own Content-Security-Policy header, so we mustn't
+			// replace that with a header holding this request's nonce.
+			w.Header().Del("Content-Security-Policy")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	app.render(w, r, http.StatusOK, "view.html", data)
+}
+
+// snippetViewRaw sends just the content of a snippet as plain text, without
+// the page around it, so that it can be fetched with curl or piped into an
+// editor. The same rules apply as for the HTML view: private and scheduled
+// snippets are only found for their owner, and expired snippets aren't found
+// at all.
+func (app *application) snippetViewRaw(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+	viewerID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	snippet, err := app.snippets.Get(r.Context(), id, viewerID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(snippet.Content))
+}
+
+// snippetEmbed shows a snippet in the stripped-down embed layout, so that it
+// can be put in an iframe on another site. Browsers don't send our session
+// cookie from other sites, so we look the snippet up as an anonymous visitor
+// would: private and scheduled snippets are never embedded. The page has no
+// forms or buttons to be tricked into clicking, so it's safe to let any site
+// frame it, and we drop the X-Frame-Options header which secureHeaders sets.
+func (app *application) snippetEmbed(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+	snippet, err := app.snippets.Get(r.Context(), id, 0)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	data := app.newTemplateData(r)
+	data.Snippet = snippet
+	w.Header().Del("X-Frame-Options")
+	app.renderLayout(w, r, http.StatusOK, "embed", "view.html", data)
+}
+
+// snippetDownload sends the content of a snippet as a file download, named
+// after the snippet's title with an extension for its language. It follows
+// the same rules as snippetViewRaw() about who can see which snippets.
+func (app *application) snippetDownload(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+	viewerID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	snippet, err := app.snippets.Get(r.Context(), id, viewerID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", contentDisposition(downloadFilename(snippet)))
+	w.Write([]byte(snippet.Content))
+}
+
+// The downloadFilename() function returns a safe file name for downloading a
+// snippet. The title is used as the name, with any characters which could
+// cause trouble removed: path separators become hyphens, so the file can't be
+// saved outside the user's chosen directory, and control characters and those
+// which aren't allowed in Windows file names are dropped. Leading and trailing
+// dots and spaces are trimmed too, so the file isn't hidden. If nothing is
+// left we fall back to the snippet's ID.
+func downloadFilename(snippet *models.Snippet) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r == '/' || r == '\\':
+			return '-'
+		case unicode.IsControl(r) || strings.ContainsRune(`<>:"|?*`, r):
+			return -1
+		default:
+			return r
+		}
+	}, snippet.Title)
+	name = strings.Trim(name, ". ")
+	if name == "" {
+		name = fmt.Sprintf("snippet-%d", snippet.ID)
+	}
+	ext, ok := languageExtensions[snippet.Language]
+	if !ok {
+		ext = ".txt"
+	}
+	return name + ext
+}
+
+// The contentDisposition() function returns a Content-Disposition header
+// value telling the browser to download the response as a file with the given
+// name. The plain filename parameter can only hold ASCII, so for names with
+// other characters in we add the RFC 6266 filename* parameter with the UTF-8
+// name, and give older clients a version with those characters replaced.
+// The name must not contain double quotes or backslashes.
+func contentDisposition(name string) string {
+	ascii := strings.Map(func(r rune) rune {
+		if r > unicode.MaxASCII {
+			return '_'
+		}
+		return r
+	}, name)
+	if ascii == name {
+		return fmt.Sprintf(`attachment; filename="%s"`, name)
+	}
+	return fmt.Sprintf(`attachment; filename="%s"; filename*=UTF-8''%s`, ascii, url.PathEscape(name))
+}
+
+// The snippetViewData() helper gathers everything that the view.html template
+// needs to show a snippet: its tags and comments, and whether the user has
+// favourited it. It's shared with snippetCommentPost(), which shows the page
+// again if a comment fails validation.
+func (app *application) snippetViewData(r *http.Request, snippet *models.Snippet) (*templateData, error) {
+	tags, err := app.snippets.GetTags(r.Context(), snippet.ID)
+	if err != nil {
+		return nil, err
+	}
+	comments, err := app.comments.ForSnippet(r.Context(), snippet.ID)
+	if err != nil {
+		return nil, err
+	}
+	// The newTemplateData() helper pops any flash messages from the session,
+	// so they act like a one-time fetch.
+	data := app.newTemplateData(r)
+	data.Snippet = snippet
+	data.Tags = tags
+	data.Comments = comments
+	// Get() only returns an unpublished scheduled snippet to its owner, so if
+	// the publish time is still in the future we let them know.
+	data.Scheduled = validator.AfterNow(snippet.PublishAt)
+	// If the user is logged in, check whether they've already favourited the
+	// snippet so that the template can show the right toggle button.
+	if data.IsAuthenticated {
+		data.IsFavourite, err = app.users.IsFavourite(r.Context(), data.AuthenticatedUserID, snippet.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// commentForm holds the data from the comment form on the snippet view page.
+type commentForm struct {
+	Body                string `form:"body,trim"`
+	validator.Validator `form:"-"`
+}
+
+// The snippetCommentPost() handler adds a comment to a snippet. Users can
+// comment on any snippet which they're allowed to see.
+func (app *application) snippetCommentPost(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	snippet, err := app.snippets.Get(r.Context(), id, userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	var form commentForm
+	err = app.decodePostForm(r, &form)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	form.CheckField(validator.NotBlank(form.Body), "body", "This field cannot be blank")
+	validator.CheckMaxChars(&form.Validator, "body", form.Body, 1000)
+	if !form.Valid() {
+		data, err := app.snippetViewData(r, snippet)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "view.html", data)
+		return
+	}
+
+	_, err = app.comments.Insert(r.Context(), id, userID, form.Body)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	// Let the owner of the snippet know about the comment, unless they wrote
+	// it themselves. The comment has been added by now, so if this fails we
+	// log the error rather than telling the user that something went wrong.
+	if snippet.UserID != userID {
+		err = app.notifyComment(r.Context(), snippet, userID)
+		if err != nil {
+			app.logger.ErrorContext(r.Context(), err.Error())
+		}
+	}
+	app.addFlash(r, flashSuccess, "Comment added!")
+
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", id), http.StatusSeeOther)
+}
+
+// The commentDeletePost() handler deletes a comment. Only the user who wrote
+// the comment and the owner of the snippet are allowed to do this.
+func (app *application) commentDeletePost(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	snippetID, err := app.comments.Delete(r.Context(), id, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrNoRecord):
+			app.notFound(w, r)
+		case errors.Is(err, models.ErrNotPermitted):
+			app.forbidden(w, r)
+		default:
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	app.addFlash(r, flashSuccess, "Comment deleted.")
+
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", snippetID), http.StatusSeeOther)
+}
+
+// The snippetShared() handler shows a public or unlisted snippet by its share
+// link slug. It uses its own template, which leaves out the numeric ID and the
+// owner's actions, so that the link doesn't give away anything which could be
+// used to find the snippet's other URLs.
+func (app *application) snippetShared(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	snippet, err := app.snippets.GetByPublicID(r.Context(), params.ByName("slug"))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	tags, err := app.snippets.GetTags(r.Context(), snippet.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if app.firstViewInWindow(r, snippet.ID) {
+		ctx := context.WithoutCancel(r.Context())
+		app.background(func() {
+			err := app.snippets.IncrementViews(ctx, snippet.ID)
+			if err != nil {
+				app.logger.Error(err.Error())
+			}
+		})
+	}
+	data := app.newTemplateData(r)
+	data.Snippet = snippet
+	data.Tags = tags
+	app.render(w, r, http.StatusOK, "shared.html", data)
+}
+
+func (app *application) snippetCreate(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	// Initialize a new createSnippetForm instance and pass it to the template.
+	// Notice how this is also a great opportunity to set any default or
+	// 'initial' values for the form --- here we set the initial value for the
+	// snippet expiry to 365 days.
+	form := snippetCreateForm{
+		Expires:    "365",
+		Visibility: models.VisibilityPublic,
+	}
+	// Each time the form is shown it gets a new one-time token, so that
+	// submitting it twice (by double-clicking, or by going back and pressing
+	// the button again) only creates one snippet.
+	var err error
+	form.Token, err = app.newCreateToken(r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	data.Form = form
+	app.render(w, r, http.StatusOK, "create.html", data)
+}
+
+// Define a snippetCreateForm struct to represent the form data and validation
+// errors for the form fields. Note that all the struct fields are deliberately
+// exported (i.e. start with a capital letter). This is because struct fields
+// must be exported in order to be read by the html/template package when
+// rendering the template.
+type snippetCreateForm struct {
+	Title      string `form:"title,trim"`
+	Content    string `form:"content"`
+	Expires    string `form:"expires"`
+	CustomDays int    `form:"customDays"`
+	Tags       string `form:"tags,trim"`
+	Visibility string `form:"visibility"`
+	Language   string `form:"language"`
+	PublishAt  string `form:"publishAt,trim"`
+	// KeepExpiry is set for the update form, which has an extra "keep"
+	// expiry option for leaving the snippet's expiry as it is.
+	KeepExpiry bool `form:"-"`
+	// Token is the one-time token which stops the create form from being
+	// submitted twice. The update form doesn't use it.
+	Token               string `form:"createToken"`
+	validator.Validator `form:"-"`
+}
+
+// The ExpiresDays() method converts the chosen expiry option into the number
+// of days to pass to the SnippetModel, using models.NeverExpires for snippets
+// which should never expire and models.KeepExpiry for the "keep" option. It
+// should only be called on a valid form.
+func (form *snippetCreateForm) ExpiresDays() int {
+	switch form.Expires {
+	case "never":
+		return models.NeverExpires
+	case "keep":
+		return models.KeepExpiry
+	case "custom":
+		return form.CustomDays
+	default:
+		days, _ := strconv.Atoi(form.Expires)
+		return days
+	}
+}
+
+// publishAtLayout is the format of the value sent by a datetime-local input.
+// It has no time zone, so the time is taken to be in the user's own time zone.
+const publishAtLayout = "2006-01-02T15:04"
+
+// The PublishTime() method returns the time that the snippet should be
+// published, reading the PublishAt field as a time in loc. It returns the zero
+// time if the field is blank (meaning publish straight away) or invalid.
+func (form *snippetCreateForm) PublishTime(loc *time.Location) time.Time {
+	if form.PublishAt == "" {
+		return time.Time{}
+	}
+	t, err := time.ParseInLocation(publishAtLayout, form.PublishAt, loc)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// tagRX restricts tags to characters which are safe to use in a URL path.
+var tagRX = regexp.MustCompile(`^[\p{L}\p{N}_-]+$`)
+
+// The TagList() method splits the comma-separated Tags field into individual
+// tags, trimming whitespace, lowercasing, and dropping any empty or duplicate
+// values.
+func (form *snippetCreateForm) TagList() []string {
+	tags := []string{}
+	seen := make(map[string]bool)
+	for _, tag := range strings.Split(form.Tags, ",") {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// The validate() method runs the validation checks which are shared by the
+// create and update snippet forms. The longest title and content allowed are
+// set by the operator, so they're passed in.
+func (form *snippetCreateForm) validate(limits snippetLimits) {
+	// Because the Validator type is embedded by the snippetCreateForm struct,
+	// we can call CheckField() directly on it to execute our validation checks.
+	// CheckField() will add the provided key and error message to the
+	// FieldErrors map if the check does not evaluate to true. For example, in
+	// the first line here we "check that the form.Title field is not blank".
+	// The CheckMaxChars() function does the same for length limits, and puts
+	// the limit into the error message for us.
+	form.CheckField(validator.NotBlank(form.Title), "title", "This field cannot be blank")
+	validator.CheckMaxChars(&form.Validator, "title", form.Title, limits.titleMax)
+	form.CheckField(validator.NotBlank(form.Content), "content", "This field cannot be blank")
+	validator.CheckMaxChars(&form.Validator, "content", form.Content, limits.contentMax)
+	// As well as the preset expiry options, the user can choose for the
+	// snippet to never expire, or enter a custom number of days. When
+	// updating a snippet they can also keep its current expiry.
+	expiresOptions := []string{"1", "7", "365", "custom", "never"}
+	if form.KeepExpiry {
+		expiresOptions = append(expiresOptions, "keep")
+	}
+	form.CheckField(validator.PermittedValue(form.Expires, expiresOptions...), "expires", "This field must equal 1, 7, 365, custom or never")
+	form.CheckFieldIf(form.Expires == "custom", validator.Between(form.CustomDays, 1, 3650), "expires", "Custom expiry must be between 1 and 3650 days")
+	form.CheckField(validator.PermittedValue(form.Visibility, models.VisibilityPublic, models.VisibilityUnlisted, models.VisibilityPrivate), "visibility", "This field must equal public, unlisted or private")
+	// The language is optional, so an empty value (meaning plain text) is
+	// allowed as well as the known languages.
+	form.CheckField(validator.PermittedValue(form.Language, append([]string{""}, languages...)...), "language", "This field must be a supported language")
+	tags := form.TagList()
+	form.CheckField(len(tags) <= 5, "tags", "This field cannot contain more than 5 tags")
+	for _, tag := range tags {
+		form.CheckField(validator.MaxChars(tag, 30), "tags", "Each tag cannot be more than 30 characters long")
+		form.CheckField(validator.Matches(tag, tagRX), "tags", "Tags may only contain letters, numbers, hyphens and underscores")
+	}
+	// The publish time is optional.
+	form.CheckFieldIf(form.PublishAt != "", validator.IsDate(form.PublishAt, publishAtLayout), "publishAt", "This field must be a valid date and time")
+}
+
+// The checkUniqueTitle() helper adds an error to the form if the user has
+// asked for their snippet titles to be unique and they already have a snippet
+// with the form's title. It's only checked once the title is otherwise valid,
+// to save a query.
+func (app *application) checkUniqueTitle(ctx context.Context, userID int, form *snippetCreateForm) error {
+	if _, ok := form.FieldErrors["title"]; ok {
+		return nil
+	}
+	user, err := app.users.Get(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !user.UniqueTitles {
+		return nil
+	}
+	exists, err := app.snippets.TitleExistsForUser(ctx, userID, form.Title)
+	if err != nil {
+		return err
+	}
+	form.CheckField(!exists, "title", "You already have a snippet with this title.")
+	return nil
+}
+
+func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request) {
+	var form snippetCreateForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	// Claim the form's one-time token before doing anything else. If another
+	// request has already used it, then this is a repeated submission of the
+	// same form, so rather than creating a second copy of the snippet we send
+	// the user to the one which was created the first time.
+	if app.pendingCreateToken(r, form.Token) || app.createClaims.used(userID, form.Token) {
+		id, claimed, err := app.createClaims.claim(r.Context(), userID, form.Token)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		if !claimed {
+			app.usedCreateToken(r, form.Token)
+			app.addFlash(r, flashWarning, "This snippet has already been created.")
+			http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", id), http.StatusSeeOther)
+			return
+		}
+	} else {
+		// Either the token is missing, or it has expired along with the rest
+		// of the session. We can't tell whether the form has been submitted
+		// before, so we ask the user to check and submit it again with a new
+		// token.
+		form.AddNonFieldError("This form has already been submitted or has expired. Please check your snippets and try again.")
+		form.Token, err = app.newCreateToken(r)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "create.html", data)
+		return
+	}
+
+	form.validate(app.snippetLimits)
+	// A snippet can only be scheduled for the future. We check this here
+	// rather than in validate(), as the time is in the user's time zone.
+	publishAt := form.PublishTime(app.userLocation(r))
+	form.CheckFieldIf(!publishAt.IsZero(), validator.AfterNow(publishAt), "publishAt", "This field must be in the future")
+	err = app.checkUniqueTitle(r.Context(), userID, &form)
+	if err != nil {
+		app.createClaims.release(userID, form.Token)
+		app.serverError(w, r, err)
+		return
+	}
+	// Use the Valid() method to see if any of the checks failed. If they did,
+	// then re-render the template passing in the form in the same way as
+	// before. The token hasn't been used, so we release it for the user's
+	// next attempt.
+	if !form.Valid() {
+		app.createClaims.release(userID, form.Token)
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "create.html", data)
+		return
+	}
+	// Record the currently authenticated user as the owner of the snippet.
+	id, err := app.snippets.InsertWithTags(r.Context(), form.Title, form.Content, form.ExpiresDays(), userID, form.TagList(), form.Visibility, form.Language, publishAt)
+	if err != nil {
+		app.createClaims.release(userID, form.Token)
+		app.serverError(w, r, err)
+		return
+	}
+	app.createClaims.finish(userID, form.Token, id)
+	app.usedCreateToken(r, form.Token)
+	// Use the addFlash() helper to queue a success message in the session
+	// data, which will be shown on the next page that the user views.
+	app.addFlash(r, flashSuccess, "Snippet successfully created!")
+
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", id), http.StatusSeeOther)
+}
+
+// The ownedSnippet() helper fetches the snippet with the id given in the URL
+// and checks that it belongs to the currently authenticated user. If anything
+// goes wrong the appropriate error response is sent and ok will be false, in
+// which case the caller should return straight away.
+func (app *application) ownedSnippet(w http.ResponseWriter, r *http.Request) (snippet *models.Snippet, ok bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFound(w, r)
+		return nil, false
+	}
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	snippet, err = app.snippets.Get(r.Context(), id, userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return nil, false
+	}
+	// Only the owner of a snippet is allowed to change it.
+	if snippet.UserID != userID {
+		app.forbidden(w, r)
+		return nil, false
+	}
+	return snippet, true
+}
+
+func (app *application) snippetUpdate(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.ownedSnippet(w, r)
+	if !ok {
+		return
+	}
+	// Pre-populate the form with the current snippet values. The expiry
+	// defaults to keeping the snippet's current one, so that saving the form
+	// without touching it doesn't change when the snippet expires.
+	form := snippetCreateForm{
+		Title:      snippet.Title,
+		Content:    snippet.Content,
+		Expires:    "keep",
+		Visibility: snippet.Visibility,
+		Language:   snippet.Language,
+		KeepExpiry: true,
+	}
+	data := app.newTemplateData(r)
+	data.Snippet = snippet
+	data.Form = form
+	app.render(w, r, http.StatusOK, "edit.html", data)
+}
+
+func (app *application) snippetUpdatePost(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.ownedSnippet(w, r)
+	if !ok {
+		return
+	}
+	form := snippetCreateForm{KeepExpiry: true}
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	form.validate(app.snippetLimits)
+	// Keeping the snippet's own title (or changing only its case) is always
+	// allowed, even though it's a title which the user already has.
+	if !strings.EqualFold(form.Title, snippet.Title) {
+		err = app.checkUniqueTitle(r.Context(), snippet.UserID, &form)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Snippet = snippet
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "edit.html", data)
+		return
+	}
+	err = app.snippets.Update(r.Context(), snippet.ID, form.Title, form.Content, form.ExpiresDays(), form.Visibility, form.Language)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	app.addFlash(r, flashSuccess, "Snippet successfully updated!")
+
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", snippet.ID), http.StatusSeeOther)
+}
+
+func (app *application) snippetDeletePost(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.ownedSnippet(w, r)
+	if !ok {
+		return
+	}
+	err := app.snippets.Delete(r.Context(), snippet.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	app.addFlash(r, flashSuccess, "Snippet successfully deleted!")
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// snippetRestorePost restores one of the user's soft-deleted snippets. We
+// can't use ownedSnippet() here, as Get() ignores deleted snippets, so
+// instead we check that the snippet is in the user's own trash.
+func (app *application) snippetRestorePost(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	trash, err := app.snippets.Trash(r.Context(), userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !slices.ContainsFunc(trash, func(s *models.Snippet) bool { return s.ID == id }) {
+		app.notFound(w, r)
+		return
+	}
+	err = app.snippets.Restore(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	app.addFlash(r, flashSuccess, "Snippet successfully restored!")
+
+	http.Redirect(w, r, "/account/trash", http.StatusSeeOther)
+}
+
+// snippetHistory lists the earlier versions of one of the user's snippets,
+// newest first. Only the owner can see them, as an edit might well have been
+// made to take something out of the snippet.
+func (app *application) snippetHistory(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.ownedSnippet(w, r)
+	if !ok {
+		return
+	}
+	revisions, err := app.snippets.Revisions(r.Context(), snippet.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	data := app.newTemplateData(r)
+	data.Snippet = snippet
+	data.Revisions = revisions
+	app.render(w, r, http.StatusOK, "history.html", data)
+}
+
+// snippetRevertForm is the form on the history page for putting an earlier
+// version of a snippet back.
+type snippetRevertForm struct {
+	Revision int `form:"revision"`
+}
+
+// snippetRevertPost restores the title and content of a snippet from one of
+// its revisions. The version being replaced is saved as a revision in turn,
+// so reverting can itself be undone.
+func (app *application) snippetRevertPost(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.ownedSnippet(w, r)
+	if !ok {
+		return
+	}
+	var form snippetRevertForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	err = app.snippets.RestoreRevision(r.Context(), snippet.ID, form.Revision, userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	app.addFlash(r, flashSuccess, "Snippet successfully reverted!")
+
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", snippet.ID), http.StatusSeeOther)
+}
+
+// forkTitle returns the title for a copy of a snippet. The title is cut short
+// if need be, so that the copy still fits in the maxChars characters allowed
+// for a snippet created through the form.
+func forkTitle(title string, maxChars int) string {
+	runes := []rune("Copy of " + title)
+	if len(runes) > maxChars {
+		runes = runes[:maxChars]
+	}
+	return string(runes)
+}
+
+// snippetForkPost copies a snippet into a new one owned by the current user,
+// which they can then edit without affecting the original. Get() only finds
+// public snippets and the user's own, so nobody can fork a private or
+// unlisted snippet belonging to somebody else. The copy keeps the original's
+// tags, language and visibility, and expires in a year like a new snippet.
+// It's checked in the same way as a snippet created through the form, so if
+// the limits have changed since the original was made, or the user wants
+// unique titles and already has a copy, we redirect back with a flash message
+// instead.
+func (app *application) snippetForkPost(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	snippet, err := app.snippets.Get(r.Context(), id, userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	tags, err := app.snippets.GetTags(r.Context(), id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	form := snippetCreateForm{
+		Title:      forkTitle(snippet.Title, app.snippetLimits.titleMax),
+		Content:    snippet.Content,
+		Expires:    "365",
+		Tags:       strings.Join(tags, ","),
+		Visibility: snippet.Visibility,
+		Language:   snippet.Language,
+	}
+	form.validate(app.snippetLimits)
+	err = app.checkUniqueTitle(r.Context(), userID, &form)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !form.Valid() {
+		var problems []string
+		for _, key := range slices.Sorted(maps.Keys(form.FieldErrors)) {
+			problems = append(problems, key+": "+form.FieldErrors[key])
+		}
+		app.addFlash(r, flashError, "This snippet can't be forked. "+strings.Join(problems, "; "))
+		http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", snippet.ID), http.StatusSeeOther)
+		return
+	}
+	newID, err := app.snippets.InsertWithTags(r.Context(), form.Title, form.Content, form.ExpiresDays(), userID, form.TagList(), form.Visibility, form.Language, time.Time{})
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.addFlash(r, flashSuccess, "Snippet successfully forked!")
+
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", newID), http.StatusSeeOther)
+}
+
+// extendDays is how far into the future snippetExtendPost pushes a snippet's
+// expiry: a year, like the default option on the create form.
+const extendDays = 365
+
+// snippetExtendPost keeps a snippet for another year, from the expiring-soon
+// banner on the account page.
+func (app *application) snippetExtendPost(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.ownedSnippet(w, r)
+	if !ok {
+		return
+	}
+	if snippet.Expires.IsZero() {
+		app.addFlash(r, flashInfo, "That snippet never expires.")
+		http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+		return
+	}
+	err := app.snippets.Extend(r.Context(), snippet.ID, extendDays)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.addFlash(r, flashSuccess, "Snippet will now be kept for another year!")
+
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+// snippetPinPost pins one of the user's snippets, so that it's listed before
+// their other snippets on their account page and public profile.
+func (app *application) snippetPinPost(w http.ResponseWriter, r *http.Request) {
+	app.setPinned(w, r, true)
+}
+
+func (app *application) snippetUnpinPost(w http.ResponseWriter, r *http.Request) {
+	app.setPinned(w, r, false)
+}
+
+// The setPinned() helper pins or unpins the snippet with the id given in the
+// URL, and sends the user back to their list of snippets. We don't look the
+// snippet up with ownedSnippet(), as that leaves out expired snippets which
+// are still shown in the list. Instead SetPinned() only matches the user's own
+// snippets, so trying to pin someone else's results in a 404.
+func (app *application) setPinned(w http.ResponseWriter, r *http.Request, pinned bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	err = app.snippets.SetPinned(r.Context(), id, userID, pinned)
+	switch {
+	case errors.Is(err, models.ErrNoRecord):
+		app.notFound(w, r)
+		return
+	case errors.Is(err, models.ErrTooManyPins):
+		app.addFlash(r, flashError, fmt.Sprintf("You can't pin more than %d snippets. Unpin one first.", app.snippetLimits.maxPins))
+	case err != nil:
+		app.serverError(w, r, err)
+		return
+	case pinned:
+		app.addFlash(r, flashSuccess, "Snippet pinned!")
+	default:
+		app.addFlash(r, flashSuccess, "Snippet unpinned.")
+	}
+
+	http.Redirect(w, r, "/account/snippets", http.StatusSeeOther)
+}
+
+func (app *application) snippetFavouritePost(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	err = app.users.AddFavourite(r.Context(), userID, id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	app.addFlash(r, flashSuccess, "Snippet added to your favourites!")
+
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", id), http.StatusSeeOther)
+}
+
+func (app *application) snippetUnfavouritePost(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	err = app.users.RemoveFavourite(r.Context(), userID, id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.addFlash(r, flashSuccess, "Snippet removed from your favourites.")
+
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", id), http.StatusSeeOther)
+}
+
+// reportForm holds the data from the form for reporting a snippet.
+type reportForm struct {
+	Reason              string `form:"reason"`
+	Note                string `form:"note,trim"`
+	validator.Validator `form:"-"`
+}
+
+// The reportableSnippet() helper fetches the snippet with the id given in the
+// URL, checking that the current user can see it and doesn't own it (there's
+// no need to report your own snippet -- you can just delete it). If anything
+// goes wrong the appropriate error response is sent and ok will be false.
+func (app *application) reportableSnippet(w http.ResponseWriter, r *http.Request) (snippet *models.Snippet, ok bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFound(w, r)
+		return nil, false
+	}
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	snippet, err = app.snippets.Get(r.Context(), id, userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return nil, false
+	}
+	if snippet.UserID == userID {
+		app.forbidden(w, r)
+		return nil, false
+	}
+	return snippet, true
+}
+
+func (app *application) snippetReport(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.reportableSnippet(w, r)
+	if !ok {
+		return
+	}
+	data := app.newTemplateData(r)
+	data.Snippet = snippet
+	data.Form = reportForm{}
+	app.render(w, r, http.StatusOK, "report.html", data)
+}
+
+func (app *application) snippetReportPost(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.reportableSnippet(w, r)
+	if !ok {
+		return
+	}
+	var form reportForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	form.CheckField(validator.PermittedValue(form.Reason, models.ReportReasonSpam, models.ReportReasonOffensive, models.ReportReasonIllegal, models.ReportReasonOther), "reason", "This field must equal spam, offensive, illegal or other")
+	validator.CheckMaxChars(&form.Validator, "note", form.Note, 500)
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Snippet = snippet
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "report.html", data)
+		return
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	_, err = app.reports.Insert(r.Context(), snippet.ID, userID, form.Reason, form.Note)
+	if err != nil {
+		if !errors.Is(err, models.ErrDuplicateReport) {
+			app.serverError(w, r, err)
+			return
+		}
+		app.addFlash(r, flashWarning, "You have already reported this snippet.")
+	} else {
+		app.addFlash(r, flashSuccess, "Thanks for letting us know. An admin will look at this snippet soon.")
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", snippet.ID), http.StatusSeeOther)
+}
+
+func (app *application) snippetsByTag(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	tag := params.ByName("tag")
+	snippets, err := app.snippets.ByTag(r.Context(), tag)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	data := app.newTemplateData(r)
+	data.Tag = tag
+	data.Snippets = snippets
+	app.render(w, r, http.StatusOK, "tag.html", data)
+}
+
+// Define a snippetSearchForm struct to hold the search query and any
+// validation errors. The query is read from the URL query string rather than
+// the request body.
+type snippetSearchForm struct {
+	Query               string `form:"q"`
+	validator.Validator `form:"-"`
+}
+
+// searchResultsPerPage is the number of snippets shown on each page of search
+// results.
+const searchResultsPerPage = 20
+
+func (app *application) snippetSearch(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	// If no query was given at all then just display the empty search form.
+	qs := r.URL.Query()
+	if !qs.Has("q") {
+		data.Form = snippetSearchForm{}
+		app.render(w, r, http.StatusOK, "search.html", data)
+		return
+	}
+	var form snippetSearchForm
+	err := app.formDecoder.Decode(&form, qs)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+	form.CheckField(validator.NotBlank(form.Query), "q", "This field cannot be blank")
+	validator.CheckMaxChars(&form.Validator, "q", form.Query, 100)
+	data.Form = form
+	if !form.Valid() {
+		app.render(w, r, http.StatusUnprocessableEntity, "search.html", data)
+		return
+	}
+	page, err := app.readPageParam(r)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+	total, err := app.snippets.SearchCount(r.Context(), form.Query)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	pagination := newPagination(total, page, searchResultsPerPage).withURL(r.URL)
+	snippets, err := app.snippets.Search(r.Context(), form.Query, pagination.offset(), searchResultsPerPage)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	data.Snippets = snippets
+	data.Pagination = &pagination
+	app.render(w, r, http.StatusOK, "search.html", data)
+}
+
+// reservedUsernames can't be chosen at signup, as they could be mistaken for
+// the site itself or clash with paths which we might want to use one day.
+var reservedUsernames = []string{
+	"about", "admin", "administrator", "api", "help", "moderator", "root",
+	"snippetbox", "staff", "support", "system",
+}
+
+// Create a new userSignupForm struct.
+type userSignupForm struct {
+	Name                string `form:"name,trim"`
+	Username            string `form:"username,trim,lower"`
+	Email               string `form:"email,trim,lower"`
+	Password            string `form:"password"`
+	validator.Validator `form:"-"`
+}
+
+// The validate() method checks the fields of a new user. It's shared by the
+// signup form and the -create-admin command, so that the first admin is held
+// to the same rules as everybody else.
+func (form *userSignupForm) validate() {
+	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank")
+	form.CheckField(validator.NotBlank(form.Username), "username", "This field cannot be blank")
+	validator.CheckMaxChars(&form.Validator, "username", form.Username, 30)
+	form.CheckField(validator.Matches(form.Username, validator.UsernameRX), "username", "Usernames may only contain letters, numbers, hyphens and underscores, and must start and end with a letter or number")
+	// The username has already been lowercased, but we ignore case anyway so
+	// that the check doesn't depend on the form decoder.
+	form.CheckField(validator.NoneOfFold(form.Username, reservedUsernames...), "username", "This username is reserved")
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
+	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
+	checkPassword(&form.Validator, "password", form.Password)
+}
+
+// Update the handler so it displays the signup page.
+func (app *application) userSignup(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = userSignupForm{}
+	app.render(w, r, http.StatusOK, "signup.html", data)
+}
+
+// Update the handler so it displays the signup page.
+func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
+	// Declare an zero-valued instance of our userSignupForm struct.
+	var form userSignupForm
+	// Parse the form data into the userSignupForm struct.
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	// Validate the form contents using our helper functions.
+	form.validate()
+	// If there are any errors, redisplay the signup form along with a 422
+	// status code.
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "signup.html", data)
+		return
+	}
+	// Try to create a new user record in the database. If the email or
+	// username already exists then add an error message to the form and
+	// re-display it.
+	token, err := app.users.Insert(r.Context(), form.Name, form.Username, form.Email, form.Password)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrDuplicateEmail):
+			form.AddFieldError("email", "Email address is already in use")
+		case errors.Is(err, models.ErrDuplicateUsername):
+			form.AddFieldError("username", "Username is already taken")
+		default:
+			app.serverError(w, r, err)
+			return
+		}
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "signup.html", data)
+		return
+	}
+	// Email the user a link to activate their account. We do this in the
+	// background so that the response isn't held up by the SMTP server, and
+	// just log any failure as the user has already been created.
+	data := map[string]any{
+		"name":          form.Name,
+		"activationURL": fmt.Sprintf("%s/user/activate/%s", app.baseURL, token),
+	}
+	app.background(func() {
+		err := app.mailer.Send(form.Email, "user_activation.html", data)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+	// Otherwise add a confirmation flash message to the session confirming that
+	// their signup worked.
+	app.addFlash(r, flashSuccess, "Your signup was successful. Please check your email to activate your account.")
+	// And redirect the user to the login page.
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+func (app *application) activateAccount(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	err := app.users.Activate(r.Context(), params.ByName("token"))
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidToken) {
+			data := app.newTemplateData(r)
+			app.render(w, r, http.StatusBadRequest, "activate.html", data)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	data := app.newTemplateData(r)
+	data.Activated = true
+	app.render(w, r, http.StatusOK, "activate.html", data)
+}
+
+// Create a new userLoginForm struct.
+type userLoginForm struct {
+	Email               string `form:"email,trim,lower"`
+	Password            string `form:"password"`
+	RememberMe          bool   `form:"remember"`
+	validator.Validator `form:"-"`
+}
+
+// rememberMeLifetime is how long a user stays logged in for if they tick the
+// "remember me" box on the login form.
+const rememberMeLifetime = 30 * 24 * time.Hour
+
+// Update the handler so it displays the login page.
+func (app *application) userLogin(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = userLoginForm{}
+	app.render(w, r, http.StatusOK, "login.html", data)
+}
+
+func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
+	// Decode the form data into the userLoginForm struct.
+	var form userLoginForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	// Do some validation checks on the form. We check that both email and
+	// password are provided, and also check the format of the email address as
+	// a UX-nicety (in case the user makes a typo).
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
+	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "login.html", data)
+		return
+	}
+	// Refuse to even check the credentials if there have been too many failed
+	// attempts from this IP address or against this account recently.
+	ipKey, accountKey := "ip:"+app.clientIP(r), "email:"+strings.ToLower(form.Email)
+	if app.loginLimiter.blocked(ipKey, accountKey) {
+		form.AddNonFieldError("Too many failed login attempts. Please try again later.")
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusTooManyRequests, "login.html", data)
+		return
+	}
+	// Check whether the credentials are valid. If they're not, add a generic
+	// non-field error message and re-display the login page.
+	id, err := app.users.Authenticate(r.Context(), form.Email, form.Password)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			app.loginLimiter.fail(ipKey, accountKey)
+			form.AddNonFieldError("Email or password is incorrect")
+			data := app.newTemplateData(r)
+			data.Form = form
+			app.render(w, r, http.StatusUnprocessableEntity, "login.html", data)
+		} else if errors.Is(err, models.ErrAccountNotActivated) {
+			form.AddNonFieldError("Please verify your email first.")
+			data := app.newTemplateData(r)
+			data.Form = form
+			app.render(w, r, http.StatusUnprocessableEntity, "login.html", data)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	// The login succeeded, so clear the failed attempts for the account.
+	app.loginLimiter.reset(accountKey)
+	user, err := app.users.Get(r.Context(), id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.completeLogin(w, r, user, form.RememberMe)
+}
+
+func (app *application) userLogoutPost(w http.ResponseWriter, r *http.Request) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	// Use the RenewToken() method on the current session to change the session
+	// ID again.
+	err := app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.recordAudit(r, userID, models.AuditLogout, "")
+	// Remove the authenticatedUserID from the session data so that the user is
+	// 'logged out'.
+	app.sessionManager.Remove(r.Context(), "authenticatedUserID")
+	app.sessionManager.Remove(r.Context(), "timezone")
+	// Add a flash message to the session to confirm to the user that they've been
+	// logged out.
+	app.addFlash(r, flashInfo, "You've been logged out successfully!")
+	// Redirect the user to the application home page.
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (app *application) about(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	app.render(w, r, http.StatusOK, "about.html", data)
+}
+
+type languageForm struct {
+	Language string `form:"language"`
+}
+
+// The languagePost() handler saves the user's choice of language in their
+// session, where it takes priority over their browser's Accept-Language
+// header. It works whether or not the user is logged in.
+func (app *application) languagePost(w http.ResponseWriter, r *http.Request) {
+	var form languageForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	if !validator.PermittedValue(form.Language, i18n.Languages...) {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+	app.sessionManager.Put(r.Context(), "language", form.Language)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+type themeForm struct {
+	Theme string `form:"theme"`
+}
+
+// The themePost() handler saves the user's choice of UI theme in their
+// session. It works whether or not the user is logged in, and for logged-in
+// users the choice is saved to their account too, so that it follows them to
+// other devices. As with languagePost(), the theme selectors only offer valid
+// themes, so anything else gets a plain 400 response.
+func (app *application) themePost(w http.ResponseWriter, r *http.Request) {
+	var form themeForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	if !validator.PermittedValue(form.Theme, themes...) {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+	if app.isAuthenticated(r) {
+		userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+		err = app.users.SetTheme(r.Context(), userID, form.Theme)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		app.sessionManager.Put(r.Context(), "theme", form.Theme)
+		app.addFlash(r, flashSuccess, "Your theme has been updated!")
+		http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+		return
+	}
+	app.sessionManager.Put(r.Context(), "theme", form.Theme)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// expiringSoonWindow is how close to expiring a snippet has to be before we
+// warn its owner about it on the account page.
+const expiringSoonWindow = 3 * 24 * time.Hour
+
+func (app *application) accountView(w http.ResponseWriter, r *http.Request) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	user, err := app.users.Get(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	expiring, err := app.snippets.ExpiringSoonForUser(r.Context(), userID, expiringSoonWindow)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	data := app.newTemplateData(r)
+	data.User = user
+	data.Form = timezoneForm{Timezone: user.Timezone}
+	data.ExpiringSnippets = expiring
+	app.render(w, r, http.StatusOK, "account.html", data)
+}
+
+type uniqueTitlesForm struct {
+	Enabled bool `form:"enabled"`
+}
+
+// accountUniqueTitlesPost turns the user's preference for unique snippet
+// titles on or off. The form on the account page sends the new setting,
+// rather than asking us to toggle it, so that submitting it twice doesn't
+// undo it.
+func (app *application) accountUniqueTitlesPost(w http.ResponseWriter, r *http.Request) {
+	var form uniqueTitlesForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	err = app.users.SetUniqueTitles(r.Context(), userID, form.Enabled)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if form.Enabled {
+		app.addFlash(r, flashSuccess, "Your snippet titles must now be unique.")
+	} else {
+		app.addFlash(r, flashSuccess, "Your snippets can now share titles.")
+	}
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+type timezoneForm struct {
+	Timezone            string `form:"timezone"`
+	validator.Validator `form:"-"`
+}
+
+// accountTimezonePost changes the time zone which the user's dates are shown
+// in. The selector on the account page only offers a handful of zones, but we
+// accept any zone in the IANA database.
+func (app *application) accountTimezonePost(w http.ResponseWriter, r *http.Request) {
+	var form timezoneForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	form.CheckField(validator.IsTimezone(form.Timezone), "timezone", "This field must be a valid time zone")
+	if !form.Valid() {
+		user, err := app.users.Get(r.Context(), userID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		data := app.newTemplateData(r)
+		data.User = user
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "account.html", data)
+		return
+	}
+	err = app.users.SetTimezone(r.Context(), userID, form.Timezone)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.sessionManager.Put(r.Context(), "timezone", form.Timezone)
+	app.addFlash(r, flashSuccess, "Your time zone has been updated!")
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+func (app *application) accountFavourites(w http.ResponseWriter, r *http.Request) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	snippets, err := app.snippets.FavouritesFor(r.Context(), userID)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 	data := app.newTemplateData(r)
 	data.Snippets = snippets
-	app.render(w, http.StatusOK, "home.html", data)
+	app.render(w, r, http.StatusOK, "favourites.html", data)
 }
 
-func (app *application) snippetView(w http.ResponseWriter, r *http.Request) {
-	// When httprouter is parsing a request, the values of any named parameters
-	// will be stored in the request context. We'll talk about request context
-	// in detail later in the book, but for now it's enough to know that you can
-	// use the ParamsFromContext() function to retrieve a slice containing these
-	// parameter names and values like so:
-	params := httprouter.ParamsFromContext(r.Context())
-	// We can then use the ByName() method to get the value of the "id" named
-	// parameter from the slice and validate it as normal.
-	id, err := strconv.Atoi(params.ByName("id"))
-	if err != nil || id < 1 {
-		app.notFound(w)
+func (app *application) accountTrash(w http.ResponseWriter, r *http.Request) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	snippets, err := app.snippets.Trash(r.Context(), userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	data := app.newTemplateData(r)
+	data.Snippets = snippets
+	app.render(w, r, http.StatusOK, "trash.html", data)
+}
+
+// accountSnippetsPerPage is the number of snippets shown on each page of the
+// user's own snippet list.
+const accountSnippetsPerPage = 20
+
+// accountSnippets lists the snippets owned by the current user, newest first,
+// with links to edit or delete each one.
+func (app *application) accountSnippets(w http.ResponseWriter, r *http.Request) {
+	page, err := app.readPageParam(r)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	total, err := app.snippets.CountForUser(r.Context(), userID, false)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	pagination := newPagination(total, page, accountSnippetsPerPage).withURL(r.URL)
+	snippets, err := app.snippets.LatestForUser(r.Context(), userID, false, pagination.offset(), accountSnippetsPerPage)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	data := app.newTemplateData(r)
+	data.Snippets = snippets
+	data.Pagination = &pagination
+	app.render(w, r, http.StatusOK, "account_snippets.html", data)
+}
+
+// profileSnippetsPerPage is the number of snippets shown on each page of a
+// user's public profile.
+const profileSnippetsPerPage = 20
+
+// userProfile shows a user's public profile: their name, when they joined and
+// their public snippets, newest first. Users who haven't activated their
+// account (or have been deactivated) don't have a profile.
+func (app *application) userProfile(w http.ResponseWriter, r *http.Request) {
+	page, err := app.readPageParam(r)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
 		return
 	}
-	snippet, err := app.snippets.Get(id)
+	params := httprouter.ParamsFromContext(r.Context())
+	user, err := app.users.GetByUsername(r.Context(), params.ByName("username"))
 	if err != nil {
 		if errors.Is(err, models.ErrNoRecord) {
-			app.notFound(w)
+			app.notFound(w, r)
 		} else {
-			app.serverError(w, err)
+			app.serverError(w, r, err)
 		}
 		return
 	}
-	// Use the PopString() method to retrieve the value for the "flash" key.
-	// PopString() also deletes the key and value from the session data, so it
-	// acts like a one-time fetch. If there is no matching key in the session
-	// data this will return the empty string.
+	if !user.Activated {
+		app.notFound(w, r)
+		return
+	}
+	// Only public snippets are shown here, even to the user themselves, so
+	// that they see the same page as everybody else.
+	total, err := app.snippets.CountForUser(r.Context(), user.ID, true)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	pagination := newPagination(total, page, profileSnippetsPerPage).withURL(r.URL)
+	snippets, err := app.snippets.LatestForUser(r.Context(), user.ID, true, pagination.offset(), profileSnippetsPerPage)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
 	data := app.newTemplateData(r)
-	data.Snippet = snippet
-	// Pass the flash message to the template.
-	app.render(w, http.StatusOK, "view.html", data)
+	data.User = user
+	data.Snippets = snippets
+	data.Pagination = &pagination
+	app.render(w, r, http.StatusOK, "profile.html", data)
 }
 
-func (app *application) snippetCreate(w http.ResponseWriter, r *http.Request) {
+// accountExport sends all of the user's snippets as a CSV file, so that they
+// can keep a backup. The rows are streamed to the client as they're read from
+// the database, flushing each one, as accountExportJSON does. This means that
+// once the first rows have been sent we can't change our minds and send an
+// error response instead, so any error after that point is logged and the
+// download is cut short.
+func (app *application) accountExport(w http.ResponseWriter, r *http.Request) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="snippets.csv"`)
+
+	// The csv.Writer takes care of quoting any fields which contain commas,
+	// quotes or newlines.
+	cw := csv.NewWriter(w)
+	rc := http.NewResponseController(w)
+	cw.Write([]string{"id", "title", "content", "created", "expires"})
+	cw.Flush()
+	rc.Flush()
+	for s, err := range app.snippets.IterateFor(r.Context(), userID) {
+		if err != nil {
+			app.logger.ErrorContext(r.Context(), err.Error())
+			return
+		}
+		var expires string
+		if !s.Expires.IsZero() {
+			expires = s.Expires.UTC().Format(time.RFC3339)
+		}
+		cw.Write([]string{
+			strconv.Itoa(s.ID),
+			s.Title,
+			s.Content,
+			s.Created.UTC().Format(time.RFC3339),
+			expires,
+		})
+		cw.Flush()
+		rc.Flush()
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+	}
+}
+
+func (app *application) accountSessions(w http.ResponseWriter, r *http.Request) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	sessions, err := app.sessions.ListSessions(r.Context(), userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
 	data := app.newTemplateData(r)
-	// Initialize a new createSnippetForm instance and pass it to the template.
-	// Notice how this is also a great opportunity to set any default or
-	// 'initial' values for the form --- here we set the initial value for the
-	// snippet expiry to 365 days.
-	data.Form = snippetCreateForm{
-		Expires: 365,
+	data.Sessions = sessions
+	// Let the template mark the session which is making this request.
+	if token := app.sessionManager.Token(r.Context()); len(token) >= models.SessionPrefixLength {
+		data.CurrentSession = token[:models.SessionPrefixLength]
 	}
-	app.render(w, http.StatusOK, "create.html", data)
+	app.render(w, r, http.StatusOK, "sessions.html", data)
 }
 
-// Define a snippetCreateForm struct to represent the form data and validation
-// errors for the form fields. Note that all the struct fields are deliberately
-// exported (i.e. start with a capital letter). This is because struct fields
-// must be exported in order to be read by the html/template package when
-// rendering the template.
-type snippetCreateForm struct {
-	Title               string `form:"title"`
-	Content             string `form:"content"`
-	Expires             int    `form:"expires"`
-	validator.Validator `form:"-"`
+// accountSessionRevokePost logs out one of the user's sessions. The session
+// is identified by its token prefix, and the model only matches sessions owned
+// by the user, so trying to revoke someone else's session results in a 404.
+func (app *application) accountSessionRevokePost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	err := app.sessions.Revoke(r.Context(), userID, params.ByName("token"))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	app.addFlash(r, flashSuccess, "Session successfully revoked.")
+
+	http.Redirect(w, r, "/account/sessions", http.StatusSeeOther)
 }
 
-func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request) {
-	var form snippetCreateForm
+// accountSessionRevokeOthersPost logs the user out everywhere except for the
+// session which is making this request.
+func (app *application) accountSessionRevokeOthersPost(w http.ResponseWriter, r *http.Request) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	err := app.sessions.RevokeOthers(r.Context(), userID, app.sessionManager.Token(r.Context()))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.addFlash(r, flashSuccess, "You've been logged out everywhere else.")
+
+	http.Redirect(w, r, "/account/sessions", http.StatusSeeOther)
+}
+
+type passwordUpdateForm struct {
+	CurrentPassword string `form:"currentPassword"`
+	NewPassword     string `form:"newPassword"`
+	ConfirmPassword string `form:"newPasswordConfirmation"`
+	validator.Validator
+}
+
+func (app *application) accountPasswordUpdate(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = passwordUpdateForm{}
+	app.render(w, r, http.StatusOK, "password.html", data)
+}
+
+func (app *application) accountPasswordUpdatePost(w http.ResponseWriter, r *http.Request) {
+	var form passwordUpdateForm
 	err := app.decodePostForm(r, &form)
 	if err != nil {
-		app.clientError(w, http.StatusBadRequest)
+		app.badRequest(w, r, err)
 		return
 	}
-	// Because the Validator type is embedded by the snippetCreateForm struct,
-	// we can call CheckField() directly on it to execute our validation checks.
-	// CheckField() will add the provided key and error message to the
-	// FieldErrors map if the check does not evaluate to true. For example, in
-	// the first line here we "check that the form.Title field is not blank". In
-	// the second, we "check that the form.Title field has a maximum character
-	// length of 100" and so on.
-	form.CheckField(validator.NotBlank(form.Title), "title", "This field cannot be blank")
-	form.CheckField(validator.MaxChars(form.Title, 100), "title", "This field cannot be more than 100 characters long")
-	form.CheckField(validator.NotBlank(form.Content), "content", "This field cannot be blank")
-	// Use the generic PermittedValue() function instead of the type-specific
-	// PermittedInt() function.
-	form.CheckField(validator.PermittedValue(form.Expires, 1, 7, 365), "expires", "This field must equal 1, 7 or 365")
-	// Use the Valid() method to see if any of the checks failed. If they did,
-	// then re-render the template passing in the form in the same way as
-	// before.
+	form.CheckField(validator.NotBlank(form.CurrentPassword), "currentPassword", "This field cannot be blank")
+	form.CheckField(validator.NotBlank(form.NewPassword), "newPassword", "This field cannot be blank")
+	form.CheckField(validator.NotBlank(form.ConfirmPassword), "newPasswordConfirmation", "This field cannot be blank")
+	checkPassword(&form.Validator, "newPassword", form.NewPassword)
+	form.CheckField(validator.Equal(form.NewPassword, form.ConfirmPassword), "newPasswordConfirmation", "Passwords do not match")
 	if !form.Valid() {
 		data := app.newTemplateData(r)
 		data.Form = form
-		app.render(w, http.StatusUnprocessableEntity, "create.html", data)
+		app.render(w, r, http.StatusUnprocessableEntity, "password.html", data)
 		return
 	}
-	id, err := app.snippets.Insert(form.Title, form.Content, form.Expires)
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	err = app.users.PasswordUpdate(r.Context(), userID, form.CurrentPassword, form.NewPassword)
 	if err != nil {
-		app.serverError(w, err)
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			form.AddFieldError("currentPassword", "Current password is incorrect")
+			data := app.newTemplateData(r)
+			data.Form = form
+			app.render(w, r, http.StatusUnprocessableEntity, "password.html", data)
+		} else {
+			app.serverError(w, r, err)
+		}
 		return
 	}
-	// Use the Put() method to add a string value ("Snippet successfully
-	// created!") and the corresponding key ("flash") to the session data.
-	app.sessionManager.Put(r.Context(), "flash", "Snippet successfully created!")
 
-	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", id), http.StatusSeeOther)
+	app.recordAudit(r, userID, models.AuditPasswordChange, "")
+	// Add a flash message to the session to confirm to the user that their
+	// password has been updated.
+	app.addFlash(r, flashSuccess, "Your password has been updated successfully!")
+
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
 }
 
-// Create a new userSignupForm struct.
-type userSignupForm struct {
-	Name                string `form:"name"`
-	Email               string `form:"email"`
+type emailUpdateForm struct {
+	NewEmail            string `form:"newEmail,trim,lower"`
 	Password            string `form:"password"`
 	validator.Validator `form:"-"`
 }
 
-// Update the handler so it displays the signup page.
-func (app *application) userSignup(w http.ResponseWriter, r *http.Request) {
+func (app *application) accountEmailUpdate(w http.ResponseWriter, r *http.Request) {
 	data := app.newTemplateData(r)
-	data.Form = userSignupForm{}
-	app.render(w, http.StatusOK, "signup.html", data)
+	data.Form = emailUpdateForm{}
+	app.render(w, r, http.StatusOK, "email.html", data)
 }
 
-// Update the handler so it displays the signup page.
-func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
-	// Declare an zero-valued instance of our userSignupForm struct.
-	var form userSignupForm
-	// Parse the form data into the userSignupForm struct.
+// accountEmailUpdatePost starts changing the user's email address. The user
+// must re-enter their password, and the change only takes effect once they
+// click the confirmation link which we send to the new address.
+func (app *application) accountEmailUpdatePost(w http.ResponseWriter, r *http.Request) {
+	var form emailUpdateForm
 	err := app.decodePostForm(r, &form)
 	if err != nil {
-		app.clientError(w, http.StatusBadRequest)
+		app.badRequest(w, r, err)
 		return
 	}
-	// Validate the form contents using our helper functions.
-	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank")
-	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
-	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
+	form.CheckField(validator.NotBlank(form.NewEmail), "newEmail", "This field cannot be blank")
+	form.CheckField(validator.Matches(form.NewEmail, validator.EmailRX), "newEmail", "This field must be a valid email address")
 	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
-	form.CheckField(validator.MinChars(form.Password, 8), "password", "This field must be at least 8 characters long")
-	// If there are any errors, redisplay the signup form along with a 422
-	// status code.
 	if !form.Valid() {
 		data := app.newTemplateData(r)
 		data.Form = form
-		app.render(w, http.StatusUnprocessableEntity, "signup.html", data)
+		app.render(w, r, http.StatusUnprocessableEntity, "email.html", data)
 		return
 	}
-	// Try to create a new user record in the database. If the email already
-	// exists then add an error message to the form and re-display it.
-	err = app.users.Insert(form.Name, form.Email, form.Password)
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	user, err := app.users.Get(r.Context(), userID)
 	if err != nil {
-		if errors.Is(err, models.ErrDuplicateEmail) {
-			form.AddFieldError("email", "Email address is already in use")
-			data := app.newTemplateData(r)
-			data.Form = form
-			app.render(w, http.StatusUnprocessableEntity, "signup.html", data)
+		app.serverError(w, r, err)
+		return
+	}
+	token, err := app.users.CreateEmailChange(r.Context(), userID, form.Password, form.NewEmail)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			form.AddFieldError("password", "Password is incorrect")
+		} else if errors.Is(err, models.ErrDuplicateEmail) {
+			form.AddFieldError("newEmail", "Email address is already in use")
 		} else {
-			app.serverError(w, err)
+			app.serverError(w, r, err)
+			return
 		}
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "email.html", data)
 		return
 	}
-	// Otherwise add a confirmation flash message to the session confirming that
-	// their signup worked.
-	app.sessionManager.Put(r.Context(), "flash", "Your signup was successful. Please log in.")
-	// And redirect the user to the login page.
-	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+
+	app.background(func() {
+		data := map[string]any{
+			"name":       user.Name,
+			"confirmURL": app.baseURL + "/account/email/confirm/" + token,
+		}
+		err := app.mailer.Send(form.NewEmail, "email_change.html", data)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+
+	app.addFlash(r, flashInfo, "We've sent a confirmation link to your new email address.")
+
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
 }
 
-// Create a new userLoginForm struct.
-type userLoginForm struct {
-	Email               string `form:"email"`
+// accountEmailConfirm completes a change of email address. The token in the
+// URL is enough to identify the user, so this doesn't require the user to be
+// logged in (they might open the link in a different browser).
+func (app *application) accountEmailConfirm(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	userID, err := app.users.ConfirmEmailChange(r.Context(), params.ByName("token"))
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidToken) {
+			app.addFlash(r, flashError, "This email confirmation link is invalid or has expired.")
+		} else if errors.Is(err, models.ErrDuplicateEmail) {
+			app.addFlash(r, flashError, "That email address is now in use by another account.")
+		} else {
+			app.serverError(w, r, err)
+			return
+		}
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	app.recordAudit(r, userID, models.AuditEmailChange, "")
+	app.addFlash(r, flashSuccess, "Your email address has been updated successfully!")
+
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+type accountDeleteForm struct {
 	Password            string `form:"password"`
 	validator.Validator `form:"-"`
 }
 
-// Update the handler so it displays the login page.
-func (app *application) userLogin(w http.ResponseWriter, r *http.Request) {
+func (app *application) accountDelete(w http.ResponseWriter, r *http.Request) {
 	data := app.newTemplateData(r)
-	data.Form = userLoginForm{}
-	app.render(w, http.StatusOK, "login.html", data)
+	data.Form = accountDeleteForm{}
+	app.render(w, r, http.StatusOK, "delete.html", data)
 }
 
-func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
-	// Decode the form data into the userLoginForm struct.
-	var form userLoginForm
+// accountDeletePost permanently deletes the user's account, along with their
+// snippets and sessions. As there's no way back from this, the user must
+// re-enter their password first.
+func (app *application) accountDeletePost(w http.ResponseWriter, r *http.Request) {
+	var form accountDeleteForm
 	err := app.decodePostForm(r, &form)
 	if err != nil {
-		app.clientError(w, http.StatusBadRequest)
+		app.badRequest(w, r, err)
 		return
 	}
-	// Do some validation checks on the form. We check that both email and
-	// password are provided, and also check the format of the email address as
-	// a UX-nicety (in case the user makes a typo).
-	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
-	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
 	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
 	if !form.Valid() {
 		data := app.newTemplateData(r)
 		data.Form = form
-		app.render(w, http.StatusUnprocessableEntity, "login.html", data)
+		app.render(w, r, http.StatusUnprocessableEntity, "delete.html", data)
 		return
 	}
-	// Check whether the credentials are valid. If they're not, add a generic
-	// non-field error message and re-display the login page.
-	id, err := app.users.Authenticate(form.Email, form.Password)
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	user, err := app.users.Get(r.Context(), userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	_, err = app.users.Authenticate(r.Context(), user.Email, form.Password)
 	if err != nil {
 		if errors.Is(err, models.ErrInvalidCredentials) {
-			form.AddNonFieldError("Email or password is incorrect")
+			form.AddFieldError("password", "Password is incorrect")
 			data := app.newTemplateData(r)
 			data.Form = form
-			app.render(w, http.StatusUnprocessableEntity, "login.html", data)
+			app.render(w, r, http.StatusUnprocessableEntity, "delete.html", data)
 		} else {
-			app.serverError(w, err)
+			app.serverError(w, r, err)
 		}
 		return
 	}
-	// Use the RenewToken() method on the current session to change the session
-	// ID. It's good practice to generate a new session ID when the
-	// authentication state or privilege levels changes for the user (e.g. login
-	// and logout operations).
-	err = app.sessionManager.RenewToken(r.Context())
+
+	err = app.users.Delete(r.Context(), userID)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
-	// Add the ID of the current user to the session, so that they are now
-	// 'logged in'.
-	app.sessionManager.Put(r.Context(), "authenticatedUserID", id)
-	targetURL := app.sessionManager.GetString(r.Context(), "targetURL")
-	if targetURL != "" {
-		http.Redirect(w, r, targetURL, http.StatusSeeOther)
-		return
-	}
-	// Redirect the user to the create snippet page.
-	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
-}
+	// The audit log isn't deleted with the account, so this is kept.
+	app.recordAudit(r, userID, models.AuditAccountDelete, "")
 
-func (app *application) userLogoutPost(w http.ResponseWriter, r *http.Request) {
-	// Use the RenewToken() method on the current session to change the session
-	// ID again.
-	err := app.sessionManager.RenewToken(r.Context())
+	// Destroy the current session so that nothing about the deleted user
+	// lingers in it. Putting the flash message afterwards starts a brand new,
+	// anonymous, session to carry it.
+	err = app.sessionManager.Destroy(r.Context())
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
-	// Remove the authenticatedUserID from the session data so that the user is
-	// 'logged out'.
-	app.sessionManager.Remove(r.Context(), "authenticatedUserID")
-	// Add a flash message to the session to confirm to the user that they've been
-	// logged out.
-	app.sessionManager.Put(r.Context(), "flash", "You've been logged out successfully!")
-	// Redirect the user to the application home page.
+	app.addFlash(r, flashInfo, "Your account has been deleted.")
+
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
-func (app *application) about(w http.ResponseWriter, r *http.Request) {
+type forgotPasswordForm struct {
+	Email               string `form:"email,trim,lower"`
+	validator.Validator `form:"-"`
+}
+
+func (app *application) forgotPassword(w http.ResponseWriter, r *http.Request) {
 	data := app.newTemplateData(r)
-	app.render(w, http.StatusOK, "about.html", data)
+	data.Form = forgotPasswordForm{}
+	app.render(w, r, http.StatusOK, "forgot.html", data)
 }
 
-func (app *application) accountView(w http.ResponseWriter, r *http.Request) {
-	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
-	user, err := app.users.Get(userID)
+func (app *application) forgotPasswordPost(w http.ResponseWriter, r *http.Request) {
+	var form forgotPasswordForm
+	err := app.decodePostForm(r, &form)
 	if err != nil {
-		if errors.Is(err, models.ErrNoRecord) {
-			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
-		} else {
-			app.serverError(w, err)
-		}
+		app.badRequest(w, r, err)
 		return
 	}
-	data := app.newTemplateData(r)
-	data.User = user
-	app.render(w, http.StatusOK, "account.html", data)
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "forgot.html", data)
+		return
+	}
+	// Only send a reset email if the address belongs to a user. Either way we
+	// show exactly the same confirmation message afterwards, so that this
+	// page can't be used to discover which email addresses are registered.
+	user, err := app.users.GetByEmail(r.Context(), form.Email)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, r, err)
+		return
+	}
+	if user != nil {
+		token, err := app.users.CreatePasswordReset(r.Context(), user.ID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		data := map[string]any{
+			"name":     user.Name,
+			"resetURL": fmt.Sprintf("%s/user/password/reset?token=%s", app.baseURL, token),
+		}
+		// Send the email in the background, so that how long this takes
+		// doesn't reveal anything either. Any failure is logged.
+		app.background(func() {
+			err := app.mailer.Send(user.Email, "password_reset.html", data)
+			if err != nil {
+				app.logger.Error(err.Error())
+			}
+		})
+	}
+	app.addFlash(r, flashInfo, "If an account exists for that email address, we've sent it a link to reset the password.")
+
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 }
 
-type passwordUpdateForm struct {
-	CurrentPassword string `form:"currentPassword"`
+type resetPasswordForm struct {
+	Token           string `form:"token"`
 	NewPassword     string `form:"newPassword"`
 	ConfirmPassword string `form:"newPasswordConfirmation"`
 	validator.Validator
 }
 
-func (app *application) accountPasswordUpdate(w http.ResponseWriter, r *http.Request) {
+func (app *application) resetPassword(w http.ResponseWriter, r *http.Request) {
 	data := app.newTemplateData(r)
-	data.Form = passwordUpdateForm{}
-	app.render(w, http.StatusOK, "password.html", data)
+	data.Form = resetPasswordForm{
+		Token: r.URL.Query().Get("token"),
+	}
+	app.render(w, r, http.StatusOK, "reset.html", data)
 }
 
-func (app *application) accountPasswordUpdatePost(w http.ResponseWriter, r *http.Request) {
-	var form passwordUpdateForm
+func (app *application) resetPasswordPost(w http.ResponseWriter, r *http.Request) {
+	var form resetPasswordForm
 	err := app.decodePostForm(r, &form)
 	if err != nil {
-		app.clientError(w, http.StatusBadRequest)
+		app.badRequest(w, r, err)
 		return
 	}
-	form.CheckField(validator.NotBlank(form.CurrentPassword), "currentPassword", "This field cannot be blank")
 	form.CheckField(validator.NotBlank(form.NewPassword), "newPassword", "This field cannot be blank")
 	form.CheckField(validator.NotBlank(form.ConfirmPassword), "newPasswordConfirmation", "This field cannot be blank")
-	form.CheckField(validator.MinChars(form.NewPassword, 8), "newPassword", "This field must be at least 8 characters long")
+	checkPassword(&form.Validator, "newPassword", form.NewPassword)
 	form.CheckField(validator.Equal(form.NewPassword, form.ConfirmPassword), "newPasswordConfirmation", "Passwords do not match")
 	if !form.Valid() {
 		data := app.newTemplateData(r)
 		data.Form = form
-		app.render(w, http.StatusUnprocessableEntity, "password.html", data)
+		app.render(w, r, http.StatusUnprocessableEntity, "reset.html", data)
 		return
 	}
 
-	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
-	err = app.users.PasswordUpdate(userID, form.CurrentPassword, form.NewPassword)
+	userID, err := app.users.ResetPassword(r.Context(), form.Token, form.NewPassword)
 	if err != nil {
-		if errors.Is(err, models.ErrInvalidCredentials) {
-			form.AddFieldError("currentPassword", "Current password is incorrect")
+		if errors.Is(err, models.ErrInvalidToken) {
+			form.AddNonFieldError("This password reset link is invalid or has expired")
 			data := app.newTemplateData(r)
 			data.Form = form
-			app.render(w, http.StatusUnprocessableEntity, "password.html", data)
+			app.render(w, r, http.StatusUnprocessableEntity, "reset.html", data)
 		} else {
-			app.serverError(w, err)
+			app.serverError(w, r, err)
 		}
 		return
 	}
 
-	// Add a flash message to the session to confirm to the user that their
-	// password has been updated.
-	app.sessionManager.Put(r.Context(), "flash", "Your password has been updated successfully!")
+	app.recordAudit(r, userID, models.AuditPasswordChange, "reset by email")
+	app.addFlash(r, flashSuccess, "Your password has been reset. Please log in.")
 
-	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 }
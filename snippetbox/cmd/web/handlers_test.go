@@ -1,10 +1,20 @@
 package main
 
 import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
 	"net/http"
 	"net/url"
 	"snippetbox/internal/assert"
+	mailermocks "snippetbox/internal/mailer/mocks"
+	"snippetbox/internal/models"
+	"snippetbox/internal/models/mocks"
+	"strings"
 	"testing"
+	"time"
+	"unicode/utf8"
 )
 
 func TestPing(t *testing.T) {
@@ -16,6 +26,176 @@ func TestPing(t *testing.T) {
 	assert.Equal(t, body, "OK")
 }
 
+// TestHome checks that the home page lists the latest snippets. The snippets
+// come from the mock SnippetModel, so no database is needed.
+func TestHome(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	code, _, body := ts.get(t, "/")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "<h2>Latest Snippets</h2>")
+	assert.StringContains(t, body, "<a href='/snippet/view/1'>An old silent pond</a>")
+	assert.StringContains(t, body, "#1")
+
+	code, _, body = ts.get(t, "/?page=2")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "There are no more snippets.")
+	assert.StringContains(t, body, "<a href='/' rel='prev'>Previous</a>")
+
+	code, _, _ = ts.get(t, "/?page=0")
+	assert.Equal(t, code, http.StatusBadRequest)
+}
+
+func TestHomeSort(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// The mock returns the snippets newest first, and in reverse for the
+	// other orders.
+	newestFirst := []string{"<a href='/snippet/view/3'>", "<a href='/snippet/view/1'>"}
+	oldestFirst := []string{"<a href='/snippet/view/1'>", "<a href='/snippet/view/3'>"}
+
+	tests := []struct {
+		name      string
+		urlPath   string
+		wantCode  int
+		wantOrder []string
+		wantSort  string
+	}{
+		{
+			name:      "Default",
+			urlPath:   "/",
+			wantCode:  http.StatusOK,
+			wantOrder: newestFirst,
+			wantSort:  "<strong>Newest</strong>",
+		},
+		{
+			name:      "Newest",
+			urlPath:   "/?sort=newest",
+			wantCode:  http.StatusOK,
+			wantOrder: newestFirst,
+			wantSort:  "<strong>Newest</strong>",
+		},
+		{
+			name:      "Most viewed",
+			urlPath:   "/?sort=views",
+			wantCode:  http.StatusOK,
+			wantOrder: oldestFirst,
+			wantSort:  "<strong>Most viewed</strong>",
+		},
+		{
+			name:      "Most favourited",
+			urlPath:   "/?sort=favourites",
+			wantCode:  http.StatusOK,
+			wantOrder: oldestFirst,
+			wantSort:  "<strong>Most favourited</strong>",
+		},
+		{
+			name:     "Unknown sort",
+			urlPath:  "/?sort=title",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "SQL in sort",
+			urlPath:  "/?sort=" + url.QueryEscape("id; DROP TABLE snippets"),
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			assert.StringContains(t, body, tt.wantSort)
+			first, second := strings.Index(body, tt.wantOrder[0]), strings.Index(body, tt.wantOrder[1])
+			if first < 0 || second < first {
+				t.Errorf("snippets not in the expected order")
+			}
+		})
+	}
+
+	t.Run("Configured", func(t *testing.T) {
+		app := newTestApplication(t)
+		app.homePage = homeConfig{perPage: 1, sort: models.OrderMostViewed}
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		code, _, body := ts.get(t, "/")
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "<strong>Most viewed</strong>")
+		assert.StringContains(t, body, "<a href='/snippet/view/1'>")
+		if strings.Contains(body, "<a href='/snippet/view/3'>") {
+			t.Errorf("got more than one snippet on the page")
+		}
+		assert.StringContains(t, body, "<a href='/?page=2' rel='next'>Next</a>")
+
+		// A sort order chosen by the visitor is kept when moving between
+		// pages.
+		_, _, body = ts.get(t, "/?sort=newest")
+		assert.StringContains(t, body, "<a href='/?page=2&amp;sort=newest' rel='next'>Next</a>")
+	})
+}
+
+func TestCSRFTokenHeader(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, header, body := ts.get(t, "/csrf-token")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, header.Get("Content-Type"), "application/json")
+
+	var response struct {
+		CSRFToken string `json:"csrf_token"`
+		Header    string `json:"header"`
+	}
+	err := json.Unmarshal([]byte(body), &response)
+	assert.NilError(t, err)
+	assert.Equal(t, response.Header, "X-CSRF-Token")
+
+	tests := []struct {
+		name     string
+		token    string
+		wantCode int
+	}{
+		{
+			name:     "Valid token",
+			token:    response.CSRFToken,
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Invalid token",
+			token:    "wrongToken",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Missing token",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("email", "alice@example.com")
+			form.Add("password", "pa$$word")
+
+			header := http.Header{}
+			header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.token != "" {
+				header.Set(csrfHeader, tt.token)
+			}
+			code, _, _ := ts.do(t, http.MethodPost, "/user/login", strings.NewReader(form.Encode()), header)
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+}
+
 func TestSnippetView(t *testing.T) {
 	// Create a new instance of our application struct which uses the mocked
 	// dependencies.
@@ -38,6 +218,18 @@ func TestSnippetView(t *testing.T) {
 			wantCode: http.StatusOK,
 			wantBody: "An old silent pond...",
 		},
+		{
+			name:     "Character and line counts",
+			urlPath:  "/snippet/view/1",
+			wantCode: http.StatusOK,
+			wantBody: "21 characters, 1 line.",
+		},
+		{
+			name:     "Relative created time",
+			urlPath:  "/snippet/view/1",
+			wantCode: http.StatusOK,
+			wantBody: ">Created just now</time>",
+		},
 		{
 			name:     "Non-existent ID",
 			urlPath:  "/snippet/view/2",
@@ -76,6 +268,158 @@ func TestSnippetView(t *testing.T) {
 	}
 }
 
+func TestSnippetViewRaw(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// The test server's get() helper trims the body, so we read it ourselves
+	// to check that the content is sent exactly as it's stored.
+	getRaw := func(t *testing.T, urlPath string) (int, http.Header, []byte) {
+		rs, err := ts.Client().Get(ts.URL + urlPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rs.Body.Close()
+		body, err := io.ReadAll(rs.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rs.StatusCode, rs.Header, body
+	}
+
+	t.Run("Public snippet", func(t *testing.T) {
+		snippet, err := app.snippets.Get(context.Background(), 1, 0)
+		assert.NilError(t, err)
+
+		code, header, body := getRaw(t, "/snippet/view/1/raw")
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, header.Get("Content-Type"), "text/plain; charset=utf-8")
+		assert.Equal(t, string(body), snippet.Content)
+	})
+
+	for _, urlPath := range []string{"/snippet/view/2/raw", "/snippet/view/6/raw", "/snippet/view/8/raw", "/snippet/view/foo/raw"} {
+		t.Run("Not found "+urlPath, func(t *testing.T) {
+			code, _, _ := getRaw(t, urlPath)
+			assert.Equal(t, code, http.StatusNotFound)
+		})
+	}
+
+	// Private snippets can be fetched by their owner.
+	t.Run("Private snippet", func(t *testing.T) {
+		ts.login(t)
+		code, header, body := getRaw(t, "/snippet/view/6/raw")
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, header.Get("Content-Type"), "text/plain; charset=utf-8")
+		assert.Equal(t, string(body), "For my eyes only...")
+	})
+}
+
+func TestSnippetDownload(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, header, body := ts.get(t, "/snippet/view/1/download")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, header.Get("Content-Type"), "text/plain; charset=utf-8")
+	assert.Equal(t, header.Get("Content-Disposition"), `attachment; filename="An old silent pond.txt"`)
+	assert.Equal(t, body, "An old silent pond...")
+
+	for _, urlPath := range []string{"/snippet/view/2/download", "/snippet/view/6/download", "/snippet/view/8/download"} {
+		code, _, _ := ts.get(t, urlPath)
+		assert.Equal(t, code, http.StatusNotFound)
+	}
+}
+
+func TestSnippetEmbed(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, header, body := ts.get(t, "/snippet/view/1/embed")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "An old silent pond...")
+	assert.StringContains(t, body, "<body class='embed'>")
+	// The embed page can be framed by other sites, unlike every other page.
+	assert.Equal(t, header.Get("X-Frame-Options"), "")
+	if strings.Contains(body, "<nav>") {
+		t.Error("embed page contains the navigation bar")
+	}
+
+	// Private and scheduled snippets are never embedded, even for their
+	// owner.
+	ts.login(t)
+	for _, urlPath := range []string{"/snippet/view/2/embed", "/snippet/view/6/embed", "/snippet/view/8/embed"} {
+		code, _, _ := ts.get(t, urlPath)
+		assert.Equal(t, code, http.StatusNotFound)
+	}
+}
+
+func TestDownloadFilename(t *testing.T) {
+	tests := []struct {
+		name     string
+		title    string
+		language string
+		want     string
+	}{
+		{name: "Plain text", title: "An old silent pond", want: "An old silent pond.txt"},
+		{name: "Language", title: "main", language: "go", want: "main.go"},
+		{name: "Unknown language", title: "notes", language: "cobol", want: "notes.txt"},
+		{name: "Slashes", title: "../../etc/passwd", want: "-..-etc-passwd.txt"},
+		{name: "Backslashes", title: `C:\Windows\win.ini`, want: "C-Windows-win.ini.txt"},
+		{name: "Quotes", title: `Say "hello"`, want: "Say hello.txt"},
+		{name: "Control characters", title: "Line one\r\nLine two\x00", want: "Line oneLine two.txt"},
+		{name: "Reserved characters", title: "What? <b>Why</b>: a|b*", want: "What bWhy-b ab.txt"},
+		{name: "Leading dots", title: ".hidden", want: "hidden.txt"},
+		{name: "Unicode", title: "Café crème", want: "Café crème.txt"},
+		{name: "Nothing left", title: `"?"`, want: "snippet-7.txt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snippet := &models.Snippet{ID: 7, Title: tt.title, Language: tt.language}
+			assert.Equal(t, downloadFilename(snippet), tt.want)
+		})
+	}
+}
+
+func TestContentDisposition(t *testing.T) {
+	assert.Equal(t, contentDisposition("main.go"), `attachment; filename="main.go"`)
+	assert.Equal(t, contentDisposition("Café crème.txt"), `attachment; filename="Caf_ cr_me.txt"; filename*=UTF-8''Caf%C3%A9%20cr%C3%A8me.txt`)
+}
+
+func TestSnippetViewCount(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	snippets := app.snippets.(*mocks.SnippetModel)
+
+	// The first view in a session is counted.
+	code, _, _ := ts.get(t, "/snippet/view/1")
+	assert.Equal(t, code, http.StatusOK)
+	app.wg.Wait()
+	assert.Equal(t, snippets.Views(1), 1)
+
+	// But viewing it again in the same session within the window is not.
+	ts.get(t, "/snippet/view/1")
+	app.wg.Wait()
+	assert.Equal(t, snippets.Views(1), 1)
+
+	// Views of a different snippet are counted separately.
+	ts.get(t, "/snippet/view/3")
+	app.wg.Wait()
+	assert.Equal(t, snippets.Views(3), 1)
+
+	// A view from a new session is counted again.
+	other := newTestServer(t, app.routes())
+	defer other.Close()
+	other.get(t, "/snippet/view/1")
+	app.wg.Wait()
+	assert.Equal(t, snippets.Views(1), 2)
+}
+
 func TestUserSignup(t *testing.T) {
 	app := newTestApplication(t)
 	ts := newTestServer(t, app.routes())
@@ -84,7 +428,8 @@ func TestUserSignup(t *testing.T) {
 	validCSRFToken := extractCSRFToken(t, body)
 	const (
 		validName     = "Bob"
-		validPassword = "validPa$$word"
+		validUsername = "bob"
+		validPassword = "validPa$$w0rd"
 		validEmail    = "bob@example.com"
 		formTag       = "<form action='/user/signup' method='POST' novalidate>"
 	)
@@ -92,15 +437,18 @@ func TestUserSignup(t *testing.T) {
 	tests := []struct {
 		name         string
 		userName     string
+		userUsername string
 		userEmail    string
 		userPassword string
 		csrfToken    string
 		wantCode     int
 		wantFormTag  string
+		wantBody     string
 	}{
 		{
 			name:         "Valid submission",
 			userName:     validName,
+			userUsername: validUsername,
 			userEmail:    validEmail,
 			userPassword: validPassword,
 			csrfToken:    validCSRFToken,
@@ -109,6 +457,7 @@ func TestUserSignup(t *testing.T) {
 		{
 			name:         "Invalid CSRF Token",
 			userName:     validName,
+			userUsername: validUsername,
 			userEmail:    validEmail,
 			userPassword: validPassword,
 			csrfToken:    "wrongToken",
@@ -117,6 +466,7 @@ func TestUserSignup(t *testing.T) {
 		{
 			name:         "Empty name",
 			userName:     "",
+			userUsername: validUsername,
 			userEmail:    validEmail,
 			userPassword: validPassword,
 			csrfToken:    validCSRFToken,
@@ -126,6 +476,7 @@ func TestUserSignup(t *testing.T) {
 		{
 			name:         "Empty email",
 			userName:     validName,
+			userUsername: validUsername,
 			userEmail:    "",
 			userPassword: validPassword,
 			csrfToken:    validCSRFToken,
@@ -135,6 +486,7 @@ func TestUserSignup(t *testing.T) {
 		{
 			name:         "Empty password",
 			userName:     validName,
+			userUsername: validUsername,
 			userEmail:    validEmail,
 			userPassword: "",
 			csrfToken:    validCSRFToken,
@@ -144,6 +496,7 @@ func TestUserSignup(t *testing.T) {
 		{
 			name:         "Invalid email",
 			userName:     validName,
+			userUsername: validUsername,
 			userEmail:    "bob@example.",
 			userPassword: validPassword,
 			csrfToken:    validCSRFToken,
@@ -153,27 +506,100 @@ func TestUserSignup(t *testing.T) {
 		{
 			name:         "Short password",
 			userName:     validName,
+			userUsername: validUsername,
 			userEmail:    validEmail,
 			userPassword: "pa$$",
 			csrfToken:    validCSRFToken,
 			wantCode:     http.StatusUnprocessableEntity,
 			wantFormTag:  formTag,
 		},
+		{
+			name:         "Weak password",
+			userName:     validName,
+			userUsername: validUsername,
+			userEmail:    validEmail,
+			userPassword: "password",
+			csrfToken:    validCSRFToken,
+			wantCode:     http.StatusUnprocessableEntity,
+			wantFormTag:  formTag,
+		},
+		{
+			name:         "Common password",
+			userName:     validName,
+			userUsername: validUsername,
+			userEmail:    validEmail,
+			userPassword: "Password1!",
+			csrfToken:    validCSRFToken,
+			wantCode:     http.StatusUnprocessableEntity,
+			wantFormTag:  formTag,
+		},
 		{
 			name:         "Duplicate email",
 			userName:     validName,
+			userUsername: validUsername,
 			userEmail:    "dupe@example.com",
 			userPassword: validPassword,
 			csrfToken:    validCSRFToken,
 			wantCode:     http.StatusUnprocessableEntity,
 			wantFormTag:  formTag,
 		},
+		{
+			name:         "Empty username",
+			userName:     validName,
+			userUsername: "",
+			userEmail:    validEmail,
+			userPassword: validPassword,
+			csrfToken:    validCSRFToken,
+			wantCode:     http.StatusUnprocessableEntity,
+			wantFormTag:  formTag,
+		},
+		{
+			name:         "Invalid username",
+			userName:     validName,
+			userUsername: "bob smith!",
+			userEmail:    validEmail,
+			userPassword: validPassword,
+			csrfToken:    validCSRFToken,
+			wantCode:     http.StatusUnprocessableEntity,
+			wantBody:     "Usernames may only contain letters, numbers, hyphens and underscores",
+		},
+		{
+			name:         "Long username",
+			userName:     validName,
+			userUsername: strings.Repeat("b", 31),
+			userEmail:    validEmail,
+			userPassword: validPassword,
+			csrfToken:    validCSRFToken,
+			wantCode:     http.StatusUnprocessableEntity,
+			wantBody:     "This field cannot be more than 30 characters long",
+		},
+		{
+			name:         "Duplicate username",
+			userName:     validName,
+			userUsername: "Alice",
+			userEmail:    validEmail,
+			userPassword: validPassword,
+			csrfToken:    validCSRFToken,
+			wantCode:     http.StatusUnprocessableEntity,
+			wantBody:     "Username is already taken",
+		},
+		{
+			name:         "Reserved username",
+			userName:     validName,
+			userUsername: "Admin",
+			userEmail:    validEmail,
+			userPassword: validPassword,
+			csrfToken:    validCSRFToken,
+			wantCode:     http.StatusUnprocessableEntity,
+			wantBody:     "This username is reserved",
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			form := url.Values{}
 			form.Add("name", tt.userName)
+			form.Add("username", tt.userUsername)
 			form.Add("email", tt.userEmail)
 			form.Add("password", tt.userPassword)
 			form.Add("csrf_token", tt.csrfToken)
@@ -185,6 +611,9 @@ func TestUserSignup(t *testing.T) {
 			if tt.wantFormTag != "" {
 				assert.StringContains(t, body, tt.wantFormTag)
 			}
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
 		})
 	}
 }
@@ -215,3 +644,2757 @@ func TestSnippetCreate(t *testing.T) {
 		assert.StringContains(t, body, "<form action='/snippet/create' method='POST'>")
 	})
 }
+
+func TestSnippetCreatePostExpiry(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t)
+
+	tests := []struct {
+		name       string
+		expires    string
+		customDays string
+		wantCode   int
+		wantBody   string
+	}{
+		{
+			name:     "Preset",
+			expires:  "7",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Never",
+			expires:  "never",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:       "Custom",
+			expires:    "custom",
+			customDays: "3650",
+			wantCode:   http.StatusSeeOther,
+		},
+		{
+			name:       "Custom too small",
+			expires:    "custom",
+			customDays: "0",
+			wantCode:   http.StatusUnprocessableEntity,
+			wantBody:   "Custom expiry must be between 1 and 3650 days",
+		},
+		{
+			name:       "Custom too large",
+			expires:    "custom",
+			customDays: "3651",
+			wantCode:   http.StatusUnprocessableEntity,
+			wantBody:   "Custom expiry must be between 1 and 3650 days",
+		},
+		{
+			name:     "Unknown option",
+			expires:  "30",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field must equal 1, 7, 365, custom or never",
+		},
+		{
+			name:     "Keep on create",
+			expires:  "keep",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field must equal 1, 7, 365, custom or never",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("title", "An old silent pond")
+			form.Add("content", "A frog jumps into the pond")
+			form.Add("expires", tt.expires)
+			form.Add("customDays", tt.customDays)
+			form.Add("visibility", "public")
+			form.Add("csrf_token", csrfToken)
+
+			form.Add("createToken", ts.createToken(t))
+			code, _, body := ts.postForm(t, "/snippet/create", form)
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestSnippetCreatePublishAt(t *testing.T) {
+	// Work in whole minutes, as that's all a datetime-local input sends.
+	future := time.Now().Add(24 * time.Hour).Truncate(time.Minute)
+	past := time.Now().Add(-24 * time.Hour)
+
+	tests := []struct {
+		name          string
+		timezone      string
+		publishAt     string
+		wantCode      int
+		wantBody      string
+		wantPublishAt time.Time
+	}{
+		{
+			name:     "Blank",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:          "Future",
+			publishAt:     future.UTC().Format(publishAtLayout),
+			wantCode:      http.StatusSeeOther,
+			wantPublishAt: future,
+		},
+		{
+			name:          "Future in the user's time zone",
+			timezone:      "Asia/Tokyo",
+			publishAt:     future.In(mustLoadLocation(t, "Asia/Tokyo")).Format(publishAtLayout),
+			wantCode:      http.StatusSeeOther,
+			wantPublishAt: future,
+		},
+		{
+			name:      "Past",
+			publishAt: past.UTC().Format(publishAtLayout),
+			wantCode:  http.StatusUnprocessableEntity,
+			wantBody:  "This field must be in the future",
+		},
+		{
+			name:      "Invalid date",
+			publishAt: "2030-02-30T09:00",
+			wantCode:  http.StatusUnprocessableEntity,
+			wantBody:  "This field must be a valid date and time",
+		},
+		{
+			name:      "Wrong format",
+			publishAt: "tomorrow",
+			wantCode:  http.StatusUnprocessableEntity,
+			wantBody:  "This field must be a valid date and time",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			csrfToken := ts.login(t)
+			if tt.timezone != "" {
+				form := url.Values{}
+				form.Add("timezone", tt.timezone)
+				form.Add("csrf_token", csrfToken)
+				code, _, _ := ts.postForm(t, "/account/timezone", form)
+				assert.Equal(t, code, http.StatusSeeOther)
+			}
+
+			form := url.Values{}
+			form.Add("title", "An old silent pond")
+			form.Add("content", "A frog jumps into the pond")
+			form.Add("expires", "7")
+			form.Add("visibility", "public")
+			form.Add("publishAt", tt.publishAt)
+			form.Add("csrf_token", csrfToken)
+
+			form.Add("createToken", ts.createToken(t))
+			code, _, body := ts.postForm(t, "/snippet/create", form)
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+				// The value is kept so that the user can correct it.
+				assert.StringContains(t, body, "value='"+tt.publishAt+"'")
+				return
+			}
+			inserted := app.snippets.(*mocks.SnippetModel).Inserted()
+			assert.Equal(t, len(inserted), 1)
+			assert.Equal(t, inserted[0].PublishAt.Equal(tt.wantPublishAt), true)
+		})
+	}
+}
+
+func TestSnippetCreateDuplicate(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t)
+	newForm := func(title, token string) url.Values {
+		form := url.Values{}
+		form.Add("title", title)
+		form.Add("content", "A frog jumps into the pond")
+		form.Add("expires", "7")
+		form.Add("visibility", "public")
+		form.Add("csrf_token", csrfToken)
+		form.Add("createToken", token)
+		return form
+	}
+	token := ts.createToken(t)
+
+	// An invalid form doesn't use up the token, so it can be corrected and
+	// submitted again.
+	code, _, body := ts.postForm(t, "/snippet/create", newForm("", token))
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, body, "<input type='hidden' name='createToken' value='"+token+"'>")
+
+	code, header, _ := ts.postForm(t, "/snippet/create", newForm("An old silent pond", token))
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/snippet/view/2")
+
+	// Submitting the same form again redirects to the snippet which was
+	// created the first time, rather than creating another one.
+	code, header, _ = ts.postForm(t, "/snippet/create", newForm("An old silent pond", token))
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/snippet/view/2")
+	_, _, body = ts.get(t, "/")
+	assert.StringContains(t, body, "This snippet has already been created.")
+	assert.Equal(t, len(app.snippets.(*mocks.SnippetModel).Inserted()), 1)
+
+	// A missing or made up token is refused, and the form is shown again
+	// with a new one.
+	for _, token := range []string{"", "made-up"} {
+		code, _, body = ts.postForm(t, "/snippet/create", newForm("An old silent pond", token))
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+		assert.StringContains(t, body, "This form has already been submitted or has expired.")
+		assert.StringContains(t, body, "value='An old silent pond'")
+		assert.Equal(t, len(app.snippets.(*mocks.SnippetModel).Inserted()), 1)
+	}
+}
+
+// The mustLoadLocation() helper loads a time zone, failing the test if it
+// can't.
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestSnippetUpdate(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		code, header, _ := ts.get(t, "/snippet/update/1")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/login")
+	})
+
+	csrfToken := ts.login(t)
+
+	t.Run("Owner", func(t *testing.T) {
+		code, _, body := ts.get(t, "/snippet/update/1")
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "<form action='/snippet/update/1' method='POST'>")
+		assert.StringContains(t, body, "An old silent pond...")
+	})
+
+	t.Run("Non-owner", func(t *testing.T) {
+		code, _, _ := ts.get(t, "/snippet/update/3")
+		assert.Equal(t, code, http.StatusForbidden)
+	})
+
+	t.Run("Non-existent ID", func(t *testing.T) {
+		code, _, _ := ts.get(t, "/snippet/update/2")
+		assert.Equal(t, code, http.StatusNotFound)
+	})
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		title    string
+		wantCode int
+	}{
+		{
+			name:     "Valid submission",
+			urlPath:  "/snippet/update/1",
+			title:    "An updated pond",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Empty title",
+			urlPath:  "/snippet/update/1",
+			title:    "",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "Non-owner submission",
+			urlPath:  "/snippet/update/3",
+			title:    "An updated pond",
+			wantCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("title", tt.title)
+			form.Add("content", "A frog jumps into the pond")
+			form.Add("expires", "7")
+			form.Add("visibility", "public")
+			form.Add("csrf_token", csrfToken)
+
+			code, _, _ := ts.postForm(t, tt.urlPath, form)
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+}
+
+// expirySnippetModel is a mock SnippetModel which gives snippet 1 the expiry
+// time expires, and records the expiry passed to Update().
+type expirySnippetModel struct {
+	*mocks.SnippetModel
+	expires time.Time
+	updated int
+}
+
+func (m *expirySnippetModel) Get(ctx context.Context, id int, viewerID int) (*models.Snippet, error) {
+	snippet, err := m.SnippetModel.Get(ctx, id, viewerID)
+	if err != nil || id != 1 {
+		return snippet, err
+	}
+	withExpiry := *snippet
+	withExpiry.Expires = m.expires
+	return &withExpiry, nil
+}
+
+func (m *expirySnippetModel) Update(ctx context.Context, id int, title string, content string, expires int, visibility string, language string) error {
+	m.updated = expires
+	return m.SnippetModel.Update(ctx, id, title, content, expires, visibility, language)
+}
+
+// Saving the edit form without touching the expiry should leave it as it was,
+// even if the snippet has already expired.
+func TestSnippetUpdateExpiry(t *testing.T) {
+	app := newTestApplication(t)
+	snippets := &expirySnippetModel{SnippetModel: &mocks.SnippetModel{}, expires: time.Now().Add(-time.Hour)}
+	app.snippets = snippets
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	csrfToken := ts.login(t)
+
+	_, _, body := ts.get(t, "/snippet/update/1")
+	assert.StringContains(t, body, "value='keep' checked")
+
+	tests := []struct {
+		name       string
+		expires    string
+		customDays string
+		wantDays   int
+	}{
+		{
+			name:     "Unchanged",
+			expires:  "keep",
+			wantDays: models.KeepExpiry,
+		},
+		{
+			name:     "Never",
+			expires:  "never",
+			wantDays: models.NeverExpires,
+		},
+		{
+			name:     "One week",
+			expires:  "7",
+			wantDays: 7,
+		},
+		{
+			name:       "Custom",
+			expires:    "custom",
+			customDays: "30",
+			wantDays:   30,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snippets.updated = 0
+			form := url.Values{}
+			form.Add("title", "An old silent pond")
+			form.Add("content", "An old silent pond...")
+			form.Add("expires", tt.expires)
+			form.Add("customDays", tt.customDays)
+			form.Add("visibility", "public")
+			form.Add("csrf_token", csrfToken)
+			code, _, _ := ts.postForm(t, "/snippet/update/1", form)
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, snippets.updated, tt.wantDays)
+		})
+	}
+}
+
+func TestSnippetDelete(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Delete button hidden when logged out", func(t *testing.T) {
+		_, _, body := ts.get(t, "/snippet/view/1")
+		if strings.Contains(body, "/snippet/delete/1") {
+			t.Errorf("got delete button for anonymous user")
+		}
+	})
+
+	csrfToken := ts.login(t)
+
+	t.Run("Delete button shown to owner", func(t *testing.T) {
+		_, _, body := ts.get(t, "/snippet/view/1")
+		assert.StringContains(t, body, "<form action='/snippet/delete/1' method='POST'>")
+	})
+
+	t.Run("Delete button hidden from non-owner", func(t *testing.T) {
+		_, _, body := ts.get(t, "/snippet/view/3")
+		if strings.Contains(body, "/snippet/delete/3") {
+			t.Errorf("got delete button for non-owner")
+		}
+	})
+
+	tests := []struct {
+		name         string
+		urlPath      string
+		wantCode     int
+		wantLocation string
+	}{
+		{
+			name:         "Owner",
+			urlPath:      "/snippet/delete/1",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/",
+		},
+		{
+			name:     "Non-owner",
+			urlPath:  "/snippet/delete/3",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Non-existent ID",
+			urlPath:  "/snippet/delete/2",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("csrf_token", csrfToken)
+
+			code, header, _ := ts.postForm(t, tt.urlPath, form)
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantLocation != "" {
+				assert.Equal(t, header.Get("Location"), tt.wantLocation)
+			}
+		})
+	}
+}
+
+func TestSnippetRestore(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t)
+
+	t.Run("Trash page", func(t *testing.T) {
+		code, _, body := ts.get(t, "/account/trash")
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "First autumn morning")
+		assert.StringContains(t, body, "<form action='/snippet/restore/4' method='POST'>")
+	})
+
+	t.Run("Deleted snippet is not viewable", func(t *testing.T) {
+		code, _, _ := ts.get(t, "/snippet/view/4")
+		assert.Equal(t, code, http.StatusNotFound)
+	})
+
+	tests := []struct {
+		name         string
+		urlPath      string
+		wantCode     int
+		wantLocation string
+	}{
+		{
+			name:         "Deleted snippet",
+			urlPath:      "/snippet/restore/4",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/account/trash",
+		},
+		{
+			name:     "Snippet not in trash",
+			urlPath:  "/snippet/restore/1",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Non-existent ID",
+			urlPath:  "/snippet/restore/2",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("csrf_token", csrfToken)
+
+			code, header, _ := ts.postForm(t, tt.urlPath, form)
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantLocation != "" {
+				assert.Equal(t, header.Get("Location"), tt.wantLocation)
+			}
+		})
+	}
+}
+
+func TestSnippetHistory(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		code, header, _ := ts.get(t, "/snippet/view/1/history")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/login")
+	})
+
+	csrfToken := ts.login(t)
+
+	t.Run("Non-owner", func(t *testing.T) {
+		code, _, _ := ts.get(t, "/snippet/view/3/history")
+		assert.Equal(t, code, http.StatusForbidden)
+	})
+
+	t.Run("No revisions", func(t *testing.T) {
+		code, _, body := ts.get(t, "/snippet/view/1/history")
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "This snippet hasn't been edited yet.")
+	})
+
+	t.Run("Link shown to owner", func(t *testing.T) {
+		_, _, body := ts.get(t, "/snippet/view/1")
+		assert.StringContains(t, body, "<a href='/snippet/view/1/history'>History</a>")
+	})
+
+	form := url.Values{}
+	form.Add("title", "An updated pond")
+	form.Add("content", "A frog jumps into the pond")
+	form.Add("expires", "7")
+	form.Add("visibility", "public")
+	form.Add("csrf_token", csrfToken)
+	code, _, _ := ts.postForm(t, "/snippet/update/1", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	t.Run("Edit creates a revision", func(t *testing.T) {
+		code, _, body := ts.get(t, "/snippet/view/1/history")
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "<strong>An old silent pond</strong>")
+		assert.StringContains(t, body, "<input type='hidden' name='revision' value='1'>")
+	})
+
+	tests := []struct {
+		name         string
+		urlPath      string
+		revision     string
+		wantCode     int
+		wantLocation string
+	}{
+		{
+			name:         "Restore",
+			urlPath:      "/snippet/revert/1",
+			revision:     "1",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/snippet/view/1",
+		},
+		{
+			name:     "Non-existent revision",
+			urlPath:  "/snippet/revert/1",
+			revision: "99",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Revision of another snippet",
+			urlPath:  "/snippet/revert/6",
+			revision: "1",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Non-owner",
+			urlPath:  "/snippet/revert/3",
+			revision: "1",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Invalid revision",
+			urlPath:  "/snippet/revert/1",
+			revision: "foo",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("revision", tt.revision)
+			form.Add("csrf_token", csrfToken)
+
+			code, header, _ := ts.postForm(t, tt.urlPath, form)
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantLocation != "" {
+				assert.Equal(t, header.Get("Location"), tt.wantLocation)
+			}
+		})
+	}
+}
+
+func TestSnippetFavourite(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		_, _, body := ts.get(t, "/snippet/view/1")
+		if strings.Contains(body, "/snippet/favourite/1") {
+			t.Errorf("got favourite button for anonymous user")
+		}
+		code, header, _ := ts.get(t, "/account/favourites")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/login")
+	})
+
+	csrfToken := ts.login(t)
+
+	t.Run("Toggle reflects current state", func(t *testing.T) {
+		_, _, body := ts.get(t, "/snippet/view/1")
+		assert.StringContains(t, body, "<form action='/snippet/favourite/1' method='POST'>")
+		_, _, body = ts.get(t, "/snippet/view/3")
+		assert.StringContains(t, body, "<form action='/snippet/unfavourite/3' method='POST'>")
+	})
+
+	t.Run("Favourites page", func(t *testing.T) {
+		code, _, body := ts.get(t, "/account/favourites")
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "<a href='/snippet/view/3'>Over the wintry</a>")
+	})
+
+	tests := []struct {
+		name         string
+		urlPath      string
+		wantCode     int
+		wantLocation string
+	}{
+		{
+			name:         "Favourite",
+			urlPath:      "/snippet/favourite/1",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/snippet/view/1",
+		},
+		{
+			name:         "Unfavourite",
+			urlPath:      "/snippet/unfavourite/3",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/snippet/view/3",
+		},
+		{
+			name:     "Non-existent ID",
+			urlPath:  "/snippet/favourite/2",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Negative ID",
+			urlPath:  "/snippet/favourite/-1",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("csrf_token", csrfToken)
+
+			code, header, _ := ts.postForm(t, tt.urlPath, form)
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantLocation != "" {
+				assert.Equal(t, header.Get("Location"), tt.wantLocation)
+			}
+		})
+	}
+}
+
+func TestSnippetFork(t *testing.T) {
+	t.Run("Unauthenticated", func(t *testing.T) {
+		app := newTestApplication(t)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		_, _, body := ts.get(t, "/snippet/view/3")
+		if strings.Contains(body, "/snippet/fork/3") {
+			t.Errorf("got fork button for anonymous user")
+		}
+	})
+
+	tests := []struct {
+		name           string
+		urlPath        string
+		wantCode       int
+		wantTitle      string
+		wantContent    string
+		wantVisibility string
+	}{
+		{
+			name:           "Someone else's public snippet",
+			urlPath:        "/snippet/fork/3",
+			wantCode:       http.StatusSeeOther,
+			wantTitle:      "Copy of Over the wintry",
+			wantContent:    "Over the wintry forest...",
+			wantVisibility: "public",
+		},
+		{
+			name:           "Own private snippet",
+			urlPath:        "/snippet/fork/6",
+			wantCode:       http.StatusSeeOther,
+			wantTitle:      "Copy of A private note",
+			wantContent:    "For my eyes only...",
+			wantVisibility: "private",
+		},
+		{
+			name:     "Someone else's unlisted snippet",
+			urlPath:  "/snippet/fork/7",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Non-existent ID",
+			urlPath:  "/snippet/fork/2",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Negative ID",
+			urlPath:  "/snippet/fork/-1",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			csrfToken := ts.login(t)
+			form := url.Values{}
+			form.Add("csrf_token", csrfToken)
+			code, header, _ := ts.postForm(t, tt.urlPath, form)
+			assert.Equal(t, code, tt.wantCode)
+
+			inserted := app.snippets.(*mocks.SnippetModel).Inserted()
+			if tt.wantCode != http.StatusSeeOther {
+				assert.Equal(t, len(inserted), 0)
+				return
+			}
+			// The copy is a new snippet, owned by the user who forked it.
+			assert.Equal(t, header.Get("Location"), "/snippet/view/2")
+			assert.Equal(t, len(inserted), 1)
+			assert.Equal(t, inserted[0].UserID, 1)
+			assert.Equal(t, inserted[0].Title, tt.wantTitle)
+			assert.Equal(t, inserted[0].Content, tt.wantContent)
+			assert.Equal(t, inserted[0].Visibility, tt.wantVisibility)
+		})
+	}
+
+	t.Run("Fork button", func(t *testing.T) {
+		app := newTestApplication(t)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		ts.login(t)
+		_, _, body := ts.get(t, "/snippet/view/3")
+		assert.StringContains(t, body, "<form action='/snippet/fork/3' method='POST'>")
+	})
+
+	t.Run("Long title", func(t *testing.T) {
+		title := forkTitle(strings.Repeat("é", 100), 100)
+		assert.Equal(t, utf8.RuneCountInString(title), 100)
+		assert.Equal(t, strings.HasPrefix(title, "Copy of éé"), true)
+		assert.Equal(t, utf8.RuneCountInString(forkTitle(strings.Repeat("é", 100), 40)), 40)
+	})
+
+	// A fork is checked like any other new snippet, so it's refused if the
+	// content is now over the limit or the title is already taken.
+	refused := []struct {
+		name     string
+		setup    func(app *application)
+		wantBody string
+	}{
+		{
+			name: "Content too long",
+			setup: func(app *application) {
+				app.snippetLimits.contentMax = 10
+			},
+			wantBody: "content: This field cannot be more than 10 characters long",
+		},
+		{
+			name: "Duplicate title",
+			setup: func(app *application) {
+				err := app.users.SetUniqueTitles(context.Background(), 1, true)
+				assert.NilError(t, err)
+			},
+			wantBody: "title: You already have a snippet with this title.",
+		},
+	}
+	for _, tt := range refused {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			snippets := &forkedSnippetModel{SnippetModel: &mocks.SnippetModel{}}
+			app.snippets = snippets
+			tt.setup(app)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			csrfToken := ts.login(t)
+			form := url.Values{}
+			form.Add("csrf_token", csrfToken)
+			code, header, _ := ts.postForm(t, "/snippet/fork/3", form)
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, header.Get("Location"), "/snippet/view/3")
+			assert.Equal(t, len(snippets.Inserted()), 0)
+
+			_, _, body := ts.get(t, "/snippet/view/3")
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+}
+
+// forkedSnippetModel is a mock SnippetModel in which the user already has a
+// copy of every snippet.
+type forkedSnippetModel struct {
+	*mocks.SnippetModel
+}
+
+func (m *forkedSnippetModel) TitleExistsForUser(ctx context.Context, userID int, title string) (bool, error) {
+	return strings.HasPrefix(title, "Copy of "), nil
+}
+
+func TestSnippetExtend(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t)
+
+	t.Run("Account page banner", func(t *testing.T) {
+		code, _, body := ts.get(t, "/account/view")
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "These snippets will expire soon:")
+		assert.StringContains(t, body, "<a href='/snippet/view/1'>An old silent pond</a>")
+		assert.StringContains(t, body, "<form action='/snippet/extend/1' method='POST'>")
+	})
+
+	tests := []struct {
+		name         string
+		urlPath      string
+		wantCode     int
+		wantLocation string
+	}{
+		{
+			name:         "Own snippet",
+			urlPath:      "/snippet/extend/1",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/account/view",
+		},
+		{
+			name:     "Someone else's snippet",
+			urlPath:  "/snippet/extend/3",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Non-existent ID",
+			urlPath:  "/snippet/extend/2",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("csrf_token", csrfToken)
+			code, header, _ := ts.postForm(t, tt.urlPath, form)
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, header.Get("Location"), tt.wantLocation)
+		})
+	}
+
+	t.Run("No banner for other users", func(t *testing.T) {
+		app := newTestApplication(t)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		ts.loginAs(t, "admin@example.com")
+		_, _, body := ts.get(t, "/account/view")
+		if strings.Contains(body, "will expire soon") {
+			t.Error("got an expiring-soon banner for a user without expiring snippets")
+		}
+	})
+}
+
+func TestSnippetComments(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Anonymous", func(t *testing.T) {
+		code, _, body := ts.get(t, "/snippet/view/1")
+		assert.Equal(t, code, http.StatusOK)
+		// Comments are listed newest first, with their author.
+		newest := strings.Index(body, "Thanks for reading!")
+		oldest := strings.Index(body, "What a lovely haiku.")
+		if newest == -1 || oldest == -1 || newest > oldest {
+			t.Errorf("comments missing or in the wrong order (newest at %d, oldest at %d)", newest, oldest)
+		}
+		assert.StringContains(t, body, "<strong>Bob</strong>")
+		// Only logged-in users can comment or delete comments.
+		if strings.Contains(body, "action='/snippet/comment/1'") || strings.Contains(body, "action='/comment/delete/") {
+			t.Error("got comment forms for a user who isn't logged in")
+		}
+	})
+
+	csrfToken := ts.login(t)
+
+	t.Run("No comments", func(t *testing.T) {
+		_, _, body := ts.get(t, "/snippet/view/6")
+		assert.StringContains(t, body, "There are no comments yet.")
+		assert.StringContains(t, body, "<form action='/snippet/comment/6' method='POST'>")
+	})
+
+	t.Run("Delete buttons", func(t *testing.T) {
+		// Alice owns snippet 1, so can delete any comment on it...
+		_, _, body := ts.get(t, "/snippet/view/1")
+		assert.StringContains(t, body, "<form action='/comment/delete/1' method='POST'>")
+		assert.StringContains(t, body, "<form action='/comment/delete/2' method='POST'>")
+		// ...but not comments by other people on someone else's snippet.
+		_, _, body = ts.get(t, "/snippet/view/3")
+		if strings.Contains(body, "action='/comment/delete/3'") {
+			t.Error("got a delete button for someone else's comment")
+		}
+	})
+
+	tests := []struct {
+		name         string
+		urlPath      string
+		body         string
+		wantCode     int
+		wantLocation string
+		wantBody     string
+	}{
+		{
+			name:         "Valid comment",
+			urlPath:      "/snippet/comment/3",
+			body:         "  Beautiful.  ",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/snippet/view/3",
+		},
+		{
+			name:         "Comment on own snippet",
+			urlPath:      "/snippet/comment/1",
+			body:         "Thanks!",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/snippet/view/1",
+		},
+		{
+			name:     "Blank comment",
+			urlPath:  "/snippet/comment/1",
+			body:     "   ",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field cannot be blank",
+		},
+		{
+			name:     "Comment too long",
+			urlPath:  "/snippet/comment/1",
+			body:     strings.Repeat("a", 1001),
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field cannot be more than 1000 characters long",
+		},
+		{
+			name:     "Snippet the user can't see",
+			urlPath:  "/snippet/comment/7",
+			body:     "Hello",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Invalid ID",
+			urlPath:  "/snippet/comment/foo",
+			body:     "Hello",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("body", tt.body)
+			form.Add("csrf_token", csrfToken)
+			code, header, body := ts.postForm(t, tt.urlPath, form)
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, header.Get("Location"), tt.wantLocation)
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+				// The rest of the page is shown as usual.
+				assert.StringContains(t, body, "An old silent pond...")
+				assert.StringContains(t, body, "What a lovely haiku.")
+			}
+		})
+	}
+
+	t.Run("Inserted", func(t *testing.T) {
+		inserted := app.comments.(*mocks.CommentModel).Inserted()
+		assert.Equal(t, len(inserted), 2)
+		assert.Equal(t, inserted[0].SnippetID, 3)
+		assert.Equal(t, inserted[0].UserID, 1)
+		assert.Equal(t, inserted[0].Body, "Beautiful.")
+		assert.Equal(t, inserted[1].SnippetID, 1)
+	})
+
+	// The owner of snippet 3 was notified about Alice's comment, but Alice
+	// wasn't notified about her own comment on snippet 1.
+	t.Run("Notified", func(t *testing.T) {
+		created := app.notifications.(*mocks.NotificationModel).Created()
+		assert.Equal(t, len(created), 1)
+		assert.Equal(t, created[0].UserID, 2)
+		assert.Equal(t, created[0].Message, `Alice commented on your snippet "Over the wintry"`)
+		assert.Equal(t, created[0].Link, "/snippet/view/3")
+	})
+}
+
+func TestCommentDelete(t *testing.T) {
+	tests := []struct {
+		name         string
+		email        string
+		urlPath      string
+		wantCode     int
+		wantLocation string
+	}{
+		{
+			name:         "Comment on own snippet",
+			email:        "alice@example.com",
+			urlPath:      "/comment/delete/1",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/snippet/view/1",
+		},
+		{
+			name:         "Own comment",
+			email:        "alice@example.com",
+			urlPath:      "/comment/delete/2",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/snippet/view/1",
+		},
+		{
+			name:     "Someone else's comment on someone else's snippet",
+			email:    "alice@example.com",
+			urlPath:  "/comment/delete/3",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Neither author nor snippet owner",
+			email:    "admin@example.com",
+			urlPath:  "/comment/delete/1",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Non-existent ID",
+			email:    "alice@example.com",
+			urlPath:  "/comment/delete/99",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			form := url.Values{}
+			form.Add("csrf_token", ts.loginAs(t, tt.email))
+			code, header, _ := ts.postForm(t, tt.urlPath, form)
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, header.Get("Location"), tt.wantLocation)
+		})
+	}
+
+	t.Run("Anonymous", func(t *testing.T) {
+		app := newTestApplication(t)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		_, _, body := ts.get(t, "/user/login")
+		form := url.Values{}
+		form.Add("csrf_token", extractCSRFToken(t, body))
+		code, header, _ := ts.postForm(t, "/comment/delete/1", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/login")
+	})
+}
+
+func TestSnippetReport(t *testing.T) {
+	tests := []struct {
+		name     string
+		urlPath  string
+		reason   string
+		note     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Valid",
+			urlPath:  "/snippet/report/1",
+			reason:   "offensive",
+			note:     "Rude words in the second line.",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "No note",
+			urlPath:  "/snippet/report/1",
+			reason:   "other",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Missing reason",
+			urlPath:  "/snippet/report/1",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field must equal spam, offensive, illegal or other",
+		},
+		{
+			name:     "Unknown reason",
+			urlPath:  "/snippet/report/1",
+			reason:   "boring",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field must equal spam, offensive, illegal or other",
+		},
+		{
+			name:     "Long note",
+			urlPath:  "/snippet/report/1",
+			reason:   "spam",
+			note:     strings.Repeat("a", 501),
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field cannot be more than 500 characters long",
+		},
+		{
+			name:     "Unlisted snippet",
+			urlPath:  "/snippet/report/7",
+			reason:   "spam",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Non-existent ID",
+			urlPath:  "/snippet/report/99",
+			reason:   "spam",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			form := url.Values{}
+			form.Add("reason", tt.reason)
+			form.Add("note", tt.note)
+			form.Add("csrf_token", ts.loginAs(t, "admin@example.com"))
+			code, header, body := ts.postForm(t, tt.urlPath, form)
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+
+			inserted := app.reports.(*mocks.ReportModel).Inserted()
+			if tt.wantCode != http.StatusSeeOther {
+				assert.Equal(t, len(inserted), 0)
+				return
+			}
+			assert.Equal(t, header.Get("Location"), "/snippet/view/1")
+			assert.Equal(t, len(inserted), 1)
+			assert.Equal(t, inserted[0].SnippetID, 1)
+			assert.Equal(t, inserted[0].UserID, 9)
+			assert.Equal(t, inserted[0].Reason, tt.reason)
+			assert.Equal(t, inserted[0].Note, tt.note)
+		})
+	}
+
+	t.Run("Own snippet", func(t *testing.T) {
+		app := newTestApplication(t)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		form := url.Values{}
+		form.Add("reason", "spam")
+		form.Add("csrf_token", ts.login(t))
+		code, _, _ := ts.postForm(t, "/snippet/report/1", form)
+		assert.Equal(t, code, http.StatusForbidden)
+
+		// Nor is there a link to report it.
+		_, _, body := ts.get(t, "/snippet/view/1")
+		if strings.Contains(body, "/snippet/report/1") {
+			t.Error("owner is offered a link to report their own snippet")
+		}
+	})
+
+	t.Run("Duplicate", func(t *testing.T) {
+		app := newTestApplication(t)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		// Alice has already reported snippet 3.
+		csrfToken := ts.login(t)
+		code, _, body := ts.get(t, "/snippet/report/3")
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "<input type='radio' name='reason' value='spam'")
+
+		form := url.Values{}
+		form.Add("reason", "spam")
+		form.Add("csrf_token", csrfToken)
+		code, header, _ := ts.postForm(t, "/snippet/report/3", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/snippet/view/3")
+		assert.Equal(t, len(app.reports.(*mocks.ReportModel).Inserted()), 0)
+
+		_, _, body = ts.get(t, "/snippet/view/3")
+		assert.StringContains(t, body, "You have already reported this snippet.")
+	})
+}
+
+func TestSnippetViewETag(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, header, _ := ts.get(t, "/snippet/view/1")
+	assert.Equal(t, code, http.StatusOK)
+	etag := header.Get("ETag")
+	assert.StringContains(t, etag, `W/"`)
+	assert.Equal(t, header.Get("Cache-Control"), "private, no-cache")
+
+	t.Run("Not modified", func(t *testing.T) {
+		code, header, body := ts.do(t, http.MethodGet, "/snippet/view/1", nil, http.Header{
+			"If-None-Match": {etag},
+		})
+		assert.Equal(t, code, http.StatusNotModified)
+		assert.Equal(t, body, "")
+		assert.Equal(t, header.Get("ETag"), etag)
+		assert.Equal(t, header.Get("Content-Security-Policy"), "")
+	})
+
+	t.Run("Changed", func(t *testing.T) {
+		code, _, body := ts.do(t, http.MethodGet, "/snippet/view/1", nil, http.Header{
+			"If-None-Match": {`W/"0123456789abcdef"`},
+		})
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "An old silent pond...")
+	})
+
+	t.Run("Other snippet", func(t *testing.T) {
+		_, header, _ := ts.get(t, "/snippet/view/3")
+		if header.Get("ETag") == etag {
+			t.Error("different snippets have the same ETag")
+		}
+	})
+
+	// The theme is part of the page, so choosing another one has to change
+	// the ETag, or the browser would carry on showing the old theme.
+	t.Run("Theme changed", func(t *testing.T) {
+		app := newTestApplication(t)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		_, header, body := ts.get(t, "/snippet/view/1")
+		etag := header.Get("ETag")
+		form := url.Values{}
+		form.Add("theme", "dark")
+		form.Add("csrf_token", extractCSRFToken(t, body))
+		ts.postForm(t, "/preferences/theme", form)
+
+		code, header, body := ts.do(t, http.MethodGet, "/snippet/view/1", nil, http.Header{
+			"If-None-Match": {etag},
+		})
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "data-theme='dark'")
+		if header.Get("ETag") == etag {
+			t.Error("changing the theme didn't change the ETag")
+		}
+	})
+
+	t.Run("Logged in", func(t *testing.T) {
+		csrfToken := ts.login(t)
+		code, header, _ := ts.do(t, http.MethodGet, "/snippet/view/1", nil, http.Header{
+			"If-None-Match": {etag},
+		})
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, header.Get("ETag"), "")
+
+		// Once they log out there's a flash message waiting, which mustn't
+		// be lost by a 304 response.
+		form := url.Values{}
+		form.Add("csrf_token", csrfToken)
+		ts.postForm(t, "/user/logout", form)
+		code, header, body := ts.do(t, http.MethodGet, "/snippet/view/1", nil, http.Header{
+			"If-None-Match": {etag},
+		})
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, header.Get("ETag"), "")
+		assert.StringContains(t, body, "You&#39;ve been logged out successfully!")
+	})
+}
+
+func TestSnippetSearch(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "No query",
+			urlPath:  "/snippet/search",
+			wantCode: http.StatusOK,
+			wantBody: "<form action='/snippet/search' method='GET' novalidate>",
+		},
+		{
+			name:     "Matching query",
+			urlPath:  "/snippet/search?q=silent",
+			wantCode: http.StatusOK,
+			wantBody: "<a href='/snippet/view/1'>An old silent pond</a>",
+		},
+		{
+			name:     "No results",
+			urlPath:  "/snippet/search?q=frog",
+			wantCode: http.StatusOK,
+			wantBody: "No snippets found",
+		},
+		{
+			name:     "Past the last page",
+			urlPath:  "/snippet/search?q=silent&page=2",
+			wantCode: http.StatusOK,
+			wantBody: "<a href='/snippet/search?q=silent' rel='prev'>Previous</a>",
+		},
+		{
+			name:     "Invalid page",
+			urlPath:  "/snippet/search?q=silent&page=x",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Blank query",
+			urlPath:  "/snippet/search?q=+++",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field cannot be blank",
+		},
+		{
+			name:     "Long query",
+			urlPath:  "/snippet/search?q=" + strings.Repeat("a", 101),
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field cannot be more than 100 characters long",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+}
+
+func TestSnippetTags(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("View shows tag links", func(t *testing.T) {
+		_, _, body := ts.get(t, "/snippet/view/1")
+		assert.StringContains(t, body, "<a href='/snippet/tag/haiku'>#haiku</a>")
+		assert.StringContains(t, body, "<a href='/snippet/tag/nature'>#nature</a>")
+	})
+
+	t.Run("Tag listing", func(t *testing.T) {
+		code, _, body := ts.get(t, "/snippet/tag/haiku")
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "<a href='/snippet/view/1'>An old silent pond</a>")
+	})
+
+	t.Run("Empty tag listing", func(t *testing.T) {
+		code, _, body := ts.get(t, "/snippet/tag/unknown")
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "There are no snippets with this tag.")
+	})
+
+	csrfToken := ts.login(t)
+
+	tests := []struct {
+		name     string
+		tags     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Valid tags",
+			tags:     " haiku, Nature ,,haiku ",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Too many tags",
+			tags:     "a,b,c,d,e,f",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field cannot contain more than 5 tags",
+		},
+		{
+			name:     "Tag too long",
+			tags:     strings.Repeat("a", 31),
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "Each tag cannot be more than 30 characters long",
+		},
+		{
+			name:     "Unsafe tag",
+			tags:     "a/b",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "Tags may only contain letters, numbers, hyphens and underscores",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("title", "An old silent pond")
+			form.Add("content", "A frog jumps into the pond")
+			form.Add("expires", "7")
+			form.Add("tags", tt.tags)
+			form.Add("visibility", "public")
+			form.Add("csrf_token", csrfToken)
+
+			form.Add("createToken", ts.createToken(t))
+			code, _, body := ts.postForm(t, "/snippet/create", form)
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestSnippetLimits(t *testing.T) {
+	app := newTestApplication(t)
+	app.snippetLimits = snippetLimits{titleMax: 10, contentMax: 20}
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t)
+	snippetForm := func(title, content string) url.Values {
+		form := url.Values{}
+		form.Add("title", title)
+		form.Add("content", content)
+		form.Add("expires", "7")
+		form.Add("visibility", "public")
+		form.Add("csrf_token", csrfToken)
+		return form
+	}
+
+	tests := []struct {
+		name     string
+		title    string
+		content  string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "At the limits",
+			title:    strings.Repeat("a", 10),
+			content:  strings.Repeat("é", 20),
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Title too long",
+			title:    strings.Repeat("a", 11),
+			content:  "A frog",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field cannot be more than 10 characters long",
+		},
+		{
+			name:     "Content too long",
+			title:    "Haiku",
+			content:  strings.Repeat("a", 21),
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field cannot be more than 20 characters long",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run("Create "+tt.name, func(t *testing.T) {
+			form := snippetForm(tt.title, tt.content)
+			form.Add("createToken", ts.createToken(t))
+			code, _, body := ts.postForm(t, "/snippet/create", form)
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+
+		t.Run("Update "+tt.name, func(t *testing.T) {
+			code, _, body := ts.postForm(t, "/snippet/update/1", snippetForm(tt.title, tt.content))
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestSnippetUniqueTitles(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t)
+	snippetForm := func(title string) url.Values {
+		form := url.Values{}
+		form.Add("title", title)
+		form.Add("content", "A frog jumps into the pond")
+		form.Add("expires", "7")
+		form.Add("visibility", "public")
+		form.Add("csrf_token", csrfToken)
+		return form
+	}
+	create := func(t *testing.T, title string) (int, string) {
+		form := snippetForm(title)
+		form.Add("createToken", ts.createToken(t))
+		code, _, body := ts.postForm(t, "/snippet/create", form)
+		return code, body
+	}
+	setUniqueTitles := func(t *testing.T, enabled string) {
+		form := url.Values{}
+		form.Add("enabled", enabled)
+		form.Add("csrf_token", csrfToken)
+		code, header, _ := ts.postForm(t, "/account/unique-titles", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/account/view")
+	}
+	const wantError = "You already have a snippet with this title."
+
+	t.Run("Off by default", func(t *testing.T) {
+		code, _ := create(t, "An old silent pond")
+		assert.Equal(t, code, http.StatusSeeOther)
+	})
+
+	setUniqueTitles(t, "true")
+	_, _, body := ts.get(t, "/account/view")
+	assert.StringContains(t, body, "<button>Turn off</button>")
+
+	t.Run("Create with an existing title", func(t *testing.T) {
+		code, body := create(t, "an OLD silent pond")
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+		assert.StringContains(t, body, wantError)
+	})
+
+	t.Run("Create with a new title", func(t *testing.T) {
+		code, _ := create(t, "A brand new haiku")
+		assert.Equal(t, code, http.StatusSeeOther)
+	})
+
+	t.Run("Update keeping the title", func(t *testing.T) {
+		code, _, _ := ts.postForm(t, "/snippet/update/1", snippetForm("An Old Silent Pond"))
+		assert.Equal(t, code, http.StatusSeeOther)
+	})
+
+	t.Run("Update to another snippet's title", func(t *testing.T) {
+		code, _, body := ts.postForm(t, "/snippet/update/1", snippetForm("A private note"))
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+		assert.StringContains(t, body, wantError)
+	})
+
+	t.Run("Turned off again", func(t *testing.T) {
+		setUniqueTitles(t, "false")
+		code, _ := create(t, "An old silent pond")
+		assert.Equal(t, code, http.StatusSeeOther)
+	})
+}
+
+func TestForgotPassword(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name      string
+		userEmail string
+		wantCode  int
+	}{
+		{
+			name:      "Registered email",
+			userEmail: "alice@example.com",
+			wantCode:  http.StatusSeeOther,
+		},
+		{
+			name:      "Unregistered email",
+			userEmail: "nobody@example.com",
+			wantCode:  http.StatusSeeOther,
+		},
+		{
+			name:      "Invalid email",
+			userEmail: "alice@example.",
+			wantCode:  http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, body := ts.get(t, "/user/password/forgot")
+			form := url.Values{}
+			form.Add("email", tt.userEmail)
+			form.Add("csrf_token", extractCSRFToken(t, body))
+
+			code, _, _ := ts.postForm(t, "/user/password/forgot", form)
+			assert.Equal(t, code, tt.wantCode)
+
+			// Registered and unregistered emails must get the same
+			// confirmation message.
+			if code == http.StatusSeeOther {
+				_, _, body = ts.get(t, "/user/login")
+				assert.StringContains(t, body, "If an account exists for that email address")
+			}
+		})
+	}
+
+	// Only the registered email address should have been sent a reset link.
+	app.wg.Wait()
+	sent := app.mailer.(*mailermocks.Mailer).Sent()
+	assert.Equal(t, len(sent), 1)
+	assert.Equal(t, sent[0].Recipient, "alice@example.com")
+	assert.Equal(t, sent[0].TemplateFile, "password_reset.html")
+}
+
+func TestUserSignupSendsActivationEmail(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/signup")
+	form := url.Values{}
+	form.Add("name", "Bob")
+	form.Add("username", "bob")
+	form.Add("email", "bob@example.com")
+	form.Add("password", "validPa$$w0rd")
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	code, _, _ := ts.postForm(t, "/user/signup", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	// Wait for the background goroutine sending the email to finish.
+	app.wg.Wait()
+	sent := app.mailer.(*mailermocks.Mailer).Sent()
+	assert.Equal(t, len(sent), 1)
+	assert.Equal(t, sent[0].Recipient, "bob@example.com")
+	assert.Equal(t, sent[0].TemplateFile, "user_activation.html")
+	data := sent[0].Data.(map[string]any)
+	assert.Equal(t, data["activationURL"].(string), "https://localhost:4000/user/activate/VALIDACTIVATIONTOKEN")
+}
+
+func TestResetPassword(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/password/reset?token=VALIDRESETTOKEN")
+	assert.StringContains(t, body, "<input type='hidden' name='token' value='VALIDRESETTOKEN'>")
+	validCSRFToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name     string
+		token    string
+		password string
+		confirm  string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Valid token",
+			token:    "VALIDRESETTOKEN",
+			password: "newPa$$w0rd",
+			confirm:  "newPa$$w0rd",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Invalid token",
+			token:    "INVALIDTOKEN",
+			password: "newPa$$w0rd",
+			confirm:  "newPa$$w0rd",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This password reset link is invalid or has expired",
+		},
+		{
+			name:     "Weak password",
+			token:    "VALIDRESETTOKEN",
+			password: "newpassword",
+			confirm:  "newpassword",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field must contain an uppercase letter, a digit and a symbol",
+		},
+		{
+			name:     "Mismatched passwords",
+			token:    "VALIDRESETTOKEN",
+			password: "newPa$$w0rd",
+			confirm:  "otherPa$$word",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "Passwords do not match",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("token", tt.token)
+			form.Add("newPassword", tt.password)
+			form.Add("newPasswordConfirmation", tt.confirm)
+			form.Add("csrf_token", validCSRFToken)
+
+			code, _, body := ts.postForm(t, "/user/password/reset", form)
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestActivateAccount(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Valid token",
+			urlPath:  "/user/activate/VALIDACTIVATIONTOKEN",
+			wantCode: http.StatusOK,
+			wantBody: "your account is now active",
+		},
+		{
+			name:     "Invalid token",
+			urlPath:  "/user/activate/INVALIDTOKEN",
+			wantCode: http.StatusBadRequest,
+			wantBody: "This activation link is invalid",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+}
+
+func TestUserLoginNotActivated(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/login")
+	form := url.Values{}
+	form.Add("email", "bob@example.com")
+	form.Add("password", "pa$$word")
+	form.Add("csrf_token", extractCSRFToken(t, body))
+
+	code, _, body := ts.postForm(t, "/user/login", form)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, body, "Please verify your email first.")
+}
+
+func TestUserLoginTargetURL(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	logIn := func(t *testing.T) (int, http.Header) {
+		_, _, body := ts.get(t, "/user/login")
+		form := url.Values{}
+		form.Add("email", "alice@example.com")
+		form.Add("password", "pa$$word")
+		form.Add("csrf_token", extractCSRFToken(t, body))
+		code, header, _ := ts.postForm(t, "/user/login", form)
+		return code, header
+	}
+	logOut := func(t *testing.T) {
+		_, _, body := ts.get(t, "/snippet/create")
+		form := url.Values{}
+		form.Add("csrf_token", extractCSRFToken(t, body))
+		code, _, _ := ts.postForm(t, "/user/logout", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+	}
+
+	t.Run("Protected page", func(t *testing.T) {
+		code, header, _ := ts.get(t, "/account/snippets?page=2")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/login")
+
+		code, header = logIn(t)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/account/snippets?page=2")
+		logOut(t)
+	})
+
+	t.Run("Cleared after use", func(t *testing.T) {
+		code, header := logIn(t)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/snippet/create")
+		logOut(t)
+	})
+
+	t.Run("Not saved for POST requests", func(t *testing.T) {
+		_, _, body := ts.get(t, "/user/login")
+		form := url.Values{}
+		form.Add("csrf_token", extractCSRFToken(t, body))
+		code, header, _ := ts.postForm(t, "/snippet/delete/1", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/login")
+
+		code, header = logIn(t)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/snippet/create")
+	})
+}
+
+func TestUserLoginRememberMe(t *testing.T) {
+	tests := []struct {
+		name       string
+		remember   string
+		wantMaxAge bool
+	}{
+		{
+			name:       "Remember me",
+			remember:   "true",
+			wantMaxAge: true,
+		},
+		{
+			name:       "Session only",
+			wantMaxAge: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			_, _, body := ts.get(t, "/user/login")
+			validCSRFToken := extractCSRFToken(t, body)
+
+			form := url.Values{}
+			form.Add("email", "alice@example.com")
+			form.Add("password", "pa$$word")
+			form.Add("remember", tt.remember)
+			form.Add("csrf_token", validCSRFToken)
+			code, header, _ := ts.postForm(t, "/user/login", form)
+			assert.Equal(t, code, http.StatusSeeOther)
+
+			var session *http.Cookie
+			for _, cookie := range (&http.Response{Header: header}).Cookies() {
+				if cookie.Name == "session" {
+					session = cookie
+				}
+			}
+			if session == nil {
+				t.Fatal("no session cookie set")
+			}
+
+			if tt.wantMaxAge {
+				// The cookie should last for around 30 days.
+				assert.Equal(t, session.MaxAge > int((29*24*time.Hour).Seconds()), true)
+			} else {
+				assert.Equal(t, session.MaxAge, 0)
+				assert.Equal(t, session.Expires.IsZero(), true)
+			}
+		})
+	}
+}
+
+func TestSessionIdleTimeout(t *testing.T) {
+	app := newTestApplication(t)
+	app.sessionManager.IdleTimeout = 200 * time.Millisecond
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t)
+
+	// Each request resets the idle timeout, so a user who keeps using the
+	// site stays logged in for longer than the timeout.
+	for range 3 {
+		time.Sleep(100 * time.Millisecond)
+		code, _, _ := ts.get(t, "/account/view")
+		assert.Equal(t, code, http.StatusOK)
+	}
+
+	// But once they stop for longer than the timeout the session expires, and
+	// they have to log in again.
+	time.Sleep(400 * time.Millisecond)
+	code, header, _ := ts.get(t, "/account/view")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login")
+}
+
+func TestAccountSessions(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		code, header, _ := ts.get(t, "/account/sessions")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/login")
+	})
+
+	csrfToken := ts.login(t)
+
+	t.Run("List", func(t *testing.T) {
+		code, _, body := ts.get(t, "/account/sessions")
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "<form action='/account/sessions/revoke/AAAAAAAAAAAA' method='POST'>")
+		assert.StringContains(t, body, "<form action='/account/sessions/revoke/BBBBBBBBBBBB' method='POST'>")
+	})
+
+	tests := []struct {
+		name         string
+		urlPath      string
+		wantCode     int
+		wantLocation string
+	}{
+		{
+			name:         "Own session",
+			urlPath:      "/account/sessions/revoke/AAAAAAAAAAAA",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/account/sessions",
+		},
+		{
+			name:     "Another user's session",
+			urlPath:  "/account/sessions/revoke/CCCCCCCCCCCC",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Unknown session",
+			urlPath:  "/account/sessions/revoke/ZZZZ",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:         "Everywhere else",
+			urlPath:      "/account/sessions/revoke-others",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/account/sessions",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("csrf_token", csrfToken)
+
+			code, header, _ := ts.postForm(t, tt.urlPath, form)
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantLocation != "" {
+				assert.Equal(t, header.Get("Location"), tt.wantLocation)
+			}
+		})
+	}
+}
+
+func TestAccountEmailUpdate(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		code, header, _ := ts.get(t, "/account/email/update")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/login")
+	})
+
+	csrfToken := ts.login(t)
+
+	tests := []struct {
+		name         string
+		newEmail     string
+		password     string
+		wantCode     int
+		wantBody     string
+		wantLocation string
+	}{
+		{
+			name:         "Valid submission",
+			newEmail:     " Alice.New@Example.com ",
+			password:     "pa$$word",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/account/view",
+		},
+		{
+			name:     "Wrong password",
+			newEmail: "alice.new@example.com",
+			password: "wrongPa$$word",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "Password is incorrect",
+		},
+		{
+			name:     "Missing password",
+			newEmail: "alice.new@example.com",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field cannot be blank",
+		},
+		{
+			name:     "Duplicate email",
+			newEmail: "dupe@example.com",
+			password: "pa$$word",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "Email address is already in use",
+		},
+		{
+			name:     "Invalid email",
+			newEmail: "alice@example.",
+			password: "pa$$word",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field must be a valid email address",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("newEmail", tt.newEmail)
+			form.Add("password", tt.password)
+			form.Add("csrf_token", csrfToken)
+
+			code, header, body := ts.postForm(t, "/account/email/update", form)
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+			if tt.wantLocation != "" {
+				assert.Equal(t, header.Get("Location"), tt.wantLocation)
+			}
+		})
+	}
+
+	// Only the valid submission should have sent a confirmation email, and it
+	// should go to the new address rather than the current one.
+	app.wg.Wait()
+	sent := app.mailer.(*mailermocks.Mailer).Sent()
+	assert.Equal(t, len(sent), 1)
+	assert.Equal(t, sent[0].Recipient, "alice.new@example.com")
+	assert.Equal(t, sent[0].TemplateFile, "email_change.html")
+	assert.StringContains(t, sent[0].Data.(map[string]any)["confirmURL"].(string), "/account/email/confirm/VALIDEMAILTOKEN")
+
+	t.Run("Confirm", func(t *testing.T) {
+		code, header, _ := ts.get(t, "/account/email/confirm/VALIDEMAILTOKEN")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/account/view")
+
+		code, header, _ = ts.get(t, "/account/email/confirm/INVALIDTOKEN")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/")
+	})
+}
+
+func TestAccountDelete(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		code, header, _ := ts.get(t, "/account/delete")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/login")
+	})
+
+	csrfToken := ts.login(t)
+
+	t.Run("Wrong password", func(t *testing.T) {
+		form := url.Values{}
+		form.Add("password", "wrongPa$$word")
+		form.Add("csrf_token", csrfToken)
+		code, _, body := ts.postForm(t, "/account/delete", form)
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+		assert.StringContains(t, body, "Password is incorrect")
+	})
+
+	t.Run("Missing password", func(t *testing.T) {
+		form := url.Values{}
+		form.Add("csrf_token", csrfToken)
+		code, _, body := ts.postForm(t, "/account/delete", form)
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+		assert.StringContains(t, body, "This field cannot be blank")
+	})
+
+	t.Run("Valid password", func(t *testing.T) {
+		form := url.Values{}
+		form.Add("password", "pa$$word")
+		form.Add("csrf_token", csrfToken)
+		code, header, _ := ts.postForm(t, "/account/delete", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/")
+
+		// The flash message should be shown on the next page...
+		_, _, body := ts.get(t, "/")
+		assert.StringContains(t, body, "Your account has been deleted.")
+
+		// ...and the session should no longer be authenticated.
+		code, header, _ = ts.get(t, "/account/view")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/login")
+	})
+}
+
+func TestUserLoginRateLimit(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/login")
+	validCSRFToken := extractCSRFToken(t, body)
+
+	login := func(password string) (int, string) {
+		form := url.Values{}
+		form.Add("email", "alice@example.com")
+		form.Add("password", password)
+		form.Add("csrf_token", validCSRFToken)
+		code, _, body := ts.postForm(t, "/user/login", form)
+		return code, body
+	}
+
+	for i := 0; i < 5; i++ {
+		code, _ := login("wrongPa$$word")
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+	}
+
+	// Even the correct password is now refused.
+	code, body := login("pa$$word")
+	assert.Equal(t, code, http.StatusTooManyRequests)
+	assert.StringContains(t, body, "Too many failed login attempts. Please try again later.")
+}
+
+func TestAccountExport(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		code, header, _ := ts.get(t, "/account/export.csv")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/login")
+	})
+
+	ts.login(t)
+
+	code, header, body := ts.get(t, "/account/export.csv")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, header.Get("Content-Type"), "text/csv; charset=utf-8")
+	assert.Equal(t, header.Get("Content-Disposition"), `attachment; filename="snippets.csv"`)
+
+	records, err := csv.NewReader(strings.NewReader(body)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A header row, then only the logged in user's snippets: the mock user
+	// doesn't own snippet 3, so it must not appear.
+	assert.Equal(t, len(records), 3)
+	assert.Equal(t, strings.Join(records[0], ","), "id,title,content,created,expires")
+	assert.Equal(t, records[1][0], "1")
+	assert.Equal(t, records[1][1], "An old silent pond")
+	assert.Equal(t, records[2][0], "5")
+	// Fields containing commas, quotes and newlines survive the round trip.
+	assert.Equal(t, records[2][1], `Commas, "quotes"`)
+	assert.Equal(t, records[2][2], "Line one,\nline two")
+	assert.Equal(t, records[2][4], "")
+}
+
+func TestSnippetViewPrivate(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Anonymous", func(t *testing.T) {
+		code, _, _ := ts.get(t, "/snippet/view/6")
+		assert.Equal(t, code, http.StatusNotFound)
+	})
+
+	// The mock snippet 6 is private and owned by the user who logs in here.
+	ts.login(t)
+
+	t.Run("Owner", func(t *testing.T) {
+		code, _, body := ts.get(t, "/snippet/view/6")
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "For my eyes only...")
+		assert.StringContains(t, body, "Private #6")
+	})
+
+	t.Run("Home page", func(t *testing.T) {
+		_, _, body := ts.get(t, "/")
+		if strings.Contains(body, "A private note") {
+			t.Error("private snippet listed on the home page")
+		}
+	})
+}
+
+func TestSnippetViewScheduled(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// The mock snippet 8 is scheduled to be published tomorrow, so only its
+	// owner can see it for now.
+	t.Run("Anonymous", func(t *testing.T) {
+		code, _, _ := ts.get(t, "/snippet/view/8")
+		assert.Equal(t, code, http.StatusNotFound)
+	})
+
+	ts.login(t)
+
+	t.Run("Owner", func(t *testing.T) {
+		code, _, body := ts.get(t, "/snippet/view/8")
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "Scheduled to be published on")
+	})
+
+	t.Run("Published snippet", func(t *testing.T) {
+		_, _, body := ts.get(t, "/snippet/view/1")
+		if strings.Contains(body, "Scheduled to be published") {
+			t.Error("published snippet shown as scheduled")
+		}
+	})
+}
+
+func TestSnippetCreateVisibility(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	csrfToken := ts.login(t)
+
+	_, _, body := ts.get(t, "/snippet/create")
+	assert.StringContains(t, body, "<input type='radio' name='visibility' value='public' checked>")
+
+	tests := []struct {
+		name       string
+		visibility string
+		wantCode   int
+	}{
+		{"Public", "public", http.StatusSeeOther},
+		{"Unlisted", "unlisted", http.StatusSeeOther},
+		{"Private", "private", http.StatusSeeOther},
+		{"Missing", "", http.StatusUnprocessableEntity},
+		{"Unknown", "secret", http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("title", "A private note")
+			form.Add("content", "For my eyes only...")
+			form.Add("expires", "7")
+			form.Add("visibility", tt.visibility)
+			form.Add("csrf_token", csrfToken)
+			form.Add("createToken", ts.createToken(t))
+			code, _, body := ts.postForm(t, "/snippet/create", form)
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantCode == http.StatusUnprocessableEntity {
+				assert.StringContains(t, body, "This field must equal public, unlisted or private")
+			}
+		})
+	}
+
+	// The edit form for a private snippet has the private option selected.
+	_, _, body = ts.get(t, "/snippet/update/6")
+	assert.StringContains(t, body, "<input type='radio' name='visibility' value='private' checked>")
+}
+
+func TestSnippetShared(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Unlisted snippet",
+			urlPath:  "/s/dW5saXN0ZWQtc25pcHBldA",
+			wantCode: http.StatusOK,
+			wantBody: "Only for those with the link...",
+		},
+		{
+			name:     "Public snippet",
+			urlPath:  "/s/c2lsZW50LXBvbmQtc2x1Zw",
+			wantCode: http.StatusOK,
+			wantBody: "An old silent pond...",
+		},
+		{
+			name:     "Unknown slug",
+			urlPath:  "/s/AAAAAAAAAAAAAAAAAAAAAA",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+
+	t.Run("Numeric ID not exposed", func(t *testing.T) {
+		_, _, body := ts.get(t, "/s/dW5saXN0ZWQtc25pcHBldA")
+		for _, leak := range []string{"#7", "/snippet/view/7", "/snippet/update/7", "/snippet/favourite/7"} {
+			if strings.Contains(body, leak) {
+				t.Errorf("shared page contains %q", leak)
+			}
+		}
+	})
+
+	t.Run("Unlisted snippet not on home page", func(t *testing.T) {
+		_, _, body := ts.get(t, "/")
+		if strings.Contains(body, "An unlisted note") {
+			t.Error("unlisted snippet listed on the home page")
+		}
+	})
+}
+
+func TestSnippetCreateLanguage(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	csrfToken := ts.login(t)
+
+	_, _, body := ts.get(t, "/snippet/create")
+	assert.StringContains(t, body, "<option value='go' >go</option>")
+
+	tests := []struct {
+		name     string
+		language string
+		wantCode int
+	}{
+		{"Plain text", "", http.StatusSeeOther},
+		{"Known language", "go", http.StatusSeeOther},
+		{"Unknown language", "klingon", http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("title", "Hello, world")
+			form.Add("content", "package main")
+			form.Add("expires", "7")
+			form.Add("visibility", "public")
+			form.Add("language", tt.language)
+			form.Add("csrf_token", csrfToken)
+			form.Add("createToken", ts.createToken(t))
+			code, _, body := ts.postForm(t, "/snippet/create", form)
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantCode == http.StatusUnprocessableEntity {
+				assert.StringContains(t, body, "This field must be a supported language")
+				assert.StringContains(t, body, "<option value='go' >go</option>")
+			}
+		})
+	}
+}
+
+func TestLocalization(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	getHome := func(t *testing.T, acceptLanguage string) (http.Header, string) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept-Language", acceptLanguage)
+		rs, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rs.Body.Close()
+		body, err := io.ReadAll(rs.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rs.Header, string(body)
+	}
+
+	t.Run("Default", func(t *testing.T) {
+		header, body := getHome(t, "")
+		assert.Equal(t, header.Get("Content-Language"), "en")
+		assert.StringContains(t, body, "<html lang='en' data-theme='system'>")
+		assert.StringContains(t, body, "<h2>Latest Snippets</h2>")
+	})
+
+	t.Run("Unsupported locale", func(t *testing.T) {
+		_, body := getHome(t, "ja-JP")
+		assert.StringContains(t, body, "<h2>Latest Snippets</h2>")
+	})
+
+	t.Run("Accept-Language", func(t *testing.T) {
+		header, body := getHome(t, "fr-FR,fr;q=0.9,en;q=0.8")
+		assert.Equal(t, header.Get("Content-Language"), "fr")
+		assert.StringContains(t, body, "<html lang='fr' data-theme='system'>")
+		assert.StringContains(t, body, "<h2>Derniers extraits</h2>")
+		assert.StringContains(t, body, "<a href='/'>Accueil</a>")
+		assert.StringContains(t, body, "<option value='fr' selected>Français</option>")
+	})
+
+	t.Run("Session preference", func(t *testing.T) {
+		_, body := getHome(t, "")
+		csrfToken := extractCSRFToken(t, body)
+
+		form := url.Values{}
+		form.Add("language", "es")
+		form.Add("csrf_token", csrfToken)
+		code, _, _ := ts.postForm(t, "/language", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+
+		// The choice in the session wins over the Accept-Language header.
+		header, body := getHome(t, "fr")
+		assert.Equal(t, header.Get("Content-Language"), "es")
+		assert.StringContains(t, body, "<h2>Últimos fragmentos</h2>")
+	})
+
+	t.Run("Unsupported choice", func(t *testing.T) {
+		_, body := getHome(t, "")
+		form := url.Values{}
+		form.Add("language", "ja")
+		form.Add("csrf_token", extractCSRFToken(t, body))
+		code, _, _ := ts.postForm(t, "/language", form)
+		assert.Equal(t, code, http.StatusBadRequest)
+	})
+}
+
+func TestAccountTimezone(t *testing.T) {
+	t.Run("Default", func(t *testing.T) {
+		app := newTestApplication(t)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		// Alice hasn't chosen a time zone, so her dates stay in UTC.
+		ts.login(t)
+		code, _, body := ts.get(t, "/account/view")
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "<option value='UTC' selected>UTC</option>")
+	})
+
+	t.Run("Dates are shown in the user's time zone", func(t *testing.T) {
+		app := newTestApplication(t)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		// The mock admin joined at 12:00 UTC, which is 21:00 in Tokyo.
+		ts.loginAs(t, "admin@example.com")
+		_, _, body := ts.get(t, "/account/view")
+		assert.StringContains(t, body, "<td>15 Jan 2024 at 21:00</td>")
+		assert.StringContains(t, body, "<option value='Asia/Tokyo' selected>Asia/Tokyo</option>")
+	})
+
+	t.Run("Change time zone", func(t *testing.T) {
+		app := newTestApplication(t)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		csrfToken := ts.loginAs(t, "admin@example.com")
+		form := url.Values{}
+		form.Add("timezone", "America/New_York")
+		form.Add("csrf_token", csrfToken)
+		code, header, _ := ts.postForm(t, "/account/timezone", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/account/view")
+
+		// The mock model doesn't save the change, but the session does.
+		_, _, body := ts.get(t, "/account/view")
+		assert.StringContains(t, body, "<td>15 Jan 2024 at 07:00</td>")
+	})
+
+	t.Run("Invalid time zone", func(t *testing.T) {
+		app := newTestApplication(t)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		csrfToken := ts.login(t)
+		form := url.Values{}
+		form.Add("timezone", "Mars/Olympus_Mons")
+		form.Add("csrf_token", csrfToken)
+		code, _, body := ts.postForm(t, "/account/timezone", form)
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+		assert.StringContains(t, body, "This field must be a valid time zone")
+	})
+}
+
+func TestTheme(t *testing.T) {
+	// The postTheme() helper sends the theme form and returns the response
+	// status code and Location header.
+	postTheme := func(t *testing.T, ts *testServer, theme, csrfToken string) (int, string) {
+		form := url.Values{}
+		form.Add("theme", theme)
+		form.Add("csrf_token", csrfToken)
+		code, header, _ := ts.postForm(t, "/preferences/theme", form)
+		return code, header.Get("Location")
+	}
+
+	t.Run("Anonymous", func(t *testing.T) {
+		app := newTestApplication(t)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		// Until they choose a theme, users get the system theme.
+		_, _, body := ts.get(t, "/")
+		assert.StringContains(t, body, "data-theme='system'")
+		assert.StringContains(t, body, "<option value='system' selected>System</option>")
+
+		code, location := postTheme(t, ts, "dark", extractCSRFToken(t, body))
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, location, "/")
+
+		// The choice is kept in the session, so it sticks as they move
+		// around the site.
+		for _, urlPath := range []string{"/", "/about", "/snippet/view/1"} {
+			_, _, body = ts.get(t, urlPath)
+			assert.StringContains(t, body, "data-theme='dark'")
+		}
+		assert.StringContains(t, body, "<option value='dark' selected>Dark</option>")
+	})
+
+	t.Run("Invalid theme", func(t *testing.T) {
+		app := newTestApplication(t)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		_, _, body := ts.get(t, "/")
+		code, _ := postTheme(t, ts, "purple", extractCSRFToken(t, body))
+		assert.Equal(t, code, http.StatusBadRequest)
+		_, _, body = ts.get(t, "/")
+		assert.StringContains(t, body, "data-theme='system'")
+	})
+
+	t.Run("Logged in", func(t *testing.T) {
+		app := newTestApplication(t)
+		users := app.users.(*mocks.UserModel)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		csrfToken := ts.login(t)
+		_, _, body := ts.get(t, "/account/view")
+		assert.StringContains(t, body, "<option value='system' selected>System</option>")
+
+		code, location := postTheme(t, ts, "light", csrfToken)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, location, "/account/view")
+		_, _, body = ts.get(t, "/account/view")
+		assert.StringContains(t, body, "data-theme='light'")
+		assert.StringContains(t, body, "Your theme has been updated!")
+
+		// The theme is saved to the account, so it's back when Alice logs in
+		// again on another device.
+		user, err := users.Get(context.Background(), 1)
+		assert.NilError(t, err)
+		assert.Equal(t, user.Theme, "light")
+
+		other := newTestServer(t, app.routes())
+		defer other.Close()
+		_, _, body = other.get(t, "/")
+		assert.StringContains(t, body, "data-theme='system'")
+		other.login(t)
+		_, _, body = other.get(t, "/")
+		assert.StringContains(t, body, "data-theme='light'")
+	})
+}
+
+func TestAccountSnippets(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		code, header, _ := ts.get(t, "/account/snippets")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/login")
+	})
+
+	ts.login(t)
+
+	t.Run("Own snippets", func(t *testing.T) {
+		code, _, body := ts.get(t, "/account/snippets")
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "<a href='/snippet/view/6'>A private note</a>")
+		assert.StringContains(t, body, "<a href='/snippet/view/1'>An old silent pond</a>")
+		assert.StringContains(t, body, "<a href='/snippet/update/1'>Edit</a>")
+		assert.StringContains(t, body, "<form action='/snippet/delete/1' method='POST'>")
+		if strings.Contains(body, "/snippet/view/3") {
+			t.Error("got a snippet owned by another user")
+		}
+		if strings.Contains(body, "class='pagination'") {
+			t.Error("got pagination links for a single page")
+		}
+	})
+
+	t.Run("Past the last page", func(t *testing.T) {
+		code, _, body := ts.get(t, "/account/snippets?page=2")
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "There are no more snippets.")
+		assert.StringContains(t, body, "<a href='/account/snippets' rel='prev'>Previous</a>")
+	})
+
+	t.Run("Invalid page", func(t *testing.T) {
+		code, _, _ := ts.get(t, "/account/snippets?page=0")
+		assert.Equal(t, code, http.StatusBadRequest)
+	})
+
+	t.Run("No snippets", func(t *testing.T) {
+		app := newTestApplication(t)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		// The mock admin doesn't own any snippets.
+		ts.loginAs(t, "admin@example.com")
+		code, _, body := ts.get(t, "/account/snippets")
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "You haven't created any snippets yet.")
+	})
+}
+
+func TestUserProfile(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Public snippets", func(t *testing.T) {
+		code, _, body := ts.get(t, "/user/profile/alice")
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "<h2>Alice</h2>")
+		assert.StringContains(t, body, "@alice &middot; Joined")
+		assert.StringContains(t, body, "<a href='/snippet/view/1'>An old silent pond</a>")
+		if strings.Contains(body, "A private note") {
+			t.Error("got a private snippet on a public profile")
+		}
+	})
+
+	t.Run("Own profile", func(t *testing.T) {
+		app := newTestApplication(t)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		// Even the owner only sees their public snippets here.
+		ts.login(t)
+		_, _, body := ts.get(t, "/user/profile/alice")
+		if strings.Contains(body, "A private note") {
+			t.Error("got a private snippet on a public profile")
+		}
+		_, _, body = ts.get(t, "/account/view")
+		assert.StringContains(t, body, "<a href='/user/profile/alice'>public profile</a>")
+	})
+
+	t.Run("No snippets", func(t *testing.T) {
+		code, _, body := ts.get(t, "/user/profile/carol")
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "Carol hasn't shared any snippets yet.")
+	})
+
+	t.Run("Past the last page", func(t *testing.T) {
+		code, _, body := ts.get(t, "/user/profile/alice?page=2")
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "There are no more snippets.")
+		assert.StringContains(t, body, "<a href='/user/profile/alice' rel='prev'>Previous</a>")
+	})
+
+	t.Run("Invalid page", func(t *testing.T) {
+		code, _, _ := ts.get(t, "/user/profile/alice?page=0")
+		assert.Equal(t, code, http.StatusBadRequest)
+	})
+
+	t.Run("Unknown user", func(t *testing.T) {
+		code, _, _ := ts.get(t, "/user/profile/nobody")
+		assert.Equal(t, code, http.StatusNotFound)
+	})
+}
+
+func TestSnippetPin(t *testing.T) {
+	app := newTestApplication(t)
+	app.snippets = &mocks.SnippetModel{MaxPins: 2}
+	app.snippetLimits.maxPins = 2
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t)
+
+	// The pin() helper pins or unpins a snippet, and returns the response to
+	// the form post.
+	pin := func(t *testing.T, urlPath string) (int, string) {
+		form := url.Values{}
+		form.Add("csrf_token", csrfToken)
+		code, header, _ := ts.postForm(t, urlPath, form)
+		return code, header.Get("Location")
+	}
+
+	t.Run("Unpinned", func(t *testing.T) {
+		// Without any pins the snippets are newest first.
+		_, _, body := ts.get(t, "/account/snippets")
+		assert.StringContains(t, body, "<form action='/snippet/pin/1' method='POST'>")
+		if strings.Index(body, "/snippet/view/6") > strings.Index(body, "/snippet/view/1") {
+			t.Error("got the older snippet first")
+		}
+	})
+
+	t.Run("Pin", func(t *testing.T) {
+		code, location := pin(t, "/snippet/pin/1")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, location, "/account/snippets")
+
+		// Now the pinned snippet is listed first, on the account page and on
+		// the public profile.
+		_, _, body := ts.get(t, "/account/snippets")
+		assert.StringContains(t, body, "Snippet pinned!")
+		assert.StringContains(t, body, "<form action='/snippet/unpin/1' method='POST'>")
+		if strings.Index(body, "/snippet/view/1") > strings.Index(body, "/snippet/view/6") {
+			t.Error("got the pinned snippet after an unpinned one")
+		}
+		_, _, body = ts.get(t, "/user/profile/alice")
+		assert.StringContains(t, body, "<span class='pinned' title='Pinned'>&#128204;</span> <a href='/snippet/view/1'>")
+	})
+
+	t.Run("Limit", func(t *testing.T) {
+		code, _ := pin(t, "/snippet/pin/6")
+		assert.Equal(t, code, http.StatusSeeOther)
+		code, _ = pin(t, "/snippet/pin/8")
+		assert.Equal(t, code, http.StatusSeeOther)
+		_, _, body := ts.get(t, "/account/snippets")
+		assert.StringContains(t, body, "You can&#39;t pin more than 2 snippets. Unpin one first.")
+	})
+
+	t.Run("Unpin", func(t *testing.T) {
+		code, location := pin(t, "/snippet/unpin/1")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, location, "/account/snippets")
+		_, _, body := ts.get(t, "/account/snippets")
+		assert.StringContains(t, body, "Snippet unpinned.")
+		assert.StringContains(t, body, "<form action='/snippet/pin/1' method='POST'>")
+	})
+
+	t.Run("Someone else's snippet", func(t *testing.T) {
+		code, _ := pin(t, "/snippet/pin/3")
+		assert.Equal(t, code, http.StatusNotFound)
+	})
+
+	t.Run("Invalid ID", func(t *testing.T) {
+		code, _ := pin(t, "/snippet/pin/foo")
+		assert.Equal(t, code, http.StatusNotFound)
+	})
+}
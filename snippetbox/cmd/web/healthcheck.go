@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// version is the application version number. It's "dev" by default, and is
+// set at build time for releases using the linker flags, like so:
+//
+//	go build -ldflags="-X main.version=1.2.0" ./cmd/web
+var version = "dev"
+
+// readyTimeout is how long the readiness check waits for the database to
+// respond before reporting that the application isn't ready.
+const readyTimeout = 2 * time.Second
+
+// healthcheck reports that the process is up and able to handle requests. It
+// deliberately doesn't check any dependencies, so a load balancer or process
+// supervisor can use it to tell whether the process itself needs restarting.
+func (app *application) healthcheck(w http.ResponseWriter, r *http.Request) {
+	data := envelope{
+		"status": "available",
+		"system_info": map[string]string{
+			"environment": app.env,
+			"version":     version,
+		},
+	}
+	err := app.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// readiness reports whether the application is ready to serve traffic, which
+// means that the database must be reachable. If it isn't we send a 503 Service
+// Unavailable response, so that a load balancer stops routing requests to
+// this instance until the database comes back.
+func (app *application) readiness(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+
+	err := app.db.PingContext(ctx)
+	if err != nil {
+		app.logger.WarnContext(r.Context(), "readiness check failed", "error", err.Error())
+		app.apiError(w, http.StatusServiceUnavailable, "the database is unavailable")
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"status": "ready"}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
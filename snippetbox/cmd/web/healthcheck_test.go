@@ -0,0 +1,52 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"snippetbox/internal/assert"
+)
+
+func TestHealthcheck(t *testing.T) {
+	app := newTestApplication(t)
+	app.env = "testing"
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, header, body := ts.get(t, "/healthz")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, header.Get("Content-Type"), "application/json")
+
+	var rs struct {
+		Status     string            `json:"status"`
+		SystemInfo map[string]string `json:"system_info"`
+	}
+	err := json.Unmarshal([]byte(body), &rs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, rs.Status, "available")
+	assert.Equal(t, rs.SystemInfo["environment"], "testing")
+	assert.Equal(t, rs.SystemInfo["version"], version)
+}
+
+func TestReadinessDatabaseUnavailable(t *testing.T) {
+	// sql.Open() doesn't connect to the database, so this works without a
+	// MySQL server. Closing the pool straight away means that any ping fails.
+	db, err := sql.Open("mysql", "web:pass@/snippetbox?parseTime=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	app := newTestApplication(t)
+	app.db = db
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, _, body := ts.get(t, "/readyz")
+	assert.Equal(t, code, http.StatusServiceUnavailable)
+	assert.StringContains(t, body, "the database is unavailable")
+}
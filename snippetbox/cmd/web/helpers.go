@@ -2,13 +2,23 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"errors"
 	"fmt"
+	"html/template"
+	"net"
 	"net/http"
+	"net/url"
+	"reflect"
 	"runtime/debug"
+	"snippetbox/internal/models"
+	"snippetbox/internal/validator"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-playground/form/v4"
+	"github.com/julienschmidt/httprouter"
 	"github.com/justinas/nosurf"
 )
 
@@ -16,55 +26,170 @@ import (
 // struct initialized with the current year. Note that we're not using the
 // *http.Request parameter here at the moment, but we will do later in the book.
 func (app *application) newTemplateData(r *http.Request) *templateData {
-	return &templateData{
+	data := &templateData{
 		CurrentYear:     time.Now().Year(),
-		Flash:           app.sessionManager.PopString(r.Context(), "flash"),
+		Flashes:         app.popFlashes(r),
 		IsAuthenticated: app.isAuthenticated(r),
 		CSRFToken:       nosurf.Token(r),
+		Localizer:       contextGetLocalizer(r),
+		OAuthProviders:  app.oauthProviderTitles(),
+		Theme:           app.theme(r),
 	}
+	// Expose the ID of the logged-in user so that templates can decide whether
+	// to show owner-only controls.
+	if data.IsAuthenticated {
+		data.AuthenticatedUserID = app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+		// The notification count is only a hint, so if it can't be loaded
+		// we log the error and show the page without it.
+		count, err := app.notifications.UnreadCount(r.Context(), data.AuthenticatedUserID)
+		if err != nil {
+			app.logger.ErrorContext(r.Context(), err.Error())
+		}
+		data.UnreadNotifications = count
+	}
+	return data
 }
 
-// The serverError helper writes an error message and stack trace to the errorLog,
-// then sends a generic 500 Internal Server Error response to the user.
-func (app *application) serverError(w http.ResponseWriter, err error) {
-	trace := fmt.Sprintf("%s\n%s", err.Error(), debug.Stack())
-	app.errorLog.Output(2, trace)
+// The serverError helper logs the error message along with the request method,
+// URI and a stack trace as structured attributes (the request ID is added by
+// our contextHandler), then sends a generic 500 Internal Server Error response
+// to the user.
+func (app *application) serverError(w http.ResponseWriter, r *http.Request, err error) {
+	var (
+		method = r.Method
+		uri    = r.URL.RequestURI()
+		trace  = string(debug.Stack())
+	)
+	app.logger.ErrorContext(r.Context(), err.Error(), "method", method, "uri", uri, "trace", trace)
 	if app.debug {
-		http.Error(w, trace, http.StatusInternalServerError)
+		app.errorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("%s\n%s", err.Error(), trace))
 		return
 	}
-	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	app.errorResponse(w, r, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+}
+
+// The errorResponse() helper is used by all the other error helpers to send
+// the response. API clients get the message as JSON, in the same
+// {"error": ...} envelope which the API handlers use, and everyone else gets
+// it as plain text.
+func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message string) {
+	if wantsJSON(r) {
+		app.apiError(w, status, message)
+		return
+	}
+	http.Error(w, message, status)
+}
+
+// The wantsJSON() helper reports whether an error response for the request
+// should be JSON. That's the case for everything under /api/, and for any
+// request whose Accept header lists application/json before text/html.
+// Browsers list text/html first (and often */* after it), so they still get
+// plain text.
+func wantsJSON(r *http.Request) bool {
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		return true
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, _ := strings.Cut(accept, ";")
+		switch strings.ToLower(strings.TrimSpace(mediaType)) {
+		case "application/json":
+			return true
+		case "text/html":
+			return false
+		}
+	}
+	return false
 }
 
 // The clientError helper sends a specific status code and corresponding description
 // to the user. We'll use this later in the book to send responses like 400 "Bad
 // Request" when there's a problem with the request that the user sent.
-func (app *application) clientError(w http.ResponseWriter, status int) {
-	http.Error(w, http.StatusText(status), status)
+func (app *application) clientError(w http.ResponseWriter, r *http.Request, status int) {
+	app.errorResponse(w, r, status, http.StatusText(status))
+}
+
+// The badRequest() helper sends a 400 Bad Request response when a form
+// couldn't be decoded, or a 413 Request Entity Too Large response if that was
+// because the body was over the size limit.
+func (app *application) badRequest(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errBodyTooLarge) {
+		app.bodyTooLarge(w, r)
+		return
+	}
+	app.clientError(w, r, http.StatusBadRequest)
+}
+
+// The bodyTooLarge() helper sends a 413 Request Entity Too Large response,
+// saying what the limit is.
+func (app *application) bodyTooLarge(w http.ResponseWriter, r *http.Request) {
+	message := fmt.Sprintf("request body must not be larger than %d bytes", app.maxBodyBytes)
+	app.errorResponse(w, r, http.StatusRequestEntityTooLarge, message)
 }
 
 // For consistency, we'll also implement a notFound helper. This is simply a
 // convenience wrapper around clientError which sends a 404 Not Found response to
 // the user.
-func (app *application) notFound(w http.ResponseWriter) {
-	app.clientError(w, http.StatusNotFound)
+func (app *application) notFound(w http.ResponseWriter, r *http.Request) {
+	app.clientError(w, r, http.StatusNotFound)
+}
+
+// The forbidden helper sends a 403 Forbidden response to the user. We use this
+// when an authenticated user tries to act on a resource they don't own.
+func (app *application) forbidden(w http.ResponseWriter, r *http.Request) {
+	app.clientError(w, r, http.StatusForbidden)
 }
 
-func (app *application) render(w http.ResponseWriter, status int, page string, data *templateData) {
+// The rateLimitExceeded helper sends a 429 Too Many Requests response to the
+// user.
+func (app *application) rateLimitExceeded(w http.ResponseWriter, r *http.Request) {
+	app.clientError(w, r, http.StatusTooManyRequests)
+}
+
+// The render() helper renders a page in the normal base layout, with the
+// navigation bar and footer.
+func (app *application) render(w http.ResponseWriter, r *http.Request, status int, page string, data *templateData) {
+	app.renderLayout(w, r, status, "base", page, data)
+}
+
+// The renderLayout() helper renders a page in the given layout, which is the
+// name of one of the templates in ui/html/layouts.
+func (app *application) renderLayout(w http.ResponseWriter, r *http.Request, status int, layout, page string, data *templateData) {
 	ts, ok := app.templateCache[page]
 	if !ok {
 		err := fmt.Errorf("the template %s does not exist", page)
-		app.serverError(w, err)
+		app.serverError(w, r, err)
+		return
+	}
+	if ts.Lookup(layout) == nil {
+		err := fmt.Errorf("the layout %s does not exist", layout)
+		app.serverError(w, r, err)
 		return
 	}
+	// The nonce template function is different for every request, so we make
+	// a copy of the cached template set and give it a nonce function which
+	// returns the nonce for this request. Cloning is cheap, as the parse trees
+	// are shared. Note that html/template doesn't allow a template set to be
+	// cloned once it has been executed, so we must never execute the cached
+	// one directly.
+	ts, err := ts.Clone()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	nonce := contextGetCSPNonce(r.Context())
+	loc := app.userLocation(r)
+	ts.Funcs(template.FuncMap{
+		"nonce":     func() string { return nonce },
+		"humanDate": func(t time.Time) string { return humanDate(t, loc) },
+	})
 	// Initialize a new buffer.
 	buf := new(bytes.Buffer)
 	// Write the template to the buffer, instead of straight to the
 	// http.ResponseWriter. If there's an error, call our serverError() helper
 	// and then return.
-	err := ts.ExecuteTemplate(buf, "base", data)
+	err = ts.ExecuteTemplate(buf, layout, data)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 	// If the template is written to the buffer without any errors, we are safe
@@ -76,6 +201,34 @@ func (app *application) render(w http.ResponseWriter, status int, page string, d
 	buf.WriteTo(w)
 }
 
+// userLocation returns the time zone which dates should be shown in for the
+// current request. The user's chosen zone is copied into their session when
+// they log in (and when they change it), which saves a database query on every
+// page. Anonymous users, and any zone which can't be loaded, get UTC.
+func (app *application) userLocation(r *http.Request) *time.Location {
+	name := app.sessionManager.GetString(r.Context(), "timezone")
+	if name == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// The theme() helper returns the UI theme stored in the session. Logged-in
+// users' themes are copied there from the database when they log in, and
+// anonymous users' choices are only ever kept there. Anybody who hasn't chosen
+// a theme gets models.ThemeSystem.
+func (app *application) theme(r *http.Request) string {
+	theme := app.sessionManager.GetString(r.Context(), "theme")
+	if !validator.PermittedValue(theme, themes...) {
+		return models.ThemeSystem
+	}
+	return theme
+}
+
 // Create a new decodePostForm() helper method. The second parameter here, dst,
 // is the target destination that we want to decode the form data into.
 func (app *application) decodePostForm(r *http.Request, dst any) error {
@@ -83,6 +236,13 @@ func (app *application) decodePostForm(r *http.Request, dst any) error {
 	// createSnippetPost handler.
 	err := r.ParseForm()
 	if err != nil {
+		// If the body was cut off by the limitBody middleware, return
+		// errBodyTooLarge so that the handler can send a 413 response
+		// rather than a generic 400.
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			return errBodyTooLarge
+		}
 		return err
 	}
 	// Call Decode() on our decoder instance, passing the target destination as
@@ -100,9 +260,52 @@ func (app *application) decodePostForm(r *http.Request, dst any) error {
 		// For all other errors, we return them as normal.
 		return err
 	}
+	// Apply any trim or lower options from the struct tags to the decoded
+	// values.
+	normalizeForm(dst)
 	return nil
 }
 
+// The newFormDecoder() helper returns a form decoder which understands our
+// extra struct tag options. A tag like `form:"email,trim,lower"` is decoded
+// from the "email" field as normal, and the options after the first comma are
+// applied by normalizeForm() once decoding has finished.
+func newFormDecoder() *form.Decoder {
+	decoder := form.NewDecoder()
+	decoder.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("form"), ",")
+		return name
+	})
+	return decoder
+}
+
+// The normalizeForm() helper trims and/or lowercases the string fields of the
+// struct pointed to by dst, according to the options in their form struct
+// tags. This is opt-in per field, so that values where whitespace or case is
+// significant (like snippet content and passwords) are left alone.
+func normalizeForm(dst any) {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return
+	}
+	v = v.Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() != reflect.String || !field.CanSet() {
+			continue
+		}
+		_, options, _ := strings.Cut(v.Type().Field(i).Tag.Get("form"), ",")
+		for _, option := range strings.Split(options, ",") {
+			switch option {
+			case "trim":
+				field.SetString(strings.TrimSpace(field.String()))
+			case "lower":
+				field.SetString(strings.ToLower(field.String()))
+			}
+		}
+	}
+}
+
 func (app *application) isAuthenticated(r *http.Request) bool {
 	isAuthenticated, ok := r.Context().Value(isAuthenticatedContextKey).(bool)
 	if !ok {
@@ -110,3 +313,218 @@ func (app *application) isAuthenticated(r *http.Request) bool {
 	}
 	return isAuthenticated
 }
+
+// The readIDParam() helper retrieves the "id" URL parameter from the request
+// context and converts it to a positive integer. If this isn't possible it
+// returns an error.
+func (app *application) readIDParam(r *http.Request) (int, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		return 0, errors.New("invalid id parameter")
+	}
+	return id, nil
+}
+
+// The logIn() helper logs a user in, once they've proved who they are with
+// their password or through an OAuth provider.
+func (app *application) logIn(r *http.Request, user *models.User, rememberMe bool) error {
+	// Use the RenewToken() method on the current session to change the session
+	// ID. It's good practice to generate a new session ID when the
+	// authentication state or privilege levels changes for the user (e.g. login
+	// and logout operations).
+	err := app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		return err
+	}
+	// If the user asked to be remembered, extend the session lifetime and
+	// send a persistent cookie so that they stay logged in across browser
+	// restarts. Otherwise the session cookie is deleted when the browser is
+	// closed.
+	if rememberMe {
+		app.sessionManager.RememberMe(r.Context(), true)
+		app.sessionManager.SetDeadline(r.Context(), time.Now().Add(rememberMeLifetime).UTC())
+	} else {
+		app.sessionManager.RememberMe(r.Context(), false)
+	}
+	// Add the ID of the current user to the session, so that they are now
+	// 'logged in'.
+	app.sessionManager.Put(r.Context(), "authenticatedUserID", user.ID)
+	// Copy the user's time zone and theme into the session too, so that
+	// render() can use them without looking the user up on every request.
+	app.sessionManager.Put(r.Context(), "timezone", user.Timezone)
+	app.sessionManager.Put(r.Context(), "theme", user.Theme)
+	// Record which user the new session token belongs to, so that it shows up
+	// on their account sessions page.
+	err = app.sessions.Record(r.Context(), app.sessionManager.Token(r.Context()), user.ID, app.sessionManager.Deadline(r.Context()))
+	if err != nil {
+		return err
+	}
+	app.recordAudit(r, user.ID, models.AuditLogin, "")
+	return nil
+}
+
+// The redirectAfterLogin() helper sends a newly logged-in user back to the
+// page that they were trying to reach when they were asked to log in, or to
+// the create snippet page if there isn't one. The target is removed from the
+// session, so that it doesn't catch them out the next time they log in.
+func (app *application) redirectAfterLogin(w http.ResponseWriter, r *http.Request) {
+	targetURL := app.sessionManager.PopString(r.Context(), "targetURL")
+	http.Redirect(w, r, safeRedirect(targetURL), http.StatusSeeOther)
+}
+
+// safeRedirect() returns targetURL if it's a path on this site, and
+// "/snippet/create" otherwise (including when it's empty). The target is only
+// ever set from the path of a request to this site at the moment, but
+// checking it here means that nothing which stores a target in future can
+// turn the login form into an open redirect. A path must start with a single
+// slash: browsers treat "//evil.com" and "/\evil.com" as links to another
+// host. url.Parse() rejects control characters, which browsers strip out of
+// URLs, so "/\t/evil.com" can't sneak through either.
+func safeRedirect(targetURL string) string {
+	const fallback = "/snippet/create"
+	if !strings.HasPrefix(targetURL, "/") || strings.HasPrefix(targetURL, "//") || strings.HasPrefix(targetURL, `/\`) {
+		return fallback
+	}
+	u, err := url.Parse(targetURL)
+	if err != nil || u.Scheme != "" || u.Host != "" {
+		return fallback
+	}
+	return targetURL
+}
+
+// The readPageParam() helper reads the page number from the "page" query
+// string parameter of a paginated list. It defaults to the first page if the
+// parameter is missing, and returns an error if it isn't a positive integer.
+func (app *application) readPageParam(r *http.Request) (int, error) {
+	s := r.URL.Query().Get("page")
+	if s == "" {
+		return 1, nil
+	}
+	page, err := strconv.Atoi(s)
+	if err != nil || page < 1 {
+		return 0, errors.New("invalid page parameter")
+	}
+	return page, nil
+}
+
+// The background() helper runs fn in a new goroutine. Any panic in fn is
+// recovered and logged rather than crashing the whole application, and the
+// goroutine is tracked by app.wg so that we can wait for it to finish.
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.Error(fmt.Sprintf("%s", err))
+			}
+		}()
+		fn()
+	}()
+}
+
+// The clientIP() helper returns the IP address of the client which made the
+// request, without the port number. Behind a trusted reverse proxy, this is
+// the address which the realIP middleware found in the proxy's header.
+// Otherwise it's the address which the connection came from.
+func (app *application) clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPContextKey).(string); ok {
+		return ip
+	}
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// viewWindow is how long after viewing a snippet that further views of it in
+// the same session are ignored by the view counter.
+const viewWindow = 30 * time.Minute
+
+// The snippetETag() helper returns an ETag for the snippet view page, made from
+// a hash of everything shown on the page for an anonymous visitor (including
+// the relative times, like "3 minutes ago", which change as time goes by even
+// when the snippet doesn't, and the visitor's language and theme, which they
+// can change without logging in). The rendered HTML itself is different every
+// time, because it contains the CSP nonce and a fresh CSRF token, so we use a
+// weak ETag to say that the pages are equivalent rather than byte-for-byte
+// identical.
+func snippetETag(data *templateData) string {
+	h := sha256.New()
+	s := data.Snippet
+	fmt.Fprintf(h, "%d\x00%s\x00%s\x00%s\x00%d\x00%d\x00%d\x00%s\x00%d\x00",
+		s.ID, s.Title, s.Content, s.Language, s.Created.Unix(), s.Expires.Unix(),
+		s.ViewCount, s.Visibility, data.CurrentYear)
+	now := time.Now()
+	fmt.Fprintf(h, "%s\x00%s\x00", relativeTime(s.Created, now), relativeTime(s.Expires, now))
+	if data.Localizer != nil {
+		fmt.Fprintf(h, "%s\x00", data.Localizer.Lang)
+	}
+	fmt.Fprintf(h, "theme\x00%s\x00", data.Theme)
+	for _, tag := range data.Tags {
+		fmt.Fprintf(h, "tag\x00%s\x00", tag)
+	}
+	for _, c := range data.Comments {
+		fmt.Fprintf(h, "comment\x00%d\x00%s\x00%s\x00%s\x00", c.ID, c.UserName, c.Body, relativeTime(c.Created, now))
+	}
+	return fmt.Sprintf(`W/"%x"`, h.Sum(nil)[:16])
+}
+
+// The etagMatches() helper reports whether an If-None-Match header matches
+// etag. The header can hold a list of ETags, or "*" to match anything, and
+// If-None-Match always uses the weak comparison, which ignores the W/ prefix.
+func etagMatches(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// The firstViewInWindow() helper reports whether this is the first time the
+// snippet has been viewed in the current session within viewWindow, and
+// records the view in the session. This stops people inflating the view count
+// just by refreshing the page. We store a Unix timestamp rather than a
+// time.Time, as the session data is gob-encoded and time.Time isn't
+// registered with gob.
+func (app *application) firstViewInWindow(r *http.Request, id int) bool {
+	key := fmt.Sprintf("viewedSnippet:%d", id)
+	now := time.Now()
+	last := app.sessionManager.GetInt64(r.Context(), key)
+	if last != 0 && now.Sub(time.Unix(last, 0)) < viewWindow {
+		return false
+	}
+	app.sessionManager.Put(r.Context(), key, now.Unix())
+	return true
+}
+
+// minPasswordLength is the minimum number of characters allowed in a new
+// password.
+const minPasswordLength = 8
+
+// The checkPassword() helper runs the password strength checks against a new
+// password. If it fails any of them we add a single error message for the
+// field which lists every requirement that wasn't met, so the user can fix
+// them all in one go.
+func checkPassword(v *validator.Validator, key, password string) {
+	problems := validator.PasswordProblems(password, minPasswordLength)
+	if len(problems) > 0 {
+		v.AddFieldError(key, "This field must contain "+joinList(problems))
+		return
+	}
+	v.CheckField(!validator.CommonPassword(password), key, "This password is too common, please choose another")
+}
+
+// The joinList() helper joins items into a human-readable list, like "a, b
+// and c".
+func joinList(items []string) string {
+	if len(items) <= 1 {
+		return strings.Join(items, "")
+	}
+	return strings.Join(items[:len(items)-1], ", ") + " and " + items[len(items)-1]
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"snippetbox/internal/assert"
+	"snippetbox/internal/i18n"
+	"snippetbox/internal/models"
+	"strings"
+	"testing"
+)
+
+func TestDecodePostForm(t *testing.T) {
+	app := newTestApplication(t)
+
+	var dst struct {
+		Email   string `form:"email,trim,lower"`
+		Title   string `form:"title,trim"`
+		Name    string `form:"name,lower"`
+		Content string `form:"content"`
+		Expires int    `form:"expires"`
+		Ignored string `form:"-"`
+	}
+
+	form := url.Values{}
+	form.Add("email", "  Alice@Example.COM \n")
+	form.Add("title", "  An Old Silent Pond  ")
+	form.Add("name", " Alice ")
+	form.Add("content", "  An old silent pond...\n")
+	form.Add("expires", "7")
+	form.Add("Ignored", "value")
+
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	err := app.decodePostForm(r, &dst)
+	assert.NilError(t, err)
+
+	// Fields are only trimmed and lowercased when their tags opt in.
+	assert.Equal(t, dst.Email, "alice@example.com")
+	assert.Equal(t, dst.Title, "An Old Silent Pond")
+	assert.Equal(t, dst.Name, " alice ")
+	assert.Equal(t, dst.Content, "  An old silent pond...\n")
+	assert.Equal(t, dst.Expires, 7)
+	assert.Equal(t, dst.Ignored, "")
+}
+
+func TestDecodePostFormTooLarge(t *testing.T) {
+	app := newTestApplication(t)
+
+	var dst struct {
+		Content string `form:"content"`
+	}
+
+	body := "content=" + strings.Repeat("a", 100)
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Body = http.MaxBytesReader(httptest.NewRecorder(), r.Body, 64)
+
+	err := app.decodePostForm(r, &dst)
+	if !errors.Is(err, errBodyTooLarge) {
+		t.Errorf("got error %v; want errBodyTooLarge", err)
+	}
+}
+
+func TestErrorResponse(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name            string
+		urlPath         string
+		accept          string
+		wantContentType string
+		wantBody        string
+	}{
+		{
+			name:            "No Accept header",
+			urlPath:         "/snippet/view/99",
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Not Found",
+		},
+		{
+			name:            "Browser",
+			urlPath:         "/snippet/view/99",
+			accept:          "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8",
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Not Found",
+		},
+		{
+			name:            "JSON",
+			urlPath:         "/snippet/view/99",
+			accept:          "application/json",
+			wantContentType: "application/json",
+			wantBody:        `"error": "Not Found"`,
+		},
+		{
+			name:            "JSON preferred over HTML",
+			urlPath:         "/missing",
+			accept:          "Application/JSON; q=1.0, text/html",
+			wantContentType: "application/json",
+			wantBody:        `"error": "Not Found"`,
+		},
+		{
+			name:            "API path",
+			urlPath:         "/api/v1/missing",
+			accept:          "text/html",
+			wantContentType: "application/json",
+			wantBody:        `"error": "Not Found"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.accept != "" {
+				header.Set("Accept", tt.accept)
+			}
+			code, rsHeader, body := ts.do(t, http.MethodGet, tt.urlPath, nil, header)
+			assert.Equal(t, code, http.StatusNotFound)
+			assert.Equal(t, rsHeader.Get("Content-Type"), tt.wantContentType)
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+}
+
+func TestRenderLayout(t *testing.T) {
+	app := newTestApplication(t)
+
+	// renderLayout() reads the time zone from the session, so the request
+	// needs a session in its context.
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	ctx, err := app.sessionManager.Load(r.Context(), "")
+	assert.NilError(t, err)
+	r = r.WithContext(ctx)
+
+	data := &templateData{
+		Snippet: &models.Snippet{
+			ID:      1,
+			Title:   "An old silent pond",
+			Content: "An old silent pond...",
+		},
+		Localizer: i18n.NewLocalizer(),
+	}
+
+	tests := []struct {
+		name     string
+		layout   string
+		page     string
+		wantCode int
+		wantBody []string
+		notBody  []string
+	}{
+		{
+			name:     "Base",
+			layout:   "base",
+			page:     "view.html",
+			wantCode: http.StatusOK,
+			wantBody: []string{"<title>Snippet #1 - Snippetbox</title>", "An old silent pond...", "<footer>", "Comments"},
+		},
+		{
+			name:     "Embed",
+			layout:   "embed",
+			page:     "view.html",
+			wantCode: http.StatusOK,
+			wantBody: []string{"<title>Snippet #1 - Snippetbox</title>", "An old silent pond...", "View on Snippetbox"},
+			notBody:  []string{"<nav>", "<footer>", "Comments"},
+		},
+		{
+			name:     "Missing layout",
+			layout:   "missing",
+			page:     "view.html",
+			wantCode: http.StatusInternalServerError,
+		},
+		{
+			name:     "Missing page",
+			layout:   "embed",
+			page:     "missing.html",
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			app.renderLayout(rr, r, http.StatusOK, tt.layout, tt.page, data)
+			assert.Equal(t, rr.Code, tt.wantCode)
+			body := rr.Body.String()
+			for _, want := range tt.wantBody {
+				assert.StringContains(t, body, want)
+			}
+			for _, notWant := range tt.notBody {
+				if strings.Contains(body, notWant) {
+					t.Errorf("body contains %q", notWant)
+				}
+			}
+		})
+	}
+}
+
+func TestETagMatches(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   bool
+	}{
+		{"Empty", "", false},
+		{"Same", `W/"abc"`, true},
+		{"Strong", `"abc"`, true},
+		{"Different", `W/"abd"`, false},
+		{"List", `"xyz", W/"abc"`, true},
+		{"Wildcard", "*", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, etagMatches(tt.header, `W/"abc"`), tt.want)
+		})
+	}
+}
+
+func TestSafeRedirect(t *testing.T) {
+	tests := []struct {
+		name      string
+		targetURL string
+		want      string
+	}{
+		{
+			name:      "Valid path",
+			targetURL: "/account/view",
+			want:      "/account/view",
+		},
+		{
+			name:      "Path with query",
+			targetURL: "/snippet/search?q=pond",
+			want:      "/snippet/search?q=pond",
+		},
+		{
+			name:      "Empty",
+			targetURL: "",
+			want:      "/snippet/create",
+		},
+		{
+			name:      "Protocol-relative URL",
+			targetURL: "//evil.com",
+			want:      "/snippet/create",
+		},
+		{
+			name:      "Absolute URL",
+			targetURL: "https://evil.com",
+			want:      "/snippet/create",
+		},
+		{
+			name:      "Backslash",
+			targetURL: `/\evil.com`,
+			want:      "/snippet/create",
+		},
+		{
+			name:      "Control character",
+			targetURL: "/\t/evil.com",
+			want:      "/snippet/create",
+		},
+		{
+			name:      "Relative path",
+			targetURL: "account/view",
+			want:      "/snippet/create",
+		},
+		{
+			name:      "JavaScript URL",
+			targetURL: "javascript:alert(1)",
+			want:      "/snippet/create",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, safeRedirect(tt.targetURL), tt.want)
+		})
+	}
+}
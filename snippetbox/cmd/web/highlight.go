@@ -0,0 +1,85 @@
+package main
+
+import (
+	"html/template"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+)
+
+// languages is the list of programming languages which users can choose from
+// when creating a snippet. The values are chroma lexer names. An empty
+// language (which isn't in the list) means plain text.
+var languages = []string{
+	"bash",
+	"c",
+	"cpp",
+	"css",
+	"go",
+	"html",
+	"java",
+	"javascript",
+	"json",
+	"python",
+	"ruby",
+	"rust",
+	"sql",
+	"typescript",
+	"yaml",
+}
+
+// languageExtensions maps each of the languages to the usual file extension
+// for it, which is used when a snippet is downloaded. Plain text snippets get
+// ".txt".
+var languageExtensions = map[string]string{
+	"bash":       ".sh",
+	"c":          ".c",
+	"cpp":        ".cpp",
+	"css":        ".css",
+	"go":         ".go",
+	"html":       ".html",
+	"java":       ".java",
+	"javascript": ".js",
+	"json":       ".json",
+	"python":     ".py",
+	"ruby":       ".rb",
+	"rust":       ".rs",
+	"sql":        ".sql",
+	"typescript": ".ts",
+	"yaml":       ".yaml",
+}
+
+// highlightFormatter writes the highlighted code as <span> elements with
+// inline styles, so that we don't need to serve a separate stylesheet for
+// each colour scheme. We leave out the surrounding <pre> element, as the
+// templates already provide one.
+var highlightFormatter = html.New(html.WithClasses(false), html.PreventSurroundingPre(true))
+
+// The highlight() function returns code marked up with syntax highlighting for
+// the given language. If the language is empty or isn't known to chroma, or
+// anything goes wrong, then we return the code as plain escaped text instead.
+func highlight(code, language string) template.HTML {
+	plain := template.HTML(template.HTMLEscapeString(code))
+	if language == "" {
+		return plain
+	}
+	lexer := lexers.Get(language)
+	if lexer == nil {
+		return plain
+	}
+	iterator, err := chroma.Coalesce(lexer).Tokenise(nil, code)
+	if err != nil {
+		return plain
+	}
+	var b strings.Builder
+	err = highlightFormatter.Format(&b, styles.Get("github"), iterator)
+	if err != nil {
+		return plain
+	}
+	// This is safe to mark as HTML, because chroma escapes the text of every
+	// token that it writes.
+	return template.HTML(b.String())
+}
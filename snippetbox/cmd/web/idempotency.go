@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxPendingCreateTokens is the most create form tokens which we keep in a
+// session at once. Every visit to the create page issues a new token, so
+// without a limit somebody reloading the page would grow their session
+// forever. Ten is plenty for a user with several create forms open in tabs.
+const maxPendingCreateTokens = 10
+
+// The newCreateToken() helper issues a one-time token for the create snippet
+// form, and remembers it in the session so that snippetCreatePost() can check
+// that a submitted token is one that we handed out.
+func (app *application) newCreateToken(r *http.Request) (string, error) {
+	token, err := generateNonce()
+	if err != nil {
+		return "", err
+	}
+	tokens, _ := app.sessionManager.Get(r.Context(), "createTokens").([]string)
+	tokens = append(tokens, token)
+	if len(tokens) > maxPendingCreateTokens {
+		tokens = tokens[len(tokens)-maxPendingCreateTokens:]
+	}
+	app.sessionManager.Put(r.Context(), "createTokens", tokens)
+	return token, nil
+}
+
+// The pendingCreateToken() helper reports whether token was issued by
+// newCreateToken() in this session and hasn't been used yet.
+func (app *application) pendingCreateToken(r *http.Request, token string) bool {
+	tokens, _ := app.sessionManager.Get(r.Context(), "createTokens").([]string)
+	return token != "" && slices.Contains(tokens, token)
+}
+
+// The usedCreateToken() helper removes token from the session once a snippet
+// has been created with it, so that it can't be used again.
+func (app *application) usedCreateToken(r *http.Request, token string) {
+	tokens, _ := app.sessionManager.Get(r.Context(), "createTokens").([]string)
+	tokens = slices.DeleteFunc(tokens, func(t string) bool { return t == token })
+	app.sessionManager.Put(r.Context(), "createTokens", tokens)
+}
+
+// createClaims remembers which create form tokens are being used or have
+// been used recently, and the ID of the snippet created with each one. The
+// session alone isn't enough to stop duplicates: when somebody double-clicks
+// the submit button, both requests load the session before either of them
+// saves it, so both would see the token as unused. Instead the first request
+// claims the token here, and the second waits for it to finish and then
+// redirects to the same snippet. It is safe for concurrent use.
+type createClaims struct {
+	mu     sync.Mutex
+	claims map[string]*createClaim
+	ttl    time.Duration
+	// now returns the current time. It's a field so that tests can control
+	// the clock.
+	now func() time.Time
+}
+
+// createClaim is the state of one token. Its done channel is closed once the
+// request which claimed it has either created a snippet (and set snippetID)
+// or given up.
+type createClaim struct {
+	done      chan struct{}
+	snippetID int
+	expires   time.Time
+}
+
+func newCreateClaims(ttl time.Duration) *createClaims {
+	return &createClaims{
+		claims: make(map[string]*createClaim),
+		ttl:    ttl,
+		now:    time.Now,
+	}
+}
+
+// claimKey() returns the map key for a user's token. Tokens are random, but
+// including the user ID means that one user can never see the snippet
+// created by another, however the tokens were obtained.
+func claimKey(userID int, token string) string {
+	return strconv.Itoa(userID) + ":" + token
+}
+
+// The claim() method claims token for userID. If nobody else has claimed it
+// then it returns true, and the caller must call finish() or release() when
+// it's done. Otherwise it waits for whoever did claim it, and returns the ID
+// of the snippet they created. If they gave up without creating one the
+// token is claimed afresh. An error is only returned if ctx is cancelled
+// while waiting.
+func (c *createClaims) claim(ctx context.Context, userID int, token string) (snippetID int, claimed bool, err error) {
+	key := claimKey(userID, token)
+	for {
+		c.mu.Lock()
+		existing, ok := c.claims[key]
+		if !ok || (existing.snippetID != 0 && !c.now().Before(existing.expires)) {
+			c.claims[key] = &createClaim{done: make(chan struct{})}
+			c.mu.Unlock()
+			return 0, true, nil
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-existing.done:
+		case <-ctx.Done():
+			return 0, false, ctx.Err()
+		}
+		c.mu.Lock()
+		snippetID = existing.snippetID
+		c.mu.Unlock()
+		if snippetID != 0 {
+			return snippetID, false, nil
+		}
+	}
+}
+
+// The used() method reports whether a snippet has been created with token
+// recently. Once the snippet has been created the token is removed from the
+// session, so this is how we recognise a form which is submitted again later.
+func (c *createClaims) used(userID int, token string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	claim, ok := c.claims[claimKey(userID, token)]
+	return ok && claim.snippetID != 0 && c.now().Before(claim.expires)
+}
+
+// The finish() method records that a snippet has been created with a claimed
+// token, and wakes up anybody waiting for it.
+func (c *createClaims) finish(userID int, token string, snippetID int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if claim, ok := c.claims[claimKey(userID, token)]; ok {
+		claim.snippetID = snippetID
+		claim.expires = c.now().Add(c.ttl)
+		close(claim.done)
+	}
+}
+
+// The release() method gives up a claimed token without creating a snippet,
+// for example because the form was invalid, so that it can be used again.
+func (c *createClaims) release(userID int, token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := claimKey(userID, token)
+	if claim, ok := c.claims[key]; ok {
+		delete(c.claims, key)
+		close(claim.done)
+	}
+}
+
+// cleanup() removes the finished claims which have expired.
+func (c *createClaims) cleanup() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	for key, claim := range c.claims {
+		if claim.snippetID != 0 && !now.Before(claim.expires) {
+			delete(c.claims, key)
+		}
+	}
+}
+
+// runCleanup() calls cleanup() once every interval. It never returns, so it
+// should be run in its own goroutine.
+func (c *createClaims) runCleanup(interval time.Duration) {
+	for range time.Tick(interval) {
+		c.cleanup()
+	}
+}
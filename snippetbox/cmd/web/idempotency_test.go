@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"snippetbox/internal/assert"
+)
+
+func TestCreateClaims(t *testing.T) {
+	// Use a fake clock which we can move forward manually.
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := newCreateClaims(time.Hour)
+	c.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	_, claimed, err := c.claim(ctx, 1, "abc")
+	assert.NilError(t, err)
+	assert.Equal(t, claimed, true)
+	assert.Equal(t, c.used(1, "abc"), false)
+
+	// A second request with the same token waits until the first one has
+	// finished, and gets the ID of the snippet it created.
+	type result struct {
+		id      int
+		claimed bool
+	}
+	results := make(chan result)
+	go func() {
+		id, claimed, err := c.claim(ctx, 1, "abc")
+		if err != nil {
+			t.Error(err)
+		}
+		results <- result{id, claimed}
+	}()
+	select {
+	case <-results:
+		t.Fatal("claim returned before the token was finished")
+	case <-time.After(20 * time.Millisecond):
+	}
+	c.finish(1, "abc", 42)
+	assert.Equal(t, <-results, result{42, false})
+	assert.Equal(t, c.used(1, "abc"), true)
+
+	// The same token belonging to another user is unaffected.
+	assert.Equal(t, c.used(2, "abc"), false)
+	_, claimed, err = c.claim(ctx, 2, "abc")
+	assert.NilError(t, err)
+	assert.Equal(t, claimed, true)
+
+	// A released token can be claimed again by whoever is waiting for it.
+	go func() {
+		id, claimed, err := c.claim(ctx, 2, "abc")
+		if err != nil {
+			t.Error(err)
+		}
+		results <- result{id, claimed}
+	}()
+	time.Sleep(20 * time.Millisecond)
+	c.release(2, "abc")
+	assert.Equal(t, <-results, result{0, true})
+
+	// Giving up waiting returns the context's error.
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, _, err = c.claim(cancelled, 2, "abc")
+	assert.Equal(t, errors.Is(err, context.Canceled), true)
+
+	// Once the TTL has passed the finished claim is forgotten.
+	now = now.Add(time.Hour)
+	assert.Equal(t, c.used(1, "abc"), false)
+	c.cleanup()
+	assert.Equal(t, len(c.claims), 1)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// The loginLimiter type tracks failed login attempts against arbitrary keys
+// (such as a client IP address or an email address) using a sliding window.
+// Once a key has reached the limit of failures within the window it is
+// blocked until the oldest failures fall outside the window. It is safe for
+// concurrent use.
+type loginLimiter struct {
+	mu       sync.Mutex
+	failures map[string][]time.Time
+	limit    int
+	window   time.Duration
+	// now returns the current time. It's a field so that tests can control
+	// the clock.
+	now func() time.Time
+}
+
+func newLoginLimiter(limit int, window time.Duration) *loginLimiter {
+	return &loginLimiter{
+		failures: make(map[string][]time.Time),
+		limit:    limit,
+		window:   window,
+		now:      time.Now,
+	}
+}
+
+// recent() returns the failures for key which are still inside the window,
+// and saves them back to the map. The caller must hold the mutex.
+func (l *loginLimiter) recent(key string) []time.Time {
+	cutoff := l.now().Add(-l.window)
+	times := l.failures[key]
+	i := 0
+	for i < len(times) && !times[i].After(cutoff) {
+		i++
+	}
+	times = times[i:]
+	if len(times) == 0 {
+		delete(l.failures, key)
+	} else {
+		l.failures[key] = times
+	}
+	return times
+}
+
+// blocked() reports whether any of the given keys has reached the limit of
+// failed attempts within the window.
+func (l *loginLimiter) blocked(keys ...string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range keys {
+		if len(l.recent(key)) >= l.limit {
+			return true
+		}
+	}
+	return false
+}
+
+// fail() records a failed attempt against each of the given keys.
+func (l *loginLimiter) fail(keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range keys {
+		l.failures[key] = append(l.recent(key), l.now())
+	}
+}
+
+// reset() forgets all the failed attempts for the given keys.
+func (l *loginLimiter) reset(keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range keys {
+		delete(l.failures, key)
+	}
+}
+
+// cleanup() removes any keys which have no failures inside the window, so
+// that the map doesn't grow forever.
+func (l *loginLimiter) cleanup() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key := range l.failures {
+		l.recent(key)
+	}
+}
+
+// runCleanup() calls cleanup() once every interval. It never returns, so it
+// should be run in its own goroutine.
+func (l *loginLimiter) runCleanup(interval time.Duration) {
+	for range time.Tick(interval) {
+		l.cleanup()
+	}
+}
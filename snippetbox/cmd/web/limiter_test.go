@@ -0,0 +1,44 @@
+package main
+
+import (
+	"snippetbox/internal/assert"
+	"testing"
+	"time"
+)
+
+func TestLoginLimiter(t *testing.T) {
+	// Use a fake clock which we can move forward manually.
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := newLoginLimiter(5, 15*time.Minute)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 4; i++ {
+		l.fail("ip:192.0.2.1", "email:alice@example.com")
+		now = now.Add(time.Minute)
+	}
+	assert.Equal(t, l.blocked("ip:192.0.2.1"), false)
+	assert.Equal(t, l.blocked("email:alice@example.com"), false)
+
+	// The fifth failure within the window triggers the lockout for both keys,
+	// but not for unrelated keys.
+	l.fail("ip:192.0.2.1", "email:alice@example.com")
+	assert.Equal(t, l.blocked("ip:192.0.2.1"), true)
+	assert.Equal(t, l.blocked("email:alice@example.com"), true)
+	assert.Equal(t, l.blocked("ip:192.0.2.2", "email:bob@example.com"), false)
+
+	// Once the first failure slides out of the window the keys are unblocked.
+	now = now.Add(11 * time.Minute)
+	assert.Equal(t, l.blocked("ip:192.0.2.1"), false)
+
+	// A reset clears the failures for just the given key.
+	l.fail("ip:192.0.2.1", "email:alice@example.com")
+	assert.Equal(t, l.blocked("email:alice@example.com"), true)
+	l.reset("email:alice@example.com")
+	assert.Equal(t, l.blocked("email:alice@example.com"), false)
+	assert.Equal(t, l.blocked("ip:192.0.2.1"), true)
+
+	// Cleanup evicts keys with no failures left inside the window.
+	now = now.Add(time.Hour)
+	l.cleanup()
+	assert.Equal(t, len(l.failures), 0)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+)
+
+// contextHandler is a slog.Handler which wraps another handler, adding a
+// "request_id" attribute to any log entry made with a context that carries a
+// request ID (see the requestID middleware). This means that we don't need to
+// remember to add the ID by hand every time that we log something during a
+// request -- we just need to use the *Context() logging methods, such as
+// app.logger.InfoContext(r.Context(), ...).
+type contextHandler struct {
+	slog.Handler
+}
+
+func newContextHandler(h slog.Handler) *contextHandler {
+	return &contextHandler{h}
+}
+
+func (h *contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id, ok := contextGetRequestID(ctx); ok {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+// WithAttrs() and WithGroup() must be overridden too, otherwise the embedded
+// handler's versions would return a handler which isn't wrapped any more.
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{h.Handler.WithGroup(name)}
+}
@@ -1,78 +1,503 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"database/sql"
+	"errors"
 	"flag"
+	"fmt"
 	"html/template"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/netip"
 	"os"
+	"os/signal"
+	"slices"
+	"snippetbox/internal/mailer"
 	"snippetbox/internal/models"
+	"snippetbox/migrations"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+	"unicode"
+	// Embed a copy of the IANA time zone database in the binary, so that
+	// users' time zones can be loaded even on servers (or in containers)
+	// which don't have one installed.
+	_ "time/tzdata"
 
 	"github.com/alexedwards/scs/mysqlstore"
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-playground/form/v4"
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 )
 
 // Add a snippets field to the application struct. This will allow us to
 // make the SnippetModel object available to our handlers.
 type application struct {
-	errorLog       *log.Logger
-	infoLog        *log.Logger
+	logger         *slog.Logger
+	db             *sql.DB
 	snippets       models.SnippetModelInterface
 	users          models.UserModelInterface
+	sessions       models.SessionModelInterface
+	comments       models.CommentModelInterface
+	reports        models.ReportModelInterface
+	notifications  models.NotificationModelInterface
+	audit          models.AuditModelInterface
 	templateCache  map[string]*template.Template
 	formDecoder    *form.Decoder
 	sessionManager *scs.SessionManager
+	mailer         mailer.Mailer
+	loginLimiter   *loginLimiter
+	createClaims   *createClaims
+	limiter        limiterConfig
+	proxyHeader    string
+	trustedProxies prefixList
+	baseURL        string
 	debug          bool
+	env            string
+	metrics        *metrics
+	// The longest time to spend handling a request.
+	requestTimeout time.Duration
+	// The largest request body which we'll accept, in bytes.
+	maxBodyBytes int64
+	// Whether to compress responses for clients which accept it.
+	compress bool
+	// The directory which avatar images are stored in, and the largest
+	// avatar upload which we'll accept, in bytes.
+	avatarDir      string
+	avatarMaxBytes int64
+	// The longest title and content which a snippet can have.
+	snippetLimits snippetLimits
+	// The paths which robots.txt allows and disallows crawlers to visit.
+	robots robotsConfig
+	// How many snippets the home page shows, and in which order by default.
+	homePage homeConfig
+	// Whether the application is in maintenance mode. It can be changed at
+	// any time, by an admin or a SIGHUP signal, so it's an atomic.Bool.
+	maintenance atomic.Bool
+	// The OAuth providers which users can log in with, keyed by the name
+	// used in their URLs (like "github").
+	oauth map[string]*oauthProvider
+	// The maximum time to wait for in-flight requests to complete when
+	// shutting down.
+	drainTimeout time.Duration
+	// Include a sync.WaitGroup which is used to track any background
+	// goroutines (such as those sending emails).
+	wg sync.WaitGroup
+}
+
+// Define a limiterConfig struct to hold the settings for the general request
+// rate limiter.
+type limiterConfig struct {
+	rps     float64
+	burst   int
+	enabled bool
+}
+
+// Define a dbConfig struct to hold the settings for the database connection
+// pool.
+type dbConfig struct {
+	dsn             string
+	maxOpenConns    int
+	maxIdleConns    int
+	connMaxLifetime time.Duration
+	retries         int
+}
+
+// The validate() method checks that the connection pool settings make sense
+// together. A max-open value of 0 (or less) means there's no limit, so the
+// number of idle connections can't exceed it.
+func (cfg dbConfig) validate() error {
+	if cfg.maxOpenConns > 0 && cfg.maxIdleConns > cfg.maxOpenConns {
+		return fmt.Errorf("db-max-idle-conns (%d) must not be greater than db-max-open-conns (%d)", cfg.maxIdleConns, cfg.maxOpenConns)
+	}
+	if cfg.retries < 0 {
+		return errors.New("db-retries must not be negative")
+	}
+	return nil
+}
+
+// Define a sessionConfig struct to hold the settings for sessions and the
+// session cookie.
+type sessionConfig struct {
+	lifetime    time.Duration
+	idleTimeout time.Duration
+	cookie      struct {
+		name     string
+		path     string
+		secure   bool
+		httpOnly bool
+		sameSite sameSite
+	}
+}
+
+// The validate() method checks the session settings. Browsers refuse a cookie
+// with SameSite=None unless it's also Secure, so we don't allow that.
+func (cfg sessionConfig) validate() error {
+	if cfg.lifetime <= 0 {
+		return errors.New("session-lifetime must be greater than zero")
+	}
+	if cfg.idleTimeout < 0 {
+		return errors.New("session-idle-timeout must not be negative")
+	}
+	if cfg.idleTimeout > cfg.lifetime {
+		return errors.New("session-idle-timeout must not be longer than session-lifetime")
+	}
+	if cfg.cookie.name == "" {
+		return errors.New("session-cookie-name must not be empty")
+	}
+	if !strings.HasPrefix(cfg.cookie.path, "/") {
+		return errors.New("session-cookie-path must start with /")
+	}
+	if http.SameSite(cfg.cookie.sameSite) == http.SameSiteNoneMode && !cfg.cookie.secure {
+		return errors.New("session-cookie-samesite none requires session-cookie-secure")
+	}
+	return nil
+}
+
+// Define a snippetLimits struct to hold the longest title and content, in
+// characters, which a snippet can have, and the most snippets which a user can
+// pin.
+type snippetLimits struct {
+	titleMax   int
+	contentMax int
+	maxPins    int
+}
+
+// The validate() method checks that the limits fit in the snippets table. The
+// title column is a VARCHAR(100), and the content column is a TEXT, which
+// holds 65,535 bytes: enough for 16,383 characters even if every one of them
+// takes four bytes.
+func (cfg snippetLimits) validate() error {
+	if cfg.titleMax < 1 || cfg.titleMax > 100 {
+		return fmt.Errorf("snippet-title-max must be between 1 and 100, not %d", cfg.titleMax)
+	}
+	if cfg.contentMax < 1 || cfg.contentMax > 16_383 {
+		return fmt.Errorf("snippet-content-max must be between 1 and 16383, not %d", cfg.contentMax)
+	}
+	if cfg.maxPins < 1 {
+		return fmt.Errorf("snippet-max-pins must be at least 1, not %d", cfg.maxPins)
+	}
+	return nil
+}
+
+// Define a homeConfig struct to hold the number of snippets on each page of
+// the home page, and the order (one of models.SnippetOrders) which they're
+// shown in unless the visitor picks another.
+type homeConfig struct {
+	perPage int
+	sort    string
+}
+
+// The validate() method checks the settings. The limit on the page size is
+// only there to stop the home page from getting out of hand.
+func (cfg homeConfig) validate() error {
+	if cfg.perPage < 1 || cfg.perPage > 100 {
+		return fmt.Errorf("home-per-page must be between 1 and 100, not %d", cfg.perPage)
+	}
+	if !slices.Contains(models.SnippetOrders, cfg.sort) {
+		return fmt.Errorf("home-sort must be one of %s, not %q", strings.Join(models.SnippetOrders, ", "), cfg.sort)
+	}
+	return nil
+}
+
+// Define a robotsConfig struct to hold the paths which robots.txt allows and
+// disallows crawlers to visit. An allowed path wins over a disallowed one
+// which it's longer than, so a public page under a private prefix (like the
+// profiles under /user/) can be let through.
+type robotsConfig struct {
+	allow    pathList
+	disallow pathList
+}
+
+// The validate() method checks that every path is one which we can write to
+// robots.txt as it is. A path with a space or line break in it would break
+// the file, so those need to be percent-encoded.
+func (cfg robotsConfig) validate() error {
+	err := validateRobotsPaths("robots-allow", cfg.allow)
+	if err != nil {
+		return err
+	}
+	return validateRobotsPaths("robots-disallow", cfg.disallow)
+}
+
+func validateRobotsPaths(name string, paths pathList) error {
+	for _, path := range paths {
+		if !strings.HasPrefix(path, "/") || strings.ContainsFunc(path, unicode.IsSpace) {
+			return fmt.Errorf("%s paths must start with / and not contain spaces, not %q", name, path)
+		}
+	}
+	return nil
+}
+
+// pathList is a list of URL paths which can be read from a flag, written as a
+// comma-separated list. An empty string is an empty list.
+type pathList []string
+
+func (l *pathList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *pathList) Set(value string) error {
+	paths := pathList{}
+	for _, path := range strings.Split(value, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			paths = append(paths, path)
+		}
+	}
+	*l = paths
+	return nil
+}
+
+// prefixList is a list of IP address ranges which can be read from a flag,
+// written as a comma-separated list of CIDR ranges or single IP addresses.
+type prefixList []netip.Prefix
+
+func (l *prefixList) String() string {
+	prefixes := make([]string, len(*l))
+	for i, prefix := range *l {
+		prefixes[i] = prefix.String()
+	}
+	return strings.Join(prefixes, ",")
+}
+
+func (l *prefixList) Set(value string) error {
+	prefixes := prefixList{}
+	for _, s := range strings.Split(value, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		// A single address is a range containing just that address.
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	*l = prefixes
+	return nil
+}
+
+// The contains() method reports whether addr is in any of the ranges.
+func (l prefixList) contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range l {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameSite is a http.SameSite which can be read from a flag.
+type sameSite http.SameSite
+
+func (s sameSite) MarshalText() ([]byte, error) {
+	switch http.SameSite(s) {
+	case http.SameSiteStrictMode:
+		return []byte("strict"), nil
+	case http.SameSiteNoneMode:
+		return []byte("none"), nil
+	default:
+		return []byte("lax"), nil
+	}
+}
+
+func (s *sameSite) UnmarshalText(text []byte) error {
+	switch strings.ToLower(string(text)) {
+	case "lax":
+		*s = sameSite(http.SameSiteLaxMode)
+	case "strict":
+		*s = sameSite(http.SameSiteStrictMode)
+	case "none":
+		*s = sameSite(http.SameSiteNoneMode)
+	default:
+		return fmt.Errorf("must be lax, strict or none, not %q", text)
+	}
+	return nil
 }
 
 func main() {
-	addr := flag.String("addr", ":4000", "HTTP network address")
-	dsn := flag.String("dsn", "web:pass@/snippetbox?parseTime=true", "MySQL data source name")
-	debug := flag.Bool("debug", false, "Enable debug logging")
-	flag.Parse()
-	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	errorLog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
-	db, err := openDB(*dsn)
+	// Read the settings from the defaults, config file, environment and
+	// command-line flags. If they're no good there's no point in going any
+	// further, so print the problem and exit straight away.
+	cfg, err := loadConfig(os.Args[1:])
 	if err != nil {
-		errorLog.Fatal(err)
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
+	// Use the slog.New() function to initialize a new structured logger, which
+	// writes JSON-formatted entries to the standard out stream. We wrap the
+	// JSON handler in a contextHandler so that log entries made with a
+	// request's context automatically include the request ID.
+	logger := slog.New(newContextHandler(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+		Level: cfg.logLevel,
+	})))
+	// Bring the database schema up to date first, if we've been asked to.
+	// With -migrate that's all we do.
+	if cfg.migrate != "" || cfg.autoMigrate {
+		err = migrate(logger, cfg)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		if cfg.migrate != "" {
+			return
+		}
+	}
+	db, err := openDB(cfg.db)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	logger.Info("database connection pool established",
+		"max_open_conns", cfg.db.maxOpenConns,
+		"max_idle_conns", cfg.db.maxIdleConns,
+		"conn_max_lifetime", cfg.db.connMaxLifetime.String(),
+		"retries", cfg.db.retries,
+	)
 	defer db.Close()
+	// With -create-admin we create the admin user and then stop, in the same
+	// way as -migrate.
+	if cfg.createAdmin {
+		form := userSignupForm{
+			Name:     cfg.adminName,
+			Username: cfg.adminUsername,
+			Email:    cfg.adminEmail,
+			Password: cfg.adminPassword,
+		}
+		users := &models.UserModel{DB: db, Retries: cfg.db.retries, BcryptCost: cfg.bcryptCost}
+		id, err := createAdmin(context.Background(), users, form, os.Stdin, os.Stderr)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		logger.Info("created admin user", "user_id", id)
+		return
+	}
+	// Make sure that the avatar directory exists before we try to save any
+	// avatars in it.
+	err = os.MkdirAll(cfg.avatarDir, 0o755)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
 	// Initialize a new template cache...
 	templateCache, err := newTemplateCache()
 	if err != nil {
-		errorLog.Fatal(err)
+		logger.Error(err.Error())
+		os.Exit(1)
 	}
 	// Initialize a decoder instance...
-	formDecoder := form.NewDecoder()
+	formDecoder := newFormDecoder()
+	// Initialize the SMTP mailer. This also parses the email templates, so
+	// we'll find out about any problems with them straight away.
+	smtpMailer, err := mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
 
-	// Use the scs.New() function to initialize a new session manager. Then we
-	// configure it to use our MySQL database as the session store, and set a
-	// lifetime of 12 hours (so that sessions automatically expire 12 hours
-	// after first being created).
-	sessionManager := scs.New()
+	// Initialize a new session manager with our settings, and configure it to
+	// use our MySQL database as the session store.
+	sessionManager := newSessionManager(cfg.session)
 	sessionManager.Store = mysqlstore.New(db)
-	sessionManager.Lifetime = 12 * time.Hour
-	// Make sure that the Secure attribute is set on our session cookies.
-	// Setting this means that the cookie will only be sent by a user's web
-	// browser when a HTTPS connection is being used (and won't be sent over an
-	// unsecure HTTP connection).
-	sessionManager.Cookie.Secure = true
+	logger.Info("session settings",
+		"lifetime", cfg.session.lifetime.String(),
+		"idle_timeout", cfg.session.idleTimeout.String(),
+		"cookie_secure", cfg.session.cookie.secure,
+	)
+	// Initialize the failed login limiter, and start a background goroutine
+	// which periodically evicts stale entries.
+	loginLimiter := newLoginLimiter(cfg.login.maxAttempts, cfg.login.window)
+	go loginLimiter.runCleanup(time.Minute)
+	// The same goes for the one-time tokens on the create snippet form. A
+	// used token is remembered for an hour, which is plenty of time for
+	// anybody to notice that their snippet was created after all.
+	createClaims := newCreateClaims(time.Hour)
+	go createClaims.runCleanup(time.Minute)
+	// Wrap the snippet model in a cache, unless it has been disabled. The
+	// handlers only depend on the SnippetModelInterface, so they don't need to
+	// know whether the cache is there or not.
+	var snippets models.SnippetModelInterface = &models.SnippetModel{DB: db, Retries: cfg.db.retries, MaxPins: cfg.snippet.maxPins}
+	if cfg.cacheTTL > 0 {
+		snippets = models.NewCachedSnippetModel(snippets, cfg.cacheTTL)
+	}
 	// And add it to the application dependencies.
 	app := &application{
-		errorLog:       errorLog,
-		infoLog:        infoLog,
-		snippets:       &models.SnippetModel{DB: db},
-		users:          &models.UserModel{DB: db},
+		logger:         logger,
+		db:             db,
+		snippets:       snippets,
+		users:          &models.UserModel{DB: db, Retries: cfg.db.retries, BcryptCost: cfg.bcryptCost},
+		sessions:       &models.SessionModel{DB: db},
+		comments:       &models.CommentModel{DB: db},
+		reports:        &models.ReportModel{DB: db},
+		notifications:  &models.NotificationModel{DB: db},
+		audit:          &models.AuditModel{DB: db},
 		templateCache:  templateCache,
 		formDecoder:    formDecoder,
 		sessionManager: sessionManager,
-		debug:          *debug,
+		mailer:         smtpMailer,
+		loginLimiter:   loginLimiter,
+		createClaims:   createClaims,
+		limiter:        cfg.limiter,
+		proxyHeader:    cfg.proxyHeader,
+		trustedProxies: cfg.trustedProxies,
+		baseURL:        cfg.baseURL,
+		debug:          cfg.debug,
+		env:            cfg.env,
+		drainTimeout:   cfg.shutdownTimeout,
+		maxBodyBytes:   cfg.maxBodyBytes,
+		requestTimeout: cfg.requestTimeout,
+		compress:       cfg.compress,
+		avatarDir:      cfg.avatarDir,
+		avatarMaxBytes: cfg.avatarMaxBytes,
+		snippetLimits:  cfg.snippet,
+		robots:         cfg.robots,
+		homePage:       cfg.home,
+		oauth:          map[string]*oauthProvider{},
+	}
+	if cfg.github.clientID != "" {
+		app.oauth["github"] = newGitHubProvider(cfg.github.clientID, cfg.github.clientSecret, cfg.baseURL+"/user/oauth/github/callback")
+	}
+	if cfg.google.clientID != "" {
+		app.oauth["google"] = newGoogleProvider(cfg.google.clientID, cfg.google.clientSecret, cfg.baseURL+"/user/oauth/google/callback")
 	}
+	// Promote the bootstrap admin, if one was given. This is idempotent, so
+	// it's fine to leave the flag set across restarts.
+	if cfg.adminEmail != "" {
+		err = app.bootstrapAdmin(cfg.adminEmail)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+	}
+	// Only collect metrics if they've been asked for. A nil app.metrics means
+	// that the /metrics route and instrumentation middleware aren't set up.
+	if cfg.metrics {
+		app.metrics = newMetrics(db)
+	}
+	// Start in maintenance mode if we've been asked to, and toggle it
+	// whenever we receive a SIGHUP signal.
+	app.maintenance.Store(cfg.maintenance)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go app.watchMaintenanceSignal(hup)
 	// Initialize a tls.Config struct to hold the non-default TLS settings we
 	// want the server to use. In this case the only thing that we're changing
 	// is the curve preferences value, so that only elliptic curves with
@@ -83,8 +508,11 @@ func main() {
 	// Set the server's TLSConfig field to use the tlsConfig variable we just
 	// created.
 	srv := &http.Server{
-		Addr:      *addr,
-		ErrorLog:  errorLog,
+		Addr: cfg.addr,
+		// The http.Server still expects a *log.Logger for its own errors, so
+		// we use slog.NewLogLogger() to create one which writes to our
+		// structured logger at Error level.
+		ErrorLog:  slog.NewLogLogger(logger.Handler(), slog.LevelError),
 		Handler:   app.routes(),
 		TLSConfig: tlsConfig,
 		// Add Idle, Read and Write timeouts to the server.
@@ -92,21 +520,121 @@ func main() {
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 10 * time.Second,
 	}
-	infoLog.Printf("Starting server on %s", *addr)
+	// Start the background job which deletes expired snippets, unless it has
+	// been disabled. srv.Shutdown() calls the functions registered with
+	// RegisterOnShutdown(), so the job is cancelled as soon as a graceful
+	// shutdown begins, and because background() tracks it with app.wg,
+	// serve() waits for it to stop.
+	if cfg.purgeInterval > 0 {
+		purgeCtx, stopPurge := context.WithCancel(context.Background())
+		srv.RegisterOnShutdown(stopPurge)
+		app.background(func() {
+			app.purgeExpiredSnippets(purgeCtx, cfg.purgeInterval)
+		})
+	}
+	// Use signal.Notify() to relay any SIGINT or SIGTERM signals to the quit
+	// channel, which tells serve() to begin a graceful shutdown.
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	// Use the ListenAndServeTLS() method to start the HTTPS server. We
 	// pass in the paths to the TLS certificate and corresponding private key as
 	// the two parameters.
-	err = srv.ListenAndServeTLS("./tls/cert.pem", "./tls/key.pem")
-	errorLog.Fatal(err)
+	err = app.serve(srv, func() error {
+		return srv.ListenAndServeTLS("./tls/cert.pem", "./tls/key.pem")
+	}, quit)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
 }
 
-// The openDB() function wraps sql.Open() and returns a sql.DB connection pool// for a given DSN.
-func openDB(dsn string) (*sql.DB, error) {
-	db, err := sql.Open("mysql", dsn)
+// The migrate() function applies the embedded migrations (or rolls back the
+// latest one, for -migrate down). The migration files contain several
+// statements each, so it uses its own connection pool with multiStatements
+// turned on, rather than turning it on for the main pool where it would make
+// SQL injection more dangerous.
+func migrate(logger *slog.Logger, cfg config) error {
+	dsn, err := mysql.ParseDSN(cfg.db.dsn)
+	if err != nil {
+		return err
+	}
+	dsn.MultiStatements = true
+	db, err := openDB(dbConfig{dsn: dsn.FormatDSN(), maxOpenConns: 1, maxIdleConns: 1})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	m := &models.Migrator{DB: db, Files: migrations.Files}
+
+	if cfg.migrate == "down" {
+		name, err := m.Down()
+		if errors.Is(err, models.ErrNoMigration) {
+			logger.Info("no migrations to roll back")
+			return nil
+		} else if err != nil {
+			return err
+		}
+		logger.Info("rolled back migration", "migration", name)
+		return nil
+	}
+	applied, err := m.Up()
+	for _, name := range applied {
+		logger.Info("applied migration", "migration", name)
+	}
+	if err != nil {
+		return err
+	}
+	version, _, err := m.Version()
+	if err != nil {
+		return err
+	}
+	logger.Info("database schema is up to date", "version", version)
+	return nil
+}
+
+// The newSessionManager() function uses scs.New() to initialize a new session
+// manager, and applies the session settings to it. Sessions automatically
+// expire their lifetime after first being created, or sooner if they go
+// unused for the idle timeout.
+func newSessionManager(cfg sessionConfig) *scs.SessionManager {
+	sessionManager := scs.New()
+	sessionManager.Lifetime = cfg.lifetime
+	sessionManager.IdleTimeout = cfg.idleTimeout
+	sessionManager.Cookie.Name = cfg.cookie.name
+	sessionManager.Cookie.Path = cfg.cookie.path
+	// When the Secure attribute is set the cookie will only be sent by a
+	// user's web browser when a HTTPS connection is being used (and won't be
+	// sent over an unsecure HTTP connection).
+	sessionManager.Cookie.Secure = cfg.cookie.secure
+	sessionManager.Cookie.HttpOnly = cfg.cookie.httpOnly
+	sessionManager.Cookie.SameSite = http.SameSite(cfg.cookie.sameSite)
+	// By default use a session-only cookie, which is deleted when the browser
+	// is closed. Users who tick "remember me" when logging in get a persistent
+	// cookie instead.
+	sessionManager.Cookie.Persist = false
+	return sessionManager
+}
+
+// The openDB() function wraps sql.Open() and returns a sql.DB connection pool
+// for the given config. Under load the library defaults (unlimited open
+// connections, only two idle ones) cause a lot of connection churn, so we set
+// the pool limits explicitly.
+func openDB(cfg dbConfig) (*sql.DB, error) {
+	err := cfg.validate()
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("mysql", cfg.dsn)
 	if err != nil {
 		return nil, err
 	}
+	// Passing a value less than or equal to 0 to SetMaxOpenConns() or
+	// SetConnMaxLifetime() means that there is no limit.
+	db.SetMaxOpenConns(cfg.maxOpenConns)
+	db.SetMaxIdleConns(cfg.maxIdleConns)
+	db.SetConnMaxLifetime(cfg.connMaxLifetime)
 	if err = db.Ping(); err != nil {
+		db.Close()
 		return nil, err
 	}
 	return db, nil
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"snippetbox/internal/assert"
+)
+
+func TestOpenDB(t *testing.T) {
+	t.Run("Idle greater than open", func(t *testing.T) {
+		cfg := dbConfig{
+			dsn:          "test_web:pass@/test_snippetbox?parseTime=true",
+			maxOpenConns: 5,
+			maxIdleConns: 10,
+		}
+		_, err := openDB(cfg)
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+		assert.StringContains(t, err.Error(), "db-max-idle-conns (10) must not be greater than db-max-open-conns (5)")
+	})
+
+	t.Run("Unlimited open", func(t *testing.T) {
+		cfg := dbConfig{maxOpenConns: 0, maxIdleConns: 10}
+		assert.NilError(t, cfg.validate())
+	})
+
+	t.Run("Pool settings", func(t *testing.T) {
+		// This needs a MySQL server to connect to, like the models tests.
+		if testing.Short() {
+			t.Skip("main: skipping integration test")
+		}
+		cfg := dbConfig{
+			dsn:             "test_web:pass@/test_snippetbox?parseTime=true",
+			maxOpenConns:    7,
+			maxIdleConns:    3,
+			connMaxLifetime: time.Minute,
+		}
+		db, err := openDB(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		stats := db.Stats()
+		assert.Equal(t, stats.MaxOpenConnections, 7)
+		assert.Equal(t, stats.OpenConnections, 1)
+	})
+}
+
+func TestNewSessionManager(t *testing.T) {
+	tests := []struct {
+		name         string
+		args         []string
+		wantName     string
+		wantPath     string
+		wantSecure   bool
+		wantHTTPOnly bool
+		wantSameSite http.SameSite
+	}{
+		{
+			name:         "Defaults",
+			wantName:     "session",
+			wantPath:     "/",
+			wantSecure:   true,
+			wantHTTPOnly: true,
+			wantSameSite: http.SameSiteLaxMode,
+		},
+		{
+			name: "Overridden",
+			args: []string{
+				"-session-cookie-name", "sb_session",
+				"-session-cookie-path", "/app",
+				"-session-cookie-secure=false",
+				"-session-cookie-http-only=false",
+				"-session-cookie-samesite", "Strict",
+			},
+			wantName:     "sb_session",
+			wantPath:     "/app",
+			wantSecure:   false,
+			wantHTTPOnly: false,
+			wantSameSite: http.SameSiteStrictMode,
+		},
+		{
+			name:         "SameSite none",
+			args:         []string{"-session-cookie-samesite", "none"},
+			wantName:     "session",
+			wantPath:     "/",
+			wantSecure:   true,
+			wantHTTPOnly: true,
+			wantSameSite: http.SameSiteNoneMode,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadConfig(tt.args)
+			assert.NilError(t, err)
+			sessionManager := newSessionManager(cfg.session)
+
+			// Put something in a session, so that the session manager sends
+			// a cookie, and check the attributes which the browser sees.
+			h := sessionManager.LoadAndSave(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				sessionManager.Put(r.Context(), "key", "value")
+			}))
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			cookies := rr.Result().Cookies()
+			if len(cookies) != 1 {
+				t.Fatalf("got %d cookies; want 1", len(cookies))
+			}
+			cookie := cookies[0]
+			assert.Equal(t, cookie.Name, tt.wantName)
+			assert.Equal(t, cookie.Path, tt.wantPath)
+			assert.Equal(t, cookie.Secure, tt.wantSecure)
+			assert.Equal(t, cookie.HttpOnly, tt.wantHTTPOnly)
+			assert.Equal(t, cookie.SameSite, tt.wantSameSite)
+		})
+	}
+}
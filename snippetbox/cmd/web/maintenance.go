@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"snippetbox/internal/models"
+)
+
+// maintenanceRetryAfter is how long we ask clients to wait before trying again
+// while the application is in maintenance mode. Most deploys are over well
+// within this.
+const maintenanceRetryAfter = 5 * time.Minute
+
+// The setMaintenance() method turns maintenance mode on or off. It's safe to
+// call from any goroutine, and takes effect for the very next request.
+func (app *application) setMaintenance(on bool) {
+	if app.maintenance.Swap(on) != on {
+		app.logger.Info("maintenance mode changed", "enabled", on)
+	}
+}
+
+// The watchMaintenanceSignal() method toggles maintenance mode each time a
+// signal arrives on sig, so that it can be switched on and off during a deploy
+// with `kill -HUP` rather than a restart. It returns when sig is closed.
+func (app *application) watchMaintenanceSignal(sig <-chan os.Signal) {
+	for range sig {
+		app.setMaintenance(!app.maintenance.Load())
+	}
+}
+
+// The maintenanceMode middleware sends a 503 Service Unavailable response,
+// with a Retry-After header, to every request while maintenance mode is on.
+// A few paths are let through regardless: the liveness check (as the
+// application itself is still healthy), the static files used by the
+// maintenance page, and the login page, so that an admin can log in. Admins
+// are let through to everything, so that they can check the site before
+// turning maintenance mode off again.
+func (app *application) maintenanceMode(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.maintenance.Load() || maintenanceExempt(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// This middleware runs before the session is loaded, so we load a
+		// read-only copy of it ourselves. It's never saved, so nothing we do
+		// with it here (like popping flashes while rendering) has any effect.
+		// If it can't be loaded (perhaps because the database is what's being
+		// maintained) we carry on with an empty session.
+		ctx, err := app.sessionManager.Load(r.Context(), app.sessionToken(r))
+		if err != nil {
+			ctx, _ = app.sessionManager.Load(r.Context(), "")
+		}
+		sr := r.WithContext(ctx)
+		if app.sessionIsAdmin(sr) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Retry-After", strconv.Itoa(int(maintenanceRetryAfter.Seconds())))
+		if wantsJSON(r) {
+			app.apiError(w, http.StatusServiceUnavailable, "the server is down for maintenance, please try again later")
+			return
+		}
+		app.render(w, sr, http.StatusServiceUnavailable, "maintenance.html", app.newTemplateData(sr))
+	})
+}
+
+// The maintenanceExempt() function reports whether a request for path should
+// be served even in maintenance mode.
+func maintenanceExempt(path string) bool {
+	return path == "/healthz" || strings.HasPrefix(path, "/static/") || strings.HasPrefix(path, "/user/login")
+}
+
+// The sessionToken() helper returns the session token from the request's
+// session cookie, or "" if there isn't one.
+func (app *application) sessionToken(r *http.Request) string {
+	cookie, err := r.Cookie(app.sessionManager.Cookie.Name)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+// The sessionIsAdmin() helper reports whether the session in the request
+// context belongs to an admin. Any error looking up the user is treated
+// as them not being one.
+func (app *application) sessionIsAdmin(r *http.Request) bool {
+	id := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	if id == 0 {
+		return false
+	}
+	user, err := app.users.Get(r.Context(), id)
+	if err != nil {
+		return false
+	}
+	return user.Role == models.RoleAdmin
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+
+	"snippetbox/internal/assert"
+)
+
+func TestMaintenanceMode(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Disabled", func(t *testing.T) {
+		code, header, _ := ts.get(t, "/")
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, header.Get("Retry-After"), "")
+	})
+
+	app.setMaintenance(true)
+
+	t.Run("Enabled", func(t *testing.T) {
+		code, header, body := ts.get(t, "/")
+		assert.Equal(t, code, http.StatusServiceUnavailable)
+		assert.Equal(t, header.Get("Retry-After"), "300")
+		assert.StringContains(t, body, "<h2>Down for Maintenance</h2>")
+
+		code, header, body = ts.do(t, http.MethodGet, "/snippet/view/1", nil, http.Header{"Accept": {"application/json"}})
+		assert.Equal(t, code, http.StatusServiceUnavailable)
+		assert.Equal(t, header.Get("Retry-After"), "300")
+		assert.Equal(t, header.Get("Content-Type"), "application/json")
+		assert.StringContains(t, body, "down for maintenance")
+	})
+
+	t.Run("Exempt", func(t *testing.T) {
+		for _, urlPath := range []string{"/healthz", "/static/css/main.css", "/user/login"} {
+			code, _, _ := ts.get(t, urlPath)
+			assert.Equal(t, code, http.StatusOK)
+		}
+		code, _, _ := ts.get(t, "/readyz")
+		assert.Equal(t, code, http.StatusServiceUnavailable)
+	})
+
+	// Logging in fetches a page which isn't exempt, so in these tests we do
+	// that before turning maintenance mode on, each with their own server
+	// (and so their own cookie jar).
+	t.Run("Users", func(t *testing.T) {
+		app.setMaintenance(false)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+		ts.login(t)
+		app.setMaintenance(true)
+
+		code, _, _ := ts.get(t, "/account/view")
+		assert.Equal(t, code, http.StatusServiceUnavailable)
+	})
+
+	t.Run("Admins", func(t *testing.T) {
+		app.setMaintenance(false)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+		csrfToken := ts.loginAs(t, "admin@example.com")
+		app.setMaintenance(true)
+
+		code, header, _ := ts.get(t, "/")
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, header.Get("Retry-After"), "")
+
+		_, _, body := ts.get(t, "/admin")
+		assert.StringContains(t, body, "<button>Turn off</button>")
+
+		// Admins can turn maintenance mode off, and on again.
+		form := url.Values{}
+		form.Add("csrf_token", csrfToken)
+		code, header, _ = ts.postForm(t, "/admin/maintenance", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/admin")
+		assert.Equal(t, app.maintenance.Load(), false)
+		_, _, body = ts.get(t, "/admin")
+		assert.StringContains(t, body, "Maintenance mode is off.")
+		assert.StringContains(t, body, "<button>Turn on</button>")
+
+		ts.postForm(t, "/admin/maintenance", form)
+		assert.Equal(t, app.maintenance.Load(), true)
+	})
+}
+
+func TestWatchMaintenanceSignal(t *testing.T) {
+	app := newTestApplication(t)
+
+	// Each signal toggles maintenance mode. The method returns once the
+	// channel is closed.
+	sig := make(chan os.Signal, 3)
+	sig <- syscall.SIGHUP
+	sig <- syscall.SIGHUP
+	sig <- syscall.SIGHUP
+	close(sig)
+	app.watchMaintenanceSignal(sig)
+	assert.Equal(t, app.maintenance.Load(), true)
+}
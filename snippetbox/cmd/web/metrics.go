@@ -0,0 +1,145 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics holds the Prometheus collectors for the application. We use our own
+// registry rather than the global default one, so that each test application
+// gets a fresh set of metrics.
+type metrics struct {
+	registry *prometheus.Registry
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	inFlight *prometheus.GaugeVec
+}
+
+// newMetrics() creates and registers the application's metrics. If db isn't
+// nil then the connection pool statistics from db.Stats() are exported too.
+func newMetrics(db *sql.DB) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "snippetbox_http_requests_total",
+			Help: "Total number of HTTP requests, by method, route and status code.",
+		}, []string{"method", "route", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "snippetbox_http_request_duration_seconds",
+			Help:    "HTTP request latency, by method, route and status code.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "snippetbox_http_requests_in_flight",
+			Help: "Number of HTTP requests currently being handled, by route.",
+		}, []string{"route"}),
+	}
+	m.registry.MustRegister(
+		m.requests,
+		m.duration,
+		m.inFlight,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	if db != nil {
+		m.registry.MustRegister(collectors.NewDBStatsCollector(db, "snippetbox"))
+	}
+	return m
+}
+
+// handler() returns a http.Handler which serves the metrics in the Prometheus
+// text format.
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// instrument() returns a middleware which records metrics for every request
+// handled by the router. Requests are labelled with the matched route pattern
+// (like "/snippet/view/:id") rather than the raw path, otherwise every snippet
+// would get its own time series.
+func (m *metrics) instrument(router *httprouter.Router) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := routePattern(router, r)
+			start := time.Now()
+
+			m.inFlight.WithLabelValues(route).Inc()
+			defer m.inFlight.WithLabelValues(route).Dec()
+
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(sw, r)
+
+			status := strconv.Itoa(sw.status)
+			m.requests.WithLabelValues(r.Method, route, status).Inc()
+			m.duration.WithLabelValues(r.Method, route, status).Observe(time.Since(start).Seconds())
+		})
+	}
+}
+
+// The routePattern() helper returns the httprouter pattern which matches the
+// request, or "unmatched" if there isn't one. httprouter doesn't tell us the
+// pattern directly, so we rebuild it from the path by swapping the parameter
+// values back for their names. We work from the end of the path, as that's
+// where all of our parameters are.
+func routePattern(router *httprouter.Router, r *http.Request) string {
+	handle, params, _ := router.Lookup(r.Method, r.URL.Path)
+	if handle == nil {
+		return "unmatched"
+	}
+	path := r.URL.Path
+	segments := strings.Split(path, "/")
+	end := len(segments)
+	for i := len(params) - 1; i >= 0; i-- {
+		p := params[i]
+		// A catch-all parameter's value is the whole of the rest of the path,
+		// including the leading slash.
+		if strings.HasPrefix(p.Value, "/") {
+			n := strings.Count(p.Value, "/")
+			segments = append(segments[:end-n], "*"+p.Key)
+			end = len(segments) - 1
+			continue
+		}
+		for j := end - 1; j >= 0; j-- {
+			if segments[j] == p.Value {
+				segments[j] = ":" + p.Key
+				end = j
+				break
+			}
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// statusWriter wraps a http.ResponseWriter to record the status code of the
+// response.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if !sw.wroteHeader {
+		sw.status = status
+		sw.wroteHeader = true
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	sw.wroteHeader = true
+	return sw.ResponseWriter.Write(b)
+}
+
+// Unwrap() lets http.ResponseController reach the underlying ResponseWriter.
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"snippetbox/internal/assert"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestMetrics(t *testing.T) {
+	app := newTestApplication(t)
+	app.metrics = newMetrics(nil)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Read the current value of the request counter for GET requests to the
+	// snippet view route which returned 200 OK.
+	rx := regexp.MustCompile(`snippetbox_http_requests_total\{method="GET",route="/snippet/view/:id",status="200"\} (\d+)`)
+	count := func() int {
+		code, _, body := ts.get(t, "/metrics")
+		assert.Equal(t, code, http.StatusOK)
+		matches := rx.FindStringSubmatch(body)
+		if matches == nil {
+			return 0
+		}
+		n, err := strconv.Atoi(matches[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	assert.Equal(t, count(), 0)
+	ts.get(t, "/snippet/view/1")
+	assert.Equal(t, count(), 1)
+	ts.get(t, "/snippet/view/1")
+	assert.Equal(t, count(), 2)
+
+	// The latency histogram and in-flight gauge should be exported too.
+	_, _, body := ts.get(t, "/metrics")
+	assert.StringContains(t, body, `snippetbox_http_request_duration_seconds_count{method="GET",route="/snippet/view/:id",status="200"} 2`)
+	assert.StringContains(t, body, `snippetbox_http_requests_in_flight{route="/metrics"} 1`)
+}
+
+func TestMetricsDisabled(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, _, _ := ts.get(t, "/metrics")
+	assert.Equal(t, code, http.StatusNotFound)
+}
+
+func TestRoutePattern(t *testing.T) {
+	router := httprouter.New()
+	noop := func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {}
+	router.GET("/", noop)
+	router.GET("/snippet/view/:id", noop)
+	router.GET("/snippet/tag/:tag", noop)
+	router.GET("/static/*filepath", noop)
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/", "/"},
+		{"/snippet/view/123", "/snippet/view/:id"},
+		{"/snippet/tag/tag", "/snippet/tag/:tag"},
+		{"/static/css/main.css", "/static/*filepath"},
+		{"/missing", "unmatched"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			assert.Equal(t, routePattern(router, r), tt.want)
+		})
+	}
+}
@@ -2,18 +2,63 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"maps"
 	"net/http"
+	"net/netip"
+	"slices"
+	"snippetbox/internal/i18n"
+	"snippetbox/internal/models"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/justinas/nosurf"
+	"golang.org/x/time/rate"
 )
 
+// The generateNonce() function returns a new random nonce for the
+// Content-Security-Policy header: 128 bits from the operating system's CSPRNG,
+// base-64 encoded. We use the URL-safe alphabet without padding, which CSP
+// allows, because html/template would otherwise escape the "+" characters
+// when writing the nonce into an attribute.
+func generateNonce() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
 func secureHeaders(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Generate a fresh nonce for every response, and store it in the
+		// request context so that render() can make it available to the
+		// templates. Only <script> elements carrying the nonce are allowed to
+		// run, so an attacker who manages to inject a <script> element can't
+		// run it without guessing the nonce. If the CSPRNG fails there's
+		// nothing sensible we can do, so we panic and let the recoverPanic
+		// middleware send a 500 response.
+		nonce, err := generateNonce()
+		if err != nil {
+			panic(err)
+		}
+		r = r.WithContext(context.WithValue(r.Context(), cspNonceContextKey, nonce))
 		// Note: This is split across multiple lines for readability. You don't
-		// need to do this in your own code.
+		// need to do this in your own code. The style-src-attr directive allows
+		// the inline style attributes used for syntax highlighting, but still
+		// blocks inline <style> elements. The img-src directive allows data:
+		// URLs for the QR code shown when setting up two-factor
+		// authentication.
 		w.Header().Set("Content-Security-Policy",
-			"default-src 'self'; style-src 'self' fonts.googleapis.com; font-src fonts.gstatic.com")
+			"default-src 'self'; script-src 'self' 'nonce-"+nonce+"'; "+
+				"style-src 'self' fonts.googleapis.com; style-src-attr 'unsafe-inline'; "+
+				"img-src 'self' data:; font-src fonts.gstatic.com")
 		w.Header().Set("Referrer-Policy", "origin-when-cross-origin")
 		w.Header().Set("X-Content-Type-Options", "nosniff")
 		w.Header().Set("X-Frame-Options", "deny")
@@ -22,9 +67,77 @@ func secureHeaders(next http.Handler) http.Handler {
 	})
 }
 
+// The requestID middleware generates a unique ID for every request. The ID is
+// stored in the request context, so that it's included in any log entries made
+// while handling the request, and sent back to the client in a X-Request-ID
+// header so that a user reporting a problem can tell us which request it was.
+func requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := uuid.NewString()
+		w.Header().Set("X-Request-ID", id)
+		ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// The realIP middleware works out the IP address of the client when the
+// application is behind a reverse proxy. In that case the connection comes
+// from the proxy, which passes on the client's address in a header. Anybody
+// can send that header though, so we only believe it when the connection
+// comes from one of the trusted proxies, and ignore it otherwise. The address
+// is stored in the request context, where the clientIP() helper finds it.
+func (app *application) realIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remote, err := netip.ParseAddrPort(r.RemoteAddr)
+		if err == nil && app.trustedProxies.contains(remote.Addr()) {
+			if ip, ok := app.forwardedIP(r); ok {
+				ctx := context.WithValue(r.Context(), clientIPContextKey, ip)
+				r = r.WithContext(ctx)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The forwardedIP() helper returns the client IP address from the header set
+// by a trusted proxy. Each proxy which a request passes through appends the
+// address it received the request from to X-Forwarded-For, and only the
+// addresses added by our own proxies can be believed. So we work backwards
+// from the end of the list, skipping trusted proxies, and take the first
+// address which isn't one. If there's a problem with the list, the last good
+// address before it is the best that we can do.
+func (app *application) forwardedIP(r *http.Request) (string, bool) {
+	header := app.proxyHeader
+	if header == "" {
+		header = "X-Forwarded-For"
+		if r.Header.Get(header) == "" {
+			header = "X-Real-IP"
+		}
+	}
+	values := strings.Split(strings.Join(r.Header.Values(header), ","), ",")
+	var ip string
+	for _, value := range slices.Backward(values) {
+		addr, err := netip.ParseAddr(strings.TrimSpace(value))
+		if err != nil {
+			break
+		}
+		ip = addr.Unmap().String()
+		if !app.trustedProxies.contains(addr) {
+			break
+		}
+	}
+	return ip, ip != ""
+}
+
 func (app *application) logRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		app.infoLog.Printf("%s - %s %s %s", r.RemoteAddr, r.Proto, r.Method, r.URL.RequestURI())
+		var (
+			ip     = app.clientIP(r)
+			proto  = r.Proto
+			method = r.Method
+			uri    = r.URL.RequestURI()
+		)
+		app.logger.InfoContext(r.Context(), "received request", "ip", ip, "proto", proto, "method", method, "uri", uri)
 		next.ServeHTTP(w, r)
 	})
 }
@@ -41,20 +154,137 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 				w.Header().Set("Connection", "close")
 				// Call the app.serverError helper method to return a 500
 				// Internal Server response.
-				app.serverError(w, fmt.Errorf("%s", err))
+				app.serverError(w, r, fmt.Errorf("%s", err))
 			}
 		}()
 		next.ServeHTTP(w, r)
 	})
 }
 
+// errBodyTooLarge is returned by decodePostForm() and readJSON() if the
+// request body is bigger than the limit set by the limitBody middleware.
+var errBodyTooLarge = errors.New("request body too large")
+
+// The limitBody middleware stops clients from tying up the server (or filling
+// its memory) by sending huge request bodies. If the Content-Length header
+// says that the body is over app.maxBodyBytes then we send a 413 response
+// straight away. Otherwise we wrap the body in a http.MaxBytesReader, so that
+// a handler which reads past the limit gets an error instead.
+func (app *application) limitBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > app.maxBodyBytes {
+			app.bodyTooLarge(w, r)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, app.maxBodyBytes)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The timeout middleware stops a slow request from hanging forever. If the
+// handler hasn't started its response once app.requestTimeout has passed, the
+// request context is cancelled with context.DeadlineExceeded as its cause. The
+// models pass the context on to the database driver, so any query which is
+// still running is stopped too, and when the handler returns the client is
+// sent a 503 Service Unavailable response instead of whatever it wrote.
+//
+// Unlike http.TimeoutHandler the response isn't buffered, so the handlers which
+// stream large responses (the sitemap and the account exports) still do. Once
+// a response has started the timeout no longer applies, as it can't be turned
+// into a 503 any more, and a long download which is making progress shouldn't
+// be cut short. This does mean that the timeout relies on the handler giving
+// up when its context is cancelled, which all of ours do.
+func (app *application) timeout(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithCancelCause(r.Context())
+		defer cancel(nil)
+		// Keep a copy of the headers set by the middleware so far, so that we
+		// can put them back if the handler's response is thrown away.
+		header := w.Header().Clone()
+		tw := &timeoutWriter{ResponseWriter: w}
+		timer := time.AfterFunc(app.requestTimeout, func() {
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			if !tw.started {
+				tw.timedOut = true
+				cancel(context.DeadlineExceeded)
+			}
+		})
+		next.ServeHTTP(tw, r.WithContext(ctx))
+		timer.Stop()
+
+		tw.mu.Lock()
+		defer tw.mu.Unlock()
+		if tw.timedOut {
+			clear(w.Header())
+			maps.Copy(w.Header(), header)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(http.StatusText(http.StatusServiceUnavailable)))
+		}
+	})
+}
+
+// timeoutWriter wraps the http.ResponseWriter passed to a handler by the
+// timeout middleware. It records whether the handler has started its response
+// yet, and once the request has timed out it throws away anything which the
+// handler writes, returning http.ErrHandlerTimeout.
+type timeoutWriter struct {
+	http.ResponseWriter
+	mu       sync.Mutex
+	started  bool
+	timedOut bool
+}
+
+func (tw *timeoutWriter) WriteHeader(status int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return
+	}
+	tw.started = true
+	tw.ResponseWriter.WriteHeader(status)
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	tw.started = true
+	return tw.ResponseWriter.Write(b)
+}
+
+// Flush() sends the headers and anything written so far to the client. Like
+// writing, that starts the response, so the timeout no longer applies.
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return
+	}
+	tw.started = true
+	http.NewResponseController(tw.ResponseWriter).Flush()
+}
+
+// Unwrap() lets http.ResponseController reach the underlying ResponseWriter.
+func (tw *timeoutWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
+
 func (app *application) requireAuthentication(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// If the user is not authenticated, redirect them to the login page and
 		// return from the middleware chain so that no subsequent handlers in
-		// the chain are executed.
+		// the chain are executed. The page which they asked for (including any
+		// query string) is saved in the session, so that they can be sent back
+		// to it once they've logged in. That only makes sense for a GET
+		// request, as a redirect after logging in is always a GET: a form
+		// posted from a page which has since logged out can't be resubmitted.
 		if !app.isAuthenticated(r) {
-			app.sessionManager.Put(r.Context(), "targetURL", r.URL.Path)
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				app.sessionManager.Put(r.Context(), "targetURL", r.URL.RequestURI())
+			}
 			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 			return
 		}
@@ -67,6 +297,31 @@ func (app *application) requireAuthentication(next http.Handler) http.Handler {
 	})
 }
 
+// The requireAdmin middleware sends a 403 Forbidden response unless the
+// logged-in user is an admin. It must come after requireAuthentication in the
+// chain. We look the user up on every request, rather than storing their role
+// in the session, so that taking away someone's admin role takes effect
+// straight away.
+func (app *application) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+		user, err := app.users.Get(r.Context(), id)
+		if err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				app.forbidden(w, r)
+			} else {
+				app.serverError(w, r, err)
+			}
+			return
+		}
+		if user.Role != models.RoleAdmin {
+			app.forbidden(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Retrieve the authenticatedUserID value from the session using the
@@ -80,9 +335,9 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		}
 		// Otherwise, we check to see if a user with that ID exists in our
 		// database.
-		exists, err := app.users.Exists(id)
+		exists, err := app.users.Exists(r.Context(), id)
 		if err != nil {
-			app.serverError(w, err)
+			app.serverError(w, r, err)
 			return
 		}
 		// If a matching user is found, we know we know that the request is
@@ -98,6 +353,60 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 	})
 }
 
+// The localize middleware builds the i18n.Localizer for the request, and
+// stores it in the request context for newTemplateData() to pick up. A
+// language which the user has chosen explicitly (and which is stored in their
+// session) takes priority over their browser's Accept-Language header. It
+// must come after the session middleware in the chain.
+func (app *application) localize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l := i18n.NewLocalizer(
+			app.sessionManager.GetString(r.Context(), "language"),
+			r.Header.Get("Accept-Language"),
+		)
+		w.Header().Set("Content-Language", l.Lang)
+		w.Header().Add("Vary", "Accept-Language")
+		ctx := context.WithValue(r.Context(), localizerContextKey, l)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// The authenticateAPIToken middleware authenticates requests to the JSON API
+// using the "Authorization: Bearer <token>" header instead of a session
+// cookie. If the token is missing, malformed or doesn't belong to a user then
+// we send a 401 Unauthorized JSON response and stop the chain.
+func (app *application) authenticateAPIToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The response varies depending on the Authorization header, so
+		// make sure that caches don't serve one user's response to another.
+		w.Header().Add("Vary", "Authorization")
+
+		headerParts := strings.Split(r.Header.Get("Authorization"), " ")
+		if len(headerParts) != 2 || headerParts[0] != "Bearer" || headerParts[1] == "" {
+			app.apiInvalidToken(w)
+			return
+		}
+
+		user, err := app.users.GetForToken(r.Context(), headerParts[1])
+		if err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				app.apiInvalidToken(w)
+			} else {
+				app.apiServerError(w, r, err)
+			}
+			return
+		}
+
+		next.ServeHTTP(w, app.contextSetUser(r, user))
+	})
+}
+
+// csrfHeader is the request header which AJAX clients can use to send the CSRF
+// token, instead of including it in a csrf_token form field. This is handled
+// by nosurf itself -- it checks the header first, then falls back to the form
+// field.
+const csrfHeader = nosurf.HeaderName
+
 // Create a NoSurf middleware function which uses a customized CSRF cookie with
 // the Secure, Path and HttpOnly attributes set.
 func noSurf(next http.Handler) http.Handler {
@@ -109,3 +418,59 @@ func noSurf(next http.Handler) http.Handler {
 	})
 	return csrfHandler
 }
+
+func (app *application) rateLimit(next http.Handler) http.Handler {
+	// If rate limiting is disabled then there's nothing to do.
+	if !app.limiter.enabled {
+		return next
+	}
+	// Define a client struct to hold the rate limiter and last seen time for
+	// each client.
+	type client struct {
+		limiter  *rate.Limiter
+		lastSeen time.Time
+	}
+	var (
+		mu      sync.Mutex
+		clients = make(map[string]*client)
+	)
+	// Launch a background goroutine which removes old entries from the
+	// clients map once every minute.
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			// Lock the mutex to prevent any rate limiter checks from happening
+			// while the cleanup is taking place, and delete any clients which
+			// haven't been seen within the last three minutes.
+			mu.Lock()
+			for ip, client := range clients {
+				if time.Since(client.lastSeen) > 3*time.Minute {
+					delete(clients, ip)
+				}
+			}
+			mu.Unlock()
+		}
+	}()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := app.clientIP(r)
+		mu.Lock()
+		// Initialize a new rate limiter for the IP address if we haven't seen
+		// it before.
+		if _, found := clients[ip]; !found {
+			clients[ip] = &client{
+				limiter: rate.NewLimiter(rate.Limit(app.limiter.rps), app.limiter.burst),
+			}
+		}
+		clients[ip].lastSeen = time.Now()
+		if !clients[ip].limiter.Allow() {
+			mu.Unlock()
+			app.rateLimitExceeded(w, r)
+			return
+		}
+		// Importantly, unlock the mutex before calling the next handler in the
+		// chain.
+		mu.Unlock()
+		next.ServeHTTP(w, r)
+	})
+}
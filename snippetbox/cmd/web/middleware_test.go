@@ -2,12 +2,24 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"snippetbox/internal/assert"
+	"snippetbox/internal/models"
+	"snippetbox/internal/models/mocks"
 )
 
 func TestSecureHeaders(t *testing.T) {
@@ -19,7 +31,10 @@ func TestSecureHeaders(t *testing.T) {
 	}
 	// Create a mock HTTP handler that we can pass to our secureHeaders
 	// middleware, which writes a 200 status code and an "OK" response body.
+	// It also records the CSP nonce from the request context.
+	var nonce string
 	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce = contextGetCSPNonce(r.Context())
 		w.Write([]byte("OK"))
 	})
 	// Pass the mock HTTP handler to our secureHeaders middleware. Because
@@ -31,8 +46,12 @@ func TestSecureHeaders(t *testing.T) {
 	// of the test.
 	rs := rr.Result()
 	// Check that the middleware has correctly set the Content-Security-Policy
-	// header on the response.
-	expectedValue := "default-src 'self'; style-src 'self' fonts.googleapis.com; font-src fonts.gstatic.com"
+	// header on the response, including the nonce that it passed on in the
+	// request context.
+	if nonce == "" {
+		t.Fatal("no CSP nonce in the request context")
+	}
+	expectedValue := "default-src 'self'; script-src 'self' 'nonce-" + nonce + "'; style-src 'self' fonts.googleapis.com; style-src-attr 'unsafe-inline'; img-src 'self' data:; font-src fonts.gstatic.com"
 	assert.Equal(t, rs.Header.Get("Content-Security-Policy"), expectedValue)
 	// Check that the middleware has correctly set the Referrer-Policy
 	// header on the response.
@@ -61,3 +80,337 @@ func TestSecureHeaders(t *testing.T) {
 	bytes.TrimSpace(body)
 	assert.Equal(t, string(body), "OK")
 }
+
+func TestRateLimit(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+
+	// send() makes a request through the middleware from the given remote
+	// address and X-Forwarded-For header, returning the response status code.
+	send := func(h http.Handler, remoteAddr, forwardedFor string) int {
+		rr := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		h.ServeHTTP(rr, r)
+		return rr.Code
+	}
+
+	t.Run("Burst then limited", func(t *testing.T) {
+		app := newTestApplication(t)
+		app.limiter = limiterConfig{rps: 1, burst: 4, enabled: true}
+		h := app.rateLimit(next)
+
+		for i := 0; i < 4; i++ {
+			assert.Equal(t, send(h, "192.0.2.1:1234", ""), http.StatusOK)
+		}
+		assert.Equal(t, send(h, "192.0.2.1:5678", ""), http.StatusTooManyRequests)
+		// Other clients have their own limiter.
+		assert.Equal(t, send(h, "192.0.2.2:1234", ""), http.StatusOK)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		app := newTestApplication(t)
+		app.limiter = limiterConfig{rps: 1, burst: 1, enabled: false}
+		h := app.rateLimit(next)
+
+		for i := 0; i < 10; i++ {
+			assert.Equal(t, send(h, "192.0.2.1:1234", ""), http.StatusOK)
+		}
+	})
+
+	t.Run("Trusted proxy header", func(t *testing.T) {
+		app := newTestApplication(t)
+		app.limiter = limiterConfig{rps: 1, burst: 1, enabled: true}
+		app.trustedProxies = prefixList{netip.MustParsePrefix("10.0.0.0/8")}
+		h := app.realIP(app.rateLimit(next))
+
+		// All requests come from the same proxy, but are limited according
+		// to the client IP address which the proxy appended to the header.
+		assert.Equal(t, send(h, "10.0.0.1:1234", "203.0.113.9, 192.0.2.1"), http.StatusOK)
+		assert.Equal(t, send(h, "10.0.0.1:1234", "192.0.2.2"), http.StatusOK)
+		assert.Equal(t, send(h, "10.0.0.1:1234", "192.0.2.1"), http.StatusTooManyRequests)
+	})
+
+	t.Run("Untrusted header ignored", func(t *testing.T) {
+		app := newTestApplication(t)
+		app.limiter = limiterConfig{rps: 1, burst: 1, enabled: true}
+		h := app.realIP(app.rateLimit(next))
+
+		assert.Equal(t, send(h, "10.0.0.1:1234", "192.0.2.1"), http.StatusOK)
+		assert.Equal(t, send(h, "10.0.0.1:1234", "192.0.2.2"), http.StatusTooManyRequests)
+	})
+}
+
+func TestRealIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     string
+		headers    map[string]string
+		wantIP     string
+	}{
+		{
+			name:       "No proxy",
+			remoteAddr: "192.0.2.1:1234",
+			wantIP:     "192.0.2.1",
+		},
+		{
+			name:       "Trusted proxy",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"X-Forwarded-For": "192.0.2.1"},
+			wantIP:     "192.0.2.1",
+		},
+		{
+			name:       "Untrusted source",
+			remoteAddr: "198.51.100.7:1234",
+			headers:    map[string]string{"X-Forwarded-For": "192.0.2.1", "X-Real-IP": "192.0.2.2"},
+			wantIP:     "198.51.100.7",
+		},
+		{
+			name:       "Spoofed entry before proxy",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"X-Forwarded-For": "203.0.113.9, 192.0.2.1"},
+			wantIP:     "192.0.2.1",
+		},
+		{
+			name:       "Chain of trusted proxies",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"X-Forwarded-For": "192.0.2.1, 10.0.0.2, 10.0.0.3"},
+			wantIP:     "192.0.2.1",
+		},
+		{
+			name:       "Invalid entry",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"X-Forwarded-For": "192.0.2.1, nonsense, 10.0.0.2"},
+			wantIP:     "10.0.0.2",
+		},
+		{
+			name:       "X-Real-IP",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"X-Real-IP": "192.0.2.1"},
+			wantIP:     "192.0.2.1",
+		},
+		{
+			name:       "No header",
+			remoteAddr: "10.0.0.1:1234",
+			wantIP:     "10.0.0.1",
+		},
+		{
+			name:       "Configured header",
+			remoteAddr: "10.0.0.1:1234",
+			header:     "CF-Connecting-IP",
+			headers:    map[string]string{"CF-Connecting-IP": "192.0.2.1", "X-Forwarded-For": "192.0.2.2"},
+			wantIP:     "192.0.2.1",
+		},
+		{
+			name:       "IPv6",
+			remoteAddr: "[2001:db8::1]:1234",
+			headers:    map[string]string{"X-Forwarded-For": "2001:db8:1::9"},
+			wantIP:     "2001:db8:1::9",
+		},
+		{
+			name:       "IPv4-mapped proxy",
+			remoteAddr: "[::ffff:10.0.0.1]:1234",
+			headers:    map[string]string{"X-Forwarded-For": "192.0.2.1"},
+			wantIP:     "192.0.2.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.trustedProxies = prefixList{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("2001:db8::/64")}
+			app.proxyHeader = tt.header
+
+			var gotIP string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotIP = app.clientIP(r)
+			})
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+			app.realIP(next).ServeHTTP(httptest.NewRecorder(), r)
+			assert.Equal(t, gotIP, tt.wantIP)
+		})
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	// Capture the application's log output as JSON, so that we can check which
+	// attributes were logged.
+	var buf bytes.Buffer
+	app := newTestApplication(t)
+	app.logger = slog.New(newContextHandler(slog.NewJSONHandler(&buf, nil)))
+
+	// A handler which logs an error during the request.
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		app.serverError(w, r, errors.New("something went wrong"))
+	})
+
+	rr := httptest.NewRecorder()
+	r, err := http.NewRequest(http.MethodGet, "/foo?bar=baz", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requestID(app.logRequest(next)).ServeHTTP(rr, r)
+
+	id := rr.Result().Header.Get("X-Request-ID")
+	assert.Equal(t, len(id), 36)
+
+	// Both the request log entry and the error log entry should include the
+	// same request ID as the response header.
+	dec := json.NewDecoder(&buf)
+	var entries []map[string]any
+	for dec.More() {
+		var entry map[string]any
+		if err := dec.Decode(&entry); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	assert.Equal(t, len(entries), 2)
+	for _, entry := range entries {
+		assert.Equal(t, entry["request_id"], any(id))
+	}
+	assert.Equal(t, entries[1]["level"], any("ERROR"))
+	assert.Equal(t, entries[1]["msg"], any("something went wrong"))
+	assert.Equal(t, entries[1]["method"], any(http.MethodGet))
+	assert.Equal(t, entries[1]["uri"], any("/foo?bar=baz"))
+	assert.StringContains(t, entries[1]["trace"].(string), "serverError")
+
+	// Each request gets a different ID.
+	rr = httptest.NewRecorder()
+	requestID(next).ServeHTTP(rr, r)
+	if rr.Result().Header.Get("X-Request-ID") == id {
+		t.Errorf("got the same request ID twice: %q", id)
+	}
+}
+
+func TestCSPNonce(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	nonceRX := regexp.MustCompile(`script-src 'self' 'nonce-([^']+)'`)
+
+	seen := make(map[string]bool)
+	for range 3 {
+		_, header, body := ts.get(t, "/")
+
+		matches := nonceRX.FindStringSubmatch(header.Get("Content-Security-Policy"))
+		if matches == nil {
+			t.Fatalf("no nonce in Content-Security-Policy header %q", header.Get("Content-Security-Policy"))
+		}
+		nonce := matches[1]
+
+		// The nonce must be 128 bits, base-64 encoded.
+		b, err := base64.RawURLEncoding.DecodeString(nonce)
+		assert.NilError(t, err)
+		assert.Equal(t, len(b), 16)
+
+		// The template must use the same nonce as the header...
+		assert.StringContains(t, body, "nonce='"+nonce+"'")
+
+		// ...and it must be different for every response.
+		if seen[nonce] {
+			t.Fatalf("nonce %q used for more than one response", nonce)
+		}
+		seen[nonce] = true
+	}
+}
+
+func TestLimitBody(t *testing.T) {
+	app := newTestApplication(t)
+	// Big enough for the login form, but not much else.
+	app.maxBodyBytes = 512
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Oversized form", func(t *testing.T) {
+		csrfToken := ts.login(t)
+		form := url.Values{}
+		form.Add("title", "O snail")
+		form.Add("content", strings.Repeat("Climb Mount Fuji, ", 50))
+		form.Add("expires", "7")
+		form.Add("csrf_token", csrfToken)
+
+		code, _, body := ts.postForm(t, "/snippet/create", form)
+		assert.Equal(t, code, http.StatusRequestEntityTooLarge)
+		assert.StringContains(t, body, "request body must not be larger than 512 bytes")
+	})
+
+	t.Run("Oversized JSON with no Content-Length", func(t *testing.T) {
+		// Wrapping the reader hides its length from http.NewRequest(), so
+		// the body is sent chunked and the limit is only hit while reading.
+		payload := `{"email": "alice@example.com", "password": "` + strings.Repeat("x", 600) + `"}`
+		body := io.MultiReader(strings.NewReader(payload))
+		header := http.Header{"Content-Type": {"application/json"}}
+
+		code, _, resBody := ts.do(t, http.MethodPost, "/api/v1/tokens", body, header)
+		assert.Equal(t, code, http.StatusRequestEntityTooLarge)
+		assert.StringContains(t, resBody, `"error": "request body must not be larger than 512 bytes"`)
+	})
+
+	t.Run("Within the limit", func(t *testing.T) {
+		body := strings.NewReader(`{"email": "alice@example.com"}`)
+		header := http.Header{"Content-Type": {"application/json"}}
+
+		code, _, _ := ts.do(t, http.MethodPost, "/api/v1/tokens", body, header)
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+	})
+}
+
+// slowSnippetModel is a mock SnippetModel whose LatestPublic() method takes
+// far longer than any request timeout, unless its context is cancelled.
+type slowSnippetModel struct {
+	*mocks.SnippetModel
+	err chan error
+}
+
+func (m *slowSnippetModel) LatestPublic(ctx context.Context) ([]*models.Snippet, error) {
+	select {
+	case <-ctx.Done():
+		m.err <- context.Cause(ctx)
+		return nil, ctx.Err()
+	case <-time.After(10 * time.Second):
+		m.err <- nil
+		return nil, nil
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	app := newTestApplication(t)
+	app.requestTimeout = 50 * time.Millisecond
+	slow := &slowSnippetModel{SnippetModel: &mocks.SnippetModel{}, err: make(chan error, 1)}
+	app.snippets = slow
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	start := time.Now()
+	code, _, body := ts.get(t, "/")
+	assert.Equal(t, code, http.StatusServiceUnavailable)
+	assert.Equal(t, body, "Service Unavailable")
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("request took %s", elapsed)
+	}
+
+	// The deadline was passed on to the model, which gave up straight away.
+	select {
+	case err := <-slow.err:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("got error %v; want context.DeadlineExceeded", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("model wasn't cancelled")
+	}
+
+	// Requests which finish in time aren't affected.
+	code, _, _ = ts.get(t, "/ping")
+	assert.Equal(t, code, http.StatusOK)
+}
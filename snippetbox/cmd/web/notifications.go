@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"snippetbox/internal/models"
+)
+
+// The notifyComment() helper notifies the owner of a snippet that userID has
+// commented on it. The notifications table only has room for 255 characters
+// in a message, so long names and titles are shortened.
+func (app *application) notifyComment(ctx context.Context, snippet *models.Snippet, userID int) error {
+	user, err := app.users.Get(ctx, userID)
+	if err != nil {
+		return err
+	}
+	message := fmt.Sprintf("%s commented on your snippet \"%s\"", truncate(user.Name, 100), truncate(snippet.Title, 100))
+	_, err = app.notifications.Create(ctx, snippet.UserID, message, fmt.Sprintf("/snippet/view/%d", snippet.ID))
+	return err
+}
+
+// notificationList shows the current user's unread notifications, newest
+// first, each with a button to mark it as read.
+func (app *application) notificationList(w http.ResponseWriter, r *http.Request) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	notifications, err := app.notifications.UnreadFor(r.Context(), userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	data := app.newTemplateData(r)
+	data.Notifications = notifications
+	app.render(w, r, http.StatusOK, "notifications.html", data)
+}
+
+// notificationReadPost marks one of the current user's notifications as read,
+// and sends them back to the list. A notification which belongs to somebody
+// else is treated as not found, so that its ID can't be used to find out
+// whether it exists.
+func (app *application) notificationReadPost(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	err = app.notifications.MarkRead(r.Context(), id, userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	http.Redirect(w, r, "/notifications", http.StatusSeeOther)
+}
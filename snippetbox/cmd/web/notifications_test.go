@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"snippetbox/internal/assert"
+)
+
+func TestNotifications(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Anonymous", func(t *testing.T) {
+		code, header, _ := ts.get(t, "/notifications")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/login")
+		_, _, body := ts.get(t, "/")
+		if strings.Contains(body, "href='/notifications'") {
+			t.Error("got a notifications link for a user who isn't logged in")
+		}
+	})
+
+	csrfToken := ts.login(t)
+
+	// Alice has one unread notification, which is counted in the navigation
+	// bar on every page and listed on the notifications page.
+	t.Run("Unread", func(t *testing.T) {
+		_, _, body := ts.get(t, "/")
+		assert.StringContains(t, body, "<span class='badge'>1</span>")
+
+		code, _, body := ts.get(t, "/notifications")
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "<a href='/snippet/view/1'>Bob commented on your snippet &#34;An old silent pond&#34;</a>")
+		assert.StringContains(t, body, "<form action='/notifications/1/read' method='POST'>")
+	})
+
+	tests := []struct {
+		name         string
+		urlPath      string
+		wantCode     int
+		wantLocation string
+	}{
+		{
+			name:     "Someone else's notification",
+			urlPath:  "/notifications/2/read",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Non-existent notification",
+			urlPath:  "/notifications/99/read",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Invalid ID",
+			urlPath:  "/notifications/foo/read",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:         "Own notification",
+			urlPath:      "/notifications/1/read",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/notifications",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("csrf_token", csrfToken)
+			code, header, _ := ts.postForm(t, tt.urlPath, form)
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, header.Get("Location"), tt.wantLocation)
+		})
+	}
+
+	// Once it has been read the notification is gone, and so is the count.
+	t.Run("Read", func(t *testing.T) {
+		_, _, body := ts.get(t, "/notifications")
+		assert.StringContains(t, body, "You have no unread notifications.")
+		if strings.Contains(body, "class='badge'") {
+			t.Error("got an unread count after reading every notification")
+		}
+	})
+}
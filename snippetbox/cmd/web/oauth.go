@@ -0,0 +1,264 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"snippetbox/internal/models"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/endpoints"
+)
+
+// oauthTimeout is how long we allow for exchanging the authorization code and
+// fetching the user's profile from an OAuth provider.
+const oauthTimeout = 10 * time.Second
+
+// oauthProfile holds the details of a user's account at an OAuth provider.
+// Email is empty unless the provider has verified the user's email address.
+type oauthProfile struct {
+	ID    string
+	Email string
+	Name  string
+}
+
+// An oauthProvider is an OAuth 2.0 provider which users can log in with. The
+// endpoints in config and the apiURL default to the real provider's, but are
+// fields so that the tests can point them at a stub server.
+type oauthProvider struct {
+	// title is the name of the provider as shown to users, like "GitHub".
+	title  string
+	config *oauth2.Config
+	apiURL string
+	// fetchProfile gets the user's profile from the provider's API at
+	// apiURL. The client adds the user's access token to each request.
+	fetchProfile func(ctx context.Context, client *http.Client, apiURL string) (*oauthProfile, error)
+}
+
+// newGitHubProvider returns an oauthProvider for logging in with GitHub.
+func newGitHubProvider(clientID, clientSecret, redirectURL string) *oauthProvider {
+	return &oauthProvider{
+		title: "GitHub",
+		config: &oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			Endpoint:     endpoints.GitHub,
+			RedirectURL:  redirectURL,
+			Scopes:       []string{"read:user", "user:email"},
+		},
+		apiURL:       "https://api.github.com",
+		fetchProfile: fetchGitHubProfile,
+	}
+}
+
+// fetchGitHubProfile gets the user's profile from the GitHub API. The email
+// address in the profile itself is the user's public email, which may be
+// missing or unverified, so we look up their primary email address instead.
+func fetchGitHubProfile(ctx context.Context, client *http.Client, apiURL string) (*oauthProfile, error) {
+	var user struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}
+	err := getJSON(ctx, client, apiURL+"/user", &user)
+	if err != nil {
+		return nil, err
+	}
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	err = getJSON(ctx, client, apiURL+"/user/emails", &emails)
+	if err != nil {
+		return nil, err
+	}
+	profile := &oauthProfile{
+		ID:   strconv.FormatInt(user.ID, 10),
+		Name: cmp.Or(user.Name, user.Login),
+	}
+	for _, e := range emails {
+		if e.Primary && e.Verified {
+			profile.Email = e.Email
+		}
+	}
+	return profile, nil
+}
+
+// newGoogleProvider returns an oauthProvider for logging in with Google.
+func newGoogleProvider(clientID, clientSecret, redirectURL string) *oauthProvider {
+	return &oauthProvider{
+		title: "Google",
+		config: &oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			Endpoint:     endpoints.Google,
+			RedirectURL:  redirectURL,
+			Scopes:       []string{"openid", "email", "profile"},
+		},
+		apiURL:       "https://openidconnect.googleapis.com",
+		fetchProfile: fetchGoogleProfile,
+	}
+}
+
+// fetchGoogleProfile gets the user's profile from Google's OpenID Connect
+// userinfo endpoint.
+func fetchGoogleProfile(ctx context.Context, client *http.Client, apiURL string) (*oauthProfile, error) {
+	var info struct {
+		Sub           string `json:"sub"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name"`
+	}
+	err := getJSON(ctx, client, apiURL+"/v1/userinfo", &info)
+	if err != nil {
+		return nil, err
+	}
+	profile := &oauthProfile{
+		ID:   info.Sub,
+		Name: cmp.Or(info.Name, info.Email),
+	}
+	if info.EmailVerified {
+		profile.Email = info.Email
+	}
+	return profile, nil
+}
+
+// The getJSON() helper makes a GET request to an OAuth provider's API and
+// decodes the JSON response into dst.
+func getJSON(ctx context.Context, client *http.Client, url string, dst any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	rs, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rs.Body.Close()
+	if rs.StatusCode != http.StatusOK {
+		return fmt.Errorf("oauth: GET %s: unexpected status %s", url, rs.Status)
+	}
+	return json.NewDecoder(io.LimitReader(rs.Body, 1<<20)).Decode(dst)
+}
+
+// The oauthProviderTitles() helper returns the titles of the providers which
+// users can log in with, keyed by their names.
+func (app *application) oauthProviderTitles() map[string]string {
+	titles := make(map[string]string, len(app.oauth))
+	for name, provider := range app.oauth {
+		titles[name] = provider.title
+	}
+	return titles
+}
+
+// oauthLogin starts logging in with the provider named in the URL, by sending
+// the user to the provider to authorize us. The state parameter is a random
+// value which we also store in the session; the provider sends it back to
+// oauthCallback, and checking that it matches stops an attacker from logging
+// the user in to the attacker's own account (login CSRF).
+func (app *application) oauthLogin(w http.ResponseWriter, r *http.Request) {
+	provider, ok := app.oauth[httprouter.ParamsFromContext(r.Context()).ByName("provider")]
+	if !ok {
+		app.notFound(w, r)
+		return
+	}
+	state, err := generateNonce()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.sessionManager.Put(r.Context(), "oauthState", state)
+	http.Redirect(w, r, provider.config.AuthCodeURL(state), http.StatusSeeOther)
+}
+
+// oauthCallback is where the provider sends the user back to once they've
+// authorized us (or refused to). We exchange the authorization code for an
+// access token, use it to fetch the user's profile, and then log in the user
+// with that provider account -- creating them first if they're new.
+func (app *application) oauthCallback(w http.ResponseWriter, r *http.Request) {
+	name := httprouter.ParamsFromContext(r.Context()).ByName("provider")
+	provider, ok := app.oauth[name]
+	if !ok {
+		app.notFound(w, r)
+		return
+	}
+	// The state can only be used once. If it's missing from the session then
+	// the login attempt was started in another browser (or too long ago).
+	state := app.sessionManager.PopString(r.Context(), "oauthState")
+	query := r.URL.Query()
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(query.Get("state"))) != 1 {
+		app.oauthFailed(w, r, "Your login attempt has expired. Please try again.")
+		return
+	}
+	// If the user declined to authorize us then the provider sends them back
+	// with an error instead of a code. That's their choice to make, so it's
+	// not shown as an error.
+	if errCode := query.Get("error"); errCode != "" {
+		if errCode == "access_denied" {
+			app.addFlash(r, flashInfo, fmt.Sprintf("You cancelled logging in with %s.", provider.title))
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			return
+		}
+		app.logger.WarnContext(r.Context(), "oauth authorization failed", "provider", name, "error", errCode)
+		app.oauthFailed(w, r, fmt.Sprintf("%s couldn't log you in. Please try again.", provider.title))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), oauthTimeout)
+	defer cancel()
+	token, err := provider.config.Exchange(ctx, query.Get("code"))
+	if err != nil {
+		app.logger.WarnContext(r.Context(), "oauth code exchange failed", "provider", name, "error", err.Error())
+		app.oauthFailed(w, r, fmt.Sprintf("%s couldn't log you in. Please try again.", provider.title))
+		return
+	}
+	profile, err := provider.fetchProfile(ctx, provider.config.Client(ctx, token), provider.apiURL)
+	if err != nil {
+		app.logger.WarnContext(r.Context(), "oauth profile fetch failed", "provider", name, "error", err.Error())
+		app.oauthFailed(w, r, fmt.Sprintf("%s couldn't log you in. Please try again.", provider.title))
+		return
+	}
+	if profile.Email == "" {
+		app.oauthFailed(w, r, fmt.Sprintf("Your %s account doesn't have a verified email address.", provider.title))
+		return
+	}
+
+	id, err := app.users.UpsertOAuthUser(r.Context(), name, profile.ID, profile.Email, profile.Name)
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateEmail) {
+			app.oauthFailed(w, r, "Something went wrong. Please try again.")
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	user, err := app.users.Get(r.Context(), id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	// Users who have been deactivated, or who signed up with a password but
+	// haven't verified their email address yet, can't log in this way either.
+	if !user.Activated {
+		app.oauthFailed(w, r, "Please verify your email first.")
+		return
+	}
+	app.completeLogin(w, r, user, false)
+}
+
+// The oauthFailed() helper sends the user back to the login page with an error
+// message, when logging in with an OAuth provider didn't work.
+func (app *application) oauthFailed(w http.ResponseWriter, r *http.Request, message string) {
+	app.addFlash(r, flashError, message)
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
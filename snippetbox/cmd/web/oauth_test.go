@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"snippetbox/internal/assert"
+	"strings"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+// newStubGitHub starts a server which stands in for GitHub's token endpoint
+// and API. It issues an access token for the authorization code "valid-code"
+// only, and reports email as the user's primary email address.
+func newStubGitHub(t *testing.T, email string, verified bool) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /login/oauth/access_token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.FormValue("code") != "valid-code" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "bad_verification_code"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "stub-token", "token_type": "bearer"})
+	})
+	requireToken := func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer stub-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			next(w, r)
+		}
+	}
+	mux.HandleFunc("GET /user", requireToken(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"id": 1001, "login": "alice", "name": "Alice"})
+	}))
+	mux.HandleFunc("GET /user/emails", requireToken(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]map[string]any{
+			{"email": "alice@old.example.com", "primary": false, "verified": true},
+			{"email": email, "primary": true, "verified": verified},
+		})
+	}))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// newOAuthTestServer returns a test server for an application which can log
+// in with a GitHub provider pointed at stub.
+func newOAuthTestServer(t *testing.T, stub *httptest.Server) *testServer {
+	app := newTestApplication(t)
+	github := newGitHubProvider("client-id", "client-secret", app.baseURL+"/user/oauth/github/callback")
+	github.config.Endpoint = oauth2.Endpoint{
+		AuthURL:  stub.URL + "/login/oauth/authorize",
+		TokenURL: stub.URL + "/login/oauth/access_token",
+	}
+	github.apiURL = stub.URL
+	app.oauth = map[string]*oauthProvider{"github": github}
+	ts := newTestServer(t, app.routes())
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// The startOAuth() method starts logging in with the stub GitHub, and returns
+// the state which would be sent back to the callback.
+func (ts *testServer) startOAuth(t *testing.T, stub *httptest.Server) string {
+	code, header, _ := ts.get(t, "/user/oauth/github")
+	assert.Equal(t, code, http.StatusSeeOther)
+	location, err := url.Parse(header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, location.Scheme+"://"+location.Host+location.Path, stub.URL+"/login/oauth/authorize")
+	assert.Equal(t, location.Query().Get("client_id"), "client-id")
+	assert.Equal(t, location.Query().Get("redirect_uri"), "https://localhost:4000/user/oauth/github/callback")
+	state := location.Query().Get("state")
+	if state == "" {
+		t.Fatal("missing state parameter")
+	}
+	return state
+}
+
+func TestOAuthLogin(t *testing.T) {
+	t.Run("Login page", func(t *testing.T) {
+		ts := newOAuthTestServer(t, newStubGitHub(t, "alice@example.com", true))
+		_, _, body := ts.get(t, "/user/login")
+		assert.StringContains(t, body, "<a href='/user/oauth/github' class='button'>Log in with GitHub</a>")
+	})
+
+	t.Run("Unknown provider", func(t *testing.T) {
+		ts := newOAuthTestServer(t, newStubGitHub(t, "alice@example.com", true))
+		code, _, _ := ts.get(t, "/user/oauth/google")
+		assert.Equal(t, code, http.StatusNotFound)
+		code, _, _ = ts.get(t, "/user/oauth/google/callback?code=valid-code")
+		assert.Equal(t, code, http.StatusNotFound)
+	})
+
+	t.Run("Valid", func(t *testing.T) {
+		stub := newStubGitHub(t, "alice@example.com", true)
+		ts := newOAuthTestServer(t, stub)
+		state := ts.startOAuth(t, stub)
+
+		code, header, _ := ts.get(t, "/user/oauth/github/callback?code=valid-code&state="+url.QueryEscape(state))
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/snippet/create")
+
+		code, _, body := ts.get(t, "/account/view")
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "alice@example.com")
+
+		// The state can't be used again.
+		code, header, _ = ts.get(t, "/user/oauth/github/callback?code=valid-code&state="+url.QueryEscape(state))
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/login")
+	})
+
+	tests := []struct {
+		name      string
+		email     string
+		verified  bool
+		query     func(state string) string
+		wantFlash string
+	}{
+		{
+			name:      "State mismatch",
+			email:     "alice@example.com",
+			verified:  true,
+			query:     func(state string) string { return "code=valid-code&state=wrong" },
+			wantFlash: "Your login attempt has expired. Please try again.",
+		},
+		{
+			name:      "Missing state",
+			email:     "alice@example.com",
+			verified:  true,
+			query:     func(state string) string { return "code=valid-code" },
+			wantFlash: "Your login attempt has expired. Please try again.",
+		},
+		{
+			name:     "Denied consent",
+			email:    "alice@example.com",
+			verified: true,
+			query: func(state string) string {
+				return "error=access_denied&state=" + url.QueryEscape(state)
+			},
+			wantFlash: "You cancelled logging in with GitHub.",
+		},
+		{
+			name:     "Invalid code",
+			email:    "alice@example.com",
+			verified: true,
+			query: func(state string) string {
+				return "code=expired-code&state=" + url.QueryEscape(state)
+			},
+			wantFlash: "GitHub couldn&#39;t log you in. Please try again.",
+		},
+		{
+			name:     "Unverified email",
+			email:    "alice@example.com",
+			verified: false,
+			query: func(state string) string {
+				return "code=valid-code&state=" + url.QueryEscape(state)
+			},
+			wantFlash: "account doesn&#39;t have a verified email address.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newStubGitHub(t, tt.email, tt.verified)
+			ts := newOAuthTestServer(t, stub)
+			state := ts.startOAuth(t, stub)
+
+			code, header, _ := ts.get(t, "/user/oauth/github/callback?"+tt.query(state))
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, header.Get("Location"), "/user/login")
+
+			_, _, body := ts.get(t, "/user/login")
+			assert.StringContains(t, body, tt.wantFlash)
+
+			// The user must not have been logged in.
+			code, _, _ = ts.get(t, "/account/view")
+			assert.Equal(t, code, http.StatusSeeOther)
+		})
+	}
+}
+
+func TestFetchGoogleProfile(t *testing.T) {
+	tests := []struct {
+		name      string
+		verified  bool
+		wantEmail string
+	}{
+		{"Verified", true, "alice@example.com"},
+		{"Unverified", false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/v1/userinfo" {
+					http.NotFound(w, r)
+					return
+				}
+				json.NewEncoder(w).Encode(map[string]any{
+					"sub":            "108",
+					"email":          "alice@example.com",
+					"email_verified": tt.verified,
+					"name":           "Alice",
+				})
+			}))
+			defer srv.Close()
+
+			profile, err := fetchGoogleProfile(context.Background(), srv.Client(), srv.URL)
+			assert.NilError(t, err)
+			assert.Equal(t, profile.ID, "108")
+			assert.Equal(t, profile.Name, "Alice")
+			assert.Equal(t, profile.Email, tt.wantEmail)
+		})
+	}
+
+	t.Run("Error response", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}))
+		defer srv.Close()
+
+		_, err := fetchGoogleProfile(context.Background(), srv.Client(), srv.URL)
+		if err == nil || !strings.Contains(err.Error(), "401") {
+			t.Errorf("got error %v; want a 401 error", err)
+		}
+	})
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"snippetbox/internal/models"
+)
+
+// The size of the iframe which the oEmbed response asks consumers to show,
+// unless they ask for something smaller with maxwidth and maxheight.
+const (
+	oembedWidth  = 600
+	oembedHeight = 400
+)
+
+// oembed implements the provider side of the oEmbed protocol
+// (https://oembed.com), so that sites which support it can turn a link to a
+// snippet into an embedded copy of it. The url parameter must be the URL of a
+// snippet's view page on this site. We answer with a "rich" response, whose
+// HTML is an iframe showing the snippet's embed page. Only JSON is supported,
+// so any other format gets the 501 Not Implemented response which the spec
+// asks for. As with the embed page itself, the snippet is looked up as an
+// anonymous visitor would, so private snippets are never found.
+func (app *application) oembed(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	if format := qs.Get("format"); format != "" && format != "json" {
+		app.apiError(w, http.StatusNotImplemented, "format must be json")
+		return
+	}
+	rawURL := qs.Get("url")
+	if rawURL == "" {
+		app.apiError(w, http.StatusBadRequest, "url must be provided")
+		return
+	}
+	id, ok := app.snippetIDFromURL(rawURL)
+	if !ok {
+		app.apiError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return
+	}
+	snippet, err := app.snippets.Get(r.Context(), id, 0)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.apiError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	// Consumers can ask for a smaller iframe, but not a bigger one. Anything
+	// which isn't a positive number is ignored.
+	width, height := oembedWidth, oembedHeight
+	if n, err := strconv.Atoi(qs.Get("maxwidth")); err == nil && n > 0 {
+		width = min(width, n)
+	}
+	if n, err := strconv.Atoi(qs.Get("maxheight")); err == nil && n > 0 {
+		height = min(height, n)
+	}
+	embedURL := fmt.Sprintf("%s/snippet/view/%d/embed", app.baseURL, snippet.ID)
+	iframe := fmt.Sprintf(`<iframe src="%s" width="%d" height="%d" title="%s" frameborder="0"></iframe>`,
+		html.EscapeString(embedURL), width, height, html.EscapeString(snippet.Title))
+	err = app.writeJSON(w, http.StatusOK, envelope{
+		"version":       "1.0",
+		"type":          "rich",
+		"title":         snippet.Title,
+		"provider_name": "Snippetbox",
+		"provider_url":  app.baseURL + "/",
+		"html":          iframe,
+		"width":         width,
+		"height":        height,
+	}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// snippetIDFromURL() returns the ID of the snippet whose view page is at
+// rawURL. It returns false if the URL isn't on this site or isn't the URL of a
+// view page.
+func (app *application) snippetIDFromURL(rawURL string) (int, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return 0, false
+	}
+	base, err := url.Parse(app.baseURL)
+	if err != nil {
+		return 0, false
+	}
+	if !strings.EqualFold(u.Scheme, base.Scheme) || !strings.EqualFold(u.Host, base.Host) {
+		return 0, false
+	}
+	rest, ok := strings.CutPrefix(u.Path, "/snippet/view/")
+	if !ok {
+		return 0, false
+	}
+	id, err := strconv.Atoi(rest)
+	if err != nil || id < 1 {
+		return 0, false
+	}
+	return id, true
+}
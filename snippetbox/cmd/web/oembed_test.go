@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"snippetbox/internal/assert"
+)
+
+func TestOEmbed(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// The oembedPath() helper builds the path for an oEmbed request with the
+	// given query string parameters.
+	oembedPath := func(params ...string) string {
+		qs := url.Values{}
+		for i := 0; i < len(params); i += 2 {
+			qs.Set(params[i], params[i+1])
+		}
+		return "/oembed?" + qs.Encode()
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		code, header, body := ts.get(t, oembedPath("url", "https://localhost:4000/snippet/view/1", "format", "json"))
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, header.Get("Content-Type"), "application/json")
+
+		var data struct {
+			Version      string `json:"version"`
+			Type         string `json:"type"`
+			Title        string `json:"title"`
+			ProviderName string `json:"provider_name"`
+			ProviderURL  string `json:"provider_url"`
+			HTML         string `json:"html"`
+			Width        int    `json:"width"`
+			Height       int    `json:"height"`
+		}
+		err := json.Unmarshal([]byte(body), &data)
+		assert.NilError(t, err)
+		assert.Equal(t, data.Version, "1.0")
+		assert.Equal(t, data.Type, "rich")
+		assert.Equal(t, data.Title, "An old silent pond")
+		assert.Equal(t, data.ProviderName, "Snippetbox")
+		assert.Equal(t, data.ProviderURL, "https://localhost:4000/")
+		assert.Equal(t, data.Width, 600)
+		assert.Equal(t, data.Height, 400)
+		assert.Equal(t, data.HTML, `<iframe src="https://localhost:4000/snippet/view/1/embed" width="600" height="400" title="An old silent pond" frameborder="0"></iframe>`)
+	})
+
+	t.Run("Max size", func(t *testing.T) {
+		_, _, body := ts.get(t, oembedPath("url", "https://localhost:4000/snippet/view/1", "maxwidth", "300", "maxheight", "1000"))
+		var data struct {
+			Width  int `json:"width"`
+			Height int `json:"height"`
+		}
+		err := json.Unmarshal([]byte(body), &data)
+		assert.NilError(t, err)
+		assert.Equal(t, data.Width, 300)
+		assert.Equal(t, data.Height, 400)
+	})
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+	}{
+		{"Missing URL", oembedPath(), http.StatusBadRequest},
+		{"XML format", oembedPath("url", "https://localhost:4000/snippet/view/1", "format", "xml"), http.StatusNotImplemented},
+		{"Other site", oembedPath("url", "https://example.com/snippet/view/1"), http.StatusNotFound},
+		{"Other scheme", oembedPath("url", "http://localhost:4000/snippet/view/1"), http.StatusNotFound},
+		{"Not a view page", oembedPath("url", "https://localhost:4000/snippet/view/1/raw"), http.StatusNotFound},
+		{"Invalid ID", oembedPath("url", "https://localhost:4000/snippet/view/-1"), http.StatusNotFound},
+		{"Unknown snippet", oembedPath("url", "https://localhost:4000/snippet/view/99"), http.StatusNotFound},
+		{"Private snippet", oembedPath("url", "https://localhost:4000/snippet/view/6"), http.StatusNotFound},
+		{"Malformed URL", oembedPath("url", "://localhost"), http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, header, _ := ts.get(t, tt.urlPath)
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, header.Get("Content-Type"), "application/json")
+		})
+	}
+}
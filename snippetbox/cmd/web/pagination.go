@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+)
+
+// paginationWindow is the number of pages either side of the current one
+// which are linked to directly. The first and last pages are always linked to
+// as well, and any gaps in between are shown as an ellipsis.
+const paginationWindow = 2
+
+// Pagination holds everything that the "pagination" partial template needs to
+// show the links between the pages of a list. PageNumbers lists the page
+// numbers to link to in order, with a 0 wherever there's a gap which should be
+// shown as an ellipsis.
+type Pagination struct {
+	CurrentPage int
+	TotalPages  int
+	HasPrev     bool
+	HasNext     bool
+	PageNumbers []int
+
+	pageSize int
+	// path and query are the path and query string of the list's URL, which
+	// are set by withURL() and used to build the links to other pages.
+	path  string
+	query url.Values
+}
+
+// The newPagination() function works out the pagination for page (counting
+// from 1) of a list of total items, split into pages of pageSize items. There
+// is always at least one page, even if the list is empty. The page can be
+// past the last page, in which case there's nothing to show on it but the
+// links back.
+func newPagination(total, page, pageSize int) Pagination {
+	totalPages := max(1, (total+pageSize-1)/pageSize)
+	p := Pagination{
+		CurrentPage: page,
+		TotalPages:  totalPages,
+		HasPrev:     page > 1,
+		HasNext:     page < totalPages,
+		pageSize:    pageSize,
+	}
+	// Every page in the window around the current page is linked, along
+	// with the first and last pages. A gap of a single page is filled in with
+	// that page's number, as an ellipsis wouldn't save any space.
+	first := max(1, min(page, totalPages)-paginationWindow)
+	last := min(totalPages, page+paginationWindow)
+	if first <= 3 {
+		first = 1
+	}
+	if last >= totalPages-2 {
+		last = totalPages
+	}
+	if first > 1 {
+		p.PageNumbers = append(p.PageNumbers, 1, 0)
+	}
+	for n := first; n <= last; n++ {
+		p.PageNumbers = append(p.PageNumbers, n)
+	}
+	if last < totalPages {
+		p.PageNumbers = append(p.PageNumbers, 0, totalPages)
+	}
+	return p
+}
+
+// The withURL() method sets the URL of the list, so that the links to the
+// other pages keep the same path and any other query string parameters (like
+// the search terms).
+func (p Pagination) withURL(u *url.URL) Pagination {
+	p.path = u.Path
+	p.query = u.Query()
+	return p
+}
+
+// The offset() method returns the number of items before the current page,
+// for passing to the models as the SQL OFFSET.
+func (p Pagination) offset() int {
+	return (p.CurrentPage - 1) * p.pageSize
+}
+
+// The PrevPage() method returns the number of the page before the current one.
+// From past the end of the list it goes back to the last page instead, as
+// there's nothing on the pages in between.
+func (p Pagination) PrevPage() int {
+	return min(p.CurrentPage-1, p.TotalPages)
+}
+
+// The NextPage() method returns the number of the page after the current one.
+func (p Pagination) NextPage() int {
+	return p.CurrentPage + 1
+}
+
+// The PageURL() method returns the URL of page n of the list. The first page
+// doesn't need a page parameter at all, so it's left out to keep the URL
+// canonical.
+func (p Pagination) PageURL(n int) string {
+	query := url.Values{}
+	for key, values := range p.query {
+		query[key] = values
+	}
+	if n > 1 {
+		query.Set("page", strconv.Itoa(n))
+	} else {
+		query.Del("page")
+	}
+	if len(query) == 0 {
+		return p.path
+	}
+	return p.path + "?" + query.Encode()
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net/url"
+	"slices"
+	"testing"
+
+	"snippetbox/internal/assert"
+)
+
+func TestNewPagination(t *testing.T) {
+	tests := []struct {
+		name            string
+		total           int
+		page            int
+		wantTotalPages  int
+		wantHasPrev     bool
+		wantHasNext     bool
+		wantPageNumbers []int
+	}{
+		{
+			name:            "Empty list",
+			total:           0,
+			page:            1,
+			wantTotalPages:  1,
+			wantPageNumbers: []int{1},
+		},
+		{
+			name:            "Exactly one page",
+			total:           10,
+			page:            1,
+			wantTotalPages:  1,
+			wantPageNumbers: []int{1},
+		},
+		{
+			name:            "One more than a page",
+			total:           11,
+			page:            1,
+			wantTotalPages:  2,
+			wantHasNext:     true,
+			wantPageNumbers: []int{1, 2},
+		},
+		{
+			name:            "Few pages",
+			total:           50,
+			page:            3,
+			wantTotalPages:  5,
+			wantHasPrev:     true,
+			wantHasNext:     true,
+			wantPageNumbers: []int{1, 2, 3, 4, 5},
+		},
+		{
+			name:            "First page",
+			total:           200,
+			page:            1,
+			wantTotalPages:  20,
+			wantHasNext:     true,
+			wantPageNumbers: []int{1, 2, 3, 0, 20},
+		},
+		{
+			name:            "Near the start",
+			total:           200,
+			page:            4,
+			wantTotalPages:  20,
+			wantHasPrev:     true,
+			wantHasNext:     true,
+			wantPageNumbers: []int{1, 2, 3, 4, 5, 6, 0, 20},
+		},
+		{
+			name:            "Middle",
+			total:           200,
+			page:            10,
+			wantTotalPages:  20,
+			wantHasPrev:     true,
+			wantHasNext:     true,
+			wantPageNumbers: []int{1, 0, 8, 9, 10, 11, 12, 0, 20},
+		},
+		{
+			name:            "Near the end",
+			total:           200,
+			page:            17,
+			wantTotalPages:  20,
+			wantHasPrev:     true,
+			wantHasNext:     true,
+			wantPageNumbers: []int{1, 0, 15, 16, 17, 18, 19, 20},
+		},
+		{
+			name:            "Last page",
+			total:           195,
+			page:            20,
+			wantTotalPages:  20,
+			wantHasPrev:     true,
+			wantPageNumbers: []int{1, 0, 18, 19, 20},
+		},
+		{
+			name:            "Past the last page",
+			total:           200,
+			page:            25,
+			wantTotalPages:  20,
+			wantHasPrev:     true,
+			wantPageNumbers: []int{1, 0, 18, 19, 20},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newPagination(tt.total, tt.page, 10)
+			assert.Equal(t, p.CurrentPage, tt.page)
+			assert.Equal(t, p.TotalPages, tt.wantTotalPages)
+			assert.Equal(t, p.HasPrev, tt.wantHasPrev)
+			assert.Equal(t, p.HasNext, tt.wantHasNext)
+			if !slices.Equal(p.PageNumbers, tt.wantPageNumbers) {
+				t.Errorf("got page numbers %v; want %v", p.PageNumbers, tt.wantPageNumbers)
+			}
+			assert.Equal(t, p.offset(), (tt.page-1)*10)
+		})
+	}
+}
+
+func TestPaginationLinks(t *testing.T) {
+	u, err := url.Parse("/snippet/search?q=frog+pond&page=3")
+	assert.NilError(t, err)
+	p := newPagination(100, 3, 10).withURL(u)
+
+	assert.Equal(t, p.PageURL(1), "/snippet/search?q=frog+pond")
+	assert.Equal(t, p.PageURL(4), "/snippet/search?page=4&q=frog+pond")
+	assert.Equal(t, p.PrevPage(), 2)
+	assert.Equal(t, p.NextPage(), 4)
+
+	// Building links doesn't change the query of the pagination itself.
+	assert.Equal(t, p.PageURL(3), "/snippet/search?page=3&q=frog+pond")
+
+	u, err = url.Parse("/account/snippets?page=9")
+	assert.NilError(t, err)
+	p = newPagination(30, 9, 10).withURL(u)
+	assert.Equal(t, p.PageURL(1), "/account/snippets")
+	assert.Equal(t, p.PrevPage(), 3)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+
+	"snippetbox/internal/validator"
+)
+
+type snippetPreviewForm struct {
+	Content             string `form:"content"`
+	Language            string `form:"language"`
+	validator.Validator `form:"-"`
+}
+
+// snippetPreviewPost renders the content from the create or edit snippet form
+// without saving it, and sends back the HTML fragment as JSON. It uses the
+// same highlight() function as the snippet view page, which escapes
+// everything it writes, so the preview shows exactly what the saved snippet
+// will look like and can't be used to inject markup into the page. The
+// content and language are checked in the same way as when a snippet is saved.
+// Like the other form posts it needs a CSRF token, and the limitBody
+// middleware turns away any body which is too large.
+func (app *application) snippetPreviewPost(w http.ResponseWriter, r *http.Request) {
+	var form snippetPreviewForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	validator.CheckMaxChars(&form.Validator, "content", form.Content, app.snippetLimits.contentMax)
+	form.CheckField(validator.PermittedValue(form.Language, append([]string{""}, languages...)...), "language", "This field must be a supported language")
+	if !form.Valid() {
+		app.apiError(w, http.StatusUnprocessableEntity, form.FieldErrors)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"html": highlight(form.Content, form.Language)}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"snippetbox/internal/assert"
+)
+
+func TestSnippetPreview(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Anonymous", func(t *testing.T) {
+		_, _, body := ts.get(t, "/user/login")
+		form := url.Values{}
+		form.Add("content", "Hello")
+		form.Add("csrf_token", extractCSRFToken(t, body))
+		code, header, _ := ts.postForm(t, "/snippet/preview", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/login")
+	})
+
+	csrfToken := ts.login(t)
+
+	t.Run("Missing CSRF token", func(t *testing.T) {
+		code, _, _ := ts.postForm(t, "/snippet/preview", url.Values{"content": {"Hello"}})
+		assert.Equal(t, code, http.StatusBadRequest)
+	})
+
+	// Markdown which would run a script, or link to one, if it was rendered
+	// as HTML without being sanitized.
+	markdown := "# Title\n\n<script>alert('hi')</script>\n\n[click me](javascript:alert(1))\n\n<img src=x onerror=alert(1)>"
+
+	tests := []struct {
+		name     string
+		content  string
+		language string
+		wantCode int
+		wantBody []string
+		notBody  []string
+	}{
+		{
+			name:     "Markdown",
+			content:  markdown,
+			wantCode: http.StatusOK,
+			wantBody: []string{
+				"# Title",
+				"&lt;script&gt;alert(&#39;hi&#39;)&lt;/script&gt;",
+				"[click me](javascript:alert(1))",
+				"&lt;img src=x onerror=alert(1)&gt;",
+			},
+			notBody: []string{"<script", "<img", "<a "},
+		},
+		{
+			name:     "Highlighted",
+			content:  "package main\n\n// <b>not bold</b>",
+			language: "go",
+			wantCode: http.StatusOK,
+			wantBody: []string{"<span", "package", "&lt;b&gt;not bold&lt;/b&gt;"},
+			notBody:  []string{"<b>"},
+		},
+		{
+			name:     "Too long",
+			content:  strings.Repeat("a", 10_001),
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: []string{"This field cannot be more than 10000 characters long"},
+		},
+		{
+			name:     "Unsupported language",
+			content:  "Hello",
+			language: "brainfuck",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: []string{"This field must be a supported language"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("content", tt.content)
+			form.Add("language", tt.language)
+			form.Add("csrf_token", csrfToken)
+			code, header, body := ts.postForm(t, "/snippet/preview", form)
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, header.Get("Content-Type"), "application/json")
+
+			var data struct {
+				HTML  string            `json:"html"`
+				Error map[string]string `json:"error"`
+			}
+			err := json.Unmarshal([]byte(body), &data)
+			assert.NilError(t, err)
+			got := data.HTML
+			if tt.wantCode != http.StatusOK {
+				got = strings.Join([]string{data.Error["content"], data.Error["language"]}, " ")
+			}
+			for _, want := range tt.wantBody {
+				assert.StringContains(t, got, want)
+			}
+			for _, notWant := range tt.notBody {
+				if strings.Contains(got, notWant) {
+					t.Errorf("preview contains %q: %s", notWant, got)
+				}
+			}
+		})
+	}
+
+	t.Run("Matches view", func(t *testing.T) {
+		// The preview is exactly what the snippet view page shows for the
+		// same content.
+		content := "x := 1 < 2 && \"a\" > `b`"
+		form := url.Values{}
+		form.Add("content", content)
+		form.Add("language", "go")
+		form.Add("csrf_token", csrfToken)
+		_, _, body := ts.postForm(t, "/snippet/preview", form)
+		var data struct {
+			HTML string `json:"html"`
+		}
+		err := json.Unmarshal([]byte(body), &data)
+		assert.NilError(t, err)
+		assert.Equal(t, data.HTML, string(highlight(content, "go")))
+	})
+
+	t.Run("Body too large", func(t *testing.T) {
+		form := url.Values{}
+		form.Add("content", strings.Repeat("a", 2_000_000))
+		form.Add("csrf_token", csrfToken)
+		code, _, _ := ts.postForm(t, "/snippet/preview", form)
+		assert.Equal(t, code, http.StatusRequestEntityTooLarge)
+	})
+}
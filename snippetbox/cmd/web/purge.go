@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// The purgeExpiredSnippets() method permanently deletes long-expired snippets
+// once every interval, until ctx is cancelled. Expired snippets are hidden from
+// everyone but their owners straight away, and after a grace period (see
+// models.ExpiredRetentionDays) they're deleted, so that the snippets table
+// doesn't grow forever. Snippets in the trash are kept, as they can still be
+// restored. A failed purge is logged and tried again at the next tick. It
+// should be run in its own goroutine.
+func (app *application) purgeExpiredSnippets(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := app.snippets.PurgeExpired(ctx)
+			if err != nil {
+				// Being cancelled part way through a purge is expected when
+				// we're shutting down, so it isn't worth logging.
+				if ctx.Err() == nil {
+					app.logger.Error(err.Error())
+				}
+				continue
+			}
+			if n > 0 {
+				app.logger.Info("purged expired snippets", "count", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"snippetbox/internal/assert"
+	"snippetbox/internal/models/mocks"
+)
+
+// purgeSnippetModel is a mock SnippetModel which reports each call to
+// PurgeExpired() on a channel.
+type purgeSnippetModel struct {
+	*mocks.SnippetModel
+	purged chan struct{}
+}
+
+func (m *purgeSnippetModel) PurgeExpired(ctx context.Context) (int64, error) {
+	m.purged <- struct{}{}
+	return m.SnippetModel.PurgeExpired(ctx)
+}
+
+func TestPurgeExpiredSnippets(t *testing.T) {
+	var buf bytes.Buffer
+	app := newTestApplication(t)
+	app.logger = slog.New(slog.NewTextHandler(&buf, nil))
+	m := &purgeSnippetModel{SnippetModel: &mocks.SnippetModel{}, purged: make(chan struct{})}
+	app.snippets = m
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		app.purgeExpiredSnippets(ctx, 10*time.Millisecond)
+		close(done)
+	}()
+
+	// The job purges once per tick...
+	for range 2 {
+		select {
+		case <-m.purged:
+		case <-time.After(5 * time.Second):
+			t.Fatal("expired snippets weren't purged")
+		}
+	}
+
+	// ...until it's cancelled, when it stops. A tick may be due at the same
+	// moment as the cancellation, so we let any purges which are already
+	// under way carry on.
+	cancel()
+	timeout := time.After(5 * time.Second)
+	for stopped := false; !stopped; {
+		select {
+		case <-done:
+			stopped = true
+		case <-m.purged:
+		case <-timeout:
+			t.Fatal("purge job didn't stop")
+		}
+	}
+	assert.StringContains(t, buf.String(), "msg=\"purged expired snippets\" count=2")
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// robotsTxt serves a robots.txt file, which asks well-behaved crawlers to keep
+// out of the paths in the robots-disallow setting and points them at the
+// sitemap. Nothing stops a crawler from ignoring it, so it's no substitute for
+// requiring authentication -- it just keeps pages which are no use to anyone
+// out of search results.
+func (app *application) robotsTxt(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	b.WriteString("User-agent: *\n")
+	for _, path := range app.robots.allow {
+		fmt.Fprintf(&b, "Allow: %s\n", path)
+	}
+	for _, path := range app.robots.disallow {
+		fmt.Fprintf(&b, "Disallow: %s\n", path)
+	}
+	// Every group needs at least one rule, and an empty Disallow line allows
+	// everything.
+	if len(app.robots.allow) == 0 && len(app.robots.disallow) == 0 {
+		b.WriteString("Disallow:\n")
+	}
+	fmt.Fprintf(&b, "\nSitemap: %s/sitemap.xml\n", app.baseURL)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"snippetbox/internal/assert"
+)
+
+func TestRobotsTxt(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, header, body := ts.get(t, "/robots.txt")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, header.Get("Content-Type"), "text/plain; charset=utf-8")
+	assert.StringContains(t, body, "User-agent: *\n")
+	assert.StringContains(t, body, "Allow: /user/profile/\n")
+	assert.StringContains(t, body, "Disallow: /account/\n")
+	assert.StringContains(t, body, "Disallow: /user/\n")
+	assert.StringContains(t, body, "Sitemap: https://localhost:4000/sitemap.xml\n")
+
+	t.Run("No rules", func(t *testing.T) {
+		app.robots = robotsConfig{}
+		_, _, body := ts.get(t, "/robots.txt")
+		assert.Equal(t, body, "User-agent: *\nDisallow:\n\nSitemap: https://localhost:4000/sitemap.xml\n")
+	})
+}
@@ -10,8 +10,17 @@ import (
 
 func (app *application) routes() http.Handler {
 	router := httprouter.New()
+	// Use our own helpers for requests which don't match a route, so that the
+	// error responses are consistent with the rest of the application.
 	router.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		app.notFound(w)
+		app.notFound(w, r)
+	})
+	// If the URL path matches a route but the method doesn't, httprouter sets
+	// the Allow header to the methods which the path does support before
+	// calling this handler, so we just need to send the 405 status.
+	router.HandleMethodNotAllowed = true
+	router.MethodNotAllowed = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		app.clientError(w, r, http.StatusMethodNotAllowed)
 	})
 	// Take the ui.Files embedded filesystem and convert it to a http.FS type so
 	// that it satisfies the http.FileSystem interface. We then pass that to the
@@ -22,28 +31,145 @@ func (app *application) routes() http.Handler {
 	// "static/css/main.css". This means that we now longer need to strip the
 	// prefix from the request URL -- any requests that start with /static/ can
 	// just be passed directly to the file server and the corresponding static
-	// file will be served (so long as it exists).
-	router.Handler(http.MethodGet, "/static/*filepath", fileServer)
+	// file will be served (so long as it exists). The cacheStatic middleware
+	// lets browsers cache the files for as long as they're unchanged.
+	router.Handler(http.MethodGet, "/static/*filepath", cacheStatic(fileServer))
 	// Add a new GET /ping route.
 	router.HandlerFunc(http.MethodGet, "/ping", ping)
+	// Liveness and readiness checks for load balancers and orchestrators.
+	router.HandlerFunc(http.MethodGet, "/healthz", app.healthcheck)
+	router.HandlerFunc(http.MethodGet, "/readyz", app.readiness)
+	// The RSS feed, robots.txt, sitemap, avatars and oEmbed endpoint don't use
+	// the session, so they don't need the dynamic middleware (which would also
+	// stop them from being cached).
+	router.HandlerFunc(http.MethodGet, "/feed.xml", app.feed)
+	router.HandlerFunc(http.MethodGet, "/robots.txt", app.robotsTxt)
+	router.HandlerFunc(http.MethodGet, "/sitemap.xml", app.sitemap)
+	router.HandlerFunc(http.MethodGet, "/avatar/:name", app.avatar)
+	router.HandlerFunc(http.MethodGet, "/oembed", app.oembed)
 	// Unprotected application routes using the "dynamic" middleware chain.
-	dynamic := alice.New(app.sessionManager.LoadAndSave, noSurf, app.authenticate)
+	dynamic := alice.New(app.sessionManager.LoadAndSave, noSurf, app.authenticate, app.localize)
 	router.Handler(http.MethodGet, "/", dynamic.ThenFunc(app.home))
 	router.Handler(http.MethodGet, "/about", dynamic.ThenFunc(app.about))
+	router.Handler(http.MethodGet, "/csrf-token", dynamic.ThenFunc(app.csrfToken))
+	router.Handler(http.MethodPost, "/language", dynamic.ThenFunc(app.languagePost))
+	router.Handler(http.MethodPost, "/preferences/theme", dynamic.ThenFunc(app.themePost))
 	router.Handler(http.MethodGet, "/snippet/view/:id", dynamic.ThenFunc(app.snippetView))
+	router.Handler(http.MethodGet, "/snippet/view/:id/raw", dynamic.ThenFunc(app.snippetViewRaw))
+	router.Handler(http.MethodGet, "/snippet/view/:id/download", dynamic.ThenFunc(app.snippetDownload))
+	router.Handler(http.MethodGet, "/snippet/view/:id/embed", dynamic.ThenFunc(app.snippetEmbed))
+	router.Handler(http.MethodGet, "/s/:slug", dynamic.ThenFunc(app.snippetShared))
+	router.Handler(http.MethodGet, "/snippet/search", dynamic.ThenFunc(app.snippetSearch))
+	router.Handler(http.MethodGet, "/snippet/tag/:tag", dynamic.ThenFunc(app.snippetsByTag))
+	// httprouter doesn't allow a wildcard at the same level as the other
+	// /user/ routes, so profiles live under /user/profile/.
+	router.Handler(http.MethodGet, "/user/profile/:username", dynamic.ThenFunc(app.userProfile))
 	router.Handler(http.MethodGet, "/user/signup", dynamic.ThenFunc(app.userSignup))
 	router.Handler(http.MethodPost, "/user/signup", dynamic.ThenFunc(app.userSignupPost))
+	router.Handler(http.MethodGet, "/user/activate/:token", dynamic.ThenFunc(app.activateAccount))
 	router.Handler(http.MethodGet, "/user/login", dynamic.ThenFunc(app.userLogin))
 	router.Handler(http.MethodPost, "/user/login", dynamic.ThenFunc(app.userLoginPost))
+	router.Handler(http.MethodGet, "/user/login/2fa", dynamic.ThenFunc(app.userLogin2FA))
+	router.Handler(http.MethodPost, "/user/login/2fa", dynamic.ThenFunc(app.userLogin2FAPost))
+	router.Handler(http.MethodGet, "/user/oauth/:provider", dynamic.ThenFunc(app.oauthLogin))
+	router.Handler(http.MethodGet, "/user/oauth/:provider/callback", dynamic.ThenFunc(app.oauthCallback))
+	router.Handler(http.MethodGet, "/account/email/confirm/:token", dynamic.ThenFunc(app.accountEmailConfirm))
+	router.Handler(http.MethodGet, "/user/password/forgot", dynamic.ThenFunc(app.forgotPassword))
+	router.Handler(http.MethodPost, "/user/password/forgot", dynamic.ThenFunc(app.forgotPasswordPost))
+	router.Handler(http.MethodGet, "/user/password/reset", dynamic.ThenFunc(app.resetPassword))
+	router.Handler(http.MethodPost, "/user/password/reset", dynamic.ThenFunc(app.resetPasswordPost))
 	// Protected (authenticated-only) application routes, using a new "protected"
 	// middleware chain which includes the requireAuthentication middleware.
 	protected := dynamic.Append(app.requireAuthentication)
 	router.Handler(http.MethodGet, "/snippet/create", protected.ThenFunc(app.snippetCreate))
 	router.Handler(http.MethodPost, "/snippet/create", protected.ThenFunc(app.snippetCreatePost))
+	router.Handler(http.MethodPost, "/snippet/preview", protected.ThenFunc(app.snippetPreviewPost))
+	router.Handler(http.MethodGet, "/snippet/update/:id", protected.ThenFunc(app.snippetUpdate))
+	router.Handler(http.MethodPost, "/snippet/update/:id", protected.ThenFunc(app.snippetUpdatePost))
+	router.Handler(http.MethodPost, "/snippet/delete/:id", protected.ThenFunc(app.snippetDeletePost))
+	router.Handler(http.MethodPost, "/snippet/restore/:id", protected.ThenFunc(app.snippetRestorePost))
+	router.Handler(http.MethodGet, "/snippet/view/:id/history", protected.ThenFunc(app.snippetHistory))
+	router.Handler(http.MethodGet, "/snippet/view/:id/diff", protected.ThenFunc(app.snippetDiff))
+	router.Handler(http.MethodPost, "/snippet/revert/:id", protected.ThenFunc(app.snippetRevertPost))
+	router.Handler(http.MethodPost, "/snippet/extend/:id", protected.ThenFunc(app.snippetExtendPost))
+	router.Handler(http.MethodPost, "/snippet/pin/:id", protected.ThenFunc(app.snippetPinPost))
+	router.Handler(http.MethodPost, "/snippet/unpin/:id", protected.ThenFunc(app.snippetUnpinPost))
+	router.Handler(http.MethodPost, "/snippet/fork/:id", protected.ThenFunc(app.snippetForkPost))
+	router.Handler(http.MethodPost, "/snippet/comment/:id", protected.ThenFunc(app.snippetCommentPost))
+	router.Handler(http.MethodPost, "/comment/delete/:id", protected.ThenFunc(app.commentDeletePost))
+	router.Handler(http.MethodGet, "/snippet/report/:id", protected.ThenFunc(app.snippetReport))
+	router.Handler(http.MethodPost, "/snippet/report/:id", protected.ThenFunc(app.snippetReportPost))
+	router.Handler(http.MethodPost, "/snippet/favourite/:id", protected.ThenFunc(app.snippetFavouritePost))
+	router.Handler(http.MethodPost, "/snippet/unfavourite/:id", protected.ThenFunc(app.snippetUnfavouritePost))
 	router.Handler(http.MethodPost, "/user/logout", protected.ThenFunc(app.userLogoutPost))
+	router.Handler(http.MethodGet, "/notifications", protected.ThenFunc(app.notificationList))
+	router.Handler(http.MethodPost, "/notifications/:id/read", protected.ThenFunc(app.notificationReadPost))
 	router.Handler(http.MethodGet, "/account/view", protected.ThenFunc(app.accountView))
+	router.Handler(http.MethodPost, "/account/timezone", protected.ThenFunc(app.accountTimezonePost))
+	router.Handler(http.MethodPost, "/account/avatar", protected.ThenFunc(app.accountAvatarPost))
+	router.Handler(http.MethodPost, "/account/unique-titles", protected.ThenFunc(app.accountUniqueTitlesPost))
+	router.Handler(http.MethodGet, "/account/snippets", protected.ThenFunc(app.accountSnippets))
+	router.Handler(http.MethodGet, "/account/2fa", protected.ThenFunc(app.accountTOTP))
+	router.Handler(http.MethodPost, "/account/2fa/enable", protected.ThenFunc(app.accountTOTPEnablePost))
+	router.Handler(http.MethodPost, "/account/2fa/disable", protected.ThenFunc(app.accountTOTPDisablePost))
+	router.Handler(http.MethodGet, "/account/favourites", protected.ThenFunc(app.accountFavourites))
+	router.Handler(http.MethodGet, "/account/trash", protected.ThenFunc(app.accountTrash))
+	router.Handler(http.MethodGet, "/account/export.csv", protected.ThenFunc(app.accountExport))
+	router.Handler(http.MethodGet, "/account/export.json", protected.ThenFunc(app.accountExportJSON))
+	router.Handler(http.MethodPost, "/account/import", protected.ThenFunc(app.accountImportPost))
+	router.Handler(http.MethodGet, "/account/sessions", protected.ThenFunc(app.accountSessions))
+	router.Handler(http.MethodGet, "/account/activity", protected.ThenFunc(app.accountActivity))
+	router.Handler(http.MethodPost, "/account/sessions/revoke/:token", protected.ThenFunc(app.accountSessionRevokePost))
+	router.Handler(http.MethodPost, "/account/sessions/revoke-others", protected.ThenFunc(app.accountSessionRevokeOthersPost))
+	router.Handler(http.MethodGet, "/account/email/update", protected.ThenFunc(app.accountEmailUpdate))
+	router.Handler(http.MethodPost, "/account/email/update", protected.ThenFunc(app.accountEmailUpdatePost))
 	router.Handler(http.MethodGet, "/account/password/update", protected.ThenFunc(app.accountPasswordUpdate))
 	router.Handler(http.MethodPost, "/account/password/update", protected.ThenFunc(app.accountPasswordUpdatePost))
-	standard := alice.New(app.recoverPanic, app.logRequest, secureHeaders)
+	router.Handler(http.MethodGet, "/account/delete", protected.ThenFunc(app.accountDelete))
+	router.Handler(http.MethodPost, "/account/delete", protected.ThenFunc(app.accountDeletePost))
+	// Admin routes, which are only available to users with the admin role.
+	admin := protected.Append(app.requireAdmin)
+	router.Handler(http.MethodGet, "/admin", admin.ThenFunc(app.adminDashboard))
+	router.Handler(http.MethodGet, "/admin/users", admin.ThenFunc(app.adminUsers))
+	router.Handler(http.MethodPost, "/admin/users/deactivate/:id", admin.ThenFunc(app.adminUserDeactivatePost))
+	router.Handler(http.MethodPost, "/admin/users/activate/:id", admin.ThenFunc(app.adminUserActivatePost))
+	router.Handler(http.MethodPost, "/admin/snippets/delete", admin.ThenFunc(app.adminSnippetDeletePost))
+	router.Handler(http.MethodGet, "/admin/reports", admin.ThenFunc(app.adminReports))
+	router.Handler(http.MethodPost, "/admin/reports/resolve/:id", admin.ThenFunc(app.adminReportResolvePost))
+	router.Handler(http.MethodPost, "/admin/reports/dismiss/:id", admin.ThenFunc(app.adminReportDismissPost))
+	router.Handler(http.MethodPost, "/admin/maintenance", admin.ThenFunc(app.adminMaintenancePost))
+	// JSON API routes. These authenticate with bearer tokens rather than
+	// session cookies, so they don't use the session or CSRF middleware.
+	router.HandlerFunc(http.MethodPost, "/api/v1/tokens", app.apiTokenCreate)
+	api := alice.New(app.authenticateAPIToken)
+	router.Handler(http.MethodGet, "/api/v1/account", api.ThenFunc(app.apiAccountView))
+	router.Handler(http.MethodPost, "/api/v1/snippets/bulk", api.ThenFunc(app.apiSnippetBulkCreate))
+	// Except for the snippet content, which is fetched by the copy button on
+	// the snippet page and so uses the session like the page itself does.
+	router.Handler(http.MethodGet, "/api/v1/snippets/:id/content", dynamic.ThenFunc(app.apiSnippetContent))
+	// The requestID middleware comes first, so that every log entry for the
+	// request (including one for a recovered panic) includes the request ID,
+	// followed by realIP so that the client's real address is logged and rate
+	// limited.
+	// If compression is enabled it comes straight after, so that everything
+	// written further down the chain (including the 500 response for a
+	// recovered panic) is compressed.
+	standard := alice.New(requestID, app.realIP)
+	if app.compress {
+		standard = standard.Append(compressResponse)
+	}
+	// The timeout middleware comes after secureHeaders, so that the 503
+	// response for a request which times out has the same security headers
+	// as any other. The maintenanceMode middleware comes after secureHeaders
+	// too, so that the maintenance page has a CSP nonce for its script.
+	standard = standard.Append(app.recoverPanic, app.logRequest, secureHeaders, app.timeout, app.rateLimit, app.maintenanceMode, app.limitBody)
+	// If metrics are enabled, register the /metrics route and wrap everything
+	// in the instrumentation middleware. It goes at the very start of the
+	// chain so that the status codes of rate limited requests and recovered
+	// panics are recorded too.
+	if app.metrics != nil {
+		router.Handler(http.MethodGet, "/metrics", app.metrics.handler())
+		standard = alice.New(app.metrics.instrument(router)).Extend(standard)
+	}
 	return standard.Then(router)
 }
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"snippetbox/internal/assert"
+)
+
+func TestRoutesUnmatched(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name      string
+		method    string
+		urlPath   string
+		wantCode  int
+		wantAllow string
+		wantBody  string
+	}{
+		{
+			name:      "Wrong method",
+			method:    http.MethodDelete,
+			urlPath:   "/snippet/view/1",
+			wantCode:  http.StatusMethodNotAllowed,
+			wantAllow: "GET, OPTIONS",
+			wantBody:  "Method Not Allowed",
+		},
+		{
+			name:      "Route with GET and POST",
+			method:    http.MethodPut,
+			urlPath:   "/snippet/create",
+			wantCode:  http.StatusMethodNotAllowed,
+			wantAllow: "GET, OPTIONS, POST",
+			wantBody:  "Method Not Allowed",
+		},
+		{
+			name:      "GET on a POST-only API route",
+			method:    http.MethodGet,
+			urlPath:   "/api/v1/tokens",
+			wantCode:  http.StatusMethodNotAllowed,
+			wantAllow: "OPTIONS, POST",
+			wantBody:  `"error": "Method Not Allowed"`,
+		},
+		{
+			name:     "Unknown path",
+			method:   http.MethodGet,
+			urlPath:  "/missing",
+			wantCode: http.StatusNotFound,
+			wantBody: "Not Found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, header, body := ts.do(t, tt.method, tt.urlPath, nil, nil)
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, header.Get("Allow"), tt.wantAllow)
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+)
+
+// The serve() method runs the server until a signal is received on the quit
+// channel, and then shuts it down gracefully. The listen function should start
+// the server (e.g. by calling srv.ListenAndServeTLS()); we take it as a
+// parameter so that the tests can use a plain listener on a random port.
+//
+// When a signal arrives we call srv.Shutdown(), which stops accepting new
+// connections and waits for any in-flight requests to complete, up to the
+// app.drainTimeout deadline. After that we wait for any background
+// goroutines (such as those sending emails) to finish before returning, so
+// that their work isn't cut off when the process exits.
+func (app *application) serve(srv *http.Server, listen func() error, quit <-chan os.Signal) error {
+	// We use a buffered channel, so that the goroutine below never blocks on
+	// sending its result even if listen() has failed and nobody is receiving.
+	shutdownError := make(chan error, 1)
+
+	go func() {
+		s := <-quit
+		app.logger.Info("shutting down server", "signal", s.String())
+
+		ctx, cancel := context.WithTimeout(context.Background(), app.drainTimeout)
+		defer cancel()
+
+		// Shutdown() will return an error if the in-flight requests didn't
+		// complete before the context deadline. In that case we don't wait for
+		// the background goroutines either -- the process is going away soon
+		// regardless.
+		err := srv.Shutdown(ctx)
+		if err != nil {
+			shutdownError <- err
+			return
+		}
+
+		app.logger.Info("completing background tasks", "addr", srv.Addr)
+		app.wg.Wait()
+		shutdownError <- nil
+	}()
+
+	app.logger.Info("starting server", "addr", srv.Addr)
+
+	// Calling Shutdown() causes listen() to return http.ErrServerClosed
+	// straight away, so that error means that the graceful shutdown has
+	// started. Any other error is a genuine problem starting the server.
+	err := listen()
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	err = <-shutdownError
+	if err != nil {
+		return err
+	}
+
+	app.logger.Info("shutdown completed", "addr", srv.Addr)
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"snippetbox/internal/assert"
+)
+
+func TestServeGracefulShutdown(t *testing.T) {
+	app := newTestApplication(t)
+	app.drainTimeout = 5 * time.Second
+
+	// A slow handler, which lets us know once the request is in-flight.
+	started := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("OK"))
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: mux}
+	quit := make(chan os.Signal, 1)
+	served := make(chan error, 1)
+	go func() {
+		served <- app.serve(srv, func() error { return srv.Serve(ln) }, quit)
+	}()
+
+	// A background task which is still running when shutdown begins.
+	var finished atomic.Bool
+	app.background(func() {
+		time.Sleep(300 * time.Millisecond)
+		finished.Store(true)
+	})
+
+	type response struct {
+		code int
+		body string
+		err  error
+	}
+	responses := make(chan response, 1)
+	go func() {
+		rs, err := http.Get("http://" + ln.Addr().String() + "/slow")
+		if err != nil {
+			responses <- response{err: err}
+			return
+		}
+		defer rs.Body.Close()
+		body, err := io.ReadAll(rs.Body)
+		responses <- response{rs.StatusCode, string(body), err}
+	}()
+
+	// Trigger the shutdown while the request is being handled.
+	<-started
+	quit <- syscall.SIGTERM
+
+	rs := <-responses
+	assert.NilError(t, rs.err)
+	assert.Equal(t, rs.code, http.StatusOK)
+	assert.Equal(t, rs.body, "OK")
+
+	select {
+	case err := <-served:
+		assert.NilError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve() did not return after shutdown")
+	}
+	assert.Equal(t, finished.Load(), true)
+
+	// The server should no longer accept connections.
+	_, err = http.Get("http://" + ln.Addr().String() + "/slow")
+	if err == nil {
+		t.Error("expected an error connecting after shutdown")
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strconv"
+)
+
+// sitemapNS is the XML namespace for the sitemaps.org protocol.
+const sitemapNS = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+// sitemapURL is a single <url> entry in a sitemap.
+type sitemapURL struct {
+	XMLName xml.Name `xml:"url"`
+	Loc     string   `xml:"loc"`
+	LastMod string   `xml:"lastmod,omitempty"`
+}
+
+// sitemap serves a sitemap.xml listing the home page, the about page and the
+// view page for every unexpired snippet. There could be a lot of snippets, so
+// rather than building the whole document in memory we stream each entry to
+// the response as we go. The downside is that once we've started writing, we
+// can't send an error response any more -- so errors after that point are
+// just logged.
+func (app *application) sitemap(w http.ResponseWriter, r *http.Request) {
+	refs, err := app.snippets.AllIDs(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+
+	enc := xml.NewEncoder(w)
+	urlset := xml.StartElement{
+		Name: xml.Name{Local: "urlset"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "xmlns"}, Value: sitemapNS}},
+	}
+	err = enc.EncodeToken(urlset)
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+		return
+	}
+
+	urls := []sitemapURL{
+		{Loc: app.baseURL + "/"},
+		{Loc: app.baseURL + "/about"},
+	}
+	for _, u := range urls {
+		if err := enc.Encode(u); err != nil {
+			app.logger.ErrorContext(r.Context(), err.Error())
+			return
+		}
+	}
+	for _, ref := range refs {
+		u := sitemapURL{
+			Loc:     app.baseURL + "/snippet/view/" + strconv.Itoa(ref.ID),
+			LastMod: ref.Created.UTC().Format("2006-01-02"),
+		}
+		if err := enc.Encode(u); err != nil {
+			app.logger.ErrorContext(r.Context(), err.Error())
+			return
+		}
+	}
+
+	err = enc.EncodeToken(urlset.End())
+	if err == nil {
+		err = enc.Flush()
+	}
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"testing"
+
+	"snippetbox/internal/assert"
+)
+
+func TestSitemap(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, header, body := ts.get(t, "/sitemap.xml")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, header.Get("Content-Type"), "application/xml; charset=utf-8")
+
+	var sitemap struct {
+		XMLName xml.Name `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+		URLs    []struct {
+			Loc     string `xml:"loc"`
+			LastMod string `xml:"lastmod"`
+		} `xml:"url"`
+	}
+	err := xml.Unmarshal([]byte(body), &sitemap)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The home and about pages, followed by the two snippets returned by the
+	// mock AllIDs(). The mock's deleted snippet (ID 4) must not be listed.
+	var locs []string
+	for _, u := range sitemap.URLs {
+		locs = append(locs, u.Loc)
+	}
+	want := []string{
+		"https://localhost:4000/",
+		"https://localhost:4000/about",
+		"https://localhost:4000/snippet/view/3",
+		"https://localhost:4000/snippet/view/1",
+	}
+	assert.Equal(t, len(locs), len(want))
+	for i := range want {
+		assert.Equal(t, locs[i], want[i])
+	}
+	assert.Equal(t, sitemap.URLs[0].LastMod, "")
+	assert.Equal(t, len(sitemap.URLs[2].LastMod), len("2006-01-02"))
+}
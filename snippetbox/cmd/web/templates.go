@@ -1,40 +1,198 @@
 package main
 
 import (
+	"fmt"
 	"html/template"
 	"io/fs"
 	"path/filepath"
+	"snippetbox/internal/i18n"
 	"snippetbox/internal/models"
 	"snippetbox/ui"
+	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 // Include a Snippets field in the templateData struct.
 type templateData struct {
-	Snippet         *models.Snippet
-	Snippets        []*models.Snippet
-	CurrentYear     int
-	Form            any
-	Flash           string
-	IsAuthenticated bool
-	CSRFToken       string
-	User            *models.User
+	Snippet             *models.Snippet
+	Snippets            []*models.Snippet
+	Tag                 string
+	Tags                []string
+	Comments            []*models.Comment
+	CurrentYear         int
+	Form                any
+	Flashes             []flash
+	IsAuthenticated     bool
+	AuthenticatedUserID int
+	CSRFToken           string
+	User                *models.User
+	Activated           bool
+	IsFavourite         bool
+	Sessions            []*models.Session
+	CurrentSession      string
+	Users               []*models.User
+	Reports             []*models.Report
+	Notifications       []*models.Notification
+	AuditEvents         []*models.AuditEvent
+	UserCount           int
+	SnippetCount        int
+	Maintenance         bool
+	Pagination          *Pagination
+	RecoveryCodes       []string
+	Localizer           *i18n.Localizer
+	// UnreadNotifications is the number of unread notifications which the
+	// logged-in user has, for the indicator in the navigation bar.
+	UnreadNotifications int
+	// ExpiringSnippets are the user's snippets which will expire soon.
+	ExpiringSnippets []*models.Snippet
+	// Scheduled is true when the owner of a scheduled snippet views it before
+	// it has been published.
+	Scheduled bool
+	// OAuthProviders maps the name of each OAuth provider which users can log
+	// in with to its title. Templates range over maps in key order.
+	OAuthProviders map[string]string
+	// Theme is the UI theme which the current user has chosen, or
+	// models.ThemeSystem if they haven't chosen one.
+	Theme string
+	// Revisions are the earlier versions of a snippet, for its history page.
+	Revisions []*models.SnippetRevision
+	// Diff is the difference between two revisions of a snippet.
+	Diff *revisionDiff
+	// Sort is the order which the snippets on the home page are shown in.
+	Sort string
 }
 
-func humanDate(t time.Time) string {
+// humanDate formats a time in the given location, which is normally the time
+// zone chosen by the current user. Go's time zone database takes care of
+// daylight saving time, so a time in July shows as 13:15 in Europe/London
+// while the same UTC time in January shows as 12:15.
+func humanDate(t time.Time, loc *time.Location) string {
 	// Return the empty string if time has the zero value.
 	if t.IsZero() {
 		return ""
 	}
-	// Convert the time to UTC before formatting it.
-	return t.UTC().Format("02 Jan 2006 at 15:04")
+	return t.In(loc).Format("02 Jan 2006 at 15:04")
 }
 
+// relativeTime describes how long before or after now the time t is, like
+// "3 minutes ago" or "in 2 days". Anything within a minute of now is "just
+// now". Each unit is rounded down, so 119 seconds ago is "1 minute ago". A
+// month is counted as 30 days and a year as 365, which is close enough when
+// all we want is a rough idea.
+func relativeTime(t, now time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	d := now.Sub(t)
+	future := d < 0
+	if future {
+		d = -d
+	}
+
+	const day = 24 * time.Hour
+	var n int
+	var unit string
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		n, unit = int(d/time.Minute), "minute"
+	case d < day:
+		n, unit = int(d/time.Hour), "hour"
+	case d < 30*day:
+		n, unit = int(d/day), "day"
+	case d < 365*day:
+		n, unit = int(d/(30*day)), "month"
+	default:
+		n, unit = int(d/(365*day)), "year"
+	}
+	if n != 1 {
+		unit += "s"
+	}
+	if future {
+		return fmt.Sprintf("in %d %s", n, unit)
+	}
+	return fmt.Sprintf("%d %s ago", n, unit)
+}
+
+// charCount returns the number of characters in s. It counts runes rather than
+// bytes, so that "café" is four characters long rather than five. Browsers
+// submit line breaks in textareas as "\r\n", which we count as one character.
+func charCount(s string) int {
+	return utf8.RuneCountInString(s) - strings.Count(s, "\r\n")
+}
+
+// lineCount returns the number of lines in s. A trailing line break doesn't
+// start a new line, and an empty string has no lines at all.
+func lineCount(s string) int {
+	if s == "" {
+		return 0
+	}
+	return strings.Count(strings.TrimSuffix(s, "\n"), "\n") + 1
+}
+
+// timezones are the time zones offered on the account page. Any name which
+// time.LoadLocation() accepts is allowed, but listing every zone in the IANA
+// database would make for an unusable dropdown.
+var timezones = []string{
+	"UTC",
+	"America/Los_Angeles",
+	"America/Denver",
+	"America/Chicago",
+	"America/New_York",
+	"America/Sao_Paulo",
+	"Europe/London",
+	"Europe/Paris",
+	"Europe/Berlin",
+	"Europe/Madrid",
+	"Europe/Athens",
+	"Europe/Moscow",
+	"Africa/Lagos",
+	"Africa/Johannesburg",
+	"Asia/Dubai",
+	"Asia/Kolkata",
+	"Asia/Shanghai",
+	"Asia/Singapore",
+	"Asia/Tokyo",
+	"Australia/Sydney",
+	"Pacific/Auckland",
+}
+
+// themes are the UI themes offered on the account page and in the footer.
+var themes = []string{models.ThemeSystem, models.ThemeLight, models.ThemeDark}
+
 // Initialize a template.FuncMap object and store it in a global variable. This is
 // essentially a string-keyed map which acts as a lookup between the names of our
 // custom template functions and the functions themselves.
 var functions = template.FuncMap{
-	"humanDate": humanDate,
+	// Like nonce, humanDate is replaced in render() with a function which
+	// formats times in the current user's time zone. This placeholder uses
+	// UTC, which is what anonymous users see.
+	"humanDate": func(t time.Time) string { return humanDate(t, time.UTC) },
+	"timezones": func() []string { return timezones },
+	"themes":    func() []string { return themes },
+	"orders":    func() []string { return models.SnippetOrders },
+	"highlight": highlight,
+	"charCount": charCount,
+	"lineCount": lineCount,
+	// The timeSince function describes a time relative to now, like
+	// {{timeSince .Created}} for "3 minutes ago".
+	"timeSince": func(t time.Time) string { return relativeTime(t, time.Now()) },
+	"languages": func() []string { return languages },
+	// The translate function returns the message with the given key in the
+	// given language, which is normally the language of the request's
+	// localizer, like {{translate "nav.home" .Localizer.Lang}}.
+	"translate": func(key, lang string) string { return i18n.Translate(lang, key) },
+	"locales":   func() []string { return i18n.Languages },
+	// The fingerprint function adds a hash of a static file's contents to
+	// its URL, like {{fingerprint "/static/css/main.css"}}.
+	"fingerprint": fingerprint,
+	// The nonce function returns the Content-Security-Policy nonce for the
+	// current request. It has to be registered here so that templates which
+	// use it can be parsed, but render() replaces it with a function which
+	// returns the real nonce before executing the template.
+	"nonce": func() string { return "" },
 }
 
 func newTemplateCache() (map[string]*template.Template, error) {
@@ -50,9 +208,11 @@ func newTemplateCache() (map[string]*template.Template, error) {
 	for _, page := range pages {
 		name := filepath.Base(page)
 		// Create a slice containing the filepath patterns for the templates we
-		// want to parse.
+		// want to parse. Every page is parsed with every layout, so any page
+		// can be rendered in any layout. Each layout defines a template named
+		// after itself, like "base" or "embed", which render() executes.
 		patterns := []string{
-			"html/base.html",
+			"html/layouts/*.html",
 			"html/partials/*.html",
 			page,
 		}
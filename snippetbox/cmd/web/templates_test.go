@@ -2,34 +2,90 @@ package main
 
 import (
 	"snippetbox/internal/assert"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/alecthomas/chroma/v2/lexers"
 )
 
 func TestHumanDate(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	// Create a slice of anonymous structs containing the test case name,
-	// input to our humanDate() function (the tm field), and expected output
-	// (the want field).
+	// input to our humanDate() function (the tm and loc fields), and expected
+	// output (the want field).
 	tests := []struct {
 		name string
 		tm   time.Time
+		loc  *time.Location
 		want string
 	}{
 		{
 			name: "UTC",
 			tm:   time.Date(2022, 3, 17, 10, 15, 0, 0, time.UTC),
+			loc:  time.UTC,
 			want: "17 Mar 2022 at 10:15",
 		},
 		{
 			name: "Empty",
 			tm:   time.Time{},
+			loc:  london,
 			want: "",
 		},
 		{
 			name: "CET",
 			tm:   time.Date(2022, 3, 17, 10, 15, 0, 0, time.FixedZone("CET", 1*60*60)),
+			loc:  time.UTC,
 			want: "17 Mar 2022 at 09:15",
 		},
+		{
+			name: "London winter",
+			tm:   time.Date(2022, 1, 17, 10, 15, 0, 0, time.UTC),
+			loc:  london,
+			want: "17 Jan 2022 at 10:15",
+		},
+		{
+			name: "London summer",
+			tm:   time.Date(2022, 7, 17, 10, 15, 0, 0, time.UTC),
+			loc:  london,
+			want: "17 Jul 2022 at 11:15",
+		},
+		{
+			// The clocks in New York went forward an hour at 2am local time
+			// (07:00 UTC) on 13 March 2022, so there was no 02:30 that day.
+			name: "New York before DST",
+			tm:   time.Date(2022, 3, 13, 6, 59, 0, 0, time.UTC),
+			loc:  newYork,
+			want: "13 Mar 2022 at 01:59",
+		},
+		{
+			name: "New York after DST",
+			tm:   time.Date(2022, 3, 13, 7, 0, 0, 0, time.UTC),
+			loc:  newYork,
+			want: "13 Mar 2022 at 03:00",
+		},
+		{
+			// And they went back an hour at 2am local time (06:00 UTC) on 6
+			// November 2022, so 01:30 happened twice.
+			name: "New York end of DST second time",
+			tm:   time.Date(2022, 11, 6, 6, 30, 0, 0, time.UTC),
+			loc:  newYork,
+			want: "06 Nov 2022 at 01:30",
+		},
+		{
+			name: "New York end of DST first time",
+			tm:   time.Date(2022, 11, 6, 5, 30, 0, 0, time.UTC),
+			loc:  newYork,
+			want: "06 Nov 2022 at 01:30",
+		},
 	}
 	// Loop over the test cases.
 	for _, tt := range tests {
@@ -38,10 +94,122 @@ func TestHumanDate(t *testing.T) {
 		// identify the sub-test in any log output) and the second parameter is
 		// and anonymous function containing the actual test for each case.
 		t.Run(tt.name, func(t *testing.T) {
-			hd := humanDate(tt.tm)
+			hd := humanDate(tt.tm, tt.loc)
 			// Use the new assert.Equal() helper to compare the expected and
 			// actual values.
 			assert.Equal(t, hd, tt.want)
 		})
 	}
 }
+
+func TestRelativeTime(t *testing.T) {
+	now := time.Date(2024, 3, 17, 10, 15, 0, 0, time.UTC)
+	const day = 24 * time.Hour
+
+	tests := []struct {
+		name string
+		d    time.Duration
+		want string
+	}{
+		{"Now", 0, "just now"},
+		{"59 seconds ago", -59 * time.Second, "just now"},
+		{"In 59 seconds", 59 * time.Second, "just now"},
+		{"One minute ago", -time.Minute, "1 minute ago"},
+		{"Rounds down", -119 * time.Second, "1 minute ago"},
+		{"Two minutes ago", -2 * time.Minute, "2 minutes ago"},
+		{"59 minutes ago", -59*time.Minute - 59*time.Second, "59 minutes ago"},
+		{"One hour ago", -time.Hour, "1 hour ago"},
+		{"23 hours ago", -23*time.Hour - 59*time.Minute, "23 hours ago"},
+		{"One day ago", -day, "1 day ago"},
+		{"29 days ago", -29 * day, "29 days ago"},
+		{"One month ago", -30 * day, "1 month ago"},
+		{"364 days ago", -364 * day, "12 months ago"},
+		{"One year ago", -365 * day, "1 year ago"},
+		{"Three years ago", -3 * 365 * day, "3 years ago"},
+		{"In one minute", time.Minute, "in 1 minute"},
+		{"In three hours", 3 * time.Hour, "in 3 hours"},
+		{"In one day", day, "in 1 day"},
+		{"In seven days", 7 * day, "in 7 days"},
+		{"In two years", 2 * 365 * day, "in 2 years"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, relativeTime(now.Add(tt.d), now), tt.want)
+		})
+	}
+
+	t.Run("Zero time", func(t *testing.T) {
+		assert.Equal(t, relativeTime(time.Time{}, now), "")
+	})
+}
+
+func TestCounts(t *testing.T) {
+	tests := []struct {
+		name      string
+		s         string
+		wantChars int
+		wantLines int
+	}{
+		{"Empty", "", 0, 0},
+		{"ASCII", "An old silent pond...", 21, 1},
+		{"Multibyte", "古池や 蛙飛び込む 水の音", 13, 1},
+		{"Accents", "café\nnaïve", 10, 2},
+		{"Emoji", "🐸🐸", 2, 1},
+		{"Trailing newline", "one\ntwo\n", 8, 2},
+		{"Blank lines", "one\n\n\ntwo", 9, 4},
+		{"CRLF", "one\r\ntwo", 7, 2},
+		{"Only a newline", "\n", 1, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, charCount(tt.s), tt.wantChars)
+			assert.Equal(t, lineCount(tt.s), tt.wantLines)
+		})
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	code := `func main() { fmt.Println("<b>&</b>") }`
+
+	// Every language in the dropdown must be known to chroma. The spans
+	// depend on the lexer (YAML sees this as one plain string, for example),
+	// but the output must always be escaped.
+	t.Run("Known languages", func(t *testing.T) {
+		for _, language := range languages {
+			if lexers.Get(language) == nil {
+				t.Errorf("%s: no chroma lexer", language)
+			}
+			got := string(highlight(code, language))
+			if strings.Contains(got, "<b>") {
+				t.Errorf("%s: code was not escaped: %q", language, got)
+			}
+		}
+	})
+
+	t.Run("Go", func(t *testing.T) {
+		got := string(highlight(code, "go"))
+		assert.StringContains(t, got, ">func</span>")
+		assert.StringContains(t, got, "&lt;b&gt;&amp;&lt;/b&gt;")
+		if strings.Contains(got, "<pre") {
+			t.Error("output includes a <pre> element")
+		}
+	})
+
+	tests := []struct {
+		name     string
+		language string
+	}{
+		{"Plain text", ""},
+		{"Unknown language", "klingon"},
+		{"Markup as language", "<script>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(highlight(code, tt.language))
+			assert.Equal(t, got, `func main() { fmt.Println(&#34;&lt;b&gt;&amp;&lt;/b&gt;&#34;) }`)
+		})
+	}
+}
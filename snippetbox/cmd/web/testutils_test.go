@@ -4,24 +4,27 @@ import (
 	"bytes"
 	"html"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/http/cookiejar"
 	"net/http/httptest"
 	"net/url"
 	"regexp"
+	mailermocks "snippetbox/internal/mailer/mocks"
+	"snippetbox/internal/models"
 	"snippetbox/internal/models/mocks"
 	"testing"
 	"time"
 
 	"github.com/alexedwards/scs/v2"
-	"github.com/go-playground/form/v4"
 )
 
 // Define a regular expression which captures the CSRF token value from the
 // HTML for our user signup page.
 var csrfTokenRX = regexp.MustCompile(`<input type='hidden' name='csrf_token' value='(.+)'>`)
 
+var createTokenRX = regexp.MustCompile(`<input type='hidden' name='createToken' value='(.+)'>`)
+
 func extractCSRFToken(t *testing.T, body string) string {
 	// Use the FindStringSubmatch method to extract the token from the HTML body.
 	// Note that this returns an array with the entire matched pattern in the
@@ -41,7 +44,7 @@ func newTestApplication(t *testing.T) *application {
 		t.Fatal(err)
 	}
 	// And a form decoder.
-	formDecoder := form.NewDecoder()
+	formDecoder := newFormDecoder()
 	// And a session manager instance. Note that we use the same settings as
 	// production, except that we *don't* set a Store for the session manager.
 	// If no store is set, the SCS package will default to using a transient
@@ -49,14 +52,30 @@ func newTestApplication(t *testing.T) *application {
 	sessionManager := scs.New()
 	sessionManager.Lifetime = 12 * time.Hour
 	sessionManager.Cookie.Secure = true
+	sessionManager.Cookie.Persist = false
 	return &application{
-		errorLog:       log.New(io.Discard, "", 0),
-		infoLog:        log.New(io.Discard, "", 0),
-		snippets:       &mocks.SnippetModel{}, // Use the mock.
-		users:          &mocks.UserModel{},    // Use the mock.
+		logger:         slog.New(slog.NewTextHandler(io.Discard, nil)),
+		snippets:       &mocks.SnippetModel{},      // Use the mock.
+		users:          &mocks.UserModel{},         // Use the mock.
+		sessions:       &mocks.SessionModel{},      // Use the mock.
+		comments:       &mocks.CommentModel{},      // Use the mock.
+		reports:        &mocks.ReportModel{},       // Use the mock.
+		notifications:  &mocks.NotificationModel{}, // Use the mock.
+		audit:          &mocks.AuditModel{},        // Use the mock.
 		templateCache:  templateCache,
 		formDecoder:    formDecoder,
 		sessionManager: sessionManager,
+		mailer:         &mailermocks.Mailer{}, // Use the mock.
+		loginLimiter:   newLoginLimiter(5, 15*time.Minute),
+		createClaims:   newCreateClaims(time.Hour),
+		baseURL:        "https://localhost:4000",
+		maxBodyBytes:   1_048_576,
+		requestTimeout: 5 * time.Second,
+		avatarDir:      t.TempDir(),
+		avatarMaxBytes: 524_288,
+		snippetLimits:  snippetLimits{titleMax: 100, contentMax: 10_000, maxPins: 5},
+		robots:         robotsConfig{allow: pathList{"/user/profile/"}, disallow: pathList{"/account/", "/user/"}},
+		homePage:       homeConfig{perPage: homeSnippetsPerPage, sort: models.OrderNewest},
 	}
 }
 
@@ -122,3 +141,62 @@ func (ts *testServer) postForm(t *testing.T, urlPath string, form url.Values) (i
 	// Return the response status, headers and body.
 	return rs.StatusCode, rs.Header, string(body)
 }
+
+// The do() method sends a request with an arbitrary method, body and headers
+// to the test server. We use it for the JSON API, where we need to set the
+// Content-Type and Authorization headers ourselves.
+func (ts *testServer) do(t *testing.T, method, urlPath string, body io.Reader, header http.Header) (int, http.Header, string) {
+	req, err := http.NewRequest(method, ts.URL+urlPath, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	rs, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Body.Close()
+	b, err := io.ReadAll(rs.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rs.StatusCode, rs.Header, string(bytes.TrimSpace(b))
+}
+
+// The login() method logs in as Alice, a normal (non-admin) user known to the
+// mock UserModel, so that subsequent requests made with the test server client
+// are authenticated. It returns a valid CSRF token for use in any later POST
+// requests.
+func (ts *testServer) login(t *testing.T) string {
+	return ts.loginAs(t, "alice@example.com")
+}
+
+// The loginAs() method logs in as the mock user with the given email address,
+// in the same way as login().
+func (ts *testServer) loginAs(t *testing.T, email string) string {
+	_, _, body := ts.get(t, "/user/login")
+	validCSRFToken := extractCSRFToken(t, body)
+
+	form := url.Values{}
+	form.Add("email", email)
+	form.Add("password", "pa$$word")
+	form.Add("csrf_token", validCSRFToken)
+	ts.postForm(t, "/user/login", form)
+
+	// The session token is renewed on login, so fetch a fresh CSRF token.
+	_, _, body = ts.get(t, "/snippet/create")
+	return extractCSRFToken(t, body)
+}
+
+// The createToken() method fetches the create snippet form and returns the
+// one-time token from it, which must be submitted along with the form.
+func (ts *testServer) createToken(t *testing.T) string {
+	_, _, body := ts.get(t, "/snippet/create")
+	matches := createTokenRX.FindStringSubmatch(body)
+	if len(matches) < 2 {
+		t.Fatal("no create token found in body")
+	}
+	return html.UnescapeString(matches[1])
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"image/png"
+	"net/http"
+	"snippetbox/internal/models"
+	"snippetbox/internal/validator"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+// pending2FALifetime is how long a user has to enter their two-factor
+// authentication code after entering their password.
+const pending2FALifetime = 5 * time.Minute
+
+// totpIssuer is the name shown for our accounts in authenticator apps.
+const totpIssuer = "Snippetbox"
+
+// The completeLogin() helper finishes logging in a user once their password
+// (or their account at an OAuth provider) has been checked. If they've turned
+// on two-factor authentication then they still need to enter a code from
+// their authenticator app, so instead of logging them in we store their ID in
+// the session as "pending2FA" and ask them for one.
+func (app *application) completeLogin(w http.ResponseWriter, r *http.Request, user *models.User, rememberMe bool) {
+	if user.TOTPEnabled {
+		app.sessionManager.Put(r.Context(), "pending2FA", user.ID)
+		app.sessionManager.Put(r.Context(), "pending2FARememberMe", rememberMe)
+		app.sessionManager.Put(r.Context(), "pending2FAExpiry", time.Now().Add(pending2FALifetime).Unix())
+		http.Redirect(w, r, "/user/login/2fa", http.StatusSeeOther)
+		return
+	}
+	err := app.logIn(r, user, rememberMe)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.redirectAfterLogin(w, r)
+}
+
+// The pending2FA() helper returns the ID of the user who is part-way through
+// logging in, or 0 if there isn't one (or they took too long).
+func (app *application) pending2FA(r *http.Request) int {
+	if time.Now().Unix() > app.sessionManager.GetInt64(r.Context(), "pending2FAExpiry") {
+		return 0
+	}
+	return app.sessionManager.GetInt(r.Context(), "pending2FA")
+}
+
+// The checkSecondFactor() helper reports whether code is either the current
+// TOTP code for a user, or one of their unused recovery codes. A recovery code
+// can only be used once, so it's deleted if it matches.
+func (app *application) checkSecondFactor(ctx context.Context, userID int, code string) (bool, error) {
+	secret, err := app.users.TOTPSecret(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	if secret != "" && totp.Validate(strings.ReplaceAll(code, " ", ""), secret) {
+		return true, nil
+	}
+	err = app.users.UseRecoveryCode(ctx, userID, code)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+type totpForm struct {
+	Code string `form:"code"`
+	// When setting up two-factor authentication we also show the secret (for
+	// typing in by hand), along with a QR code of the otpauth:// URL for
+	// scanning. These aren't part of the submitted form.
+	Secret              string       `form:"-"`
+	QRCode              template.URL `form:"-"`
+	validator.Validator `form:"-"`
+}
+
+func (app *application) userLogin2FA(w http.ResponseWriter, r *http.Request) {
+	if app.pending2FA(r) == 0 {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+	data := app.newTemplateData(r)
+	data.Form = totpForm{}
+	app.render(w, r, http.StatusOK, "login_2fa.html", data)
+}
+
+// userLogin2FAPost is the second step of logging in for users who have turned
+// on two-factor authentication. Failed attempts are counted by the same limiter
+// as passwords, as otherwise a six-digit code could be brute forced.
+func (app *application) userLogin2FAPost(w http.ResponseWriter, r *http.Request) {
+	id := app.pending2FA(r)
+	if id == 0 {
+		app.addFlash(r, flashError, "Your login attempt has expired. Please try again.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+	var form totpForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	form.CheckField(validator.NotBlank(form.Code), "code", "This field cannot be blank")
+	limiterKey := "2fa:" + strconv.Itoa(id)
+	if form.Valid() && app.loginLimiter.blocked(limiterKey) {
+		form.AddNonFieldError("Too many failed attempts. Please try again later.")
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusTooManyRequests, "login_2fa.html", data)
+		return
+	}
+	if form.Valid() {
+		ok, err := app.checkSecondFactor(r.Context(), id, form.Code)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		if !ok {
+			app.loginLimiter.fail(limiterKey)
+			form.AddFieldError("code", "Invalid authentication code")
+		}
+	}
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "login_2fa.html", data)
+		return
+	}
+	app.loginLimiter.reset(limiterKey)
+
+	user, err := app.users.Get(r.Context(), id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	rememberMe := app.sessionManager.GetBool(r.Context(), "pending2FARememberMe")
+	app.sessionManager.Remove(r.Context(), "pending2FA")
+	app.sessionManager.Remove(r.Context(), "pending2FARememberMe")
+	app.sessionManager.Remove(r.Context(), "pending2FAExpiry")
+	err = app.logIn(r, user, rememberMe)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.redirectAfterLogin(w, r)
+}
+
+// The totpSetupForm() helper returns the form for turning on two-factor
+// authentication. The new TOTP key is kept in the user's session until they've
+// proved that their authenticator app works by entering a code from it, so
+// that reloading the page doesn't give them a different QR code to scan.
+func (app *application) totpSetupForm(r *http.Request, user *models.User) (totpForm, error) {
+	var key *otp.Key
+	var err error
+	if u := app.sessionManager.GetString(r.Context(), "totpSetupURL"); u != "" {
+		key, err = otp.NewKeyFromURL(u)
+	} else {
+		key, err = totp.Generate(totp.GenerateOpts{Issuer: totpIssuer, AccountName: user.Email})
+		if err == nil {
+			app.sessionManager.Put(r.Context(), "totpSetupURL", key.URL())
+		}
+	}
+	if err != nil {
+		return totpForm{}, err
+	}
+	img, err := key.Image(200, 200)
+	if err != nil {
+		return totpForm{}, err
+	}
+	var buf bytes.Buffer
+	err = png.Encode(&buf, img)
+	if err != nil {
+		return totpForm{}, err
+	}
+	return totpForm{
+		Secret: key.Secret(),
+		QRCode: template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())),
+	}, nil
+}
+
+func (app *application) accountTOTP(w http.ResponseWriter, r *http.Request) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	user, err := app.users.Get(r.Context(), userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	form := totpForm{}
+	if !user.TOTPEnabled {
+		form, err = app.totpSetupForm(r, user)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+	data := app.newTemplateData(r)
+	data.User = user
+	data.Form = form
+	app.render(w, r, http.StatusOK, "account_2fa.html", data)
+}
+
+// accountTOTPEnablePost turns on two-factor authentication, once the user has
+// entered a valid code for the key in their session. Their recovery codes are
+// shown straight away rather than after a redirect, as this is the only time
+// that we'll ever have them in plain text.
+func (app *application) accountTOTPEnablePost(w http.ResponseWriter, r *http.Request) {
+	var form totpForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	user, err := app.users.Get(r.Context(), userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	// If there's no key in the session then the user has already turned on
+	// two-factor authentication (in another tab, say), or their session has
+	// been renewed since they loaded the page.
+	setupURL := app.sessionManager.GetString(r.Context(), "totpSetupURL")
+	if setupURL == "" || user.TOTPEnabled {
+		http.Redirect(w, r, "/account/2fa", http.StatusSeeOther)
+		return
+	}
+	key, err := otp.NewKeyFromURL(setupURL)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	form.CheckField(validator.NotBlank(form.Code), "code", "This field cannot be blank")
+	if form.Valid() && !totp.Validate(strings.ReplaceAll(form.Code, " ", ""), key.Secret()) {
+		form.AddFieldError("code", "Invalid authentication code")
+	}
+	if !form.Valid() {
+		setup, err := app.totpSetupForm(r, user)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		form.Secret, form.QRCode = setup.Secret, setup.QRCode
+		data := app.newTemplateData(r)
+		data.User = user
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "account_2fa.html", data)
+		return
+	}
+
+	codes, err := app.users.EnableTOTP(r.Context(), userID, key.Secret())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.sessionManager.Remove(r.Context(), "totpSetupURL")
+	user.TOTPEnabled = true
+	data := app.newTemplateData(r)
+	data.User = user
+	data.Form = totpForm{}
+	data.RecoveryCodes = codes
+	app.render(w, r, http.StatusOK, "account_2fa.html", data)
+}
+
+// accountTOTPDisablePost turns off two-factor authentication. The user has to
+// enter a current code (or a recovery code) to do this, so that someone who
+// gets hold of a logged-in session can't turn it off.
+func (app *application) accountTOTPDisablePost(w http.ResponseWriter, r *http.Request) {
+	var form totpForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	form.CheckField(validator.NotBlank(form.Code), "code", "This field cannot be blank")
+	if form.Valid() {
+		ok, err := app.checkSecondFactor(r.Context(), userID, form.Code)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		if !ok {
+			form.AddFieldError("code", "Invalid authentication code")
+		}
+	}
+	if !form.Valid() {
+		user, err := app.users.Get(r.Context(), userID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		data := app.newTemplateData(r)
+		data.User = user
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "account_2fa.html", data)
+		return
+	}
+	err = app.users.DisableTOTP(r.Context(), userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.addFlash(r, flashSuccess, "Two-factor authentication has been turned off.")
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"snippetbox/internal/assert"
+	"snippetbox/internal/models/mocks"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+)
+
+// The startLogin() method enters the mock 2FA user's email address and
+// password, leaving them waiting to enter a code. It returns a CSRF token for
+// the code form.
+func (ts *testServer) startLogin(t *testing.T) string {
+	_, _, body := ts.get(t, "/user/login")
+	form := url.Values{}
+	form.Add("email", "dave@example.com")
+	form.Add("password", "pa$$word")
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	code, header, _ := ts.postForm(t, "/user/login", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login/2fa")
+
+	code, _, body = ts.get(t, "/user/login/2fa")
+	assert.Equal(t, code, http.StatusOK)
+	return extractCSRFToken(t, body)
+}
+
+// The validCode() helper returns the current TOTP code for the mock 2FA user.
+func validCode(t *testing.T) string {
+	code, err := totp.GenerateCode(mocks.MockTOTPSecret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestUserLogin2FA(t *testing.T) {
+	// A code from ten minutes ago is well outside the one-step window of
+	// clock skew which totp.Validate() allows.
+	expiredCode, err := totp.GenerateCode(mocks.MockTOTPSecret, time.Now().Add(-10*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := validCode(t)
+
+	tests := []struct {
+		name     string
+		code     string
+		wantCode int
+		wantBody string
+	}{
+		{"Valid code", valid, http.StatusSeeOther, ""},
+		{"Valid code with spaces", valid[:3] + " " + valid[3:], http.StatusSeeOther, ""},
+		{"Recovery code", mocks.MockRecoveryCode, http.StatusSeeOther, ""},
+		{"Expired code", expiredCode, http.StatusUnprocessableEntity, "Invalid authentication code"},
+		{"Invalid code", "abcdef", http.StatusUnprocessableEntity, "Invalid authentication code"},
+		{"Blank code", "", http.StatusUnprocessableEntity, "This field cannot be blank"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			csrfToken := ts.startLogin(t)
+			// The password alone isn't enough to log in. Trying to view the
+			// account page also makes it the page which the user is sent to
+			// once they've logged in.
+			code, _, _ := ts.get(t, "/account/view")
+			assert.Equal(t, code, http.StatusSeeOther)
+
+			form := url.Values{}
+			form.Add("code", tt.code)
+			form.Add("csrf_token", csrfToken)
+			code, header, body := ts.postForm(t, "/user/login/2fa", form)
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+
+			code, _, _ = ts.get(t, "/account/view")
+			if tt.wantCode == http.StatusSeeOther {
+				assert.Equal(t, header.Get("Location"), "/account/view")
+				assert.Equal(t, code, http.StatusOK)
+			} else {
+				assert.Equal(t, code, http.StatusSeeOther)
+			}
+		})
+	}
+
+	t.Run("No pending login", func(t *testing.T) {
+		app := newTestApplication(t)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		code, header, body := ts.get(t, "/user/login/2fa")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/login")
+
+		_, _, body = ts.get(t, "/user/login")
+		form := url.Values{}
+		form.Add("code", validCode(t))
+		form.Add("csrf_token", extractCSRFToken(t, body))
+		code, header, _ = ts.postForm(t, "/user/login/2fa", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/login")
+	})
+
+	t.Run("Too many attempts", func(t *testing.T) {
+		app := newTestApplication(t)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		csrfToken := ts.startLogin(t)
+		form := url.Values{}
+		form.Add("code", "abcdef")
+		form.Add("csrf_token", csrfToken)
+		for range 5 {
+			code, _, _ := ts.postForm(t, "/user/login/2fa", form)
+			assert.Equal(t, code, http.StatusUnprocessableEntity)
+		}
+		// Even the right code is refused now.
+		form.Set("code", validCode(t))
+		code, _, body := ts.postForm(t, "/user/login/2fa", form)
+		assert.Equal(t, code, http.StatusTooManyRequests)
+		assert.StringContains(t, body, "Too many failed attempts")
+	})
+}
+
+var totpSecretRX = regexp.MustCompile(`Enter this key instead: <code>([A-Z2-7]+)</code>`)
+
+func TestAccountTOTP(t *testing.T) {
+	t.Run("Enable", func(t *testing.T) {
+		app := newTestApplication(t)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		csrfToken := ts.login(t)
+		code, _, body := ts.get(t, "/account/2fa")
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "<img src='data:image/png;base64,")
+		matches := totpSecretRX.FindStringSubmatch(body)
+		if len(matches) < 2 {
+			t.Fatal("no TOTP secret in the page")
+		}
+		secret := matches[1]
+
+		// Reloading the page shows the same key.
+		_, _, body = ts.get(t, "/account/2fa")
+		assert.StringContains(t, body, "<code>"+secret+"</code>")
+
+		form := url.Values{}
+		form.Add("code", "abcdef")
+		form.Add("csrf_token", csrfToken)
+		code, _, body = ts.postForm(t, "/account/2fa/enable", form)
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+		assert.StringContains(t, body, "Invalid authentication code")
+		assert.StringContains(t, body, "<code>"+secret+"</code>")
+
+		totpCode, err := totp.GenerateCode(secret, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		form.Set("code", totpCode)
+		code, _, body = ts.postForm(t, "/account/2fa/enable", form)
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "<li><code>"+mocks.MockRecoveryCode+"</code></li>")
+
+		// The key has been used up, so submitting the form again does
+		// nothing.
+		code, header, _ := ts.postForm(t, "/account/2fa/enable", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/account/2fa")
+	})
+
+	t.Run("Disable", func(t *testing.T) {
+		app := newTestApplication(t)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		form := url.Values{}
+		form.Add("code", validCode(t))
+		form.Add("csrf_token", ts.startLogin(t))
+		ts.postForm(t, "/user/login/2fa", form)
+
+		_, _, body := ts.get(t, "/account/2fa")
+		assert.StringContains(t, body, "Two-factor authentication is on.")
+		if strings.Contains(body, "<img") {
+			t.Error("got a QR code for a user who has already turned on 2FA")
+		}
+		form.Set("csrf_token", extractCSRFToken(t, body))
+
+		form.Set("code", "abcdef")
+		code, _, body := ts.postForm(t, "/account/2fa/disable", form)
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+		assert.StringContains(t, body, "Invalid authentication code")
+
+		form.Set("code", validCode(t))
+		code, header, _ := ts.postForm(t, "/account/2fa/disable", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/account/view")
+	})
+}
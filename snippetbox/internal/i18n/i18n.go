@@ -0,0 +1,126 @@
+// Package i18n holds the translations of the user interface strings, and
+// picks the best language for each request.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// Embed the message catalogs so that they are compiled into the binary. There
+// is one JSON file per language, named after its BCP 47 tag (like "fr.json"),
+// each holding a flat object which maps message keys to translated strings.
+//
+//go:embed "locales"
+var localeFS embed.FS
+
+// DefaultLanguage is the language used when none of a user's preferences are
+// supported, and for any message which is missing from another catalog.
+const DefaultLanguage = "en"
+
+var (
+	// catalogs maps each supported language to its messages.
+	catalogs map[string]map[string]string
+	// Languages is the list of supported languages, with the default first.
+	Languages []string
+	// matcher picks the best supported language for a list of preferences.
+	matcher language.Matcher
+)
+
+func init() {
+	var err error
+	catalogs, err = loadCatalogs(localeFS, "locales")
+	if err != nil {
+		panic(err)
+	}
+	// The matcher treats the first tag as the default, so make sure that the
+	// default language comes first. The rest are sorted so that the order is
+	// the same every time.
+	Languages = []string{DefaultLanguage}
+	for lang := range catalogs {
+		if lang != DefaultLanguage {
+			Languages = append(Languages, lang)
+		}
+	}
+	slices.Sort(Languages[1:])
+	tags := make([]language.Tag, len(Languages))
+	for i, lang := range Languages {
+		tags[i] = language.MustParse(lang)
+	}
+	matcher = language.NewMatcher(tags)
+}
+
+// The loadCatalogs() function reads every JSON file in the given directory of
+// fsys into a map of catalogs, keyed by language.
+func loadCatalogs(fsys fs.FS, dir string) (map[string]map[string]string, error) {
+	files, err := fs.Glob(fsys, path.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]map[string]string)
+	for _, file := range files {
+		b, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+		var messages map[string]string
+		err = json.Unmarshal(b, &messages)
+		if err != nil {
+			return nil, fmt.Errorf("i18n: %s: %w", file, err)
+		}
+		result[strings.TrimSuffix(path.Base(file), ".json")] = messages
+	}
+	if _, ok := result[DefaultLanguage]; !ok {
+		return nil, fmt.Errorf("i18n: no catalog for the default language %q", DefaultLanguage)
+	}
+	return result, nil
+}
+
+// Match() returns the supported language which best matches the given
+// preferences, which are tried in order. Each preference can be a single
+// language tag (like "fr") or a whole Accept-Language header value (like
+// "fr-CH, fr;q=0.9, en;q=0.8"). Empty or invalid preferences are skipped, and
+// DefaultLanguage is returned if nothing matches.
+func Match(preferences ...string) string {
+	_, index := language.MatchStrings(matcher, preferences...)
+	return Languages[index]
+}
+
+// Translate() returns the message with the given key in the given language. If
+// the language isn't supported, or its catalog doesn't have the message, then
+// the message in DefaultLanguage is used instead. If that's missing too, the
+// key itself is returned, so that a missing translation is obvious on the page
+// without breaking it.
+func Translate(lang, key string) string {
+	if msg, ok := catalogs[lang][key]; ok {
+		return msg
+	}
+	if msg, ok := catalogs[DefaultLanguage][key]; ok {
+		return msg
+	}
+	return key
+}
+
+// Localizer holds the language that a request should be answered in. A new
+// Localizer is made for every request, from the user's preferences.
+type Localizer struct {
+	Lang string
+}
+
+// NewLocalizer() returns a Localizer for the supported language which best
+// matches the given preferences (see Match()).
+func NewLocalizer(preferences ...string) *Localizer {
+	return &Localizer{Lang: Match(preferences...)}
+}
+
+// T() returns the message with the given key in the Localizer's language.
+func (l *Localizer) T(key string) string {
+	return Translate(l.Lang, key)
+}
@@ -0,0 +1,98 @@
+package i18n
+
+import (
+	"testing"
+
+	"snippetbox/internal/assert"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		preferences []string
+		want        string
+	}{
+		{
+			name: "No preferences",
+			want: "en",
+		},
+		{
+			name:        "Accept-Language header",
+			preferences: []string{"", "fr-CH, fr;q=0.9, en;q=0.8"},
+			want:        "fr",
+		},
+		{
+			name:        "Quality values",
+			preferences: []string{"", "fr;q=0.5, es;q=0.9"},
+			want:        "es",
+		},
+		{
+			name:        "Session preference first",
+			preferences: []string{"es", "fr"},
+			want:        "es",
+		},
+		{
+			name:        "Unsupported language",
+			preferences: []string{"", "ja"},
+			want:        "en",
+		},
+		{
+			name:        "Invalid header",
+			preferences: []string{"", "!!not a language!!"},
+			want:        "en",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, Match(tt.preferences...), tt.want)
+		})
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	// Swap in some small catalogs, so that the test doesn't depend on the
+	// real translations.
+	original := catalogs
+	catalogs = map[string]map[string]string{
+		"en": {"greeting": "Hello", "farewell": "Goodbye"},
+		"fr": {"greeting": "Bonjour"},
+	}
+	t.Cleanup(func() { catalogs = original })
+
+	tests := []struct {
+		name string
+		lang string
+		key  string
+		want string
+	}{
+		{"Default language", "en", "greeting", "Hello"},
+		{"Other language", "fr", "greeting", "Bonjour"},
+		{"Missing key falls back to default", "fr", "farewell", "Goodbye"},
+		{"Missing language falls back to default", "de", "greeting", "Hello"},
+		{"Missing everywhere", "fr", "unknown.key", "unknown.key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, Translate(tt.lang, tt.key), tt.want)
+		})
+	}
+
+	l := &Localizer{Lang: "fr"}
+	assert.Equal(t, l.T("greeting"), "Bonjour")
+	assert.Equal(t, l.T("farewell"), "Goodbye")
+}
+
+// Every catalog should translate every message in the default catalog, so
+// that falling back to English is the exception rather than the rule.
+func TestCatalogsComplete(t *testing.T) {
+	assert.Equal(t, Languages[0], DefaultLanguage)
+	for _, lang := range Languages {
+		for key := range catalogs[DefaultLanguage] {
+			if _, ok := catalogs[lang][key]; !ok {
+				t.Errorf("%s: missing translation for %q", lang, key)
+			}
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"path/filepath"
+	"time"
+
+	"github.com/go-mail/mail/v2"
+)
+
+// Embed the email templates so that they are compiled into the binary along
+// with the rest of the application.
+//
+//go:embed "templates"
+var templateFS embed.FS
+
+// The Mailer interface describes anything which can send a templated email.
+// The application depends on this rather than on the SMTP implementation, so
+// that it can be swapped for a mock in tests.
+type Mailer interface {
+	Send(recipient, templateFile string, data any) error
+}
+
+// Define a SMTPMailer struct which contains a mail.Dialer instance (used to
+// connect to a SMTP server), the sender information for our emails (the name
+// and address the email is from, such as "Alice Smith <alice@example.com>"),
+// and a cache of the parsed email templates.
+type SMTPMailer struct {
+	dialer        *mail.Dialer
+	sender        string
+	templateCache map[string]*template.Template
+}
+
+func New(host string, port int, username, password, sender string) (*SMTPMailer, error) {
+	templateCache, err := newTemplateCache()
+	if err != nil {
+		return nil, err
+	}
+	// Initialize a new mail.Dialer instance with the given SMTP server settings.
+	// We also configure this to use a 5-second timeout whenever we send an
+	// email.
+	dialer := mail.NewDialer(host, port, username, password)
+	dialer.Timeout = 5 * time.Second
+	return &SMTPMailer{
+		dialer:        dialer,
+		sender:        sender,
+		templateCache: templateCache,
+	}, nil
+}
+
+// The newTemplateCache() function parses every email template in the embedded
+// filesystem once at startup, in the same way as the HTML page templates, so
+// that a broken template is caught straight away rather than at send time.
+func newTemplateCache() (map[string]*template.Template, error) {
+	cache := map[string]*template.Template{}
+	files, err := fs.Glob(templateFS, "templates/*.html")
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		name := filepath.Base(file)
+		tmpl, err := template.New("email").ParseFS(templateFS, file)
+		if err != nil {
+			return nil, err
+		}
+		cache[name] = tmpl
+	}
+	return cache, nil
+}
+
+// The Send() method takes the recipient email address, the name of the file
+// containing the templates, and any dynamic data for the templates. Each
+// template file must define "subject", "plainBody" and "htmlBody" templates,
+// which are used to build a multipart plain-text and HTML email.
+func (m *SMTPMailer) Send(recipient, templateFile string, data any) error {
+	tmpl, ok := m.templateCache[templateFile]
+	if !ok {
+		return fmt.Errorf("the email template %s does not exist", templateFile)
+	}
+	subject := new(bytes.Buffer)
+	err := tmpl.ExecuteTemplate(subject, "subject", data)
+	if err != nil {
+		return err
+	}
+	plainBody := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
+	if err != nil {
+		return err
+	}
+	htmlBody := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
+	if err != nil {
+		return err
+	}
+	// Note that AddAlternative() should always be called *after* SetBody().
+	msg := mail.NewMessage()
+	msg.SetHeader("To", recipient)
+	msg.SetHeader("From", m.sender)
+	msg.SetHeader("Subject", subject.String())
+	msg.SetBody("text/plain", plainBody.String())
+	msg.AddAlternative("text/html", htmlBody.String())
+	// Call the DialAndSend() method on the dialer, passing in the message to
+	// send. This opens a connection to the SMTP server, sends the message,
+	// then closes the connection.
+	return m.dialer.DialAndSend(msg)
+}
@@ -0,0 +1,28 @@
+package mailer
+
+import (
+	"io"
+	"testing"
+)
+
+func TestTemplateCache(t *testing.T) {
+	cache, err := newTemplateCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cache) == 0 {
+		t.Fatal("no email templates found")
+	}
+	// Every email template must define the three named templates which
+	// Send() executes.
+	for name, tmpl := range cache {
+		for _, part := range []string{"subject", "plainBody", "htmlBody"} {
+			t.Run(name+"/"+part, func(t *testing.T) {
+				err := tmpl.ExecuteTemplate(io.Discard, part, map[string]any{})
+				if err != nil {
+					t.Error(err)
+				}
+			})
+		}
+	}
+}
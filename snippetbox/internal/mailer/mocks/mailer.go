@@ -0,0 +1,38 @@
+package mocks
+
+import (
+	"sync"
+)
+
+// Message records the arguments of a single call to Mailer.Send().
+type Message struct {
+	Recipient    string
+	TemplateFile string
+	Data         any
+}
+
+// Mailer is a mock mailer which records the messages sent with it instead of
+// delivering them. It is safe for concurrent use, as emails are sent from
+// background goroutines.
+type Mailer struct {
+	mu   sync.Mutex
+	sent []Message
+}
+
+func (m *Mailer) Send(recipient, templateFile string, data any) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, Message{
+		Recipient:    recipient,
+		TemplateFile: templateFile,
+		Data:         data,
+	})
+	return nil
+}
+
+// Sent returns a copy of the messages sent so far.
+func (m *Mailer) Sent() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.sent...)
+}
@@ -0,0 +1,87 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type AuditModelInterface interface {
+	Record(ctx context.Context, userID int, action, detail, ip, userAgent string) error
+	Recent(ctx context.Context, userID, limit int) ([]*AuditEvent, error)
+}
+
+var _ AuditModelInterface = (*AuditModel)(nil)
+
+// The actions which are recorded in the audit log.
+const (
+	AuditLogin          = "login"
+	AuditLogout         = "logout"
+	AuditPasswordChange = "password_change"
+	AuditEmailChange    = "email_change"
+	AuditAccountDelete  = "account_delete"
+)
+
+// AuditEvent is a security-relevant event on a user's account. IP and
+// UserAgent describe the client which made the request that caused it.
+type AuditEvent struct {
+	ID        int
+	UserID    int
+	Action    string
+	Detail    string
+	IP        string
+	UserAgent string
+	Created   time.Time
+}
+
+// AuditModel wraps a database connection pool for working with the audit_log
+// table.
+type AuditModel struct {
+	DB *sql.DB
+}
+
+// Record() adds an event to a user's audit log. The detail and user agent are
+// cut down to fit their columns, as the user agent in particular comes
+// straight from the client and can be any length.
+func (m *AuditModel) Record(ctx context.Context, userID int, action, detail, ip, userAgent string) error {
+	stmt := `INSERT INTO audit_log (user_id, action, detail, ip, user_agent, created)
+	VALUES(?, ?, ?, ?, ?, UTC_TIMESTAMP())`
+	_, err := m.DB.ExecContext(ctx, stmt, userID, action, truncateRunes(detail, 255), ip, truncateRunes(userAgent, 255))
+	return err
+}
+
+// Recent() returns up to limit of a user's most recent events, newest first.
+func (m *AuditModel) Recent(ctx context.Context, userID, limit int) ([]*AuditEvent, error) {
+	stmt := `SELECT id, user_id, action, detail, ip, user_agent, created FROM audit_log
+	WHERE user_id = ?
+	ORDER BY created DESC, id DESC LIMIT ?`
+	rows, err := m.DB.QueryContext(ctx, stmt, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := []*AuditEvent{}
+	for rows.Next() {
+		e := &AuditEvent{}
+		err = rows.Scan(&e.ID, &e.UserID, &e.Action, &e.Detail, &e.IP, &e.UserAgent, &e.Created)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// The truncateRunes() helper cuts s down to at most n characters. MySQL
+// measures VARCHAR lengths in characters rather than bytes, so we count runes
+// too.
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n])
+}
@@ -0,0 +1,66 @@
+package models
+
+import (
+	"context"
+	"snippetbox/internal/assert"
+	"strings"
+	"testing"
+)
+
+func TestAuditModel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+
+	db := newTestDB(t)
+	m := AuditModel{db}
+
+	err := m.Record(ctx, 1, AuditLogin, "", "192.0.2.1", "Mozilla/5.0")
+	assert.NilError(t, err)
+	// A user agent which is too long for its column is cut down to fit.
+	err = m.Record(ctx, 1, AuditPasswordChange, "", "192.0.2.1", strings.Repeat("é", 300))
+	assert.NilError(t, err)
+	err = m.Record(ctx, 2, AuditLogin, "", "198.51.100.7", "curl/8.0")
+	assert.NilError(t, err)
+	// Make sure that the login is older than the password change, as DATETIME
+	// columns only store whole seconds.
+	_, err = db.Exec("UPDATE audit_log SET created = DATE_SUB(created, INTERVAL 1 MINUTE) WHERE action = ? AND user_id = 1", AuditLogin)
+	assert.NilError(t, err)
+
+	t.Run("Recent", func(t *testing.T) {
+		events, err := m.Recent(ctx, 1, 10)
+		assert.NilError(t, err)
+		assert.Equal(t, len(events), 2)
+		assert.Equal(t, events[0].Action, AuditPasswordChange)
+		assert.Equal(t, events[0].UserAgent, strings.Repeat("é", 255))
+		assert.Equal(t, events[1].Action, AuditLogin)
+		assert.Equal(t, events[1].UserID, 1)
+		assert.Equal(t, events[1].IP, "192.0.2.1")
+		assert.Equal(t, events[1].UserAgent, "Mozilla/5.0")
+	})
+
+	t.Run("Limit", func(t *testing.T) {
+		events, err := m.Recent(ctx, 1, 1)
+		assert.NilError(t, err)
+		assert.Equal(t, len(events), 1)
+		assert.Equal(t, events[0].Action, AuditPasswordChange)
+	})
+
+	t.Run("No events", func(t *testing.T) {
+		events, err := m.Recent(ctx, 3, 10)
+		assert.NilError(t, err)
+		assert.Equal(t, len(events), 0)
+	})
+
+	t.Run("Deleted user", func(t *testing.T) {
+		// The audit log is kept when the account is deleted.
+		users := UserModel{DB: db}
+		err := users.Delete(ctx, 1)
+		assert.NilError(t, err)
+		events, err := m.Recent(ctx, 1, 10)
+		assert.NilError(t, err)
+		assert.Equal(t, len(events), 2)
+	})
+}
@@ -0,0 +1,127 @@
+package models
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// CachedSnippetModel wraps another SnippetModelInterface and caches the results
+// of LatestPublic() and CountPublic() for a fixed TTL. The first page of the
+// home page calls both of them on every hit, but the latest snippets rarely
+// change, so this saves a lot of identical database queries. Every other method
+// is passed straight through, and the cache is cleared whenever a snippet is
+// added, changed or (un)deleted so that users see their own changes straight
+// away.
+//
+// Snippets can still expire while they're in the cache, so the TTL should be
+// kept short (a few seconds is plenty to absorb bursts of traffic).
+type CachedSnippetModel struct {
+	SnippetModelInterface
+	ttl time.Duration
+	now func() time.Time
+
+	mu      sync.Mutex
+	latest  []*Snippet
+	expires time.Time
+	// count is the cached result of CountPublic(), or -1 if there isn't one.
+	count        int
+	countExpires time.Time
+}
+
+var _ SnippetModelInterface = (*CachedSnippetModel)(nil)
+
+// NewCachedSnippetModel() returns a CachedSnippetModel which caches the
+// results of m.LatestPublic() and m.CountPublic() for the given TTL.
+func NewCachedSnippetModel(m SnippetModelInterface, ttl time.Duration) *CachedSnippetModel {
+	return &CachedSnippetModel{
+		SnippetModelInterface: m,
+		ttl:                   ttl,
+		now:                   time.Now,
+		count:                 -1,
+	}
+}
+
+// LatestPublic() returns the cached latest snippets if they haven't expired
+// yet, otherwise it fetches them from the underlying model and caches them. We
+// return a copy of the slice so that callers can't change the cached one.
+func (m *CachedSnippetModel) LatestPublic(ctx context.Context) ([]*Snippet, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.latest == nil || !m.now().Before(m.expires) {
+		snippets, err := m.SnippetModelInterface.LatestPublic(ctx)
+		if err != nil {
+			return nil, err
+		}
+		m.latest = snippets
+		m.expires = m.now().Add(m.ttl)
+	}
+	return append([]*Snippet(nil), m.latest...), nil
+}
+
+// CountPublic() returns the cached count of public snippets in the same way
+// as LatestPublic().
+func (m *CachedSnippetModel) CountPublic(ctx context.Context) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.count < 0 || !m.now().Before(m.countExpires) {
+		count, err := m.SnippetModelInterface.CountPublic(ctx)
+		if err != nil {
+			return 0, err
+		}
+		m.count = count
+		m.countExpires = m.now().Add(m.ttl)
+	}
+	return m.count, nil
+}
+
+// invalidate() empties the cache, so that the next calls to LatestPublic() and
+// CountPublic() hit the underlying model.
+func (m *CachedSnippetModel) invalidate() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.latest = nil
+	m.count = -1
+}
+
+func (m *CachedSnippetModel) Insert(ctx context.Context, title string, content string, expires int, userID int) (int, error) {
+	defer m.invalidate()
+	return m.SnippetModelInterface.Insert(ctx, title, content, expires, userID)
+}
+
+func (m *CachedSnippetModel) InsertWithTags(ctx context.Context, title string, content string, expires int, userID int, tags []string, visibility string, language string, publishAt time.Time) (int, error) {
+	defer m.invalidate()
+	return m.SnippetModelInterface.InsertWithTags(ctx, title, content, expires, userID, tags, visibility, language, publishAt)
+}
+
+func (m *CachedSnippetModel) BulkInsert(ctx context.Context, snippets []InsertParams) ([]int, error) {
+	defer m.invalidate()
+	return m.SnippetModelInterface.BulkInsert(ctx, snippets)
+}
+
+func (m *CachedSnippetModel) Update(ctx context.Context, id int, title string, content string, expires int, visibility string, language string) error {
+	defer m.invalidate()
+	return m.SnippetModelInterface.Update(ctx, id, title, content, expires, visibility, language)
+}
+
+func (m *CachedSnippetModel) Delete(ctx context.Context, id int) error {
+	defer m.invalidate()
+	return m.SnippetModelInterface.Delete(ctx, id)
+}
+
+func (m *CachedSnippetModel) Restore(ctx context.Context, id int) error {
+	defer m.invalidate()
+	return m.SnippetModelInterface.Restore(ctx, id)
+}
+
+func (m *CachedSnippetModel) RestoreRevision(ctx context.Context, snippetID int, revisionID int, userID int) error {
+	defer m.invalidate()
+	return m.SnippetModelInterface.RestoreRevision(ctx, snippetID, revisionID, userID)
+}
+
+func (m *CachedSnippetModel) Extend(ctx context.Context, id int, days int) error {
+	defer m.invalidate()
+	return m.SnippetModelInterface.Extend(ctx, id, days)
+}
@@ -0,0 +1,115 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"snippetbox/internal/assert"
+)
+
+// countingSnippetModel is a stub SnippetModelInterface which counts how many
+// times LatestPublic() and CountPublic() are called. Any other method which
+// isn't implemented here will panic, as the embedded interface is nil.
+type countingSnippetModel struct {
+	SnippetModelInterface
+	calls      int
+	countCalls int
+}
+
+func (m *countingSnippetModel) LatestPublic(ctx context.Context) ([]*Snippet, error) {
+	m.calls++
+	return []*Snippet{{ID: 1}}, nil
+}
+
+func (m *countingSnippetModel) CountPublic(ctx context.Context) (int, error) {
+	m.countCalls++
+	return 1, nil
+}
+
+func (m *countingSnippetModel) Insert(ctx context.Context, title string, content string, expires int, userID int) (int, error) {
+	return 2, nil
+}
+
+func (m *countingSnippetModel) Delete(ctx context.Context, id int) error {
+	return nil
+}
+
+func TestCachedSnippetModelLatestPublic(t *testing.T) {
+	ctx := context.Background()
+	stub := &countingSnippetModel{}
+	m := NewCachedSnippetModel(stub, time.Minute)
+	now := time.Now()
+	m.now = func() time.Time { return now }
+
+	snippets, err := m.LatestPublic(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, len(snippets), 1)
+	assert.Equal(t, stub.calls, 1)
+
+	// A second call within the TTL is served from the cache.
+	now = now.Add(59 * time.Second)
+	_, err = m.LatestPublic(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, stub.calls, 1)
+
+	// Once the TTL has passed, the underlying model is queried again.
+	now = now.Add(time.Second)
+	_, err = m.LatestPublic(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, stub.calls, 2)
+}
+
+func TestCachedSnippetModelCountPublic(t *testing.T) {
+	ctx := context.Background()
+	stub := &countingSnippetModel{}
+	m := NewCachedSnippetModel(stub, time.Minute)
+	now := time.Now()
+	m.now = func() time.Time { return now }
+
+	count, err := m.CountPublic(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, count, 1)
+	assert.Equal(t, stub.countCalls, 1)
+
+	now = now.Add(59 * time.Second)
+	_, err = m.CountPublic(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, stub.countCalls, 1)
+
+	now = now.Add(time.Second)
+	_, err = m.CountPublic(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, stub.countCalls, 2)
+
+	_, err = m.Insert(ctx, "Title", "Content", 7, 1)
+	assert.NilError(t, err)
+	_, err = m.CountPublic(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, stub.countCalls, 3)
+}
+
+func TestCachedSnippetModelInvalidation(t *testing.T) {
+	ctx := context.Background()
+	stub := &countingSnippetModel{}
+	m := NewCachedSnippetModel(stub, time.Minute)
+
+	m.LatestPublic(ctx)
+	assert.Equal(t, stub.calls, 1)
+
+	_, err := m.Insert(ctx, "Title", "Content", 7, 1)
+	assert.NilError(t, err)
+	m.LatestPublic(ctx)
+	assert.Equal(t, stub.calls, 2)
+
+	assert.NilError(t, m.Delete(ctx, 1))
+	m.LatestPublic(ctx)
+	assert.Equal(t, stub.calls, 3)
+
+	// Changing the returned slice doesn't affect the cached copy.
+	snippets, _ := m.LatestPublic(ctx)
+	snippets[0] = nil
+	snippets, _ = m.LatestPublic(ctx)
+	assert.Equal(t, snippets[0].ID, 1)
+	assert.Equal(t, stub.calls, 3)
+}
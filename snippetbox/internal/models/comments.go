@@ -0,0 +1,102 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type CommentModelInterface interface {
+	Insert(ctx context.Context, snippetID, userID int, body string) (int, error)
+	ForSnippet(ctx context.Context, snippetID int) ([]*Comment, error)
+	Delete(ctx context.Context, id, userID int) (int, error)
+}
+
+var _ CommentModelInterface = (*CommentModel)(nil)
+
+// Comment is a comment left on a snippet. UserName is the name of the user who
+// wrote it, so that it can be shown alongside the comment without a separate
+// lookup.
+type Comment struct {
+	ID        int
+	SnippetID int
+	UserID    int
+	UserName  string
+	Body      string
+	Created   time.Time
+}
+
+// CommentModel wraps a database connection pool for working with the
+// comments table.
+type CommentModel struct {
+	DB *sql.DB
+}
+
+// Insert() adds a comment to a snippet, and returns its ID. The caller should
+// check that the user is allowed to see the snippet first.
+func (m *CommentModel) Insert(ctx context.Context, snippetID, userID int, body string) (int, error) {
+	stmt := `INSERT INTO comments (snippet_id, user_id, body, created)
+	VALUES(?, ?, ?, UTC_TIMESTAMP())`
+	result, err := m.DB.ExecContext(ctx, stmt, snippetID, userID, body)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(id), nil
+}
+
+// ForSnippet() returns the comments on a snippet, newest first.
+func (m *CommentModel) ForSnippet(ctx context.Context, snippetID int) ([]*Comment, error) {
+	stmt := `SELECT c.id, c.snippet_id, c.user_id, u.name, c.body, c.created FROM comments c
+	INNER JOIN users u ON u.id = c.user_id
+	WHERE c.snippet_id = ?
+	ORDER BY c.created DESC, c.id DESC`
+	rows, err := m.DB.QueryContext(ctx, stmt, snippetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	comments := []*Comment{}
+	for rows.Next() {
+		c := &Comment{}
+		err = rows.Scan(&c.ID, &c.SnippetID, &c.UserID, &c.UserName, &c.Body, &c.Created)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return comments, nil
+}
+
+// Delete() removes a comment, and returns the ID of the snippet which it was
+// on. A comment can be deleted by the user who wrote it, or by the owner of
+// the snippet. If the comment doesn't exist then ErrNoRecord is returned, and
+// if userID is neither of those users then ErrNotPermitted is returned.
+func (m *CommentModel) Delete(ctx context.Context, id, userID int) (int, error) {
+	var snippetID, authorID, ownerID int
+	stmt := `SELECT c.snippet_id, c.user_id, s.user_id FROM comments c
+	INNER JOIN snippets s ON s.id = c.snippet_id
+	WHERE c.id = ?`
+	err := m.DB.QueryRowContext(ctx, stmt, id).Scan(&snippetID, &authorID, &ownerID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoRecord
+		}
+		return 0, err
+	}
+	if userID != authorID && userID != ownerID {
+		return 0, ErrNotPermitted
+	}
+	_, err = m.DB.ExecContext(ctx, "DELETE FROM comments WHERE id = ?", id)
+	if err != nil {
+		return 0, err
+	}
+	return snippetID, nil
+}
@@ -0,0 +1,76 @@
+package models
+
+import (
+	"context"
+	"snippetbox/internal/assert"
+	"testing"
+	"time"
+)
+
+func TestCommentModel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+
+	db := newTestDB(t)
+	m := CommentModel{db}
+	snippets := SnippetModel{DB: db}
+
+	// Alice (user 1) owns the snippet, and Bob (user 2) and Carol (user 3)
+	// comment on it.
+	_, err := db.Exec(`INSERT INTO users (name, email, hashed_password, created, activated)
+	VALUES ('Bob', 'bob@example.com', 'x', UTC_TIMESTAMP(), TRUE), ('Carol', 'carol@example.com', 'x', UTC_TIMESTAMP(), TRUE)`)
+	assert.NilError(t, err)
+	snippetID, err := snippets.InsertWithTags(ctx, "An old silent pond", "A frog jumps into the pond", 7, 1, nil, VisibilityPublic, "", time.Time{})
+	assert.NilError(t, err)
+
+	first, err := m.Insert(ctx, snippetID, 2, "Lovely haiku")
+	assert.NilError(t, err)
+	second, err := m.Insert(ctx, snippetID, 3, "I prefer the one about the crow")
+	assert.NilError(t, err)
+	// Make sure that the comments have different creation times, as DATETIME
+	// columns only store whole seconds.
+	_, err = db.Exec("UPDATE comments SET created = DATE_SUB(created, INTERVAL 1 MINUTE) WHERE id = ?", first)
+	assert.NilError(t, err)
+
+	t.Run("Newest first", func(t *testing.T) {
+		comments, err := m.ForSnippet(ctx, snippetID)
+		assert.NilError(t, err)
+		assert.Equal(t, len(comments), 2)
+		assert.Equal(t, comments[0].ID, second)
+		assert.Equal(t, comments[0].UserName, "Carol")
+		assert.Equal(t, comments[0].Body, "I prefer the one about the crow")
+		assert.Equal(t, comments[1].ID, first)
+		assert.Equal(t, comments[1].UserName, "Bob")
+		assert.Equal(t, comments[1].SnippetID, snippetID)
+	})
+
+	t.Run("No comments", func(t *testing.T) {
+		comments, err := m.ForSnippet(ctx, snippetID+1)
+		assert.NilError(t, err)
+		assert.Equal(t, len(comments), 0)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		// Carol didn't write the first comment and doesn't own the snippet.
+		_, err := m.Delete(ctx, first, 3)
+		assert.Equal(t, err, ErrNotPermitted)
+
+		// Bob wrote it, so Bob can delete it.
+		id, err := m.Delete(ctx, first, 2)
+		assert.NilError(t, err)
+		assert.Equal(t, id, snippetID)
+		_, err = m.Delete(ctx, first, 2)
+		assert.Equal(t, err, ErrNoRecord)
+
+		// Alice owns the snippet, so she can delete Carol's comment.
+		_, err = m.Delete(ctx, second, 1)
+		assert.NilError(t, err)
+
+		comments, err := m.ForSnippet(ctx, snippetID)
+		assert.NilError(t, err)
+		assert.Equal(t, len(comments), 0)
+	})
+}
@@ -12,4 +12,32 @@ var (
 	// Add a new ErrDuplicateEmail error. We'll use this later if a user
 	// tries to signup with an email address that's already in use.
 	ErrDuplicateEmail = errors.New("models: duplicate email")
+	// Add a new ErrDuplicateUsername error. We'll use this if a user tries to
+	// signup with a username that's already taken.
+	ErrDuplicateUsername = errors.New("models: duplicate username")
+	// Add a new ErrInvalidToken error. We'll use this if a one-time token
+	// doesn't exist, has expired or has already been used.
+	ErrInvalidToken = errors.New("models: invalid or expired token")
+	// Add a new ErrAccountNotActivated error. We'll use this if a user tries
+	// to login before they have verified their email address.
+	ErrAccountNotActivated = errors.New("models: account not activated")
+	// Add a new ErrNoMigration error. We'll use this if there's no migration
+	// to roll back.
+	ErrNoMigration = errors.New("models: no migration to roll back")
+	// Add a new ErrDirtySchema error. We'll use this if a migration failed
+	// part-way through, and the schema needs fixing by hand before any more
+	// migrations can be run.
+	ErrDirtySchema = errors.New("models: schema is dirty")
+	// Add a new ErrNotPermitted error. We'll use this if a user tries to
+	// change something which exists but which they aren't allowed to touch.
+	ErrNotPermitted = errors.New("models: not permitted")
+	// Add a new ErrDuplicateReport error. We'll use this if a user tries to
+	// report a snippet which they've already reported.
+	ErrDuplicateReport = errors.New("models: duplicate report")
+	// Add a new ErrTooManyPins error. We'll use this if a user tries to pin
+	// a snippet when they've already pinned as many as they're allowed.
+	ErrTooManyPins = errors.New("models: too many pinned snippets")
+	// Add a new ErrInvalidOrder error. We'll use this if snippets are asked
+	// for in an order which isn't one of SnippetOrders.
+	ErrInvalidOrder = errors.New("models: invalid sort order")
 )
@@ -0,0 +1,274 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// migrationLockName is the name of the MySQL lock which is held while
+// migrating, so that if several copies of the application start at the same
+// time only one of them applies the migrations.
+const migrationLockName = "snippetbox_schema_migrations"
+
+// migrationLockTimeout is how many seconds to wait for the lock.
+const migrationLockTimeout = 60
+
+// Migration files are named like "000012_add_users_totp.up.sql", with a
+// matching ".down.sql" file to undo the change.
+var migrationFileRX = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// migration describes one numbered change to the database schema.
+type migration struct {
+	version int
+	name    string
+	up      string
+	down    string
+}
+
+// Migrator applies the SQL migration files in Files to the database. The
+// current schema version is recorded in a schema_migrations table, which has
+// the same layout as the one used by the golang-migrate command-line tool, so
+// a database which has been migrated by hand with that tool carries on from
+// where it was. Migration files can contain several statements, so DB must be
+// opened with the multiStatements=true DSN parameter.
+type Migrator struct {
+	DB    *sql.DB
+	Files fs.FS
+}
+
+// The migrations() method reads the names of the migration files and returns
+// them sorted by version.
+func (m *Migrator) migrations() ([]*migration, error) {
+	entries, err := fs.ReadDir(m.Files, ".")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*migration{}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+		matches := migrationFileRX.FindStringSubmatch(entry.Name())
+		if matches == nil {
+			return nil, fmt.Errorf("models: badly named migration file %s", entry.Name())
+		}
+		version, err := strconv.Atoi(matches[1])
+		if err != nil || version == 0 {
+			return nil, fmt.Errorf("models: badly numbered migration file %s", entry.Name())
+		}
+		name := matches[1] + "_" + matches[2]
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &migration{version: version, name: name}
+			byVersion[version] = mig
+		} else if mig.name != name {
+			return nil, fmt.Errorf("models: migrations %s and %s have the same version", mig.name, name)
+		}
+		if matches[3] == "up" {
+			mig.up = entry.Name()
+		} else {
+			mig.down = entry.Name()
+		}
+	}
+
+	migrations := make([]*migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.up == "" {
+			return nil, fmt.Errorf("models: migration %s has no up file", mig.name)
+		}
+		migrations = append(migrations, mig)
+	}
+	slices.SortFunc(migrations, func(a, b *migration) int {
+		return a.version - b.version
+	})
+	return migrations, nil
+}
+
+// The lock() method gets a connection from the pool and takes the migration
+// lock on it. MySQL locks belong to the connection which took them, so all the
+// migration statements must be run on the same connection, and the returned
+// function releases the lock before putting the connection back.
+func (m *Migrator) lock(ctx context.Context) (*sql.Conn, func(), error) {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	var got sql.NullInt64
+	err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", migrationLockName, migrationLockTimeout).Scan(&got)
+	if err == nil && got.Int64 != 1 {
+		err = errors.New("models: timed out waiting for the migration lock")
+	}
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	unlock := func() {
+		var released sql.NullInt64
+		conn.QueryRowContext(ctx, "SELECT RELEASE_LOCK(?)", migrationLockName).Scan(&released)
+		conn.Close()
+	}
+	return conn, unlock, nil
+}
+
+// The version() function creates the schema_migrations table if it doesn't
+// exist yet, and returns the current version. The version is 0 if no
+// migrations have been applied.
+func version(ctx context.Context, conn *sql.Conn) (int, bool, error) {
+	stmt := `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version BIGINT NOT NULL PRIMARY KEY,
+    dirty BOOLEAN NOT NULL
+    )`
+	_, err := conn.ExecContext(ctx, stmt)
+	if err != nil {
+		return 0, false, err
+	}
+	var version int
+	var dirty bool
+	err = conn.QueryRowContext(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	return version, dirty, err
+}
+
+// The setVersion() function records the current version. While a migration is
+// being applied the version is marked as dirty, so that if it fails part-way
+// through we know that the schema needs fixing by hand.
+func setVersion(ctx context.Context, conn *sql.Conn, version int, dirty bool) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, "DELETE FROM schema_migrations")
+	if err != nil {
+		return err
+	}
+	if version > 0 {
+		_, err = tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, dirty) VALUES (?, ?)", version, dirty)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// The run() method runs the statements in a migration file, marking the
+// schema as dirty at version while it does so, and then records the new
+// version once they've all succeeded.
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, file string, version, newVersion int) error {
+	script, err := fs.ReadFile(m.Files, file)
+	if err != nil {
+		return err
+	}
+	err = setVersion(ctx, conn, version, true)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(script)) != "" {
+		_, err = conn.ExecContext(ctx, string(script))
+		if err != nil {
+			return fmt.Errorf("models: migration %s: %w", file, err)
+		}
+	}
+	return setVersion(ctx, conn, newVersion, false)
+}
+
+// Version returns the current schema version, and whether a migration failed
+// part-way through leaving the schema dirty.
+func (m *Migrator) Version() (int, bool, error) {
+	ctx := context.Background()
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+	defer conn.Close()
+	return version(ctx, conn)
+}
+
+// Up applies all the migrations which haven't been applied yet, in order, and
+// returns the names of the ones which it applied.
+func (m *Migrator) Up() ([]string, error) {
+	migrations, err := m.migrations()
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	conn, unlock, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	current, dirty, err := version(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	if dirty {
+		return nil, fmt.Errorf("%w at version %d", ErrDirtySchema, current)
+	}
+	var applied []string
+	for _, mig := range migrations {
+		if mig.version <= current {
+			continue
+		}
+		err = m.run(ctx, conn, mig.up, mig.version, mig.version)
+		if err != nil {
+			return applied, err
+		}
+		applied = append(applied, mig.name)
+	}
+	return applied, nil
+}
+
+// Down rolls back the latest migration, and returns its name. If no
+// migrations have been applied then it returns ErrNoMigration.
+func (m *Migrator) Down() (string, error) {
+	migrations, err := m.migrations()
+	if err != nil {
+		return "", err
+	}
+	ctx := context.Background()
+	conn, unlock, err := m.lock(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	current, dirty, err := version(ctx, conn)
+	if err != nil {
+		return "", err
+	}
+	if dirty {
+		return "", fmt.Errorf("%w at version %d", ErrDirtySchema, current)
+	}
+	if current == 0 {
+		return "", ErrNoMigration
+	}
+	i := slices.IndexFunc(migrations, func(mig *migration) bool {
+		return mig.version == current
+	})
+	if i == -1 {
+		return "", fmt.Errorf("models: no migration file for version %d", current)
+	}
+	mig := migrations[i]
+	if mig.down == "" {
+		return "", fmt.Errorf("models: migration %s has no down file", mig.name)
+	}
+	previous := 0
+	if i > 0 {
+		previous = migrations[i-1].version
+	}
+	err = m.run(ctx, conn, mig.down, current, previous)
+	if err != nil {
+		return "", err
+	}
+	return mig.name, nil
+}
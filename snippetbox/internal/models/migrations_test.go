@@ -0,0 +1,171 @@
+package models
+
+import (
+	"database/sql"
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	"snippetbox/internal/assert"
+	"snippetbox/migrations"
+)
+
+// The tables() helper returns the names of the tables in the database.
+func tables(t *testing.T, db *sql.DB) []string {
+	rows, err := db.Query("SHOW TABLES")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	if err = rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestMigrator(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+	// Unlike the other tests we don't call newTestDB(), as we want to start
+	// with an empty database and let the migrations create the schema.
+	db, err := sql.Open("mysql", "test_web:pass@/test_snippetbox?parseTime=true&multiStatements=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Migrator{DB: db, Files: migrations.Files}
+	// Roll everything back afterwards, even if the test fails part-way
+	// through, so that the tables don't get in the way of the other tests.
+	t.Cleanup(func() {
+		for {
+			_, err := m.Down()
+			if err != nil {
+				break
+			}
+		}
+		db.Exec("DROP TABLE IF EXISTS schema_migrations")
+		db.Close()
+	})
+
+	version, dirty, err := m.Version()
+	assert.NilError(t, err)
+	assert.Equal(t, version, 0)
+	assert.Equal(t, dirty, false)
+
+	all, err := m.migrations()
+	assert.NilError(t, err)
+	latest := all[len(all)-1]
+
+	applied, err := m.Up()
+	assert.NilError(t, err)
+	assert.Equal(t, len(applied), len(all))
+	assert.Equal(t, applied[0], "000001_create_initial_schema")
+
+	version, dirty, err = m.Version()
+	assert.NilError(t, err)
+	assert.Equal(t, version, latest.version)
+	assert.Equal(t, dirty, false)
+
+	got := tables(t, db)
+	for _, table := range []string{
+		"schema_migrations", "snippets", "tags", "snippet_tags", "sessions",
+		"users", "snippet_favourites", "tokens", "api_tokens",
+		"password_resets", "user_sessions", "user_identities", "recovery_codes",
+		"comments", "reports", "notifications", "audit_log",
+	} {
+		if !slices.Contains(got, table) {
+			t.Errorf("missing table %q", table)
+		}
+	}
+
+	// Running the migrations again does nothing.
+	applied, err = m.Up()
+	assert.NilError(t, err)
+	assert.Equal(t, len(applied), 0)
+
+	// Rolling back the latest migration drops the table it added, and takes
+	// us back to the previous version.
+	name, err := m.Down()
+	assert.NilError(t, err)
+	assert.Equal(t, name, latest.name)
+	var columns int
+	err = db.QueryRow(`SELECT COUNT(*) FROM information_schema.columns
+	WHERE table_schema = DATABASE() AND table_name = 'snippet_revisions'`).Scan(&columns)
+	assert.NilError(t, err)
+	assert.Equal(t, columns, 0)
+	version, _, err = m.Version()
+	assert.NilError(t, err)
+	assert.Equal(t, version, all[len(all)-2].version)
+
+	for range len(all) - 1 {
+		_, err = m.Down()
+		assert.NilError(t, err)
+	}
+	_, err = m.Down()
+	assert.Equal(t, err, ErrNoMigration)
+	assert.Equal(t, slices.Equal(tables(t, db), []string{"schema_migrations"}), true)
+}
+
+func TestMigratorFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   fstest.MapFS
+		wantErr string
+	}{
+		{
+			name: "Valid",
+			files: fstest.MapFS{
+				"000002_second.up.sql":   {},
+				"000001_first.up.sql":    {},
+				"000001_first.down.sql":  {},
+				"000002_second.down.sql": {},
+				"README":                 {},
+			},
+		},
+		{
+			name:    "Bad name",
+			files:   fstest.MapFS{"first.up.sql": {}},
+			wantErr: "models: badly named migration file first.up.sql",
+		},
+		{
+			name: "Duplicate version",
+			files: fstest.MapFS{
+				"000001_first.up.sql":  {},
+				"000001_second.up.sql": {},
+			},
+			wantErr: "have the same version",
+		},
+		{
+			name:    "Missing up",
+			files:   fstest.MapFS{"000001_first.down.sql": {}},
+			wantErr: "models: migration 000001_first has no up file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Migrator{Files: tt.files}
+			migrations, err := m.migrations()
+			if tt.wantErr != "" {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				assert.StringContains(t, err.Error(), tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, len(migrations), 2)
+			assert.Equal(t, migrations[0].name, "000001_first")
+			assert.Equal(t, migrations[1].up, "000002_second.up.sql")
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package mocks
+
+import (
+	"context"
+	"slices"
+	"snippetbox/internal/models"
+	"sync"
+	"time"
+)
+
+// AuditModel is a mock audit model. It keeps the events which are recorded in
+// memory, so that the tests can check them and they show up on the account
+// activity page. It is safe for concurrent use.
+type AuditModel struct {
+	mu     sync.Mutex
+	events []*models.AuditEvent
+}
+
+var _ models.AuditModelInterface = (*AuditModel)(nil)
+
+func (m *AuditModel) Record(ctx context.Context, userID int, action, detail, ip, userAgent string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = append(m.events, &models.AuditEvent{
+		ID:        len(m.events) + 1,
+		UserID:    userID,
+		Action:    action,
+		Detail:    detail,
+		IP:        ip,
+		UserAgent: userAgent,
+		Created:   time.Now(),
+	})
+	return nil
+}
+
+// Events returns the events which have been recorded, oldest first.
+func (m *AuditModel) Events() []*models.AuditEvent {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.events)
+}
+
+func (m *AuditModel) Recent(ctx context.Context, userID, limit int) ([]*models.AuditEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	events := []*models.AuditEvent{}
+	for _, e := range slices.Backward(m.events) {
+		if e.UserID == userID && len(events) < limit {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
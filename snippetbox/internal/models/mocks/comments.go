@@ -0,0 +1,100 @@
+package mocks
+
+import (
+	"context"
+	"snippetbox/internal/models"
+	"sync"
+	"time"
+)
+
+// The mock comments. The first two are on mockSnippet, which is owned by the
+// user who can log in via the mock UserModel, and are listed newest first.
+// mockOtherComment is on mockOtherSnippet and was written by its owner, so
+// the mock user can't delete it.
+var (
+	mockOwnComment = &models.Comment{
+		ID:        2,
+		SnippetID: 1,
+		UserID:    1,
+		UserName:  "Alice",
+		Body:      "Thanks for reading!",
+		Created:   time.Now(),
+	}
+	mockComment = &models.Comment{
+		ID:        1,
+		SnippetID: 1,
+		UserID:    2,
+		UserName:  "Bob",
+		Body:      "What a lovely haiku.",
+		Created:   time.Now().Add(-time.Hour),
+	}
+	mockOtherComment = &models.Comment{
+		ID:        3,
+		SnippetID: 3,
+		UserID:    2,
+		UserName:  "Bob",
+		Body:      "Comments welcome.",
+		Created:   time.Now(),
+	}
+)
+
+// CommentModel is a mock comment model. It records the comments which were
+// inserted.
+type CommentModel struct {
+	mu       sync.Mutex
+	inserted []*models.Comment
+}
+
+var _ models.CommentModelInterface = (*CommentModel)(nil)
+
+func (m *CommentModel) Insert(ctx context.Context, snippetID, userID int, body string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inserted = append(m.inserted, &models.Comment{
+		ID:        4,
+		SnippetID: snippetID,
+		UserID:    userID,
+		Body:      body,
+	})
+	return 4, nil
+}
+
+// Inserted returns the comments which have been passed to Insert().
+func (m *CommentModel) Inserted() []*models.Comment {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.inserted
+}
+
+func (m *CommentModel) ForSnippet(ctx context.Context, snippetID int) ([]*models.Comment, error) {
+	switch snippetID {
+	case 1:
+		return []*models.Comment{mockOwnComment, mockComment}, nil
+	case 3:
+		return []*models.Comment{mockOtherComment}, nil
+	default:
+		return []*models.Comment{}, nil
+	}
+}
+
+// Delete follows the same rules as the real model: the comment's author and
+// the snippet's owner (user 1 for mockSnippet, user 2 for mockOtherSnippet)
+// can delete it.
+func (m *CommentModel) Delete(ctx context.Context, id, userID int) (int, error) {
+	var comment *models.Comment
+	var ownerID int
+	switch id {
+	case mockComment.ID:
+		comment, ownerID = mockComment, mockSnippet.UserID
+	case mockOwnComment.ID:
+		comment, ownerID = mockOwnComment, mockSnippet.UserID
+	case mockOtherComment.ID:
+		comment, ownerID = mockOtherComment, mockOtherSnippet.UserID
+	default:
+		return 0, models.ErrNoRecord
+	}
+	if userID != comment.UserID && userID != ownerID {
+		return 0, models.ErrNotPermitted
+	}
+	return comment.SnippetID, nil
+}
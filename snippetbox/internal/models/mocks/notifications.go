@@ -0,0 +1,100 @@
+package mocks
+
+import (
+	"context"
+	"slices"
+	"snippetbox/internal/models"
+	"sync"
+	"time"
+)
+
+// The mock notifications. mockNotification is for the user who can log in via
+// the mock UserModel, and mockOtherNotification is for the owner of
+// mockOtherSnippet, so the mock user can't mark it as read.
+var (
+	mockNotification = &models.Notification{
+		ID:      1,
+		UserID:  1,
+		Message: `Bob commented on your snippet "An old silent pond"`,
+		Link:    "/snippet/view/1",
+		Created: time.Now(),
+	}
+	mockOtherNotification = &models.Notification{
+		ID:      2,
+		UserID:  2,
+		Message: `Alice commented on your snippet "Over the wintry"`,
+		Link:    "/snippet/view/3",
+		Created: time.Now(),
+	}
+)
+
+// NotificationModel is a mock notification model. It records the
+// notifications which were created and the ones which have been marked as
+// read, so that both show up in later calls. It is safe for concurrent use.
+type NotificationModel struct {
+	mu      sync.Mutex
+	created []*models.Notification
+	read    []int
+}
+
+var _ models.NotificationModelInterface = (*NotificationModel)(nil)
+
+func (m *NotificationModel) Create(ctx context.Context, userID int, message, link string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := 3 + len(m.created)
+	m.created = append(m.created, &models.Notification{
+		ID:      id,
+		UserID:  userID,
+		Message: message,
+		Link:    link,
+		Created: time.Now(),
+	})
+	return id, nil
+}
+
+// Created returns the notifications which have been passed to Create().
+func (m *NotificationModel) Created() []*models.Notification {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.created
+}
+
+// all() returns every notification, newest first. The caller must hold m.mu.
+func (m *NotificationModel) all() []*models.Notification {
+	all := []*models.Notification{mockNotification, mockOtherNotification}
+	all = append(all, m.created...)
+	slices.Reverse(all)
+	return all
+}
+
+func (m *NotificationModel) UnreadFor(ctx context.Context, userID int) ([]*models.Notification, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	notifications := []*models.Notification{}
+	for _, n := range m.all() {
+		if n.UserID == userID && !slices.Contains(m.read, n.ID) {
+			notifications = append(notifications, n)
+		}
+	}
+	return notifications, nil
+}
+
+func (m *NotificationModel) UnreadCount(ctx context.Context, userID int) (int, error) {
+	notifications, err := m.UnreadFor(ctx, userID)
+	return len(notifications), err
+}
+
+func (m *NotificationModel) MarkRead(ctx context.Context, id, userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, n := range m.all() {
+		if n.ID == id && n.UserID == userID {
+			if !slices.Contains(m.read, id) {
+				m.read = append(m.read, id)
+			}
+			return nil
+		}
+	}
+	return models.ErrNoRecord
+}
@@ -0,0 +1,70 @@
+package mocks
+
+import (
+	"context"
+	"snippetbox/internal/models"
+	"sync"
+	"time"
+)
+
+// mockReport is an open report of mockOtherSnippet by the user who can log in
+// via the mock UserModel.
+var mockReport = &models.Report{
+	ID:           1,
+	SnippetID:    3,
+	SnippetTitle: "Over the wintry",
+	UserID:       1,
+	UserName:     "Alice",
+	Reason:       models.ReportReasonSpam,
+	Note:         "Posted over and over again",
+	Status:       models.ReportOpen,
+	Created:      time.Now(),
+}
+
+// ReportModel is a mock report model. Like the real model it only lets each
+// user report a snippet once, and it records the reports which were
+// inserted.
+type ReportModel struct {
+	mu       sync.Mutex
+	inserted []*models.Report
+}
+
+var _ models.ReportModelInterface = (*ReportModel)(nil)
+
+func (m *ReportModel) Insert(ctx context.Context, snippetID, userID int, reason, note string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, r := range append([]*models.Report{mockReport}, m.inserted...) {
+		if r.SnippetID == snippetID && r.UserID == userID {
+			return 0, models.ErrDuplicateReport
+		}
+	}
+	id := len(m.inserted) + 2
+	m.inserted = append(m.inserted, &models.Report{
+		ID:        id,
+		SnippetID: snippetID,
+		UserID:    userID,
+		Reason:    reason,
+		Note:      note,
+		Status:    models.ReportOpen,
+	})
+	return id, nil
+}
+
+// Inserted returns the reports which have been passed to Insert().
+func (m *ReportModel) Inserted() []*models.Report {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.inserted
+}
+
+func (m *ReportModel) Open(ctx context.Context) ([]*models.Report, error) {
+	return []*models.Report{mockReport}, nil
+}
+
+func (m *ReportModel) SetStatus(ctx context.Context, id int, status string) error {
+	if id == mockReport.ID {
+		return nil
+	}
+	return models.ErrNoRecord
+}
@@ -0,0 +1,37 @@
+package mocks
+
+import (
+	"context"
+	"snippetbox/internal/models"
+	"time"
+)
+
+// SessionModel is a mock session model. The user known to the mock UserModel
+// has two sessions, with the prefixes "AAAAAAAAAAAA" and "BBBBBBBBBBBB". The
+// prefix "CCCCCCCCCCCC" belongs to a session owned by another user.
+type SessionModel struct{}
+
+func (m *SessionModel) Record(ctx context.Context, token string, userID int, expiry time.Time) error {
+	return nil
+}
+
+func (m *SessionModel) ListSessions(ctx context.Context, userID int) ([]*models.Session, error) {
+	if userID != 1 {
+		return []*models.Session{}, nil
+	}
+	return []*models.Session{
+		{Prefix: "AAAAAAAAAAAA", Created: time.Now(), Expiry: time.Now().Add(time.Hour)},
+		{Prefix: "BBBBBBBBBBBB", Created: time.Now(), Expiry: time.Now().Add(time.Hour)},
+	}, nil
+}
+
+func (m *SessionModel) Revoke(ctx context.Context, userID int, prefix string) error {
+	if userID == 1 && (prefix == "AAAAAAAAAAAA" || prefix == "BBBBBBBBBBBB") {
+		return nil
+	}
+	return models.ErrNoRecord
+}
+
+func (m *SessionModel) RevokeOthers(ctx context.Context, userID int, currentToken string) error {
+	return nil
+}
@@ -1,31 +1,501 @@
 package mocks
 
 import (
+	"context"
+	"iter"
+	"math"
+	"slices"
 	"snippetbox/internal/models"
+	"strings"
+	"sync"
 	"time"
 )
 
 var mockSnippet = &models.Snippet{
-	ID:      1,
-	Title:   "An old silent pond",
-	Content: "An old silent pond...",
+	ID:         1,
+	PublicID:   "c2lsZW50LXBvbmQtc2x1Zw",
+	Title:      "An old silent pond",
+	Content:    "An old silent pond...",
+	Created:    time.Now(),
+	Expires:    time.Now(),
+	UserID:     1,
+	Visibility: models.VisibilityPublic,
+}
+
+// mockOtherSnippet is owned by a different user to the one who can log in via
+// the mock UserModel, so that we can test ownership checks.
+var mockOtherSnippet = &models.Snippet{
+	ID:         3,
+	Title:      "Over the wintry",
+	Content:    "Over the wintry forest...",
+	Created:    time.Now(),
+	Expires:    time.Now(),
+	UserID:     2,
+	Visibility: models.VisibilityPublic,
+}
+
+// mockDeletedSnippet has been soft-deleted by the user who can log in via the
+// mock UserModel, so it only shows up in their trash.
+var mockDeletedSnippet = &models.Snippet{
+	ID:      4,
+	Title:   "First autumn morning",
+	Content: "First autumn morning...",
 	Created: time.Now(),
 	Expires: time.Now(),
+	UserID:  1,
+}
+
+// mockPrivateSnippet is a private snippet owned by the user who can log in via
+// the mock UserModel. Nobody else can see it.
+var mockPrivateSnippet = &models.Snippet{
+	ID:         6,
+	Title:      "A private note",
+	Content:    "For my eyes only...",
+	Created:    time.Now(),
+	Expires:    time.Now(),
+	UserID:     1,
+	Visibility: models.VisibilityPrivate,
+}
+
+// mockUnlistedSnippet is an unlisted snippet, which can only be found through
+// its share link.
+var mockUnlistedSnippet = &models.Snippet{
+	ID:         7,
+	PublicID:   "dW5saXN0ZWQtc25pcHBldA",
+	Title:      "An unlisted note",
+	Content:    "Only for those with the link...",
+	Created:    time.Now(),
+	Expires:    time.Now(),
+	UserID:     2,
+	Visibility: models.VisibilityUnlisted,
 }
 
-type SnippetModel struct{}
+// mockScheduledSnippet is a public snippet owned by the user who can log in
+// via the mock UserModel, which is scheduled to be published tomorrow. Until
+// then only its owner can see it.
+var mockScheduledSnippet = &models.Snippet{
+	ID:         8,
+	Title:      "Spring rain",
+	Content:    "Spring rain...",
+	Created:    time.Now(),
+	Expires:    time.Now().Add(365 * 24 * time.Hour),
+	UserID:     1,
+	Visibility: models.VisibilityPublic,
+	PublishAt:  time.Now().Add(24 * time.Hour),
+}
+
+// SnippetModel is a mock snippet model. It records calls to IncrementViews()
+// so that tests can check how many views were counted, and the snippets which
+// were inserted or pinned. It is safe for concurrent use, as views are counted
+// from background goroutines. MaxPins works in the same way as it does for
+// the real model. Update() and RestoreRevision() save revisions like the real
+// model does, but the snippets themselves never change.
+type SnippetModel struct {
+	MaxPins  int
+	mu       sync.Mutex
+	views    map[int]int
+	inserted []*models.Snippet
+	pinned   []int
+
+	// revisions holds the saved revisions, oldest first.
+	revisions []*models.SnippetRevision
+}
 
-func (m *SnippetModel) Insert(title string, content string, expires int) (int, error) {
+// Check at compile time that the mocks satisfy the same interfaces as the real
+// models, so that they can be used in their place in the handler tests.
+var (
+	_ models.SnippetModelInterface = (*SnippetModel)(nil)
+	_ models.UserModelInterface    = (*UserModel)(nil)
+	_ models.SessionModelInterface = (*SessionModel)(nil)
+)
+
+func (m *SnippetModel) Insert(ctx context.Context, title string, content string, expires int, userID int) (int, error) {
 	return 2, nil
 }
-func (m *SnippetModel) Get(id int) (*models.Snippet, error) {
-	switch id {
-	case 1:
+func (m *SnippetModel) Get(ctx context.Context, id int, viewerID int) (*models.Snippet, error) {
+	switch {
+	case id == 1:
 		return mockSnippet, nil
+	case id == 3:
+		return mockOtherSnippet, nil
+	case id == 6 && viewerID == mockPrivateSnippet.UserID:
+		return mockPrivateSnippet, nil
+	case id == 8 && viewerID == mockScheduledSnippet.UserID:
+		return mockScheduledSnippet, nil
 	default:
 		return nil, models.ErrNoRecord
 	}
 }
-func (m *SnippetModel) Latest() ([]*models.Snippet, error) {
-	return []*models.Snippet{mockSnippet}, nil
+func (m *SnippetModel) GetByPublicID(ctx context.Context, slug string) (*models.Snippet, error) {
+	switch slug {
+	case mockSnippet.PublicID:
+		return mockSnippet, nil
+	case mockUnlistedSnippet.PublicID:
+		return mockUnlistedSnippet, nil
+	default:
+		return nil, models.ErrNoRecord
+	}
+}
+func (m *SnippetModel) LatestForUser(ctx context.Context, userID int, publicOnly bool, offset int, limit int) ([]*models.Snippet, error) {
+	snippets := []*models.Snippet{}
+	if userID == 1 {
+		snippets = []*models.Snippet{mockPrivateSnippet, mockSnippet}
+	}
+	if publicOnly {
+		snippets = slices.DeleteFunc(snippets, func(s *models.Snippet) bool {
+			return s.Visibility != models.VisibilityPublic
+		})
+	}
+	// Mark the pinned snippets on copies, so that the shared mock snippets
+	// aren't changed, and move them to the front.
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, s := range snippets {
+		if slices.Contains(m.pinned, s.ID) {
+			pinned := *s
+			pinned.Pinned = true
+			snippets[i] = &pinned
+		}
+	}
+	slices.SortStableFunc(snippets, func(a, b *models.Snippet) int {
+		switch {
+		case a.Pinned && !b.Pinned:
+			return -1
+		case b.Pinned && !a.Pinned:
+			return 1
+		default:
+			return 0
+		}
+	})
+	return page(snippets, offset, limit), nil
+}
+
+// page() returns the part of snippets selected by offset and limit, in the
+// same way as the SQL OFFSET and LIMIT clauses.
+func page(snippets []*models.Snippet, offset int, limit int) []*models.Snippet {
+	if offset >= len(snippets) {
+		return []*models.Snippet{}
+	}
+	return snippets[offset:min(offset+limit, len(snippets))]
+}
+func (m *SnippetModel) LatestPublic(ctx context.Context) ([]*models.Snippet, error) {
+	return []*models.Snippet{mockOtherSnippet, mockSnippet}, nil
+}
+func (m *SnippetModel) LatestPublicPage(ctx context.Context, offset int, limit int, order string) ([]*models.Snippet, error) {
+	return ordered([]*models.Snippet{mockOtherSnippet, mockSnippet}, offset, limit, order)
+}
+
+// ordered() returns a page of snippets, which are given newest first, in the
+// given order. Unknown orders are rejected like the real model does. For the
+// other orders the snippets are simply reversed, so that tests can tell that
+// the order was passed through.
+func ordered(snippets []*models.Snippet, offset int, limit int, order string) ([]*models.Snippet, error) {
+	switch order {
+	case models.OrderNewest:
+	case models.OrderMostViewed, models.OrderMostFavourited:
+		snippets = slices.Clone(snippets)
+		slices.Reverse(snippets)
+	default:
+		return nil, models.ErrInvalidOrder
+	}
+	return page(snippets, offset, limit), nil
+}
+
+// TitleExistsForUser() checks the titles of the mock snippets, ignoring case
+// like MySQL does.
+func (m *SnippetModel) TitleExistsForUser(ctx context.Context, userID int, title string) (bool, error) {
+	for _, s := range []*models.Snippet{mockSnippet, mockOtherSnippet, mockPrivateSnippet, mockUnlistedSnippet, mockScheduledSnippet} {
+		if s.UserID == userID && strings.EqualFold(s.Title, title) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+func (m *SnippetModel) CountPublic(ctx context.Context) (int, error) {
+	return 2, nil
+}
+func (m *SnippetModel) CountForUser(ctx context.Context, userID int, publicOnly bool) (int, error) {
+	snippets, err := m.LatestForUser(ctx, userID, publicOnly, 0, math.MaxInt)
+	return len(snippets), err
+}
+func (m *SnippetModel) Update(ctx context.Context, id int, title string, content string, expires int, visibility string, language string) error {
+	switch id {
+	case 1, 3, 6:
+		m.saveRevision(id, title, content)
+		return nil
+	default:
+		return models.ErrNoRecord
+	}
+}
+
+func (m *SnippetModel) Delete(ctx context.Context, id int) error {
+	switch id {
+	case 1, 3, 6:
+		return nil
+	default:
+		return models.ErrNoRecord
+	}
+}
+
+func (m *SnippetModel) Search(ctx context.Context, query string, offset int, limit int) ([]*models.Snippet, error) {
+	if strings.Contains(strings.ToLower(mockSnippet.Content), strings.ToLower(query)) {
+		return page([]*models.Snippet{mockSnippet}, offset, limit), nil
+	}
+	return []*models.Snippet{}, nil
+}
+func (m *SnippetModel) SearchCount(ctx context.Context, query string) (int, error) {
+	snippets, err := m.Search(ctx, query, 0, math.MaxInt)
+	return len(snippets), err
+}
+
+func (m *SnippetModel) InsertWithTags(ctx context.Context, title string, content string, expires int, userID int, tags []string, visibility string, language string, publishAt time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inserted = append(m.inserted, &models.Snippet{
+		ID:         2,
+		Title:      title,
+		Content:    content,
+		UserID:     userID,
+		Visibility: visibility,
+		Language:   language,
+		PublishAt:  publishAt,
+	})
+	return 2, nil
+}
+
+// BulkInsert records the snippets in the same way as InsertWithTags(), and
+// gives them consecutive IDs starting from 100.
+func (m *SnippetModel) BulkInsert(ctx context.Context, snippets []models.InsertParams) ([]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ids := make([]int, 0, len(snippets))
+	for i, p := range snippets {
+		id := 100 + i
+		m.inserted = append(m.inserted, &models.Snippet{
+			ID:         id,
+			Title:      p.Title,
+			Content:    p.Content,
+			UserID:     p.UserID,
+			Visibility: p.Visibility,
+			Language:   p.Language,
+			PublishAt:  p.PublishAt,
+		})
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Inserted returns the snippets which have been passed to InsertWithTags() or
+// BulkInsert().
+func (m *SnippetModel) Inserted() []*models.Snippet {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.inserted
+}
+
+func (m *SnippetModel) GetTags(ctx context.Context, snippetID int) ([]string, error) {
+	switch snippetID {
+	case 1:
+		return []string{"haiku", "nature"}, nil
+	default:
+		return []string{}, nil
+	}
+}
+
+func (m *SnippetModel) TagsForUser(ctx context.Context, userID int) (map[int][]string, error) {
+	if userID != 1 {
+		return map[int][]string{}, nil
+	}
+	return map[int][]string{1: {"haiku", "nature"}}, nil
+}
+
+func (m *SnippetModel) ByTag(ctx context.Context, tag string) ([]*models.Snippet, error) {
+	switch tag {
+	case "haiku", "nature":
+		return []*models.Snippet{mockSnippet}, nil
+	default:
+		return []*models.Snippet{}, nil
+	}
+}
+
+func (m *SnippetModel) FavouritesFor(ctx context.Context, userID int) ([]*models.Snippet, error) {
+	if userID == 1 {
+		return []*models.Snippet{mockOtherSnippet}, nil
+	}
+	return []*models.Snippet{}, nil
+}
+
+func (m *SnippetModel) IncrementViews(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.views == nil {
+		m.views = make(map[int]int)
+	}
+	m.views[id]++
+	return nil
+}
+
+// Views returns the number of times IncrementViews() has been called for the
+// given snippet.
+func (m *SnippetModel) Views(id int) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.views[id]
+}
+
+func (m *SnippetModel) Restore(ctx context.Context, id int) error {
+	switch id {
+	case 4:
+		return nil
+	default:
+		return models.ErrNoRecord
+	}
+}
+
+func (m *SnippetModel) Trash(ctx context.Context, userID int) ([]*models.Snippet, error) {
+	if userID == 1 {
+		return []*models.Snippet{mockDeletedSnippet}, nil
+	}
+	return []*models.Snippet{}, nil
+}
+
+func (m *SnippetModel) AllIDs(ctx context.Context) ([]models.SnippetRef, error) {
+	return []models.SnippetRef{
+		{ID: mockOtherSnippet.ID, Created: mockOtherSnippet.Created},
+		{ID: mockSnippet.ID, Created: mockSnippet.Created},
+	}, nil
+}
+
+func (m *SnippetModel) IterateFor(ctx context.Context, userID int) iter.Seq2[*models.Snippet, error] {
+	return func(yield func(*models.Snippet, error) bool) {
+		if userID != 1 {
+			return
+		}
+		if !yield(mockSnippet, nil) {
+			return
+		}
+		// A snippet whose fields need quoting in CSV.
+		yield(&models.Snippet{
+			ID:         5,
+			Title:      "Commas, \"quotes\"",
+			Content:    "Line one,\nline two",
+			Created:    time.Now(),
+			UserID:     1,
+			Visibility: models.VisibilityPrivate,
+			Language:   "go",
+		}, nil)
+	}
+}
+
+func (m *SnippetModel) Count(ctx context.Context) (int, error) {
+	return 5, nil
+}
+
+// PurgeExpired pretends that two expired snippets were deleted. The other mock
+// snippets are still needed by the rest of the tests, so nothing is actually
+// removed.
+func (m *SnippetModel) PurgeExpired(ctx context.Context) (int64, error) {
+	return 2, nil
+}
+
+// mockOwner() returns the ID of the user who owns one of the mock snippets
+// which isn't in the trash, or 0 if there's no such snippet.
+func mockOwner(id int) int {
+	for _, s := range []*models.Snippet{mockSnippet, mockOtherSnippet, mockPrivateSnippet, mockUnlistedSnippet, mockScheduledSnippet} {
+		if s.ID == id {
+			return s.UserID
+		}
+	}
+	return 0
+}
+
+// saveRevision() saves the fixed title and content of a snippet as a revision,
+// unless they're the same as the new ones.
+func (m *SnippetModel) saveRevision(id int, title, content string) {
+	for _, s := range []*models.Snippet{mockSnippet, mockOtherSnippet, mockPrivateSnippet} {
+		if s.ID != id || (s.Title == title && s.Content == content) {
+			continue
+		}
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.revisions = append(m.revisions, &models.SnippetRevision{
+			ID:        len(m.revisions) + 1,
+			SnippetID: id,
+			Title:     s.Title,
+			Content:   s.Content,
+			Created:   time.Now(),
+		})
+	}
+}
+
+func (m *SnippetModel) Revisions(ctx context.Context, snippetID int) ([]*models.SnippetRevision, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	revisions := []*models.SnippetRevision{}
+	for _, r := range slices.Backward(m.revisions) {
+		if r.SnippetID == snippetID {
+			revisions = append(revisions, r)
+		}
+	}
+	return revisions, nil
+}
+
+func (m *SnippetModel) RestoreRevision(ctx context.Context, snippetID int, revisionID int, userID int) error {
+	if mockOwner(snippetID) != userID {
+		return models.ErrNoRecord
+	}
+	m.mu.Lock()
+	i := slices.IndexFunc(m.revisions, func(r *models.SnippetRevision) bool {
+		return r.ID == revisionID && r.SnippetID == snippetID
+	})
+	var revision *models.SnippetRevision
+	if i >= 0 {
+		revision = m.revisions[i]
+	}
+	m.mu.Unlock()
+	if revision == nil {
+		return models.ErrNoRecord
+	}
+	m.saveRevision(snippetID, revision.Title, revision.Content)
+	return nil
+}
+
+func (m *SnippetModel) SetPinned(ctx context.Context, id int, userID int, pinned bool) error {
+	if mockOwner(id) != userID {
+		return models.ErrNoRecord
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	count := 0
+	for _, p := range m.pinned {
+		if mockOwner(p) == userID {
+			count++
+		}
+	}
+	switch {
+	case pinned == slices.Contains(m.pinned, id):
+	case !pinned:
+		m.pinned = slices.DeleteFunc(m.pinned, func(p int) bool { return p == id })
+	case m.MaxPins > 0 && count >= m.MaxPins:
+		return models.ErrTooManyPins
+	default:
+		m.pinned = append(m.pinned, id)
+	}
+	return nil
+}
+
+func (m *SnippetModel) ExpiringSoonForUser(ctx context.Context, userID int, within time.Duration) ([]*models.Snippet, error) {
+	if userID == 1 {
+		return []*models.Snippet{mockSnippet}, nil
+	}
+	return []*models.Snippet{}, nil
+}
+
+func (m *SnippetModel) Extend(ctx context.Context, id int, days int) error {
+	switch id {
+	case 1, 3, 6:
+		return nil
+	default:
+		return models.ErrNoRecord
+	}
 }
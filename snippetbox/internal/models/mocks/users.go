@@ -1,50 +1,116 @@
 package mocks
 
 import (
+	"context"
 	"snippetbox/internal/models"
+	"sync"
 	"time"
 )
 
-type UserModel struct{}
+// UserModel is a mock user model. The users are fixed, apart from Alice's
+// preference for unique snippet titles and her theme, which SetUniqueTitles()
+// and SetTheme() change so that tests can set them. It is safe for concurrent
+// use.
+type UserModel struct {
+	mu           sync.Mutex
+	uniqueTitles bool
+	theme        string
+	// Deactivating Alice deletes her API token, as it does in the real
+	// model.
+	tokenRevoked bool
+}
 
-func (m *UserModel) Insert(name, email, password string) error {
-	switch email {
-	case "dupe@example.com":
-		return models.ErrDuplicateEmail
+// mockAdmin is an admin user, who can log in with the email address
+// admin@example.com.
+var mockAdmin = &models.User{
+	ID:        9,
+	Name:      "Carol",
+	Username:  "carol",
+	Email:     "admin@example.com",
+	Created:   time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC),
+	Activated: true,
+	Role:      models.RoleAdmin,
+	Timezone:  "Asia/Tokyo",
+}
+
+// mockTOTPUser has turned on two-factor authentication, so logging in as them
+// (with the email address dave@example.com) needs a TOTP code for
+// MockTOTPSecret or the recovery code MockRecoveryCode too.
+var mockTOTPUser = &models.User{
+	ID:          10,
+	Name:        "Dave",
+	Email:       "dave@example.com",
+	Created:     time.Now(),
+	Activated:   true,
+	Role:        models.RoleUser,
+	Timezone:    "UTC",
+	TOTPEnabled: true,
+}
+
+const (
+	MockTOTPSecret   = "JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"
+	MockRecoveryCode = "VALIDRECOVERYCODE"
+)
+
+func (m *UserModel) Insert(ctx context.Context, name, username, email, password string) (string, error) {
+	switch {
+	case email == "dupe@example.com":
+		return "", models.ErrDuplicateEmail
+	case username == "alice" || username == mockAdmin.Username:
+		return "", models.ErrDuplicateUsername
 	default:
-		return nil
+		return "VALIDACTIVATIONTOKEN", nil
 	}
 }
-func (m *UserModel) Authenticate(email, password string) (int, error) {
+func (m *UserModel) Authenticate(ctx context.Context, email, password string) (int, error) {
 	if email == "alice@example.com" && password == "pa$$word" {
 		return 1, nil
 	}
+	if email == mockAdmin.Email && password == "pa$$word" {
+		return mockAdmin.ID, nil
+	}
+	if email == mockTOTPUser.Email && password == "pa$$word" {
+		return mockTOTPUser.ID, nil
+	}
+	if email == "bob@example.com" && password == "pa$$word" {
+		return 0, models.ErrAccountNotActivated
+	}
 	return 0, models.ErrInvalidCredentials
 }
-func (m *UserModel) Exists(id int) (bool, error) {
+func (m *UserModel) Exists(ctx context.Context, id int) (bool, error) {
 	switch id {
-	case 1:
+	case 1, mockAdmin.ID, mockTOTPUser.ID:
 		return true, nil
 	default:
 		return false, nil
 	}
 }
 
-func (m *UserModel) Get(id int) (*models.User, error) {
+func (m *UserModel) Get(ctx context.Context, id int) (*models.User, error) {
 	switch id {
 	case 1:
 		return &models.User{
-			ID:      1,
-			Name:    "Alice",
-			Email:   "alice@example.com",
-			Created: time.Now(),
+			ID:           1,
+			Name:         "Alice",
+			Username:     "alice",
+			Email:        "alice@example.com",
+			Created:      time.Now(),
+			Activated:    true,
+			Role:         models.RoleUser,
+			Timezone:     "UTC",
+			Theme:        m.aliceTheme(),
+			UniqueTitles: m.aliceUniqueTitles(),
 		}, nil
+	case mockAdmin.ID:
+		return mockAdmin, nil
+	case mockTOTPUser.ID:
+		return mockTOTPUser, nil
 	default:
 		return nil, models.ErrNoRecord
 	}
 }
 
-func (m *UserModel) PasswordUpdate(id int, currentPassword, newPassword string) error {
+func (m *UserModel) PasswordUpdate(ctx context.Context, id int, currentPassword, newPassword string) error {
 	if id == 1 {
 		if currentPassword != "pa$$word" {
 			return models.ErrInvalidCredentials
@@ -53,3 +119,213 @@ func (m *UserModel) PasswordUpdate(id int, currentPassword, newPassword string)
 	}
 	return models.ErrNoRecord
 }
+
+func (m *UserModel) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	switch email {
+	case "alice@example.com":
+		return m.Get(ctx, 1)
+	case mockAdmin.Email:
+		return mockAdmin, nil
+	default:
+		return nil, models.ErrNoRecord
+	}
+}
+
+func (m *UserModel) GetByUsername(ctx context.Context, username string) (*models.User, error) {
+	switch username {
+	case "alice":
+		return m.Get(ctx, 1)
+	case mockAdmin.Username:
+		return mockAdmin, nil
+	default:
+		return nil, models.ErrNoRecord
+	}
+}
+
+func (m *UserModel) CreatePasswordReset(ctx context.Context, userID int) (string, error) {
+	return "VALIDRESETTOKEN", nil
+}
+
+func (m *UserModel) ResetPassword(ctx context.Context, token, newPassword string) (int, error) {
+	if token == "VALIDRESETTOKEN" {
+		return 1, nil
+	}
+	return 0, models.ErrInvalidToken
+}
+
+func (m *UserModel) Activate(ctx context.Context, token string) error {
+	if token == "VALIDACTIVATIONTOKEN" {
+		return nil
+	}
+	return models.ErrInvalidToken
+}
+
+func (m *UserModel) CreateAPIToken(ctx context.Context, userID int) (string, error) {
+	return "VALIDAPITOKEN", nil
+}
+
+func (m *UserModel) GetForToken(ctx context.Context, token string) (*models.User, error) {
+	m.mu.Lock()
+	revoked := m.tokenRevoked
+	m.mu.Unlock()
+	if token == "VALIDAPITOKEN" && !revoked {
+		return m.Get(ctx, 1)
+	}
+	return nil, models.ErrNoRecord
+}
+
+func (m *UserModel) AddFavourite(ctx context.Context, userID, snippetID int) error {
+	switch snippetID {
+	case 1, 3:
+		return nil
+	default:
+		return models.ErrNoRecord
+	}
+}
+
+func (m *UserModel) RemoveFavourite(ctx context.Context, userID, snippetID int) error {
+	return nil
+}
+
+// IsFavourite() treats mockOtherSnippet as the only favourite, so that both
+// states of the favourite button can be tested.
+func (m *UserModel) IsFavourite(ctx context.Context, userID, snippetID int) (bool, error) {
+	return userID == 1 && snippetID == 3, nil
+}
+
+func (m *UserModel) UpdateEmail(ctx context.Context, userID int, newEmail string) error {
+	if newEmail == "dupe@example.com" {
+		return models.ErrDuplicateEmail
+	}
+	return nil
+}
+
+func (m *UserModel) CreateEmailChange(ctx context.Context, userID int, currentPassword, newEmail string) (string, error) {
+	if userID != 1 || currentPassword != "pa$$word" {
+		return "", models.ErrInvalidCredentials
+	}
+	if newEmail == "dupe@example.com" {
+		return "", models.ErrDuplicateEmail
+	}
+	return "VALIDEMAILTOKEN", nil
+}
+
+func (m *UserModel) ConfirmEmailChange(ctx context.Context, token string) (int, error) {
+	if token == "VALIDEMAILTOKEN" {
+		return 1, nil
+	}
+	return 0, models.ErrInvalidToken
+}
+
+func (m *UserModel) Delete(ctx context.Context, userID int) error {
+	if userID == 1 {
+		return nil
+	}
+	return models.ErrNoRecord
+}
+
+func (m *UserModel) SetActivated(ctx context.Context, userID int, active bool) error {
+	if userID == 1 && !active {
+		m.mu.Lock()
+		m.tokenRevoked = true
+		m.mu.Unlock()
+	}
+	return nil
+}
+
+func (m *UserModel) SetRole(ctx context.Context, userID int, role string) error {
+	return nil
+}
+
+func (m *UserModel) SetTimezone(ctx context.Context, userID int, timezone string) error {
+	return nil
+}
+
+func (m *UserModel) SetUniqueTitles(ctx context.Context, userID int, enabled bool) error {
+	if userID == 1 {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.uniqueTitles = enabled
+	}
+	return nil
+}
+
+func (m *UserModel) aliceUniqueTitles() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.uniqueTitles
+}
+
+func (m *UserModel) SetTheme(ctx context.Context, userID int, theme string) error {
+	if userID == 1 {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.theme = theme
+	}
+	return nil
+}
+
+func (m *UserModel) aliceTheme() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.theme == "" {
+		return models.ThemeSystem
+	}
+	return m.theme
+}
+
+func (m *UserModel) SetAvatar(ctx context.Context, userID int, path string) error {
+	return nil
+}
+
+func (m *UserModel) UpsertOAuthUser(ctx context.Context, provider, providerUserID, email, name string) (int, error) {
+	switch email {
+	case "alice@example.com":
+		return 1, nil
+	case mockAdmin.Email:
+		return mockAdmin.ID, nil
+	default:
+		// The only way that creating a new user can fail is if someone else
+		// signs up with the same email address at the same moment.
+		return 0, models.ErrDuplicateEmail
+	}
+}
+
+func (m *UserModel) EnableTOTP(ctx context.Context, userID int, secret string) ([]string, error) {
+	return []string{MockRecoveryCode, "ANOTHERRECOVERYCODE"}, nil
+}
+
+func (m *UserModel) DisableTOTP(ctx context.Context, userID int) error {
+	return nil
+}
+
+func (m *UserModel) TOTPSecret(ctx context.Context, userID int) (string, error) {
+	switch userID {
+	case mockTOTPUser.ID:
+		return MockTOTPSecret, nil
+	case 1, mockAdmin.ID:
+		return "", nil
+	default:
+		return "", models.ErrNoRecord
+	}
+}
+
+func (m *UserModel) UseRecoveryCode(ctx context.Context, userID int, code string) error {
+	if userID == mockTOTPUser.ID && code == MockRecoveryCode {
+		return nil
+	}
+	return models.ErrInvalidCredentials
+}
+
+func (m *UserModel) All(ctx context.Context, offset, limit int) ([]*models.User, error) {
+	alice, _ := m.Get(ctx, 1)
+	users := []*models.User{alice, mockAdmin}
+	if offset >= len(users) {
+		return []*models.User{}, nil
+	}
+	return users[offset:min(offset+limit, len(users))], nil
+}
+
+func (m *UserModel) Count(ctx context.Context) (int, error) {
+	return 2, nil
+}
@@ -0,0 +1,113 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type NotificationModelInterface interface {
+	Create(ctx context.Context, userID int, message, link string) (int, error)
+	UnreadFor(ctx context.Context, userID int) ([]*Notification, error)
+	UnreadCount(ctx context.Context, userID int) (int, error)
+	MarkRead(ctx context.Context, id, userID int) error
+}
+
+var _ NotificationModelInterface = (*NotificationModel)(nil)
+
+// Notification is a message for a user about something which happened while
+// they were away. Link is the path of the page which it's about, if any.
+type Notification struct {
+	ID      int
+	UserID  int
+	Message string
+	Link    string
+	Created time.Time
+}
+
+// NotificationModel wraps a database connection pool for working with the
+// notifications table.
+type NotificationModel struct {
+	DB *sql.DB
+}
+
+// Create() adds an unread notification for a user, and returns its ID.
+func (m *NotificationModel) Create(ctx context.Context, userID int, message, link string) (int, error) {
+	stmt := `INSERT INTO notifications (user_id, message, link, created)
+	VALUES(?, ?, ?, UTC_TIMESTAMP())`
+	result, err := m.DB.ExecContext(ctx, stmt, userID, message, link)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(id), nil
+}
+
+// UnreadFor() returns a user's unread notifications, newest first.
+func (m *NotificationModel) UnreadFor(ctx context.Context, userID int) ([]*Notification, error) {
+	stmt := `SELECT id, user_id, message, link, created FROM notifications
+	WHERE user_id = ? AND read_at IS NULL
+	ORDER BY created DESC, id DESC`
+	rows, err := m.DB.QueryContext(ctx, stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	notifications := []*Notification{}
+	for rows.Next() {
+		n := &Notification{}
+		err = rows.Scan(&n.ID, &n.UserID, &n.Message, &n.Link, &n.Created)
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return notifications, nil
+}
+
+// UnreadCount() returns the number of unread notifications which a user has.
+// It's shown on every page, so it's a cheap count rather than loading the
+// notifications themselves.
+func (m *NotificationModel) UnreadCount(ctx context.Context, userID int) (int, error) {
+	var count int
+	stmt := "SELECT COUNT(*) FROM notifications WHERE user_id = ? AND read_at IS NULL"
+	err := m.DB.QueryRowContext(ctx, stmt, userID).Scan(&count)
+	return count, err
+}
+
+// MarkRead() marks one of a user's notifications as read. Marking a
+// notification which has already been read does nothing. If the notification
+// doesn't exist, or belongs to somebody else, then ErrNoRecord is returned.
+func (m *NotificationModel) MarkRead(ctx context.Context, id, userID int) error {
+	stmt := `UPDATE notifications SET read_at = UTC_TIMESTAMP()
+	WHERE id = ? AND user_id = ? AND read_at IS NULL`
+	result, err := m.DB.ExecContext(ctx, stmt, id, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows > 0 {
+		return nil
+	}
+	// Nothing was updated, either because the notification had already been
+	// read or because there's no such notification for this user.
+	var exists bool
+	stmt = "SELECT EXISTS(SELECT true FROM notifications WHERE id = ? AND user_id = ?)"
+	err = m.DB.QueryRowContext(ctx, stmt, id, userID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNoRecord
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package models
+
+import (
+	"context"
+	"snippetbox/internal/assert"
+	"testing"
+)
+
+func TestNotificationModel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+
+	db := newTestDB(t)
+	m := NotificationModel{db}
+
+	// Alice (user 1) gets two notifications, and Bob (user 2) gets one.
+	_, err := db.Exec(`INSERT INTO users (name, email, hashed_password, created, activated)
+	VALUES ('Bob', 'bob@example.com', 'x', UTC_TIMESTAMP(), TRUE)`)
+	assert.NilError(t, err)
+	first, err := m.Create(ctx, 1, `Bob commented on your snippet "An old silent pond"`, "/snippet/view/1")
+	assert.NilError(t, err)
+	second, err := m.Create(ctx, 1, `Carol commented on your snippet "An old silent pond"`, "/snippet/view/1")
+	assert.NilError(t, err)
+	bobs, err := m.Create(ctx, 2, "Welcome!", "")
+	assert.NilError(t, err)
+	// Make sure that the notifications have different creation times, as
+	// DATETIME columns only store whole seconds.
+	_, err = db.Exec("UPDATE notifications SET created = DATE_SUB(created, INTERVAL 1 MINUTE) WHERE id = ?", first)
+	assert.NilError(t, err)
+
+	t.Run("Unread", func(t *testing.T) {
+		count, err := m.UnreadCount(ctx, 1)
+		assert.NilError(t, err)
+		assert.Equal(t, count, 2)
+
+		notifications, err := m.UnreadFor(ctx, 1)
+		assert.NilError(t, err)
+		assert.Equal(t, len(notifications), 2)
+		assert.Equal(t, notifications[0].ID, second)
+		assert.Equal(t, notifications[0].UserID, 1)
+		assert.Equal(t, notifications[0].Message, `Carol commented on your snippet "An old silent pond"`)
+		assert.Equal(t, notifications[0].Link, "/snippet/view/1")
+		assert.Equal(t, notifications[1].ID, first)
+
+		count, err = m.UnreadCount(ctx, 3)
+		assert.NilError(t, err)
+		assert.Equal(t, count, 0)
+	})
+
+	t.Run("Mark read", func(t *testing.T) {
+		// Alice can't mark Bob's notification as read, or one which doesn't
+		// exist.
+		err := m.MarkRead(ctx, bobs, 1)
+		assert.Equal(t, err, ErrNoRecord)
+		err = m.MarkRead(ctx, bobs+1, 1)
+		assert.Equal(t, err, ErrNoRecord)
+
+		err = m.MarkRead(ctx, second, 1)
+		assert.NilError(t, err)
+		// Marking it again does nothing.
+		err = m.MarkRead(ctx, second, 1)
+		assert.NilError(t, err)
+
+		count, err := m.UnreadCount(ctx, 1)
+		assert.NilError(t, err)
+		assert.Equal(t, count, 1)
+		notifications, err := m.UnreadFor(ctx, 1)
+		assert.NilError(t, err)
+		assert.Equal(t, len(notifications), 1)
+		assert.Equal(t, notifications[0].ID, first)
+
+		// Bob's notification is still unread.
+		count, err = m.UnreadCount(ctx, 2)
+		assert.NilError(t, err)
+		assert.Equal(t, count, 1)
+	})
+}
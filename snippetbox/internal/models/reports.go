@@ -0,0 +1,123 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type ReportModelInterface interface {
+	Insert(ctx context.Context, snippetID, userID int, reason, note string) (int, error)
+	Open(ctx context.Context) ([]*Report, error)
+	SetStatus(ctx context.Context, id int, status string) error
+}
+
+var _ ReportModelInterface = (*ReportModel)(nil)
+
+// The reasons which a user can give for reporting a snippet.
+const (
+	ReportReasonSpam      = "spam"
+	ReportReasonOffensive = "offensive"
+	ReportReasonIllegal   = "illegal"
+	ReportReasonOther     = "other"
+)
+
+// The status of a report. New reports are open until an admin either resolves
+// them (having dealt with the snippet) or dismisses them.
+const (
+	ReportOpen      = "open"
+	ReportResolved  = "resolved"
+	ReportDismissed = "dismissed"
+)
+
+// Report is a user's report that a snippet is inappropriate. SnippetTitle and
+// UserName are included so that the admin report queue doesn't need to look
+// them up separately.
+type Report struct {
+	ID           int
+	SnippetID    int
+	SnippetTitle string
+	UserID       int
+	UserName     string
+	Reason       string
+	Note         string
+	Status       string
+	Created      time.Time
+}
+
+// ReportModel wraps a database connection pool for working with the reports
+// table.
+type ReportModel struct {
+	DB *sql.DB
+}
+
+// Insert() records a new open report, and returns its ID. Each user can only
+// report a snippet once; if they try again then ErrDuplicateReport is
+// returned.
+func (m *ReportModel) Insert(ctx context.Context, snippetID, userID int, reason, note string) (int, error) {
+	stmt := `INSERT INTO reports (snippet_id, user_id, reason, note, status, created)
+	VALUES(?, ?, ?, ?, 'open', UTC_TIMESTAMP())`
+	result, err := m.DB.ExecContext(ctx, stmt, snippetID, userID, reason, note)
+	if err != nil {
+		// The reports_uc_snippet_user constraint is the only unique key apart
+		// from the primary key, so a duplicate key error means that the user
+		// has already reported the snippet.
+		if isDuplicateKey(err) {
+			return 0, ErrDuplicateReport
+		}
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(id), nil
+}
+
+// Open() returns the reports which haven't been dealt with yet, oldest first,
+// so that the admin report queue is worked through in order.
+func (m *ReportModel) Open(ctx context.Context) ([]*Report, error) {
+	stmt := `SELECT r.id, r.snippet_id, s.title, r.user_id, u.name, r.reason, r.note, r.status, r.created
+	FROM reports r
+	INNER JOIN snippets s ON s.id = r.snippet_id
+	INNER JOIN users u ON u.id = r.user_id
+	WHERE r.status = 'open'
+	ORDER BY r.created ASC, r.id ASC`
+	rows, err := m.DB.QueryContext(ctx, stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	reports := []*Report{}
+	for rows.Next() {
+		r := &Report{}
+		err = rows.Scan(&r.ID, &r.SnippetID, &r.SnippetTitle, &r.UserID, &r.UserName, &r.Reason, &r.Note, &r.Status, &r.Created)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return reports, nil
+}
+
+// SetStatus() closes an open report, setting its status to ReportResolved or
+// ReportDismissed. If there's no open report with the given ID then
+// ErrNoRecord is returned.
+func (m *ReportModel) SetStatus(ctx context.Context, id int, status string) error {
+	stmt := "UPDATE reports SET status = ? WHERE id = ? AND status = 'open'"
+	result, err := m.DB.ExecContext(ctx, stmt, status, id)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
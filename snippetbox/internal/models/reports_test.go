@@ -0,0 +1,67 @@
+package models
+
+import (
+	"context"
+	"snippetbox/internal/assert"
+	"testing"
+	"time"
+)
+
+func TestReportModel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+
+	db := newTestDB(t)
+	m := ReportModel{db}
+	snippets := SnippetModel{DB: db}
+
+	_, err := db.Exec(`INSERT INTO users (name, email, hashed_password, created, activated)
+	VALUES ('Bob', 'bob@example.com', 'x', UTC_TIMESTAMP(), TRUE)`)
+	assert.NilError(t, err)
+	snippetID, err := snippets.InsertWithTags(ctx, "An old silent pond", "A frog jumps into the pond", 7, 1, nil, VisibilityPublic, "", time.Time{})
+	assert.NilError(t, err)
+
+	first, err := m.Insert(ctx, snippetID, 1, ReportReasonSpam, "")
+	assert.NilError(t, err)
+
+	t.Run("Duplicate report", func(t *testing.T) {
+		_, err := m.Insert(ctx, snippetID, 1, ReportReasonOffensive, "Reporting again")
+		assert.Equal(t, err, ErrDuplicateReport)
+	})
+
+	// A different user can report the same snippet.
+	second, err := m.Insert(ctx, snippetID, 2, ReportReasonOther, "Copied from a book")
+	assert.NilError(t, err)
+
+	t.Run("Open", func(t *testing.T) {
+		reports, err := m.Open(ctx)
+		assert.NilError(t, err)
+		assert.Equal(t, len(reports), 2)
+		assert.Equal(t, reports[0].ID, first)
+		assert.Equal(t, reports[0].SnippetTitle, "An old silent pond")
+		assert.Equal(t, reports[0].UserName, "Alice Jones")
+		assert.Equal(t, reports[0].Reason, ReportReasonSpam)
+		assert.Equal(t, reports[0].Status, ReportOpen)
+		assert.Equal(t, reports[1].ID, second)
+		assert.Equal(t, reports[1].Note, "Copied from a book")
+	})
+
+	t.Run("Set status", func(t *testing.T) {
+		assert.NilError(t, m.SetStatus(ctx, first, ReportDismissed))
+		// Closed reports can't be closed again...
+		assert.Equal(t, m.SetStatus(ctx, first, ReportResolved), ErrNoRecord)
+		assert.Equal(t, m.SetStatus(ctx, second+1, ReportResolved), ErrNoRecord)
+		// ...and drop out of the queue.
+		reports, err := m.Open(ctx)
+		assert.NilError(t, err)
+		assert.Equal(t, len(reports), 1)
+		assert.Equal(t, reports[0].ID, second)
+
+		// Dismissing a report doesn't let the user report the snippet again.
+		_, err = m.Insert(ctx, snippetID, 1, ReportReasonSpam, "")
+		assert.Equal(t, err, ErrDuplicateReport)
+	})
+}
@@ -0,0 +1,63 @@
+package models
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// The MySQL error numbers which mean that a statement failed because of
+// another transaction, rather than because there's anything wrong with it.
+// InnoDB rolls back the whole transaction when it picks it as the victim of a
+// deadlock, and the statement which waited too long for a lock, so trying
+// again is safe.
+const (
+	errDeadlock        = 1213 // ER_LOCK_DEADLOCK
+	errLockWaitTimeout = 1205 // ER_LOCK_WAIT_TIMEOUT
+)
+
+// retryBaseDelay is how long to wait before the first retry. The delay doubles
+// for each retry after that.
+const retryBaseDelay = 10 * time.Millisecond
+
+// isRetryable() reports whether err is a transient database error, which
+// might well not happen if the same operation is tried again.
+func isRetryable(err error) bool {
+	var mySQLError *mysql.MySQLError
+	if errors.As(err, &mySQLError) {
+		return mySQLError.Number == errDeadlock || mySQLError.Number == errLockWaitTimeout
+	}
+	return errors.Is(err, driver.ErrBadConn)
+}
+
+// withRetry() calls fn, and calls it again up to retries more times for as
+// long as it fails with a retryable error, waiting a little longer before
+// each attempt. Any other error is returned straight away. If the context is
+// cancelled while we're waiting then the last error from fn is returned.
+//
+// Only use this for operations which are safe to repeat: reads, and writes
+// made in a single transaction (see WithTx()), which are rolled back as a
+// whole when they fail.
+func withRetry(ctx context.Context, retries int, fn func() error) error {
+	delay := retryBaseDelay
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= retries || !isRetryable(err) {
+			return err
+		}
+		// Add up to 50% of random jitter to the delay, so that the
+		// transactions which deadlocked each other don't retry in lockstep.
+		timer := time.NewTimer(delay + rand.N(delay/2))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
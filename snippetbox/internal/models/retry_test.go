@@ -0,0 +1,113 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"snippetbox/internal/assert"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestWithRetry(t *testing.T) {
+	deadlock := &mysql.MySQLError{Number: errDeadlock, Message: "Deadlock found when trying to get lock"}
+	lockWaitTimeout := &mysql.MySQLError{Number: errLockWaitTimeout, Message: "Lock wait timeout exceeded"}
+	duplicate := &mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}
+
+	// The failing() helper returns a function which fails with each of the
+	// errors in turn and then succeeds, along with a pointer to the number of
+	// times it has been called.
+	failing := func(errs ...error) (func() error, *int) {
+		calls := 0
+		return func() error {
+			calls++
+			if calls <= len(errs) {
+				return errs[calls-1]
+			}
+			return nil
+		}, &calls
+	}
+
+	tests := []struct {
+		name      string
+		retries   int
+		errs      []error
+		wantErr   error
+		wantCalls int
+	}{
+		{
+			name:      "Success",
+			retries:   2,
+			wantCalls: 1,
+		},
+		{
+			name:      "Deadlock",
+			retries:   2,
+			errs:      []error{deadlock},
+			wantCalls: 2,
+		},
+		{
+			name:      "Lock wait timeout and bad connection",
+			retries:   2,
+			errs:      []error{lockWaitTimeout, driver.ErrBadConn},
+			wantCalls: 3,
+		},
+		{
+			name:      "Wrapped deadlock",
+			retries:   2,
+			errs:      []error{fmt.Errorf("models: %w", deadlock)},
+			wantCalls: 2,
+		},
+		{
+			name:      "Out of retries",
+			retries:   2,
+			errs:      []error{deadlock, deadlock, deadlock},
+			wantErr:   deadlock,
+			wantCalls: 3,
+		},
+		{
+			name:      "No retries",
+			retries:   0,
+			errs:      []error{deadlock},
+			wantErr:   deadlock,
+			wantCalls: 1,
+		},
+		{
+			name:      "Not retryable",
+			retries:   2,
+			errs:      []error{duplicate},
+			wantErr:   duplicate,
+			wantCalls: 1,
+		},
+		{
+			name:      "No rows",
+			retries:   2,
+			errs:      []error{sql.ErrNoRows},
+			wantErr:   sql.ErrNoRows,
+			wantCalls: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fn, calls := failing(tt.errs...)
+			err := withRetry(context.Background(), tt.retries, fn)
+			assert.Equal(t, errors.Is(err, tt.wantErr), true)
+			assert.Equal(t, *calls, tt.wantCalls)
+		})
+	}
+
+	t.Run("Cancelled", func(t *testing.T) {
+		// Once the context has been cancelled there's no point waiting to
+		// try again, so the error is returned straight away.
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		fn, calls := failing(deadlock)
+		err := withRetry(ctx, 2, fn)
+		assert.Equal(t, errors.Is(err, deadlock), true)
+		assert.Equal(t, *calls, 1)
+	})
+}
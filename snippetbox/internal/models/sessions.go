@@ -0,0 +1,138 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// SessionPrefixLength is the number of characters of a session token which we
+// expose to users. It's enough to tell their sessions apart and to identify a
+// session to revoke, without revealing the whole token.
+const SessionPrefixLength = 12
+
+type SessionModelInterface interface {
+	Record(ctx context.Context, token string, userID int, expiry time.Time) error
+	ListSessions(ctx context.Context, userID int) ([]*Session, error)
+	Revoke(ctx context.Context, userID int, prefix string) error
+	RevokeOthers(ctx context.Context, userID int, currentToken string) error
+}
+
+var _ SessionModelInterface = (*SessionModel)(nil)
+
+// Session describes one of a user's active login sessions. Only a prefix of
+// the session token is included, never the full value.
+type Session struct {
+	Prefix  string
+	Created time.Time
+	Expiry  time.Time
+}
+
+// SessionModel tracks which sessions in the scs "sessions" table belong to
+// which user. The sessions table itself only holds gob-encoded data, so we
+// record the owner of each session in a separate user_sessions table at login.
+type SessionModel struct {
+	DB *sql.DB
+}
+
+// Record() tags a session token with the ID of the user who has just logged in
+// with it. Any of the user's tags which have expired are removed at the same
+// time, so that the table doesn't grow forever.
+func (m *SessionModel) Record(ctx context.Context, token string, userID int, expiry time.Time) error {
+	stmt := "DELETE FROM user_sessions WHERE user_id = ? AND expiry < UTC_TIMESTAMP()"
+	_, err := m.DB.ExecContext(ctx, stmt, userID)
+	if err != nil {
+		return err
+	}
+	stmt = `INSERT INTO user_sessions (token, user_id, created, expiry)
+	VALUES(?, ?, UTC_TIMESTAMP(), ?)`
+	_, err = m.DB.ExecContext(ctx, stmt, token, userID, expiry.UTC())
+	return err
+}
+
+// ListSessions() returns the user's active sessions, newest first. We join on
+// the scs sessions table so that sessions which have been logged out of,
+// revoked, or have expired in the store are not included.
+func (m *SessionModel) ListSessions(ctx context.Context, userID int) ([]*Session, error) {
+	stmt := `SELECT LEFT(us.token, ?), us.created, s.expiry FROM user_sessions us
+	INNER JOIN sessions s ON s.token = us.token
+	WHERE us.user_id = ? AND s.expiry > UTC_TIMESTAMP(6)
+	ORDER BY us.created DESC`
+	rows, err := m.DB.QueryContext(ctx, stmt, SessionPrefixLength, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sessions := []*Session{}
+	for rows.Next() {
+		s := &Session{}
+		err := rows.Scan(&s.Prefix, &s.Created, &s.Expiry)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// Revoke() deletes the user's session whose token starts with the given
+// prefix, logging that device out. Only sessions which belong to the user can
+// be matched, so if the prefix belongs to somebody else's session (or to no
+// session at all) then ErrNoRecord is returned.
+func (m *SessionModel) Revoke(ctx context.Context, userID int, prefix string) error {
+	if len(prefix) != SessionPrefixLength {
+		return ErrNoRecord
+	}
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var token string
+	stmt := "SELECT token FROM user_sessions WHERE user_id = ? AND LEFT(token, ?) = ?"
+	err = tx.QueryRowContext(ctx, stmt, userID, SessionPrefixLength, prefix).Scan(&token)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		}
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM sessions WHERE token = ?", token)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM user_sessions WHERE token = ?", token)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RevokeOthers() deletes all of the user's sessions except the current one,
+// logging them out everywhere else.
+func (m *SessionModel) RevokeOthers(ctx context.Context, userID int, currentToken string) error {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `DELETE FROM sessions WHERE token IN (
+		SELECT token FROM user_sessions WHERE user_id = ? AND token <> ?
+	)`
+	_, err = tx.ExecContext(ctx, stmt, userID, currentToken)
+	if err != nil {
+		return err
+	}
+	stmt = "DELETE FROM user_sessions WHERE user_id = ? AND token <> ?"
+	_, err = tx.ExecContext(ctx, stmt, userID, currentToken)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,77 @@
+package models
+
+import (
+	"context"
+	"snippetbox/internal/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionModel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+
+	db := newTestDB(t)
+	m := SessionModel{db}
+
+	_, err := db.Exec(`INSERT INTO users (name, email, hashed_password, created, activated)
+	VALUES ('Bob', 'bob@example.com', 'x', UTC_TIMESTAMP(), TRUE)`)
+	assert.NilError(t, err)
+
+	// Create a session in the scs store and tag it with its owner, in the
+	// same way as a login does.
+	login := func(userID int, prefix string) string {
+		token := prefix + strings.Repeat("x", 43-len(prefix))
+		expiry := time.Now().Add(time.Hour)
+		_, err := db.Exec("INSERT INTO sessions (token, data, expiry) VALUES (?, '', ?)", token, expiry.UTC())
+		assert.NilError(t, err)
+		assert.NilError(t, m.Record(ctx, token, userID, expiry))
+		return token
+	}
+
+	current := login(1, "AAAAAAAAAAAA")
+	login(1, "BBBBBBBBBBBB")
+	login(2, "CCCCCCCCCCCC")
+
+	t.Run("List", func(t *testing.T) {
+		sessions, err := m.ListSessions(ctx, 1)
+		assert.NilError(t, err)
+		assert.Equal(t, len(sessions), 2)
+		for _, s := range sessions {
+			assert.Equal(t, len(s.Prefix), SessionPrefixLength)
+		}
+	})
+
+	t.Run("Revoke another user's session", func(t *testing.T) {
+		err := m.Revoke(ctx, 1, "CCCCCCCCCCCC")
+		assert.Equal(t, err, ErrNoRecord)
+		sessions, err := m.ListSessions(ctx, 2)
+		assert.NilError(t, err)
+		assert.Equal(t, len(sessions), 1)
+	})
+
+	t.Run("Revoke own session", func(t *testing.T) {
+		assert.NilError(t, m.Revoke(ctx, 1, "BBBBBBBBBBBB"))
+		sessions, err := m.ListSessions(ctx, 1)
+		assert.NilError(t, err)
+		assert.Equal(t, len(sessions), 1)
+		assert.Equal(t, m.Revoke(ctx, 1, "BBBBBBBBBBBB"), ErrNoRecord)
+	})
+
+	t.Run("Revoke others", func(t *testing.T) {
+		login(1, "DDDDDDDDDDDD")
+		assert.NilError(t, m.RevokeOthers(ctx, 1, current))
+		sessions, err := m.ListSessions(ctx, 1)
+		assert.NilError(t, err)
+		assert.Equal(t, len(sessions), 1)
+		assert.Equal(t, sessions[0].Prefix, "AAAAAAAAAAAA")
+		// The other user's session is untouched.
+		sessions, err = m.ListSessions(ctx, 2)
+		assert.NilError(t, err)
+		assert.Equal(t, len(sessions), 1)
+	})
+}
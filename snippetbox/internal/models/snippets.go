@@ -1,46 +1,191 @@
 package models
 
 import (
+	"context"
+	"crypto/rand"
 	"database/sql"
+	"encoding/base64"
 	"errors"
+	"iter"
+	"strings"
 	"time"
+
+	"github.com/go-sql-driver/mysql"
 )
 
 type SnippetModelInterface interface {
-	Insert(title string, content string, expires int) (int, error)
-	Get(id int) (*Snippet, error)
-	Latest() ([]*Snippet, error)
+	Insert(ctx context.Context, title string, content string, expires int, userID int) (int, error)
+	Get(ctx context.Context, id int, viewerID int) (*Snippet, error)
+	GetByPublicID(ctx context.Context, slug string) (*Snippet, error)
+	LatestForUser(ctx context.Context, userID int, publicOnly bool, offset int, limit int) ([]*Snippet, error)
+	LatestPublic(ctx context.Context) ([]*Snippet, error)
+	LatestPublicPage(ctx context.Context, offset int, limit int, order string) ([]*Snippet, error)
+	CountPublic(ctx context.Context) (int, error)
+	CountForUser(ctx context.Context, userID int, publicOnly bool) (int, error)
+	TitleExistsForUser(ctx context.Context, userID int, title string) (bool, error)
+	Update(ctx context.Context, id int, title string, content string, expires int, visibility string, language string) error
+	Delete(ctx context.Context, id int) error
+	Search(ctx context.Context, query string, offset int, limit int) ([]*Snippet, error)
+	SearchCount(ctx context.Context, query string) (int, error)
+	InsertWithTags(ctx context.Context, title string, content string, expires int, userID int, tags []string, visibility string, language string, publishAt time.Time) (int, error)
+	BulkInsert(ctx context.Context, snippets []InsertParams) ([]int, error)
+	GetTags(ctx context.Context, snippetID int) ([]string, error)
+	TagsForUser(ctx context.Context, userID int) (map[int][]string, error)
+	ByTag(ctx context.Context, tag string) ([]*Snippet, error)
+	FavouritesFor(ctx context.Context, userID int) ([]*Snippet, error)
+	IncrementViews(ctx context.Context, id int) error
+	Restore(ctx context.Context, id int) error
+	Trash(ctx context.Context, userID int) ([]*Snippet, error)
+	AllIDs(ctx context.Context) ([]SnippetRef, error)
+	IterateFor(ctx context.Context, userID int) iter.Seq2[*Snippet, error]
+	Count(ctx context.Context) (int, error)
+	ExpiringSoonForUser(ctx context.Context, userID int, within time.Duration) ([]*Snippet, error)
+	Extend(ctx context.Context, id int, days int) error
+	PurgeExpired(ctx context.Context) (int64, error)
+	SetPinned(ctx context.Context, id int, userID int, pinned bool) error
+	Revisions(ctx context.Context, snippetID int) ([]*SnippetRevision, error)
+	RestoreRevision(ctx context.Context, snippetID int, revisionID int, userID int) error
+}
+
+// Check at compile time that the concrete SnippetModel satisfies the interface
+// which the handlers depend on.
+var _ SnippetModelInterface = (*SnippetModel)(nil)
+
+// NeverExpires can be passed as the expires argument to Insert(),
+// InsertWithTags() or Update() to store a snippet which never expires.
+const NeverExpires = 0
+
+// KeepExpiry can be passed as the expires argument to Update() to leave the
+// snippet's expiry time as it is.
+const KeepExpiry = -1
+
+// The visibility of a snippet. Public snippets are listed on the home page and
+// elsewhere, while private snippets can only be seen by their owner. Unlisted
+// snippets sit in between: they aren't listed anywhere, but anyone who knows
+// their share link (see GetByPublicID()) can view them.
+const (
+	VisibilityPublic   = "public"
+	VisibilityUnlisted = "unlisted"
+	VisibilityPrivate  = "private"
+)
+
+// The orders which LatestPublicPage() can return snippets in: newest first,
+// most viewed first or most favourited first.
+const (
+	OrderNewest         = "newest"
+	OrderMostViewed     = "views"
+	OrderMostFavourited = "favourites"
+)
+
+// SnippetOrders lists the orders in the same order as they're offered to
+// users.
+var SnippetOrders = []string{OrderNewest, OrderMostViewed, OrderMostFavourited}
+
+// orderClauses maps each order to its ORDER BY clause. The order normally
+// comes straight from the query string, so it's never put into the SQL
+// itself; only one of these fixed clauses is. Ties are broken by ID, so that
+// the order is stable from one page to the next.
+var orderClauses = map[string]string{
+	OrderNewest:     "id DESC",
+	OrderMostViewed: "view_count DESC, id DESC",
+	OrderMostFavourited: `(SELECT COUNT(*) FROM snippet_favourites f
+	WHERE f.snippet_id = snippets.id) DESC, id DESC`,
+}
+
+// orderBy() returns the ORDER BY clause for an order, or ErrInvalidOrder if
+// it isn't one of SnippetOrders.
+func orderBy(order string) (string, error) {
+	clause, ok := orderClauses[order]
+	if !ok {
+		return "", ErrInvalidOrder
+	}
+	return clause, nil
 }
 
 // Define a Snippet type to hold the data for an individual snippet. Notice how
 // the fields of the struct correspond to the fields in our MySQL snippets
-// table?
+// table? Expires is the zero time for snippets which never expire. PublicID
+// is the random slug used in the snippet's share link, and Language is the
+// programming language used for syntax highlighting (empty for plain text).
+// PublishAt is the time that a scheduled snippet becomes visible to other
+// users, and is the zero time for snippets which were published straight away.
+// Pinned snippets are listed first on their owner's account page and profile.
 type Snippet struct {
+	ID         int
+	PublicID   string
+	Title      string
+	Content    string
+	Language   string
+	Created    time.Time
+	Expires    time.Time
+	UserID     int
+	ViewCount  int
+	Visibility string
+	PublishAt  time.Time
+	Pinned     bool
+}
+
+// SnippetRef holds just the ID and creation time of a snippet. It's used where
+// we need to list every snippet, and loading their content would be wasteful.
+type SnippetRef struct {
 	ID      int
-	Title   string
-	Content string
 	Created time.Time
-	Expires time.Time
+}
+
+// SnippetRevision is an earlier version of a snippet's title and content.
+// Created is when it was replaced by a newer version.
+type SnippetRevision struct {
+	ID        int
+	SnippetID int
+	Title     string
+	Content   string
+	Created   time.Time
 }
 
 // Define a SnippetModel type which wraps a sql.DB connection pool.
 type SnippetModel struct {
 	DB *sql.DB
+	// Retries is how many more times to try the read-only queries when they
+	// fail with a transient error, like a deadlock or a dropped connection.
+	Retries int
+	// MaxPins is the most snippets which each user can pin. There's no limit
+	// if it's 0.
+	MaxPins int
+	// now returns the current time, which decides whether scheduled snippets
+	// have been published yet. It's time.Now if nil; the tests replace it
+	// to move the clock forward.
+	now func() time.Time
+}
+
+// The publishedBefore() method returns the time to compare the publish_at
+// column with. Scheduled snippets whose publish_at is at or before it are
+// visible to everyone.
+func (m *SnippetModel) publishedBefore() time.Time {
+	if m.now != nil {
+		return m.now().UTC()
+	}
+	return time.Now().UTC()
 }
 
-// This will insert a new snippet into the database.
-func (m *SnippetModel) Insert(title string, content string, expires int) (int, error) {
+// This will insert a new public snippet into the database.
+func (m *SnippetModel) Insert(ctx context.Context, title string, content string, expires int, userID int) (int, error) {
 	// Write the SQL statement we want to execute. I've split it over two lines
 	// for readability (which is why it's surrounded with backquotes instead
 	// of normal double quotes).
-	stmt := `INSERT INTO snippets (title, content, created, expires)
-	VALUES(?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY))`
-	// Use the Exec() method on the embedded connection pool to execute the
-	// statement. The first parameter is the SQL statement, followed by the
-	// title, content and expiry values for the placeholder parameters. This
-	// method returns a sql.Result type, which contains some basic
-	// information about what happened when the statement was executed.
-	result, err := m.DB.Exec(stmt, title, content, expires)
+	stmt := `INSERT INTO snippets (title, content, created, expires, user_id, public_id)
+	VALUES(?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY), ?, ?)`
+	// Use the ExecContext() method on the embedded connection pool to execute
+	// the statement. The first parameter is the context, which lets the query
+	// be cancelled if the request times out. Then comes the SQL statement,
+	// followed by the title, content, expiry, owner and public ID values for
+	// the placeholder parameters. This method returns a sql.Result type, which
+	// contains some basic information about what happened when the statement
+	// was executed. We go through the insertWithSlug() helper, which generates
+	// the public ID and retries with a new one in the unlikely event of a
+	// collision.
+	result, err := insertWithSlug(func(slug string) (sql.Result, error) {
+		return m.DB.ExecContext(ctx, stmt, title, content, expiresArg(expires), userID, slug)
+	})
 	if err != nil {
 		return 0, err
 	}
@@ -55,25 +200,38 @@ func (m *SnippetModel) Insert(title string, content string, expires int) (int, e
 	return int(id), nil
 }
 
-// This will return a specific snippet based on its id.
-func (m *SnippetModel) Get(id int) (*Snippet, error) {
+// This will return a specific snippet based on its id. Private snippets, and
+// scheduled snippets which haven't been published yet, are only returned if
+// viewerID is the ID of their owner; pass 0 for a viewer who isn't logged in.
+// Otherwise the snippet is treated as if it doesn't exist, and ErrNoRecord is
+// returned.
+func (m *SnippetModel) Get(ctx context.Context, id int, viewerID int) (*Snippet, error) {
 	// Write the SQL statement we want to execute. Again, I've split it over two
 	// lines for readability.
-	stmt := `SELECT id, title, content, created, expires FROM snippets
-	WHERE expires > UTC_TIMESTAMP() AND id = ?`
-	// Use the QueryRow() method on the connection pool to execute our
-	// SQL statement, passing in the untrusted id variable as the value for the
-	// placeholder parameter. This returns a pointer to a sql.Row object which
-	// holds the result from the database.
-	row := m.DB.QueryRow(stmt, id)
+	stmt := `SELECT id, public_id, title, content, language, created, expires, user_id, view_count, visibility, publish_at FROM snippets
+	WHERE deleted_at IS NULL AND (expires IS NULL OR expires > UTC_TIMESTAMP()) AND id = ?
+	AND (user_id = ? OR (visibility = 'public' AND (publish_at IS NULL OR publish_at <= ?)))`
 	// Initialize a pointer to a new zeroed Snippet struct.
 	s := &Snippet{}
-	// Use row.Scan() to copy the values from each field in sql.Row to the
-	// corresponding field in the Snippet struct. Notice that the arguments
-	// to row.Scan are *pointers* to the place you want to copy the data into,
-	// and the number of arguments must be exactly the same as the number of
-	// columns returned by your statement.
-	err := row.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+	// The expires column is NULL for snippets which never expire, so we scan
+	// it into a sql.NullTime first. A never-expiring snippet is left with a
+	// zero Expires value. The same goes for publish_at.
+	var expires, publishAt sql.NullTime
+	// The query is run by withRetry(), which tries it again if it fails
+	// because of a deadlock or a dropped connection.
+	err := withRetry(ctx, m.Retries, func() error {
+		// Use the QueryRowContext() method on the connection pool to execute
+		// our SQL statement, passing in the untrusted id variable as the value
+		// for the placeholder parameter. This returns a pointer to a sql.Row
+		// object which holds the result from the database.
+		row := m.DB.QueryRowContext(ctx, stmt, id, viewerID, m.publishedBefore())
+		// Use row.Scan() to copy the values from each field in sql.Row to the
+		// corresponding field in the Snippet struct. Notice that the arguments
+		// to row.Scan are *pointers* to the place you want to copy the data
+		// into, and the number of arguments must be exactly the same as the
+		// number of columns returned by your statement.
+		return row.Scan(&s.ID, &s.PublicID, &s.Title, &s.Content, &s.Language, &s.Created, &expires, &s.UserID, &s.ViewCount, &s.Visibility, &publishAt)
+	})
 	if err != nil {
 		// If the query returns no rows, then row.Scan() will return a
 		// sql.ErrNoRows error. We use the errors.Is() function check for that
@@ -85,57 +243,830 @@ func (m *SnippetModel) Get(id int) (*Snippet, error) {
 			return nil, err
 		}
 	}
+	s.Expires = expires.Time
+	s.PublishAt = publishAt.Time
 	// If everything went OK then return the Snippet object.
 	return s, nil
 }
 
-// This will return the 10 most recently created snippets.
-func (m *SnippetModel) Latest() ([]*Snippet, error) {
-	// Write the SQL statement we want to execute.
-	stmt := `SELECT id, title, content, created, expires FROM snippets
-	WHERE expires > UTC_TIMESTAMP() ORDER BY id DESC LIMIT 10`
-	// Use the Query() method on the connection pool to execute our
-	// SQL statement. This returns a sql.Rows resultset containing the result of
-	// our query.
-	rows, err := m.DB.Query(stmt)
+// GetByPublicID() returns the public or unlisted snippet with the given share
+// link slug. Private snippets and unpublished scheduled snippets are never
+// returned, even to their owner -- they should use the normal
+// /snippet/view/:id page instead.
+func (m *SnippetModel) GetByPublicID(ctx context.Context, slug string) (*Snippet, error) {
+	stmt := `SELECT id, public_id, title, content, language, created, expires, user_id, view_count, visibility FROM snippets
+	WHERE deleted_at IS NULL AND (expires IS NULL OR expires > UTC_TIMESTAMP()) AND public_id = ?
+	AND visibility IN ('public', 'unlisted') AND (publish_at IS NULL OR publish_at <= ?)`
+	s := &Snippet{}
+	var expires sql.NullTime
+	err := m.DB.QueryRowContext(ctx, stmt, slug, m.publishedBefore()).Scan(&s.ID, &s.PublicID, &s.Title, &s.Content, &s.Language, &s.Created, &expires, &s.UserID, &s.ViewCount, &s.Visibility)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+	s.Expires = expires.Time
+	return s, nil
+}
+
+// LatestPublic() returns the 10 most recently created public snippets which
+// have been published. This is what's shown on the home page and in the RSS
+// feed.
+func (m *SnippetModel) LatestPublic(ctx context.Context) ([]*Snippet, error) {
+	stmt := `SELECT id, title, content, created, expires, user_id FROM snippets
+	WHERE deleted_at IS NULL AND (expires IS NULL OR expires > UTC_TIMESTAMP()) AND visibility = 'public'
+	AND (publish_at IS NULL OR publish_at <= ?)
+	ORDER BY id DESC LIMIT 10`
+	var snippets []*Snippet
+	err := withRetry(ctx, m.Retries, func() error {
+		rows, err := m.DB.QueryContext(ctx, stmt, m.publishedBefore())
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		snippets, err = scanSnippets(rows)
+		return err
+	})
+	return snippets, err
+}
+
+// LatestPublicPage() returns a page of the public snippets which have been
+// published, in the given order (one of SnippetOrders). The first page of the
+// newest snippets is the same as LatestPublic() when limit is 10. The offset
+// and limit work in the same way as the SQL OFFSET and LIMIT clauses.
+func (m *SnippetModel) LatestPublicPage(ctx context.Context, offset int, limit int, order string) ([]*Snippet, error) {
+	orderClause, err := orderBy(order)
+	if err != nil {
+		return nil, err
+	}
+	stmt := `SELECT id, title, content, created, expires, user_id FROM snippets
+	WHERE deleted_at IS NULL AND (expires IS NULL OR expires > UTC_TIMESTAMP()) AND visibility = 'public'
+	AND (publish_at IS NULL OR publish_at <= ?)
+	ORDER BY ` + orderClause + ` LIMIT ? OFFSET ?`
+	var snippets []*Snippet
+	err = withRetry(ctx, m.Retries, func() error {
+		rows, err := m.DB.QueryContext(ctx, stmt, m.publishedBefore(), limit, offset)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		snippets, err = scanSnippets(rows)
+		return err
+	})
+	return snippets, err
+}
+
+// CountPublic() returns the total number of snippets which LatestPublicPage()
+// can return, for working out how many pages there are.
+func (m *SnippetModel) CountPublic(ctx context.Context) (int, error) {
+	stmt := `SELECT COUNT(*) FROM snippets
+	WHERE deleted_at IS NULL AND (expires IS NULL OR expires > UTC_TIMESTAMP()) AND visibility = 'public'
+	AND (publish_at IS NULL OR publish_at <= ?)`
+	var count int
+	err := withRetry(ctx, m.Retries, func() error {
+		return m.DB.QueryRowContext(ctx, stmt, m.publishedBefore()).Scan(&count)
+	})
+	return count, err
+}
+
+// This will update the title, content, expiry, visibility and language of an
+// existing snippet. The expiry is reset relative to the current time, in the
+// same way as Insert(), unless expires is KeepExpiry. If the title or content
+// changes, the old ones are saved as a revision first, in the same
+// transaction.
+// Note that MySQL reports zero affected rows when an UPDATE doesn't change any
+// values, so callers should check that the snippet exists with Get() first.
+func (m *SnippetModel) Update(ctx context.Context, id int, title string, content string, expires int, visibility string, language string) error {
+	return WithTx(ctx, m.DB, func(tx *sql.Tx) error {
+		// A snippet which doesn't exist has no revision to save, and the
+		// UPDATE doesn't change anything either.
+		err := saveRevision(ctx, tx, id, title, content)
+		if err != nil && !errors.Is(err, ErrNoRecord) {
+			return err
+		}
+		if expires == KeepExpiry {
+			stmt := `UPDATE snippets SET title = ?, content = ?, visibility = ?, language = ?
+			WHERE id = ? AND deleted_at IS NULL`
+			_, err = tx.ExecContext(ctx, stmt, title, content, visibility, language, id)
+			return err
+		}
+		stmt := `UPDATE snippets SET title = ?, content = ?,
+		expires = DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY), visibility = ?, language = ?
+		WHERE id = ? AND deleted_at IS NULL`
+		_, err = tx.ExecContext(ctx, stmt, title, content, expiresArg(expires), visibility, language, id)
+		return err
+	})
+}
+
+// The saveRevision() helper saves the current title and content of a snippet
+// as a revision, unless they're the same as the new title and content which
+// are about to replace them. The snippet's row stays locked until the
+// transaction ends, so that two edits at once can't both save the same
+// revision. If the snippet doesn't exist or is in the trash then ErrNoRecord
+// is returned.
+func saveRevision(ctx context.Context, tx *sql.Tx, id int, title, content string) error {
+	var oldTitle, oldContent string
+	stmt := "SELECT title, content FROM snippets WHERE id = ? AND deleted_at IS NULL FOR UPDATE"
+	err := tx.QueryRowContext(ctx, stmt, id).Scan(&oldTitle, &oldContent)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		}
+		return err
+	}
+	if oldTitle == title && oldContent == content {
+		return nil
+	}
+	stmt = `INSERT INTO snippet_revisions (snippet_id, title, content, created)
+	VALUES(?, ?, ?, UTC_TIMESTAMP())`
+	_, err = tx.ExecContext(ctx, stmt, id, oldTitle, oldContent)
+	return err
+}
+
+// Revisions() returns the earlier versions of a snippet, newest first. It
+// doesn't check who owns the snippet, so callers must do that first.
+func (m *SnippetModel) Revisions(ctx context.Context, snippetID int) ([]*SnippetRevision, error) {
+	stmt := `SELECT id, snippet_id, title, content, created FROM snippet_revisions
+	WHERE snippet_id = ? ORDER BY id DESC`
+	rows, err := m.DB.QueryContext(ctx, stmt, snippetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	revisions := []*SnippetRevision{}
+	for rows.Next() {
+		r := &SnippetRevision{}
+		err = rows.Scan(&r.ID, &r.SnippetID, &r.Title, &r.Content, &r.Created)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+// RestoreRevision() sets the title and content of one of a user's snippets
+// back to those of one of its revisions. Like any other edit, the title and
+// content which are replaced are saved as a new revision, so restoring can
+// itself be undone. If the snippet doesn't exist, is in the trash or belongs
+// to somebody else, or the revision isn't one of the snippet's, then
+// ErrNoRecord is returned.
+func (m *SnippetModel) RestoreRevision(ctx context.Context, snippetID int, revisionID int, userID int) error {
+	return WithTx(ctx, m.DB, func(tx *sql.Tx) error {
+		var owner int
+		stmt := "SELECT user_id FROM snippets WHERE id = ? AND deleted_at IS NULL"
+		err := tx.QueryRowContext(ctx, stmt, snippetID).Scan(&owner)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrNoRecord
+			}
+			return err
+		}
+		if owner != userID {
+			return ErrNoRecord
+		}
+
+		var title, content string
+		stmt = "SELECT title, content FROM snippet_revisions WHERE id = ? AND snippet_id = ?"
+		err = tx.QueryRowContext(ctx, stmt, revisionID, snippetID).Scan(&title, &content)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrNoRecord
+			}
+			return err
+		}
+
+		err = saveRevision(ctx, tx, snippetID, title, content)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE snippets SET title = ?, content = ? WHERE id = ?", title, content, snippetID)
+		return err
+	})
+}
+
+// This will delete a specific snippet based on its id. If there is no matching
+// snippet then ErrNoRecord is returned. This is a soft-delete: we just set the
+// deleted_at timestamp, so that the snippet can be recovered with Restore()
+// later. All of the read queries ignore soft-deleted snippets.
+func (m *SnippetModel) Delete(ctx context.Context, id int) error {
+	stmt := `UPDATE snippets SET deleted_at = UTC_TIMESTAMP()
+	WHERE id = ? AND deleted_at IS NULL`
+	result, err := m.DB.ExecContext(ctx, stmt, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// Restore() undoes a soft-delete. If the snippet doesn't exist or hasn't been
+// deleted then ErrNoRecord is returned.
+func (m *SnippetModel) Restore(ctx context.Context, id int) error {
+	stmt := `UPDATE snippets SET deleted_at = NULL
+	WHERE id = ? AND deleted_at IS NOT NULL`
+	result, err := m.DB.ExecContext(ctx, stmt, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// LatestForUser() returns a page of the snippets owned by a user, with the
+// pinned snippets first and then newest first. Unless publicOnly is set it
+// includes expired, unlisted, private and scheduled snippets, for showing users
+// their own snippets. With publicOnly set only the snippets which anyone could
+// see are returned, for showing on the user's public profile. Snippets in the
+// trash are always left out. The offset and limit work in the same way as the
+// SQL OFFSET and LIMIT clauses.
+func (m *SnippetModel) LatestForUser(ctx context.Context, userID int, publicOnly bool, offset int, limit int) ([]*Snippet, error) {
+	stmt := `SELECT id, title, content, created, expires, user_id, pinned FROM snippets
+	WHERE deleted_at IS NULL AND user_id = ?`
+	args := []any{userID}
+	if publicOnly {
+		stmt += ` AND visibility = 'public' AND (expires IS NULL OR expires > UTC_TIMESTAMP())
+		AND (publish_at IS NULL OR publish_at <= ?)`
+		args = append(args, m.publishedBefore())
+	}
+	stmt += ` ORDER BY pinned DESC, id DESC LIMIT ? OFFSET ?`
+	rows, err := m.DB.QueryContext(ctx, stmt, append(args, limit, offset)...)
 	if err != nil {
 		return nil, err
 	}
-	// We defer rows.Close() to ensure the sql.Rows resultset is
-	// always properly closed before the Latest() method returns. This defer
-	// statement should come *after* you check for an error from the Query()
-	// method. Otherwise, if Query() returns an error, you'll get a panic
-	// trying to close a nil resultset.
 	defer rows.Close()
-	// Initialize an empty slice to hold the Snippet structs.
 	snippets := []*Snippet{}
-	// Use rows.Next to iterate through the rows in the resultset. This
-	// prepares the first (and then each subsequent) row to be acted on by the
-	// rows.Scan() method. If iteration over all the rows completes then the
-	// resultset automatically closes itself and frees-up the underlying
-	// database connection.
 	for rows.Next() {
-		// Create a pointer to a new zeroed Snippet struct.
 		s := &Snippet{}
-		// Use rows.Scan() to copy the values from each field in the row to the
-		// new Snippet object that we created. Again, the arguments to row.Scan()
-		// must be pointers to the place you want to copy the data into, and the
-		// number of arguments must be exactly the same as the number of
-		// columns returned by your statement.
-		err = rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+		var expires sql.NullTime
+		err := rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &expires, &s.UserID, &s.Pinned)
 		if err != nil {
 			return nil, err
 		}
-		// Append it to the slice of snippets.
+		s.Expires = expires.Time
 		snippets = append(snippets, s)
 	}
-	// When the rows.Next() loop has finished we call rows.Err() to retrieve any
-	// error that was encountered during the iteration. It's important to
-	// call this - don't assume that a successful iteration was completed
-	// over the whole resultset.
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return snippets, nil
+}
+
+// CountForUser() returns the total number of snippets which LatestForUser()
+// can return for the same userID and publicOnly arguments.
+func (m *SnippetModel) CountForUser(ctx context.Context, userID int, publicOnly bool) (int, error) {
+	stmt := `SELECT COUNT(*) FROM snippets WHERE deleted_at IS NULL AND user_id = ?`
+	args := []any{userID}
+	if publicOnly {
+		stmt += ` AND visibility = 'public' AND (expires IS NULL OR expires > UTC_TIMESTAMP())
+		AND (publish_at IS NULL OR publish_at <= ?)`
+		args = append(args, m.publishedBefore())
+	}
+	var count int
+	err := m.DB.QueryRowContext(ctx, stmt, args...).Scan(&count)
+	return count, err
+}
+
+// TitleExistsForUser() reports whether a user already has a snippet with the
+// given title. Like the rest of MySQL's string comparisons with the default
+// collation, the match ignores case (and trailing spaces). Snippets in the
+// trash don't count.
+func (m *SnippetModel) TitleExistsForUser(ctx context.Context, userID int, title string) (bool, error) {
+	var exists bool
+	stmt := "SELECT EXISTS(SELECT true FROM snippets WHERE user_id = ? AND title = ? AND deleted_at IS NULL)"
+	err := m.DB.QueryRowContext(ctx, stmt, userID, title).Scan(&exists)
+	return exists, err
+}
+
+// ExpiringSoonForUser() returns a user's snippets which haven't expired yet
+// but will do within the given duration, soonest first. Snippets which never
+// expire are left out.
+func (m *SnippetModel) ExpiringSoonForUser(ctx context.Context, userID int, within time.Duration) ([]*Snippet, error) {
+	stmt := `SELECT id, title, content, created, expires, user_id FROM snippets
+	WHERE deleted_at IS NULL AND user_id = ? AND expires IS NOT NULL
+	AND expires > UTC_TIMESTAMP() AND expires <= DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND)
+	ORDER BY expires ASC, id ASC`
+	rows, err := m.DB.QueryContext(ctx, stmt, userID, int64(within/time.Second))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanSnippets(rows)
+}
+
+// Extend() pushes a snippet's expiry out to the given number of days from
+// now. It never brings an expiry forward, and it leaves snippets which never
+// expire (or have already expired) alone.
+func (m *SnippetModel) Extend(ctx context.Context, id int, days int) error {
+	stmt := `UPDATE snippets SET expires = DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY)
+	WHERE id = ? AND deleted_at IS NULL AND expires > UTC_TIMESTAMP()
+	AND expires < DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY)`
+	_, err := m.DB.ExecContext(ctx, stmt, days, id, days)
+	return err
+}
+
+// SetPinned() pins or unpins one of a user's snippets. If the snippet doesn't
+// exist, is in the trash or belongs to somebody else then ErrNoRecord is
+// returned. If the user has already pinned m.MaxPins snippets then pinning
+// another returns ErrTooManyPins; pinning a snippet which is already pinned
+// is fine.
+func (m *SnippetModel) SetPinned(ctx context.Context, id int, userID int, pinned bool) error {
+	return WithTx(ctx, m.DB, func(tx *sql.Tx) error {
+		// Lock the user's row first, so that two requests pinning different
+		// snippets at the same time can't both squeeze in under the limit.
+		var exists int
+		err := tx.QueryRowContext(ctx, "SELECT id FROM users WHERE id = ? FOR UPDATE", userID).Scan(&exists)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrNoRecord
+			}
+			return err
+		}
+
+		var current bool
+		stmt := `SELECT pinned FROM snippets
+		WHERE id = ? AND user_id = ? AND deleted_at IS NULL FOR UPDATE`
+		err = tx.QueryRowContext(ctx, stmt, id, userID).Scan(&current)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrNoRecord
+			}
+			return err
+		}
+		if current == pinned {
+			return nil
+		}
+
+		if pinned && m.MaxPins > 0 {
+			var count int
+			stmt = "SELECT COUNT(*) FROM snippets WHERE user_id = ? AND pinned AND deleted_at IS NULL"
+			err = tx.QueryRowContext(ctx, stmt, userID).Scan(&count)
+			if err != nil {
+				return err
+			}
+			if count >= m.MaxPins {
+				return ErrTooManyPins
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE snippets SET pinned = ? WHERE id = ?", pinned, id)
+		return err
+	})
+}
+
+// purgeBatchSize is the most expired snippets which PurgeExpired() deletes in
+// one statement. Deleting them in small batches means that no statement holds
+// its locks for long, so requests which use the snippets table aren't held up
+// even when there's a big backlog to get through.
+const purgeBatchSize = 500
+
+// ExpiredRetentionDays is how long an expired snippet is kept before
+// PurgeExpired() deletes it. Until then its owner can still see it on their
+// account page, and edit it to give it a new expiry.
+const ExpiredRetentionDays = 30
+
+// PurgeExpired() permanently deletes every snippet which expired more than
+// ExpiredRetentionDays ago, along with its tags, comments, reports and
+// favourites (thanks to the ON DELETE CASCADE foreign keys), and returns how
+// many snippets were deleted. Snippets which never expire are left alone, and
+// so are snippets in the trash, which can be restored however long ago they
+// expired. If ctx is cancelled part way through, the batches which have
+// already been deleted stay deleted.
+func (m *SnippetModel) PurgeExpired(ctx context.Context) (int64, error) {
+	stmt := `DELETE FROM snippets
+	WHERE expires < UTC_TIMESTAMP() - INTERVAL ? DAY AND deleted_at IS NULL
+	ORDER BY id LIMIT ?`
+	var total int64
+	for {
+		result, err := m.DB.ExecContext(ctx, stmt, ExpiredRetentionDays, purgeBatchSize)
+		if err != nil {
+			return total, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += n
+		if n < purgeBatchSize {
+			return total, nil
+		}
+	}
+}
+
+// Trash() returns the soft-deleted snippets owned by a user, most recently
+// deleted first. Expired snippets are included too, as they can still be
+// restored.
+func (m *SnippetModel) Trash(ctx context.Context, userID int) ([]*Snippet, error) {
+	stmt := `SELECT id, title, content, created, expires, user_id FROM snippets
+	WHERE deleted_at IS NOT NULL AND user_id = ?
+	ORDER BY deleted_at DESC, id DESC`
+	rows, err := m.DB.QueryContext(ctx, stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanSnippets(rows)
+}
+
+// This will return a page of the unexpired, published public snippets whose
+// title or content contains the given query string, newest first. We use a
+// LIKE match here rather than a FULLTEXT index so that short words and common
+// terms are always matched. The offset and limit work in the same way as the
+// SQL OFFSET and LIMIT clauses.
+func (m *SnippetModel) Search(ctx context.Context, query string, offset int, limit int) ([]*Snippet, error) {
+	stmt := `SELECT id, title, content, created, expires, user_id FROM snippets
+	WHERE deleted_at IS NULL AND (expires IS NULL OR expires > UTC_TIMESTAMP()) AND visibility = 'public'
+	AND (publish_at IS NULL OR publish_at <= ?) AND (title LIKE ? OR content LIKE ?)
+	ORDER BY id DESC LIMIT ? OFFSET ?`
+	pattern := likePattern(query)
+	rows, err := m.DB.QueryContext(ctx, stmt, m.publishedBefore(), pattern, pattern, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanSnippets(rows)
+}
+
+// SearchCount() returns the total number of snippets which Search() can
+// return for the same query.
+func (m *SnippetModel) SearchCount(ctx context.Context, query string) (int, error) {
+	stmt := `SELECT COUNT(*) FROM snippets
+	WHERE deleted_at IS NULL AND (expires IS NULL OR expires > UTC_TIMESTAMP()) AND visibility = 'public'
+	AND (publish_at IS NULL OR publish_at <= ?) AND (title LIKE ? OR content LIKE ?)`
+	pattern := likePattern(query)
+	var count int
+	err := m.DB.QueryRowContext(ctx, stmt, m.publishedBefore(), pattern, pattern).Scan(&count)
+	return count, err
+}
+
+// The likePattern() helper escapes any LIKE wildcard characters in query so
+// that they are matched literally, then wraps it in wildcards to match
+// anywhere.
+func likePattern(query string) string {
+	return "%" + likeEscaper.Replace(query) + "%"
+}
+
+// likeEscaper escapes the special characters in a LIKE pattern.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// InsertParams holds the values for a new snippet, for passing to
+// BulkInsert().
+type InsertParams struct {
+	Title   string
+	Content string
+	// Expires is the number of days until the snippet expires, or
+	// NeverExpires.
+	Expires    int
+	UserID     int
+	Tags       []string
+	Visibility string
+	Language   string
+	// PublishAt is when the snippet should become visible to other users,
+	// or the zero time to publish it straight away.
+	PublishAt time.Time
+}
+
+// This will insert a new snippet with the given visibility and language, along
+// with its tags. If publishAt is not the zero time then the snippet is
+// scheduled, and stays hidden from other users until then. Any tags which don't
+// already exist are created. All the statements are executed inside a
+// transaction, so either everything is inserted or nothing is.
+func (m *SnippetModel) InsertWithTags(ctx context.Context, title string, content string, expires int, userID int, tags []string, visibility string, language string, publishAt time.Time) (int, error) {
+	ids, err := m.BulkInsert(ctx, []InsertParams{{
+		Title:      title,
+		Content:    content,
+		Expires:    expires,
+		UserID:     userID,
+		Tags:       tags,
+		Visibility: visibility,
+		Language:   language,
+		PublishAt:  publishAt,
+	}})
+	if err != nil {
+		return 0, err
+	}
+	return ids[0], nil
+}
+
+// BulkInsert() inserts several snippets (along with their tags) in a single
+// transaction, and returns their IDs in the same order. If inserting any of
+// them fails then none of them are inserted.
+func (m *SnippetModel) BulkInsert(ctx context.Context, snippets []InsertParams) ([]int, error) {
+	ids := make([]int, 0, len(snippets))
+	err := WithTx(ctx, m.DB, func(tx *sql.Tx) error {
+		for _, p := range snippets {
+			id, err := insertSnippet(ctx, tx, p)
+			if err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// The insertSnippet() helper inserts a snippet and its tags as part of a
+// transaction.
+func insertSnippet(ctx context.Context, tx *sql.Tx, p InsertParams) (int, error) {
+	stmt := `INSERT INTO snippets (title, content, created, expires, user_id, visibility, language, publish_at, public_id)
+	VALUES(?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY), ?, ?, ?, ?, ?)`
+	// A failed statement doesn't abort a MySQL transaction, so it's fine for
+	// insertWithSlug() to retry the INSERT inside it.
+	result, err := insertWithSlug(func(slug string) (sql.Result, error) {
+		return tx.ExecContext(ctx, stmt, p.Title, p.Content, expiresArg(p.Expires), p.UserID, p.Visibility, p.Language, publishAtArg(p.PublishAt), slug)
+	})
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	for _, tag := range p.Tags {
+		_, err = tx.ExecContext(ctx, `INSERT IGNORE INTO tags (name) VALUES(?)`, tag)
+		if err != nil {
+			return 0, err
+		}
+		stmt = `INSERT INTO snippet_tags (snippet_id, tag_id)
+		SELECT ?, id FROM tags WHERE name = ?`
+		_, err = tx.ExecContext(ctx, stmt, id, tag)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return int(id), nil
+}
+
+// This will return the names of the tags for a specific snippet, sorted
+// alphabetically.
+func (m *SnippetModel) GetTags(ctx context.Context, snippetID int) ([]string, error) {
+	stmt := `SELECT t.name FROM tags t
+	INNER JOIN snippet_tags st ON st.tag_id = t.id
+	WHERE st.snippet_id = ? ORDER BY t.name`
+	rows, err := m.DB.QueryContext(ctx, stmt, snippetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tags := []string{}
+	for rows.Next() {
+		var tag string
+		err = rows.Scan(&tag)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
 	if err = rows.Err(); err != nil {
 		return nil, err
 	}
-	// If everything went OK then return the Snippets slice.
+	return tags, nil
+}
+
+// TagsForUser() returns the tags of all of a user's snippets which aren't in
+// the trash, keyed by snippet ID. Snippets without tags are left out. Getting
+// them all at once saves calling GetTags() for every snippet when exporting.
+func (m *SnippetModel) TagsForUser(ctx context.Context, userID int) (map[int][]string, error) {
+	stmt := `SELECT st.snippet_id, t.name FROM tags t
+	INNER JOIN snippet_tags st ON st.tag_id = t.id
+	INNER JOIN snippets s ON s.id = st.snippet_id
+	WHERE s.user_id = ? AND s.deleted_at IS NULL ORDER BY st.snippet_id, t.name`
+	rows, err := m.DB.QueryContext(ctx, stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tags := map[int][]string{}
+	for rows.Next() {
+		var id int
+		var tag string
+		err = rows.Scan(&id, &tag)
+		if err != nil {
+			return nil, err
+		}
+		tags[id] = append(tags[id], tag)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// This will return all the unexpired, published public snippets which carry a
+// specific tag, newest first.
+func (m *SnippetModel) ByTag(ctx context.Context, tag string) ([]*Snippet, error) {
+	stmt := `SELECT s.id, s.title, s.content, s.created, s.expires, s.user_id FROM snippets s
+	INNER JOIN snippet_tags st ON st.snippet_id = s.id
+	INNER JOIN tags t ON t.id = st.tag_id
+	WHERE s.deleted_at IS NULL AND (s.expires IS NULL OR s.expires > UTC_TIMESTAMP()) AND s.visibility = 'public'
+	AND (s.publish_at IS NULL OR s.publish_at <= ?) AND t.name = ?
+	ORDER BY s.id DESC`
+	rows, err := m.DB.QueryContext(ctx, stmt, m.publishedBefore(), tag)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanSnippets(rows)
+}
+
+// FavouritesFor() returns the unexpired snippets which a user has saved to
+// their favourites, most recently favourited first. If someone else's snippet
+// has been made private since it was favourited (or hasn't been published
+// yet) then it's left out.
+func (m *SnippetModel) FavouritesFor(ctx context.Context, userID int) ([]*Snippet, error) {
+	stmt := `SELECT s.id, s.title, s.content, s.created, s.expires, s.user_id FROM snippets s
+	INNER JOIN snippet_favourites f ON f.snippet_id = s.id
+	WHERE s.deleted_at IS NULL AND (s.expires IS NULL OR s.expires > UTC_TIMESTAMP()) AND f.user_id = ?
+	AND (s.user_id = ? OR (s.visibility = 'public' AND (s.publish_at IS NULL OR s.publish_at <= ?)))
+	ORDER BY f.created DESC, s.id DESC`
+	rows, err := m.DB.QueryContext(ctx, stmt, userID, userID, m.publishedBefore())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanSnippets(rows)
+}
+
+// IncrementViews() adds one to the view count of a snippet. We do the
+// arithmetic in SQL so that concurrent views can't overwrite each other.
+func (m *SnippetModel) IncrementViews(ctx context.Context, id int) error {
+	stmt := "UPDATE snippets SET view_count = view_count + 1 WHERE id = ?"
+	_, err := m.DB.ExecContext(ctx, stmt, id)
+	return err
+}
+
+// AllIDs() returns the ID and creation time of every unexpired, published
+// public snippet, newest first.
+func (m *SnippetModel) AllIDs(ctx context.Context) ([]SnippetRef, error) {
+	stmt := `SELECT id, created FROM snippets
+	WHERE deleted_at IS NULL AND (expires IS NULL OR expires > UTC_TIMESTAMP()) AND visibility = 'public'
+	AND (publish_at IS NULL OR publish_at <= ?)
+	ORDER BY id DESC`
+	rows, err := m.DB.QueryContext(ctx, stmt, m.publishedBefore())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	refs := []SnippetRef{}
+	for rows.Next() {
+		var ref SnippetRef
+		err = rows.Scan(&ref.ID, &ref.Created)
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, ref)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return refs, nil
+}
+
+// IterateFor() returns an iterator over all of a user's snippets (including
+// expired ones, but not those in the trash), oldest first. Unlike the other
+// methods it doesn't load every snippet into memory at once -- each row is
+// scanned as the caller asks for it, so it's suitable for exporting a large
+// number of snippets. If an error occurs then it's yielded along with a nil
+// snippet, and iteration stops.
+func (m *SnippetModel) IterateFor(ctx context.Context, userID int) iter.Seq2[*Snippet, error] {
+	return func(yield func(*Snippet, error) bool) {
+		stmt := `SELECT id, title, content, created, expires, user_id, visibility, language FROM snippets
+		WHERE deleted_at IS NULL AND user_id = ? ORDER BY id`
+		rows, err := m.DB.QueryContext(ctx, stmt, userID)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		defer rows.Close()
+		for rows.Next() {
+			s := &Snippet{}
+			var expires sql.NullTime
+			err = rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &expires, &s.UserID, &s.Visibility, &s.Language)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			s.Expires = expires.Time
+			if !yield(s, nil) {
+				return
+			}
+		}
+		if err = rows.Err(); err != nil {
+			yield(nil, err)
+		}
+	}
+}
+
+// Count() returns the total number of snippets which haven't been deleted,
+// whatever their visibility and including expired ones.
+func (m *SnippetModel) Count(ctx context.Context) (int, error) {
+	var count int
+	err := m.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM snippets WHERE deleted_at IS NULL").Scan(&count)
+	return count, err
+}
+
+// maxSlugAttempts is the number of times that insertWithSlug() will try to
+// insert a snippet before giving up.
+const maxSlugAttempts = 3
+
+// The generateSlug() function returns a new random public ID for a snippet:
+// 16 bytes from the operating system's CSPRNG, encoded as a 22-character
+// URL-safe base-64 string. That's far too many possibilities to guess, or to
+// collide in practice. It's a variable so that the tests can replace it.
+var generateSlug = func() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// The insertWithSlug() helper calls insert with a newly generated slug. The
+// public_id column is the only unique key on the snippets table apart from the
+// auto-incrementing primary key, so a duplicate key error means that the slug
+// was already taken; in that case we try again with a new one, up to
+// maxSlugAttempts times.
+func insertWithSlug(insert func(slug string) (sql.Result, error)) (sql.Result, error) {
+	var err error
+	for range maxSlugAttempts {
+		var slug string
+		slug, err = generateSlug()
+		if err != nil {
+			return nil, err
+		}
+		var result sql.Result
+		result, err = insert(slug)
+		if !isDuplicateKey(err) {
+			return result, err
+		}
+	}
+	return nil, err
+}
+
+// The isDuplicateKey() helper reports whether an error is a MySQL duplicate
+// key error (code 1062) on any key.
+func isDuplicateKey(err error) bool {
+	var mySQLError *mysql.MySQLError
+	return errors.As(err, &mySQLError) && mySQLError.Number == 1062
+}
+
+// The expiresArg() helper converts a number of days into the placeholder
+// value for the expires column. For NeverExpires we use nil, which makes
+// DATE_ADD() return NULL.
+func expiresArg(days int) any {
+	if days == NeverExpires {
+		return nil
+	}
+	return days
+}
+
+// The publishAtArg() helper converts a publish time into the placeholder value
+// for the publish_at column, using NULL for snippets which are published
+// straight away.
+func publishAtArg(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC()
+}
+
+// The scanSnippets() helper scans every row in a resultset of snippets
+// (selected in the standard column order) into a slice.
+func scanSnippets(rows *sql.Rows) ([]*Snippet, error) {
+	snippets := []*Snippet{}
+	for rows.Next() {
+		s := &Snippet{}
+		var expires sql.NullTime
+		err := rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &expires, &s.UserID)
+		if err != nil {
+			return nil, err
+		}
+		s.Expires = expires.Time
+		snippets = append(snippets, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 	return snippets, nil
 }
@@ -0,0 +1,1316 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"slices"
+	"snippetbox/internal/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnippetModelSearch(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+
+	tests := []struct {
+		name      string
+		query     string
+		wantCount int
+	}{
+		{
+			name:      "Title match",
+			query:     "pond",
+			wantCount: 1,
+		},
+		{
+			name:      "Content match",
+			query:     "frog",
+			wantCount: 2,
+		},
+		{
+			name:      "Case insensitive",
+			query:     "FROG",
+			wantCount: 2,
+		},
+		{
+			name:      "Wildcards are literal",
+			query:     "%",
+			wantCount: 0,
+		},
+		{
+			name:      "No match",
+			query:     "cherry blossom",
+			wantCount: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			m := SnippetModel{DB: db}
+			// Seed a few snippets to search against.
+			seeds := []struct{ title, content string }{
+				{"An old silent pond", "A frog jumps into the pond,\nsplash! Silence again."},
+				{"Over the wintry", "Over the wintry\nforest, winds howl in rage\nwith no leaves to blow."},
+				{"A frog haiku", "The frog sits quietly."},
+			}
+			for _, seed := range seeds {
+				_, err := m.Insert(ctx, seed.title, seed.content, 7, 1)
+				assert.NilError(t, err)
+			}
+
+			snippets, err := m.Search(ctx, tt.query, 0, 10)
+			assert.NilError(t, err)
+			assert.Equal(t, len(snippets), tt.wantCount)
+			count, err := m.SearchCount(ctx, tt.query)
+			assert.NilError(t, err)
+			assert.Equal(t, count, tt.wantCount)
+		})
+	}
+}
+
+func TestSnippetModelTags(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+
+	db := newTestDB(t)
+	m := SnippetModel{DB: db}
+
+	first, err := m.InsertWithTags(ctx, "An old silent pond", "A frog jumps into the pond", 7, 1, []string{"nature", "haiku"}, VisibilityPublic, "", time.Time{})
+	assert.NilError(t, err)
+	// The "haiku" tag already exists, so it should be shared rather than
+	// duplicated.
+	second, err := m.InsertWithTags(ctx, "Over the wintry", "Over the wintry forest", 7, 1, []string{"haiku"}, VisibilityPublic, "", time.Time{})
+	assert.NilError(t, err)
+
+	tags, err := m.GetTags(ctx, first)
+	assert.NilError(t, err)
+	assert.Equal(t, strings.Join(tags, ","), "haiku,nature")
+
+	snippets, err := m.ByTag(ctx, "haiku")
+	assert.NilError(t, err)
+	assert.Equal(t, len(snippets), 2)
+	assert.Equal(t, snippets[0].ID, second)
+
+	snippets, err = m.ByTag(ctx, "nature")
+	assert.NilError(t, err)
+	assert.Equal(t, len(snippets), 1)
+	assert.Equal(t, snippets[0].ID, first)
+}
+
+func TestSnippetModelTagsForUser(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+
+	db := newTestDB(t)
+	m := SnippetModel{DB: db}
+
+	first, err := m.InsertWithTags(ctx, "An old silent pond", "A frog jumps into the pond", 7, 1, []string{"nature", "haiku"}, VisibilityPublic, "", time.Time{})
+	assert.NilError(t, err)
+	_, err = m.InsertWithTags(ctx, "Untagged", "No tags here", 7, 1, nil, VisibilityPublic, "", time.Time{})
+	assert.NilError(t, err)
+	_, err = m.InsertWithTags(ctx, "Someone else's", "Not Alice's snippet", 7, 2, []string{"haiku"}, VisibilityPublic, "", time.Time{})
+	assert.NilError(t, err)
+	deleted, err := m.InsertWithTags(ctx, "Deleted", "Deleted", 7, 1, []string{"gone"}, VisibilityPublic, "", time.Time{})
+	assert.NilError(t, err)
+	assert.NilError(t, m.Delete(ctx, deleted))
+
+	// Only Alice's snippet with tags is in the map, and the tags are sorted.
+	tags, err := m.TagsForUser(ctx, 1)
+	assert.NilError(t, err)
+	assert.Equal(t, len(tags), 1)
+	assert.Equal(t, strings.Join(tags[first], ","), "haiku,nature")
+}
+
+func TestSnippetModelIncrementViews(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+
+	db := newTestDB(t)
+	m := SnippetModel{DB: db}
+
+	id, err := m.Insert(ctx, "An old silent pond", "A frog jumps into the pond", 7, 1)
+	assert.NilError(t, err)
+
+	s, err := m.Get(ctx, id, 0)
+	assert.NilError(t, err)
+	assert.Equal(t, s.ViewCount, 0)
+
+	for i := 0; i < 3; i++ {
+		assert.NilError(t, m.IncrementViews(ctx, id))
+	}
+
+	s, err = m.Get(ctx, id, 0)
+	assert.NilError(t, err)
+	assert.Equal(t, s.ViewCount, 3)
+}
+
+func TestSnippetModelOrders(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+
+	db := newTestDB(t)
+	m := SnippetModel{DB: db}
+	users := UserModel{DB: db}
+
+	first, err := m.Insert(ctx, "First", "Favourited", 7, 1)
+	assert.NilError(t, err)
+	second, err := m.Insert(ctx, "Second", "Viewed twice", 7, 1)
+	assert.NilError(t, err)
+	third, err := m.Insert(ctx, "Third", "Viewed once", 7, 1)
+	assert.NilError(t, err)
+
+	assert.NilError(t, m.IncrementViews(ctx, second))
+	assert.NilError(t, m.IncrementViews(ctx, second))
+	assert.NilError(t, m.IncrementViews(ctx, third))
+	assert.NilError(t, users.AddFavourite(ctx, 1, first))
+
+	tests := []struct {
+		order   string
+		wantIDs []int
+	}{
+		{order: OrderNewest, wantIDs: []int{third, second, first}},
+		{order: OrderMostViewed, wantIDs: []int{second, third, first}},
+		// Ties are broken by ID, newest first.
+		{order: OrderMostFavourited, wantIDs: []int{first, third, second}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.order, func(t *testing.T) {
+			snippets, err := m.LatestPublicPage(ctx, 0, 10, tt.order)
+			assert.NilError(t, err)
+			var ids []int
+			for _, s := range snippets {
+				ids = append(ids, s.ID)
+			}
+			if !slices.Equal(ids, tt.wantIDs) {
+				t.Errorf("got %v; want %v", ids, tt.wantIDs)
+			}
+		})
+	}
+
+	t.Run("Limit", func(t *testing.T) {
+		snippets, err := m.LatestPublicPage(ctx, 0, 2, OrderMostViewed)
+		assert.NilError(t, err)
+		assert.Equal(t, len(snippets), 2)
+		assert.Equal(t, snippets[0].ID, second)
+	})
+
+	// Anything other than the allowed orders is rejected before it gets
+	// anywhere near the SQL.
+	for _, order := range []string{"", "id", "title; DROP TABLE snippets", "NEWEST"} {
+		_, err := m.LatestPublicPage(ctx, 0, 10, order)
+		assert.Equal(t, errors.Is(err, ErrInvalidOrder), true)
+	}
+}
+
+func TestSnippetModelSoftDelete(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+
+	db := newTestDB(t)
+	m := SnippetModel{DB: db}
+
+	kept, err := m.Insert(ctx, "An old silent pond", "A frog jumps into the pond", 7, 1)
+	assert.NilError(t, err)
+	deleted, err := m.Insert(ctx, "Over the wintry", "Over the wintry forest", 7, 1)
+	assert.NilError(t, err)
+
+	assert.NilError(t, m.Delete(ctx, deleted))
+	// Deleting an already deleted snippet is treated as not found.
+	assert.Equal(t, m.Delete(ctx, deleted), ErrNoRecord)
+
+	t.Run("Get", func(t *testing.T) {
+		_, err := m.Get(ctx, deleted, 0)
+		assert.Equal(t, err, ErrNoRecord)
+		_, err = m.Get(ctx, kept, 0)
+		assert.NilError(t, err)
+	})
+
+	t.Run("LatestPublic", func(t *testing.T) {
+		snippets, err := m.LatestPublic(ctx)
+		assert.NilError(t, err)
+		assert.Equal(t, len(snippets), 1)
+		assert.Equal(t, snippets[0].ID, kept)
+	})
+
+	t.Run("Search", func(t *testing.T) {
+		snippets, err := m.Search(ctx, "wintry", 0, 10)
+		assert.NilError(t, err)
+		assert.Equal(t, len(snippets), 0)
+	})
+
+	t.Run("Trash", func(t *testing.T) {
+		snippets, err := m.Trash(ctx, 1)
+		assert.NilError(t, err)
+		assert.Equal(t, len(snippets), 1)
+		assert.Equal(t, snippets[0].ID, deleted)
+
+		snippets, err = m.Trash(ctx, 2)
+		assert.NilError(t, err)
+		assert.Equal(t, len(snippets), 0)
+	})
+
+	t.Run("Restore", func(t *testing.T) {
+		assert.NilError(t, m.Restore(ctx, deleted))
+		s, err := m.Get(ctx, deleted, 0)
+		assert.NilError(t, err)
+		assert.Equal(t, s.Title, "Over the wintry")
+		// Restoring a snippet which isn't deleted is treated as not found.
+		assert.Equal(t, m.Restore(ctx, kept), ErrNoRecord)
+	})
+}
+
+func TestSnippetModelNeverExpires(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+
+	db := newTestDB(t)
+	m := SnippetModel{DB: db}
+
+	never, err := m.Insert(ctx, "An old silent pond", "A frog jumps into the pond", NeverExpires, 1)
+	assert.NilError(t, err)
+	expired, err := m.Insert(ctx, "Over the wintry", "Over the wintry forest", 7, 1)
+	assert.NilError(t, err)
+	_, err = db.Exec("UPDATE snippets SET expires = DATE_SUB(UTC_TIMESTAMP(), INTERVAL 1 SECOND) WHERE id = ?", expired)
+	assert.NilError(t, err)
+
+	s, err := m.Get(ctx, never, 0)
+	assert.NilError(t, err)
+	assert.Equal(t, s.Expires.IsZero(), true)
+
+	_, err = m.Get(ctx, expired, 0)
+	assert.Equal(t, err, ErrNoRecord)
+
+	snippets, err := m.LatestPublic(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, len(snippets), 1)
+	assert.Equal(t, snippets[0].ID, never)
+	assert.Equal(t, snippets[0].Expires.IsZero(), true)
+
+	// Updating the snippet with an expiry makes it expire again.
+	assert.NilError(t, m.Update(ctx, never, "An old silent pond", "A frog jumps into the pond", 1, VisibilityPublic, ""))
+	s, err = m.Get(ctx, never, 0)
+	assert.NilError(t, err)
+	assert.Equal(t, s.Expires.IsZero(), false)
+}
+
+func TestSnippetModelUpdateKeepExpiry(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+
+	db := newTestDB(t)
+	m := SnippetModel{DB: db}
+
+	never, err := m.Insert(ctx, "An old silent pond", "A frog jumps into the pond", NeverExpires, 1)
+	assert.NilError(t, err)
+	expired, err := m.Insert(ctx, "Over the wintry", "Over the wintry forest", 7, 1)
+	assert.NilError(t, err)
+	_, err = db.Exec("UPDATE snippets SET expires = DATE_SUB(UTC_TIMESTAMP(), INTERVAL 1 DAY) WHERE id = ?", expired)
+	assert.NilError(t, err)
+
+	expiresOf := func(id int) sql.NullTime {
+		var expires sql.NullTime
+		err := db.QueryRow("SELECT expires FROM snippets WHERE id = ?", id).Scan(&expires)
+		assert.NilError(t, err)
+		return expires
+	}
+
+	for _, id := range []int{never, expired} {
+		before := expiresOf(id)
+		assert.NilError(t, m.Update(ctx, id, "Edited", "Edited content", KeepExpiry, VisibilityPublic, ""))
+		assert.Equal(t, expiresOf(id), before)
+	}
+
+	// The expired snippet is still hidden after the edit.
+	_, err = m.Get(ctx, expired, 0)
+	assert.Equal(t, err, ErrNoRecord)
+}
+
+func TestSnippetModelAllIDs(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+
+	db := newTestDB(t)
+	m := SnippetModel{DB: db}
+
+	first, err := m.Insert(ctx, "An old silent pond", "A frog jumps into the pond", 7, 1)
+	assert.NilError(t, err)
+	second, err := m.Insert(ctx, "Over the wintry", "Over the wintry forest", NeverExpires, 1)
+	assert.NilError(t, err)
+	expired, err := m.Insert(ctx, "First autumn morning", "First autumn morning", 7, 1)
+	assert.NilError(t, err)
+	_, err = db.Exec("UPDATE snippets SET expires = DATE_SUB(UTC_TIMESTAMP(), INTERVAL 1 SECOND) WHERE id = ?", expired)
+	assert.NilError(t, err)
+	deleted, err := m.Insert(ctx, "Deleted", "Deleted", 7, 1)
+	assert.NilError(t, err)
+	assert.NilError(t, m.Delete(ctx, deleted))
+
+	refs, err := m.AllIDs(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, len(refs), 2)
+	assert.Equal(t, refs[0].ID, second)
+	assert.Equal(t, refs[1].ID, first)
+	assert.Equal(t, refs[0].Created.IsZero(), false)
+}
+
+func TestSnippetModelIterateFor(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+
+	db := newTestDB(t)
+	m := SnippetModel{DB: db}
+
+	first, err := m.Insert(ctx, "An old silent pond", "A frog jumps into the pond", 7, 1)
+	assert.NilError(t, err)
+	second, err := m.Insert(ctx, "Over the wintry", "Over the wintry forest", NeverExpires, 1)
+	assert.NilError(t, err)
+	_, err = m.Insert(ctx, "Someone else's", "Not Alice's snippet", 7, 2)
+	assert.NilError(t, err)
+	deleted, err := m.Insert(ctx, "Deleted", "Deleted", 7, 1)
+	assert.NilError(t, err)
+	assert.NilError(t, m.Delete(ctx, deleted))
+
+	var ids []int
+	for s, err := range m.IterateFor(ctx, 1) {
+		assert.NilError(t, err)
+		assert.Equal(t, s.Visibility, VisibilityPublic)
+		ids = append(ids, s.ID)
+	}
+	assert.Equal(t, len(ids), 2)
+	assert.Equal(t, ids[0], first)
+	assert.Equal(t, ids[1], second)
+
+	// Breaking out of the loop early is fine.
+	for range m.IterateFor(ctx, 1) {
+		break
+	}
+}
+
+func TestSnippetModelVisibility(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+
+	db := newTestDB(t)
+	m := SnippetModel{DB: db}
+
+	public, err := m.InsertWithTags(ctx, "An old silent pond", "A frog jumps into the pond", 7, 1, []string{"haiku"}, VisibilityPublic, "", time.Time{})
+	assert.NilError(t, err)
+	private, err := m.InsertWithTags(ctx, "Over the wintry", "Over the wintry forest", 7, 1, []string{"haiku"}, VisibilityPrivate, "", time.Time{})
+	assert.NilError(t, err)
+
+	t.Run("Owner", func(t *testing.T) {
+		s, err := m.Get(ctx, private, 1)
+		assert.NilError(t, err)
+		assert.Equal(t, s.Visibility, VisibilityPrivate)
+	})
+
+	t.Run("Non-owner", func(t *testing.T) {
+		_, err := m.Get(ctx, private, 2)
+		assert.Equal(t, err, ErrNoRecord)
+		_, err = m.Get(ctx, private, 0)
+		assert.Equal(t, err, ErrNoRecord)
+		s, err := m.Get(ctx, public, 2)
+		assert.NilError(t, err)
+		assert.Equal(t, s.Visibility, VisibilityPublic)
+	})
+
+	t.Run("Listings", func(t *testing.T) {
+		snippets, err := m.LatestPublic(ctx)
+		assert.NilError(t, err)
+		assert.Equal(t, len(snippets), 1)
+		assert.Equal(t, snippets[0].ID, public)
+
+		snippets, err = m.LatestPublicPage(ctx, 0, 10, OrderNewest)
+		assert.NilError(t, err)
+		assert.Equal(t, len(snippets), 1)
+		snippets, err = m.LatestPublicPage(ctx, 1, 10, OrderNewest)
+		assert.NilError(t, err)
+		assert.Equal(t, len(snippets), 0)
+		count, err := m.CountPublic(ctx)
+		assert.NilError(t, err)
+		assert.Equal(t, count, 1)
+
+		snippets, err = m.ByTag(ctx, "haiku")
+		assert.NilError(t, err)
+		assert.Equal(t, len(snippets), 1)
+
+		snippets, err = m.Search(ctx, "wintry", 0, 10)
+		assert.NilError(t, err)
+		assert.Equal(t, len(snippets), 0)
+
+		refs, err := m.AllIDs(ctx)
+		assert.NilError(t, err)
+		assert.Equal(t, len(refs), 1)
+	})
+
+	t.Run("Make public", func(t *testing.T) {
+		assert.NilError(t, m.Update(ctx, private, "Over the wintry", "Over the wintry forest", 7, VisibilityPublic, ""))
+		_, err := m.Get(ctx, private, 2)
+		assert.NilError(t, err)
+	})
+}
+
+func TestSnippetModelScheduled(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+
+	db := newTestDB(t)
+	m := SnippetModel{DB: db}
+
+	// MySQL DATETIME columns only store whole seconds.
+	publishAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	id, err := m.InsertWithTags(ctx, "An old silent pond", "A frog jumps into the pond", 7, 1, []string{"haiku"}, VisibilityPublic, "", publishAt)
+	assert.NilError(t, err)
+
+	tests := []struct {
+		name        string
+		now         time.Time
+		wantVisible bool
+	}{
+		{name: "Before publish time", now: publishAt.Add(-time.Minute), wantVisible: false},
+		{name: "At publish time", now: publishAt, wantVisible: true},
+		{name: "After publish time", now: publishAt.Add(time.Minute), wantVisible: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m.now = func() time.Time { return tt.now }
+
+			// The owner can always see the snippet.
+			s, err := m.Get(ctx, id, 1)
+			assert.NilError(t, err)
+			assert.Equal(t, s.PublishAt.Equal(publishAt), true)
+
+			_, err = m.Get(ctx, id, 2)
+			assert.Equal(t, err == nil, tt.wantVisible)
+			_, err = m.Get(ctx, id, 0)
+			assert.Equal(t, err == nil, tt.wantVisible)
+			_, err = m.GetByPublicID(ctx, s.PublicID)
+			assert.Equal(t, err == nil, tt.wantVisible)
+
+			wantLen := 0
+			if tt.wantVisible {
+				wantLen = 1
+			}
+			snippets, err := m.LatestPublic(ctx)
+			assert.NilError(t, err)
+			assert.Equal(t, len(snippets), wantLen)
+			count, err := m.CountPublic(ctx)
+			assert.NilError(t, err)
+			assert.Equal(t, count, wantLen)
+			snippets, err = m.ByTag(ctx, "haiku")
+			assert.NilError(t, err)
+			assert.Equal(t, len(snippets), wantLen)
+			snippets, err = m.Search(ctx, "frog", 0, 10)
+			assert.NilError(t, err)
+			assert.Equal(t, len(snippets), wantLen)
+			refs, err := m.AllIDs(ctx)
+			assert.NilError(t, err)
+			assert.Equal(t, len(refs), wantLen)
+
+			// It's always in the owner's own list of snippets.
+			snippets, err = m.LatestForUser(ctx, 1, false, 0, 10)
+			assert.NilError(t, err)
+			assert.Equal(t, len(snippets), 1)
+		})
+	}
+}
+
+func TestGenerateSlug(t *testing.T) {
+	slugRX := regexp.MustCompile(`^[A-Za-z0-9_-]{22}$`)
+	seen := make(map[string]bool)
+	for range 1000 {
+		slug, err := generateSlug()
+		assert.NilError(t, err)
+		if !slugRX.MatchString(slug) {
+			t.Fatalf("slug %q is not 22 URL-safe characters", slug)
+		}
+		if seen[slug] {
+			t.Fatalf("slug %q generated twice", slug)
+		}
+		seen[slug] = true
+	}
+}
+
+func TestSnippetModelGetByPublicID(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+
+	db := newTestDB(t)
+	m := SnippetModel{DB: db}
+
+	ids := map[string]int{}
+	for _, visibility := range []string{VisibilityPublic, VisibilityUnlisted, VisibilityPrivate} {
+		id, err := m.InsertWithTags(ctx, "An old silent pond", "A frog jumps into the pond", 7, 1, nil, visibility, "", time.Time{})
+		assert.NilError(t, err)
+		ids[visibility] = id
+	}
+
+	t.Run("Unlisted", func(t *testing.T) {
+		// Unlisted snippets are hidden from everyone but their owner by ID...
+		_, err := m.Get(ctx, ids[VisibilityUnlisted], 2)
+		assert.Equal(t, err, ErrNoRecord)
+		owned, err := m.Get(ctx, ids[VisibilityUnlisted], 1)
+		assert.NilError(t, err)
+		assert.Equal(t, len(owned.PublicID), 22)
+
+		// ...but anyone can find them by their public ID.
+		s, err := m.GetByPublicID(ctx, owned.PublicID)
+		assert.NilError(t, err)
+		assert.Equal(t, s.ID, ids[VisibilityUnlisted])
+		assert.Equal(t, s.Visibility, VisibilityUnlisted)
+	})
+
+	t.Run("Public", func(t *testing.T) {
+		owned, err := m.Get(ctx, ids[VisibilityPublic], 1)
+		assert.NilError(t, err)
+		s, err := m.GetByPublicID(ctx, owned.PublicID)
+		assert.NilError(t, err)
+		assert.Equal(t, s.ID, ids[VisibilityPublic])
+	})
+
+	t.Run("Private", func(t *testing.T) {
+		owned, err := m.Get(ctx, ids[VisibilityPrivate], 1)
+		assert.NilError(t, err)
+		_, err = m.GetByPublicID(ctx, owned.PublicID)
+		assert.Equal(t, err, ErrNoRecord)
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		_, err := m.GetByPublicID(ctx, "AAAAAAAAAAAAAAAAAAAAAA")
+		assert.Equal(t, err, ErrNoRecord)
+	})
+
+	t.Run("Not listed", func(t *testing.T) {
+		snippets, err := m.LatestPublic(ctx)
+		assert.NilError(t, err)
+		assert.Equal(t, len(snippets), 1)
+		assert.Equal(t, snippets[0].ID, ids[VisibilityPublic])
+	})
+}
+
+func TestSnippetModelSlugCollision(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+
+	db := newTestDB(t)
+	m := SnippetModel{DB: db}
+
+	first, err := m.Insert(ctx, "An old silent pond", "A frog jumps into the pond", 7, 1)
+	assert.NilError(t, err)
+	s, err := m.Get(ctx, first, 1)
+	assert.NilError(t, err)
+
+	// Replace the slug generator with one which returns the slug that's
+	// already taken for the first few calls.
+	stubSlugs := func(t *testing.T, taken int) {
+		calls := 0
+		original := generateSlug
+		generateSlug = func() (string, error) {
+			calls++
+			if calls <= taken {
+				return s.PublicID, nil
+			}
+			return original()
+		}
+		t.Cleanup(func() { generateSlug = original })
+	}
+
+	t.Run("Retry", func(t *testing.T) {
+		stubSlugs(t, maxSlugAttempts-1)
+		id, err := m.InsertWithTags(ctx, "Over the wintry", "Over the wintry forest", 7, 1, []string{"haiku"}, VisibilityUnlisted, "", time.Time{})
+		assert.NilError(t, err)
+		got, err := m.Get(ctx, id, 1)
+		assert.NilError(t, err)
+		if got.PublicID == s.PublicID {
+			t.Error("colliding slug was stored")
+		}
+	})
+
+	t.Run("Give up", func(t *testing.T) {
+		stubSlugs(t, maxSlugAttempts)
+		_, err := m.Insert(ctx, "First autumn morning", "First autumn morning", 7, 1)
+		if !isDuplicateKey(err) {
+			t.Errorf("got: %v; want duplicate key error", err)
+		}
+	})
+}
+
+func TestSnippetModelLanguage(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+
+	db := newTestDB(t)
+	m := SnippetModel{DB: db}
+
+	id, err := m.InsertWithTags(ctx, "Hello, world", "package main", 7, 1, nil, VisibilityPublic, "go", time.Time{})
+	assert.NilError(t, err)
+	s, err := m.Get(ctx, id, 0)
+	assert.NilError(t, err)
+	assert.Equal(t, s.Language, "go")
+
+	err = m.Update(ctx, id, "Hello, world", "print('hello')", 7, VisibilityPublic, "python")
+	assert.NilError(t, err)
+	s, err = m.GetByPublicID(ctx, s.PublicID)
+	assert.NilError(t, err)
+	assert.Equal(t, s.Language, "python")
+
+	// Snippets inserted without tags default to plain text.
+	id, err = m.Insert(ctx, "An old silent pond", "A frog jumps into the pond", 7, 1)
+	assert.NilError(t, err)
+	s, err = m.Get(ctx, id, 0)
+	assert.NilError(t, err)
+	assert.Equal(t, s.Language, "")
+}
+
+func TestSnippetModelCount(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+
+	db := newTestDB(t)
+	m := SnippetModel{DB: db}
+
+	_, err := m.Insert(ctx, "An old silent pond", "A frog jumps into the pond", 7, 1)
+	assert.NilError(t, err)
+	_, err = m.InsertWithTags(ctx, "Over the wintry", "Over the wintry forest", 7, 1, nil, VisibilityPrivate, "", time.Time{})
+	assert.NilError(t, err)
+	deleted, err := m.Insert(ctx, "Deleted", "Deleted", 7, 1)
+	assert.NilError(t, err)
+	assert.NilError(t, m.Delete(ctx, deleted))
+
+	count, err := m.Count(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, count, 2)
+}
+
+func TestSnippetModelLatestForUser(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+
+	db := newTestDB(t)
+	m := SnippetModel{DB: db}
+
+	first, err := m.Insert(ctx, "An old silent pond", "A frog jumps into the pond", 7, 1)
+	assert.NilError(t, err)
+	_, err = m.Insert(ctx, "Someone else's", "Not Alice's snippet", 7, 2)
+	assert.NilError(t, err)
+	private, err := m.InsertWithTags(ctx, "Over the wintry", "Over the wintry forest", 7, 1, nil, VisibilityPrivate, "", time.Time{})
+	assert.NilError(t, err)
+	expired, err := m.Insert(ctx, "First autumn morning", "The mirror I stare into", 7, 1)
+	assert.NilError(t, err)
+	_, err = db.Exec("UPDATE snippets SET expires = UTC_TIMESTAMP() - INTERVAL 1 DAY WHERE id = ?", expired)
+	assert.NilError(t, err)
+	deleted, err := m.Insert(ctx, "Deleted", "Deleted", 7, 1)
+	assert.NilError(t, err)
+	assert.NilError(t, m.Delete(ctx, deleted))
+	_, err = m.Insert(ctx, "Someone else's again", "Still not Alice's snippet", 7, 2)
+	assert.NilError(t, err)
+
+	ids := func(snippets []*Snippet) []int {
+		var ids []int
+		for _, s := range snippets {
+			ids = append(ids, s.ID)
+		}
+		return ids
+	}
+
+	t.Run("Only the owner's snippets", func(t *testing.T) {
+		snippets, err := m.LatestForUser(ctx, 1, false, 0, 10)
+		assert.NilError(t, err)
+		got := ids(snippets)
+		assert.Equal(t, len(got), 3)
+		assert.Equal(t, got[0], expired)
+		assert.Equal(t, got[1], private)
+		assert.Equal(t, got[2], first)
+		for _, s := range snippets {
+			assert.Equal(t, s.UserID, 1)
+		}
+		count, err := m.CountForUser(ctx, 1, false)
+		assert.NilError(t, err)
+		assert.Equal(t, count, 3)
+	})
+
+	t.Run("Pagination", func(t *testing.T) {
+		snippets, err := m.LatestForUser(ctx, 1, false, 0, 2)
+		assert.NilError(t, err)
+		assert.Equal(t, len(snippets), 2)
+		assert.Equal(t, snippets[0].ID, expired)
+
+		snippets, err = m.LatestForUser(ctx, 1, false, 2, 2)
+		assert.NilError(t, err)
+		assert.Equal(t, len(snippets), 1)
+		assert.Equal(t, snippets[0].ID, first)
+	})
+
+	t.Run("Public only", func(t *testing.T) {
+		unlisted, err := m.InsertWithTags(ctx, "Unlisted", "Only with the link", 7, 1, nil, VisibilityUnlisted, "", time.Time{})
+		assert.NilError(t, err)
+		scheduled, err := m.InsertWithTags(ctx, "Scheduled", "Not yet", 7, 1, nil, VisibilityPublic, "", time.Now().Add(time.Hour))
+		assert.NilError(t, err)
+
+		// The private, unlisted, scheduled and expired snippets are all
+		// left out.
+		snippets, err := m.LatestForUser(ctx, 1, true, 0, 10)
+		assert.NilError(t, err)
+		got := ids(snippets)
+		assert.Equal(t, len(got), 1)
+		assert.Equal(t, got[0], first)
+		count, err := m.CountForUser(ctx, 1, true)
+		assert.NilError(t, err)
+		assert.Equal(t, count, 1)
+
+		for _, id := range []int{unlisted, scheduled} {
+			_, err = db.Exec("DELETE FROM snippets WHERE id = ?", id)
+			assert.NilError(t, err)
+		}
+	})
+
+	t.Run("No snippets", func(t *testing.T) {
+		snippets, err := m.LatestForUser(ctx, 3, false, 0, 10)
+		assert.NilError(t, err)
+		assert.Equal(t, len(snippets), 0)
+		count, err := m.CountForUser(ctx, 3, false)
+		assert.NilError(t, err)
+		assert.Equal(t, count, 0)
+	})
+}
+
+func TestSnippetModelSetPinned(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+
+	db := newTestDB(t)
+	m := SnippetModel{DB: db, MaxPins: 2}
+
+	oldest, err := m.Insert(ctx, "An old silent pond", "A frog jumps into the pond", 7, 1)
+	assert.NilError(t, err)
+	middle, err := m.Insert(ctx, "Over the wintry", "Over the wintry forest", 7, 1)
+	assert.NilError(t, err)
+	newest, err := m.Insert(ctx, "First autumn morning", "The mirror I stare into", 7, 1)
+	assert.NilError(t, err)
+	others, err := m.Insert(ctx, "Someone else's", "Not Alice's snippet", 7, 2)
+	assert.NilError(t, err)
+
+	// The order() helper returns the IDs of Alice's snippets in the order
+	// which LatestForUser() lists them, along with whether they're pinned.
+	order := func(t *testing.T) ([]int, []bool) {
+		snippets, err := m.LatestForUser(ctx, 1, false, 0, 10)
+		assert.NilError(t, err)
+		var ids []int
+		var pinned []bool
+		for _, s := range snippets {
+			ids = append(ids, s.ID)
+			pinned = append(pinned, s.Pinned)
+		}
+		return ids, pinned
+	}
+
+	t.Run("Ordering", func(t *testing.T) {
+		// Pinned snippets come first, newest first, followed by the rest.
+		assert.NilError(t, m.SetPinned(ctx, oldest, 1, true))
+		assert.NilError(t, m.SetPinned(ctx, middle, 1, true))
+		ids, pinned := order(t)
+		assert.Equal(t, slices.Equal(ids, []int{middle, oldest, newest}), true)
+		assert.Equal(t, slices.Equal(pinned, []bool{true, true, false}), true)
+
+		// Pinning a snippet again changes nothing, even at the limit.
+		assert.NilError(t, m.SetPinned(ctx, middle, 1, true))
+
+		// Unpinned snippets go back to their place by age.
+		assert.NilError(t, m.SetPinned(ctx, middle, 1, false))
+		ids, _ = order(t)
+		assert.Equal(t, slices.Equal(ids, []int{oldest, newest, middle}), true)
+
+		// The public profile is ordered in the same way.
+		snippets, err := m.LatestForUser(ctx, 1, true, 0, 10)
+		assert.NilError(t, err)
+		assert.Equal(t, snippets[0].ID, oldest)
+	})
+
+	t.Run("Limit", func(t *testing.T) {
+		assert.NilError(t, m.SetPinned(ctx, middle, 1, true))
+		err := m.SetPinned(ctx, newest, 1, true)
+		assert.Equal(t, err, ErrTooManyPins)
+		_, pinned := order(t)
+		assert.Equal(t, slices.Equal(pinned, []bool{true, true, false}), true)
+
+		// Snippets in the trash don't count towards the limit.
+		assert.NilError(t, m.Delete(ctx, oldest))
+		assert.NilError(t, m.SetPinned(ctx, newest, 1, true))
+	})
+
+	t.Run("Not the owner", func(t *testing.T) {
+		err := m.SetPinned(ctx, others, 1, true)
+		assert.Equal(t, err, ErrNoRecord)
+		err = m.SetPinned(ctx, 999, 1, true)
+		assert.Equal(t, err, ErrNoRecord)
+		// Nor can a snippet in the trash be pinned.
+		err = m.SetPinned(ctx, oldest, 1, false)
+		assert.Equal(t, err, ErrNoRecord)
+	})
+}
+
+func TestSnippetModelTitleExistsForUser(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+
+	db := newTestDB(t)
+	m := SnippetModel{DB: db}
+
+	_, err := m.Insert(ctx, "An old silent pond", "A frog jumps into the pond", 7, 1)
+	assert.NilError(t, err)
+	deleted, err := m.Insert(ctx, "Over the wintry", "Over the wintry forest", 7, 1)
+	assert.NilError(t, err)
+	assert.NilError(t, m.Delete(ctx, deleted))
+
+	tests := []struct {
+		name   string
+		userID int
+		title  string
+		want   bool
+	}{
+		{"Existing title", 1, "An old silent pond", true},
+		{"Different case", 1, "AN OLD SILENT POND", true},
+		{"Different title", 1, "First autumn morning", false},
+		{"Another user's title", 2, "An old silent pond", false},
+		{"Snippet in the trash", 1, "Over the wintry", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exists, err := m.TitleExistsForUser(ctx, tt.userID, tt.title)
+			assert.NilError(t, err)
+			assert.Equal(t, exists, tt.want)
+		})
+	}
+}
+
+func TestSnippetModelExpiringSoonForUser(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+
+	db := newTestDB(t)
+	m := SnippetModel{DB: db}
+
+	// The setExpiry() helper makes a snippet expire the given number of
+	// seconds from now.
+	setExpiry := func(id int, seconds int) {
+		_, err := db.Exec("UPDATE snippets SET expires = DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND) WHERE id = ?", seconds, id)
+		assert.NilError(t, err)
+	}
+
+	window := 24 * time.Hour
+	// A minute either side of the boundary is plenty to stop the test being
+	// flaky, as the query is run straight afterwards.
+	inside, err := m.Insert(ctx, "Just inside", "Expires just inside the window", 7, 1)
+	assert.NilError(t, err)
+	setExpiry(inside, 24*60*60-60)
+	outside, err := m.Insert(ctx, "Just outside", "Expires just outside the window", 7, 1)
+	assert.NilError(t, err)
+	setExpiry(outside, 24*60*60+60)
+	soonest, err := m.Insert(ctx, "Soonest", "Expires in an hour", 7, 1)
+	assert.NilError(t, err)
+	setExpiry(soonest, 60*60)
+	expired, err := m.Insert(ctx, "Expired", "Expired an hour ago", 7, 1)
+	assert.NilError(t, err)
+	setExpiry(expired, -60*60)
+	_, err = m.Insert(ctx, "Never", "Never expires", NeverExpires, 1)
+	assert.NilError(t, err)
+	deleted, err := m.Insert(ctx, "Deleted", "Deleted", 7, 1)
+	assert.NilError(t, err)
+	setExpiry(deleted, 60*60)
+	assert.NilError(t, m.Delete(ctx, deleted))
+	other, err := m.Insert(ctx, "Someone else's", "Not Alice's snippet", 7, 2)
+	assert.NilError(t, err)
+	setExpiry(other, 60*60)
+
+	snippets, err := m.ExpiringSoonForUser(ctx, 1, window)
+	assert.NilError(t, err)
+	assert.Equal(t, len(snippets), 2)
+	assert.Equal(t, snippets[0].ID, soonest)
+	assert.Equal(t, snippets[1].ID, inside)
+
+	// Widening the window takes in the snippet which was just outside.
+	snippets, err = m.ExpiringSoonForUser(ctx, 1, window+2*time.Minute)
+	assert.NilError(t, err)
+	assert.Equal(t, len(snippets), 3)
+	assert.Equal(t, snippets[2].ID, outside)
+}
+
+func TestSnippetModelExtend(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+
+	db := newTestDB(t)
+	m := SnippetModel{DB: db}
+
+	soon, err := m.Insert(ctx, "Soon", "Expires tomorrow", 1, 1)
+	assert.NilError(t, err)
+	later, err := m.Insert(ctx, "Later", "Expires in a year", 365, 1)
+	assert.NilError(t, err)
+	never, err := m.Insert(ctx, "Never", "Never expires", NeverExpires, 1)
+	assert.NilError(t, err)
+
+	for _, id := range []int{soon, later, never} {
+		assert.NilError(t, m.Extend(ctx, id, 30))
+	}
+
+	expiresIn := func(id int) time.Duration {
+		s, err := m.Get(ctx, id, 1)
+		assert.NilError(t, err)
+		if s.Expires.IsZero() {
+			return 0
+		}
+		return time.Until(s.Expires).Round(24 * time.Hour)
+	}
+	// The snippet expiring tomorrow now expires in 30 days, but the later
+	// one isn't brought forward and the never-expiring one is left alone.
+	assert.Equal(t, expiresIn(soon), 30*24*time.Hour)
+	assert.Equal(t, expiresIn(later), 365*24*time.Hour)
+	assert.Equal(t, expiresIn(never), time.Duration(0))
+}
+
+func TestSnippetModelRevisions(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+
+	db := newTestDB(t)
+	m := SnippetModel{DB: db}
+
+	id, err := m.Insert(ctx, "First draft", "An old pond", 7, 1)
+	assert.NilError(t, err)
+
+	revisions, err := m.Revisions(ctx, id)
+	assert.NilError(t, err)
+	assert.Equal(t, len(revisions), 0)
+
+	// Changing only the expiry or visibility doesn't save a revision, as the
+	// title and content are what the history is for.
+	assert.NilError(t, m.Update(ctx, id, "First draft", "An old pond", 30, VisibilityUnlisted, ""))
+	revisions, err = m.Revisions(ctx, id)
+	assert.NilError(t, err)
+	assert.Equal(t, len(revisions), 0)
+
+	assert.NilError(t, m.Update(ctx, id, "Second draft", "An old silent pond", 7, VisibilityPublic, ""))
+	revisions, err = m.Revisions(ctx, id)
+	assert.NilError(t, err)
+	assert.Equal(t, len(revisions), 1)
+	assert.Equal(t, revisions[0].SnippetID, id)
+	assert.Equal(t, revisions[0].Title, "First draft")
+	assert.Equal(t, revisions[0].Content, "An old pond")
+	first := revisions[0].ID
+
+	t.Run("Restore", func(t *testing.T) {
+		assert.NilError(t, m.RestoreRevision(ctx, id, first, 1))
+
+		s, err := m.Get(ctx, id, 1)
+		assert.NilError(t, err)
+		assert.Equal(t, s.Title, "First draft")
+		assert.Equal(t, s.Content, "An old pond")
+
+		// The version which was replaced is kept too, newest first.
+		revisions, err := m.Revisions(ctx, id)
+		assert.NilError(t, err)
+		assert.Equal(t, len(revisions), 2)
+		assert.Equal(t, revisions[0].Title, "Second draft")
+		assert.Equal(t, revisions[1].ID, first)
+	})
+
+	t.Run("Wrong user", func(t *testing.T) {
+		err := m.RestoreRevision(ctx, id, first, 2)
+		assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+	})
+
+	t.Run("Revision of another snippet", func(t *testing.T) {
+		other, err := m.Insert(ctx, "Other", "Another pond", 7, 1)
+		assert.NilError(t, err)
+		err = m.RestoreRevision(ctx, other, first, 1)
+		assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+	})
+}
+
+func TestSnippetModelPurgeExpired(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+
+	db := newTestDB(t)
+	m := SnippetModel{DB: db}
+	comments := CommentModel{db}
+
+	current, err := m.InsertWithTags(ctx, "Current", "Expires next week", 7, 1, []string{"haiku"}, VisibilityPublic, "", time.Time{})
+	assert.NilError(t, err)
+	never, err := m.Insert(ctx, "Never", "Never expires", NeverExpires, 1)
+	assert.NilError(t, err)
+	expired, err := m.InsertWithTags(ctx, "Expired", "Expired long ago", 7, 1, []string{"haiku"}, VisibilityPublic, "", time.Time{})
+	assert.NilError(t, err)
+	_, err = comments.Insert(ctx, expired, 1, "Gone soon")
+	assert.NilError(t, err)
+	// A snippet which expired recently is kept for a while, as its owner
+	// can still see it.
+	recent, err := m.Insert(ctx, "Recent", "Expired yesterday", 7, 1)
+	assert.NilError(t, err)
+	// Snippets in the trash are kept however long ago they expired, as they
+	// can still be restored.
+	trashed, err := m.Insert(ctx, "Trashed", "Expired and deleted", 7, 1)
+	assert.NilError(t, err)
+	assert.NilError(t, m.Delete(ctx, trashed))
+	_, err = db.Exec("UPDATE snippets SET expires = UTC_TIMESTAMP() - INTERVAL ? DAY WHERE id IN (?, ?)", ExpiredRetentionDays+1, expired, trashed)
+	assert.NilError(t, err)
+	_, err = db.Exec("UPDATE snippets SET expires = UTC_TIMESTAMP() - INTERVAL 1 DAY WHERE id = ?", recent)
+	assert.NilError(t, err)
+
+	n, err := m.PurgeExpired(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, n, int64(1))
+
+	var ids []int
+	rows, err := db.Query("SELECT id FROM snippets ORDER BY id")
+	assert.NilError(t, err)
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		assert.NilError(t, rows.Scan(&id))
+		ids = append(ids, id)
+	}
+	assert.NilError(t, rows.Err())
+	assert.Equal(t, slices.Equal(ids, []int{current, never, recent, trashed}), true)
+
+	trash, err := m.Trash(ctx, 1)
+	assert.NilError(t, err)
+	assert.Equal(t, len(trash), 1)
+	assert.Equal(t, trash[0].ID, trashed)
+
+	// The expired snippet's tags and comments went with it.
+	var tags, remaining int
+	err = db.QueryRow("SELECT COUNT(*) FROM snippet_tags WHERE snippet_id = ?", expired).Scan(&tags)
+	assert.NilError(t, err)
+	assert.Equal(t, tags, 0)
+	err = db.QueryRow("SELECT COUNT(*) FROM comments WHERE snippet_id = ?", expired).Scan(&remaining)
+	assert.NilError(t, err)
+	assert.Equal(t, remaining, 0)
+
+	// There's nothing left to purge the second time round.
+	n, err = m.PurgeExpired(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, n, int64(0))
+}
+
+func TestSnippetModelBulkInsert(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+
+	// The count() helper returns the number of snippets in the database,
+	// including the one which setup.sql inserts.
+	count := func(t *testing.T, m SnippetModel) int {
+		var n int
+		err := m.DB.QueryRow("SELECT COUNT(*) FROM snippets").Scan(&n)
+		assert.NilError(t, err)
+		return n
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		m := SnippetModel{DB: newTestDB(t)}
+		before := count(t, m)
+
+		ids, err := m.BulkInsert(ctx, []InsertParams{
+			{Title: "An old silent pond", Content: "A frog jumps into the pond", Expires: 7, UserID: 1, Tags: []string{"haiku"}, Visibility: VisibilityPublic},
+			{Title: "Over the wintry", Content: "Over the wintry forest", Expires: NeverExpires, UserID: 1, Visibility: VisibilityPrivate, Language: "go"},
+		})
+		assert.NilError(t, err)
+		assert.Equal(t, len(ids), 2)
+		assert.Equal(t, count(t, m), before+2)
+
+		first, err := m.Get(ctx, ids[0], 1)
+		assert.NilError(t, err)
+		assert.Equal(t, first.Title, "An old silent pond")
+		tags, err := m.GetTags(ctx, ids[0])
+		assert.NilError(t, err)
+		assert.Equal(t, len(tags), 1)
+		assert.Equal(t, tags[0], "haiku")
+
+		second, err := m.Get(ctx, ids[1], 1)
+		assert.NilError(t, err)
+		assert.Equal(t, second.Visibility, VisibilityPrivate)
+		assert.Equal(t, second.Language, "go")
+		assert.Equal(t, second.Expires.IsZero(), true)
+	})
+
+	t.Run("Rollback", func(t *testing.T) {
+		m := SnippetModel{DB: newTestDB(t)}
+		before := count(t, m)
+
+		// The second snippet's visibility is too long for its column, so
+		// inserting it fails after the first snippet has been inserted.
+		_, err := m.BulkInsert(ctx, []InsertParams{
+			{Title: "An old silent pond", Content: "A frog jumps into the pond", Expires: 7, UserID: 1, Tags: []string{"rollback"}, Visibility: VisibilityPublic},
+			{Title: "Over the wintry", Content: "Over the wintry forest", Expires: 7, UserID: 1, Visibility: strings.Repeat("x", 11)},
+		})
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+		assert.Equal(t, count(t, m), before)
+		var tags int
+		err = m.DB.QueryRow("SELECT COUNT(*) FROM tags WHERE name = 'rollback'").Scan(&tags)
+		assert.NilError(t, err)
+		assert.Equal(t, tags, 0)
+	})
+}
+
+func TestModelContextCancelled(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	db := newTestDB(t)
+	snippets := SnippetModel{DB: db}
+	users := UserModel{DB: db}
+	before, err := snippets.Count(context.Background())
+	assert.NilError(t, err)
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		query   func(ctx context.Context) error
+		wantErr error
+	}{
+		{
+			name: "Snippet Get",
+			ctx:  cancelled,
+			query: func(ctx context.Context) error {
+				_, err := snippets.Get(ctx, 1, 0)
+				return err
+			},
+			wantErr: context.Canceled,
+		},
+		{
+			name: "Snippet LatestPublicPage",
+			ctx:  expired,
+			query: func(ctx context.Context) error {
+				_, err := snippets.LatestPublicPage(ctx, 0, 10, OrderNewest)
+				return err
+			},
+			wantErr: context.DeadlineExceeded,
+		},
+		{
+			name: "Snippet Insert",
+			ctx:  cancelled,
+			query: func(ctx context.Context) error {
+				_, err := snippets.Insert(ctx, "O snail", "Climb Mount Fuji", 7, 1)
+				return err
+			},
+			wantErr: context.Canceled,
+		},
+		{
+			name: "User Get",
+			ctx:  cancelled,
+			query: func(ctx context.Context) error {
+				_, err := users.Get(ctx, 1)
+				return err
+			},
+			wantErr: context.Canceled,
+		},
+		{
+			name: "User Insert",
+			ctx:  expired,
+			query: func(ctx context.Context) error {
+				_, err := users.Insert(ctx, "Eve", "eve", "eve@example.com", "pa$$word")
+				return err
+			},
+			wantErr: context.DeadlineExceeded,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.query(tt.ctx)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v; want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// Nothing was written to the database.
+	after, err := snippets.Count(context.Background())
+	assert.NilError(t, err)
+	assert.Equal(t, after, before)
+}
@@ -1,3 +1,33 @@
+DROP TABLE recovery_codes;
+
+DROP TABLE user_identities;
+
+DROP TABLE api_tokens;
+
+DROP TABLE password_resets;
+
+DROP TABLE tokens;
+
+DROP TABLE audit_log;
+
+DROP TABLE notifications;
+
+DROP TABLE reports;
+
+DROP TABLE snippet_revisions;
+
+DROP TABLE comments;
+
+DROP TABLE snippet_favourites;
+
+DROP TABLE user_sessions;
+
+DROP TABLE sessions;
+
 DROP TABLE users;
 
+DROP TABLE snippet_tags;
+
+DROP TABLE tags;
+
 DROP TABLE snippets;
\ No newline at end of file
@@ -0,0 +1,70 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"time"
+)
+
+// Define constants for the token scopes. Tokens are stored in a shared table,
+// and the scope records what each token can be used for.
+const (
+	ScopeActivation = "activation"
+)
+
+// activationTTL is how long an account activation token remains valid for.
+const activationTTL = 3 * 24 * time.Hour
+
+// ScopeEmailChange is the scope of tokens which confirm a change of email
+// address. These tokens also store the new address in the tokens.email column.
+const ScopeEmailChange = "email_change"
+
+// emailChangeTTL is how long an email change confirmation token remains valid
+// for.
+const emailChangeTTL = 24 * time.Hour
+
+// APITokenTTL is how long an API bearer token remains valid for.
+const APITokenTTL = 24 * time.Hour
+
+// The generateToken() function returns a new cryptographically random token,
+// along with its SHA-256 hash. The plain-text token is given to the user (e.g.
+// in an email link), and only the hash is stored in the database.
+func generateToken() (string, []byte, error) {
+	// Fill a byte slice with 16 random bytes from the operating system's
+	// CSPRNG.
+	randomBytes := make([]byte, 16)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", nil, err
+	}
+	// Encode the bytes to a base-32 string without padding. This gives a
+	// 26-character token which is safe to use in URLs.
+	plaintext := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	return plaintext, hashToken(plaintext), nil
+}
+
+// The hashToken() function returns the SHA-256 hash of a plain-text token.
+func hashToken(plaintext string) []byte {
+	hash := sha256.Sum256([]byte(plaintext))
+	return hash[:]
+}
+
+// The insertToken() function generates a new token for a user with the given
+// scope and lifetime, and stores its hash in the tokens table as part of the
+// transaction tx. The plain-text token is returned.
+func insertToken(ctx context.Context, tx *sql.Tx, userID int, ttl time.Duration, scope string) (string, error) {
+	token, hash, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+	stmt := `INSERT INTO tokens (hash, user_id, expiry, scope)
+	VALUES(?, ?, DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND), ?)`
+	_, err = tx.ExecContext(ctx, stmt, hash, userID, int(ttl.Seconds()), scope)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
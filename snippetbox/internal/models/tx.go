@@ -0,0 +1,38 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+)
+
+// WithTx() runs fn inside a database transaction. If fn returns nil then the
+// transaction is committed, and if it returns an error (or panics) then it's
+// rolled back, so the statements which fn executes either all take effect or
+// none of them do. fn should use the *sql.Tx which it's given, not db, for
+// everything which should be part of the transaction.
+//
+// A panic in fn is passed on once the transaction has been rolled back, and
+// an error from fn is returned as it is, so that callers can check for errors
+// like ErrNoRecord with errors.Is().
+func WithTx(ctx context.Context, db *sql.DB, fn func(*sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	err = fn(tx)
+	if err != nil {
+		// The error from fn is the interesting one. If rolling back fails too
+		// then the connection is probably broken, and the database discards
+		// the transaction anyway.
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,93 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"snippetbox/internal/assert"
+	"testing"
+)
+
+func TestWithTx(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+
+	db := newTestDB(t)
+
+	// The count() helper returns the number of users in the database,
+	// including the one which setup.sql inserts.
+	count := func(t *testing.T) int {
+		var n int
+		err := db.QueryRow("SELECT COUNT(*) FROM users").Scan(&n)
+		assert.NilError(t, err)
+		return n
+	}
+	// The insertUsers() helper inserts two users as part of tx.
+	insertUsers := func(tx *sql.Tx) error {
+		stmt := `INSERT INTO users (name, email, hashed_password, created, activated)
+		VALUES (?, ?, 'x', UTC_TIMESTAMP(), TRUE)`
+		_, err := tx.ExecContext(ctx, stmt, "Bob", "bob@example.com")
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, stmt, "Carol", "carol@example.com")
+		return err
+	}
+
+	t.Run("Error", func(t *testing.T) {
+		errBoom := errors.New("boom")
+		err := WithTx(ctx, db, func(tx *sql.Tx) error {
+			err := insertUsers(tx)
+			if err != nil {
+				return err
+			}
+			return errBoom
+		})
+		// The error from the callback is returned unchanged, and neither of
+		// the users were inserted.
+		assert.Equal(t, err, errBoom)
+		assert.Equal(t, count(t), 1)
+	})
+
+	t.Run("Failed statement", func(t *testing.T) {
+		err := WithTx(ctx, db, func(tx *sql.Tx) error {
+			err := insertUsers(tx)
+			if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, "INSERT INTO no_such_table (id) VALUES (1)")
+			return err
+		})
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+		assert.Equal(t, count(t), 1)
+	})
+
+	t.Run("Panic", func(t *testing.T) {
+		func() {
+			defer func() {
+				// The panic is passed on once the transaction has been
+				// rolled back.
+				assert.Equal(t, recover(), any("boom"))
+			}()
+			WithTx(ctx, db, func(tx *sql.Tx) error {
+				err := insertUsers(tx)
+				if err != nil {
+					return err
+				}
+				panic("boom")
+			})
+		}()
+		assert.Equal(t, count(t), 1)
+	})
+
+	t.Run("Commit", func(t *testing.T) {
+		err := WithTx(ctx, db, insertUsers)
+		assert.NilError(t, err)
+		assert.Equal(t, count(t), 3)
+	})
+}
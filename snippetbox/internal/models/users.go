@@ -1,9 +1,12 @@
 package models
 
 import (
+	"cmp"
+	"context"
 	"database/sql"
 	"errors"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-sql-driver/mysql"
@@ -11,66 +14,202 @@ import (
 )
 
 type UserModelInterface interface {
-	Insert(name, email, password string) error
-	Authenticate(email, password string) (int, error)
-	Exists(id int) (bool, error)
-	Get(id int) (*User, error)
-	PasswordUpdate(id int, currentPassword, newPassword string) error
+	Insert(ctx context.Context, name, username, email, password string) (string, error)
+	Authenticate(ctx context.Context, email, password string) (int, error)
+	Exists(ctx context.Context, id int) (bool, error)
+	Get(ctx context.Context, id int) (*User, error)
+	PasswordUpdate(ctx context.Context, id int, currentPassword, newPassword string) error
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	GetByUsername(ctx context.Context, username string) (*User, error)
+	CreatePasswordReset(ctx context.Context, userID int) (string, error)
+	ResetPassword(ctx context.Context, token, newPassword string) (int, error)
+	Activate(ctx context.Context, token string) error
+	CreateAPIToken(ctx context.Context, userID int) (string, error)
+	GetForToken(ctx context.Context, token string) (*User, error)
+	AddFavourite(ctx context.Context, userID, snippetID int) error
+	RemoveFavourite(ctx context.Context, userID, snippetID int) error
+	IsFavourite(ctx context.Context, userID, snippetID int) (bool, error)
+	UpdateEmail(ctx context.Context, userID int, newEmail string) error
+	CreateEmailChange(ctx context.Context, userID int, currentPassword, newEmail string) (string, error)
+	ConfirmEmailChange(ctx context.Context, token string) (int, error)
+	Delete(ctx context.Context, userID int) error
+	SetActivated(ctx context.Context, userID int, active bool) error
+	SetRole(ctx context.Context, userID int, role string) error
+	SetTimezone(ctx context.Context, userID int, timezone string) error
+	SetTheme(ctx context.Context, userID int, theme string) error
+	SetUniqueTitles(ctx context.Context, userID int, enabled bool) error
+	SetAvatar(ctx context.Context, userID int, path string) error
+	UpsertOAuthUser(ctx context.Context, provider, providerUserID, email, name string) (int, error)
+	EnableTOTP(ctx context.Context, userID int, secret string) ([]string, error)
+	DisableTOTP(ctx context.Context, userID int) error
+	TOTPSecret(ctx context.Context, userID int) (string, error)
+	UseRecoveryCode(ctx context.Context, userID int, code string) error
+	All(ctx context.Context, offset, limit int) ([]*User, error)
+	Count(ctx context.Context) (int, error)
 }
 
+var _ UserModelInterface = (*UserModel)(nil)
+
+// The roles which a user can have. Admins can moderate other users and their
+// snippets; everyone else is a normal user.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// The UI themes which a user can choose from. ThemeSystem follows the light or
+// dark setting of the user's operating system, and is the default.
+const (
+	ThemeSystem = "system"
+	ThemeLight  = "light"
+	ThemeDark   = "dark"
+)
+
 // Define a new User type. Notice how the field names and types align
-// with the columns in the database "users" table?
+// with the columns in the database "users" table? Username is used in the URL
+// of the user's public profile. It's empty for users who signed up with an
+// OAuth provider, as they haven't chosen one. Avatar is the file name of the
+// user's avatar image, or empty if they haven't uploaded one. UniqueTitles is
+// set if the user doesn't want two of their snippets to have the same title.
+// Theme is the UI theme which the user has chosen.
 type User struct {
 	ID             int
 	Name           string
+	Username       string
+	Avatar         string
 	Email          string
 	HashedPassword []byte
 	Created        time.Time
+	Activated      bool
+	Role           string
+	Timezone       string
+	Theme          string
+	UniqueTitles   bool
+	TOTPEnabled    bool
 }
 
 // Define a new UserModel type which wraps a database connection pool.
 type UserModel struct {
 	DB *sql.DB
+	// Retries is how many more times to try Get(), ResetPassword() and
+	// Activate() when they fail with a transient error, like a deadlock or a
+	// dropped connection.
+	Retries int
+	// BcryptCost is the cost used when hashing passwords. If it's zero then
+	// DefaultBcryptCost is used. Raising it upgrades existing hashes as their
+	// users log in; see Authenticate().
+	BcryptCost int
 }
 
-func (m *UserModel) Insert(name, email, password string) error {
+// DefaultBcryptCost is the bcrypt cost used by a UserModel which doesn't set
+// its own.
+const DefaultBcryptCost = 12
+
+// The hashPassword() method creates a bcrypt hash of a plain-text password,
+// using the model's cost.
+func (m *UserModel) hashPassword(password string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(password), cmp.Or(m.BcryptCost, DefaultBcryptCost))
+}
+
+// Insert() creates a new, unactivated, user along with an activation token.
+// The plain-text activation token is returned so that it can be emailed to the
+// user.
+func (m *UserModel) Insert(ctx context.Context, name, username, email, password string) (string, error) {
 	// Create a bcrypt hash of the plain-text password.
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	hashedPassword, err := m.hashPassword(password)
 	if err != nil {
-		return err
+		return "", err
 	}
-	stmt := `INSERT INTO users (name, email, hashed_password, created)
-	VALUES(?, ?, ?, UTC_TIMESTAMP())`
-	// Use the Exec() method to insert the user details and hashed password
+	// Create the user and their activation token in a transaction, so that we
+	// never end up with a user who has no way of activating their account.
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+	// Check whether the username is taken first, so that we can tell the
+	// user which field is the problem. The unique key on the column still
+	// catches the case where two people sign up with the same username at
+	// the same moment.
+	var exists bool
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT true FROM users WHERE username = ?)", username).Scan(&exists)
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		return "", ErrDuplicateUsername
+	}
+
+	stmt := `INSERT INTO users (name, username, email, hashed_password, created, activated)
+	VALUES(?, ?, ?, ?, UTC_TIMESTAMP(), FALSE)`
+	// Use the ExecContext() method to insert the user details and hashed password
 	// into the users table.
-	_, err = m.DB.Exec(stmt, name, email, string(hashedPassword))
-	if err != nil {
-		// If this returns an error, we use the errors.As() function to check
-		// whether the error has the type *mysql.MySQLError. If it does, the
-		// error will be assigned to the mySQLError variable. We can then check
-		// whether or not the error relates to our users_uc_email key by
-		// checking if the error code equals 1062 and the contents of the error
-		// message string. If it does, we return an ErrDuplicateEmail error.
-		var mySQLError *mysql.MySQLError
-		if errors.As(err, &mySQLError) {
-			if mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "users_uc_email") {
-				return ErrDuplicateEmail
-			}
+	result, err := tx.ExecContext(ctx, stmt, name, username, email, string(hashedPassword))
+	if err != nil {
+		// If this returns an error, we check whether it relates to our
+		// users_uc_email or users_uc_username keys. If it does, we return an
+		// ErrDuplicateEmail or ErrDuplicateUsername error.
+		if isDuplicateEmail(err) {
+			return "", ErrDuplicateEmail
 		}
-		return err
+		if isDuplicateUsername(err) {
+			return "", ErrDuplicateUsername
+		}
+		return "", err
 	}
-	return nil
+	id, err := result.LastInsertId()
+	if err != nil {
+		return "", err
+	}
+	token, err := insertToken(ctx, tx, int(id), activationTTL, ScopeActivation)
+	if err != nil {
+		return "", err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return "", err
+	}
+	return token, nil
 }
 
-func (m *UserModel) Authenticate(email, password string) (int, error) {
+// The isDuplicateEmail() helper reports whether an error is a MySQL duplicate
+// key error on our users_uc_email key. We use the errors.As() function to
+// check whether the error has the type *mysql.MySQLError, then check the error
+// code (1062) and the contents of the error message string.
+func isDuplicateEmail(err error) bool {
+	var mySQLError *mysql.MySQLError
+	if errors.As(err, &mySQLError) {
+		return mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "users_uc_email")
+	}
+	return false
+}
+
+// The isDuplicateUsername() helper does the same for our users_uc_username
+// key.
+func isDuplicateUsername(err error) bool {
+	var mySQLError *mysql.MySQLError
+	if errors.As(err, &mySQLError) {
+		return mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "users_uc_username")
+	}
+	return false
+}
+
+func (m *UserModel) Authenticate(ctx context.Context, email, password string) (int, error) {
 	// Retrieve the id and hashed password associated with the given email. If
 	// no matching email exists we return the ErrInvalidCredentials error.
 	var id int
 	var hashedPassword []byte
-	stmt := "SELECT id, hashed_password FROM users WHERE email = ?"
-	err := m.DB.QueryRow(stmt, email).Scan(&id, &hashedPassword)
+	var activated bool
+	stmt := "SELECT id, hashed_password, activated FROM users WHERE email = ?"
+	err := m.DB.QueryRowContext(ctx, stmt, email).Scan(&id, &hashedPassword, &activated)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
+			// Comparing the password with a hash takes a noticeable amount
+			// of time, so if we returned straight away here an attacker
+			// could tell which email addresses have accounts by timing the
+			// response. Do a comparison against a dummy hash instead, so
+			// that it takes about as long as a wrong password.
+			bcrypt.CompareHashAndPassword(m.dummyHash(), []byte(password))
 			return 0, ErrInvalidCredentials
 		} else {
 			return 0, err
@@ -86,21 +225,76 @@ func (m *UserModel) Authenticate(email, password string) (int, error) {
 			return 0, err
 		}
 	}
-	// Otherwise, the password is correct. Return the user ID.
+	// The password is correct, but we don't allow users to login until they
+	// have verified their email address. Note that we deliberately only check
+	// this after the password, so it doesn't reveal anything to a stranger.
+	if !activated {
+		return 0, ErrAccountNotActivated
+	}
+	// If the hash was made with a lower cost than we use now, this is our only
+	// chance to upgrade it, as it's the only time we have the plain-text
+	// password. The password was correct, so failing to upgrade the hash
+	// shouldn't stop the user from logging in; we'll just try again next time.
+	m.rehashPassword(ctx, id, hashedPassword, password)
+	// Return the user ID.
 	return id, nil
 }
 
-func (m *UserModel) Exists(id int) (bool, error) {
+// dummyHashes holds a bcrypt hash for each cost which has been asked for by
+// dummyHash(), so that each one is only generated once.
+var dummyHashes sync.Map
+
+// The dummyHash() method returns a bcrypt hash made with the model's cost, for
+// Authenticate() to compare passwords against when there isn't a user with the
+// email address. It doesn't matter what the password is, as Authenticate()
+// fails whether or not it matches. The hash is only generated the first time
+// it's needed, as it takes as long to make as any other.
+func (m *UserModel) dummyHash() []byte {
+	cost := cmp.Or(m.BcryptCost, DefaultBcryptCost)
+	if hash, ok := dummyHashes.Load(cost); ok {
+		return hash.([]byte)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte("dummy password"), cost)
+	if err != nil {
+		// This can only fail if the cost is out of range, in which case
+		// hashing a real password would have failed too.
+		return nil
+	}
+	actual, _ := dummyHashes.LoadOrStore(cost, hash)
+	return actual.([]byte)
+}
+
+// The rehashPassword() method replaces a user's password hash with a new one
+// if it was made with a lower cost than the model's. The update only applies
+// if the hash hasn't changed since it was read, so that it can't undo the user
+// changing their password at the same moment.
+func (m *UserModel) rehashPassword(ctx context.Context, id int, hashedPassword []byte, password string) error {
+	cost, err := bcrypt.Cost(hashedPassword)
+	if err != nil || cost >= cmp.Or(m.BcryptCost, DefaultBcryptCost) {
+		return err
+	}
+	newHashedPassword, err := m.hashPassword(password)
+	if err != nil {
+		return err
+	}
+	stmt := "UPDATE users SET hashed_password = ? WHERE id = ? AND hashed_password = ?"
+	_, err = m.DB.ExecContext(ctx, stmt, string(newHashedPassword), id, string(hashedPassword))
+	return err
+}
+
+func (m *UserModel) Exists(ctx context.Context, id int) (bool, error) {
 	var exists bool
 	stmt := "SELECT EXISTS(SELECT true FROM users WHERE id = ?)"
-	err := m.DB.QueryRow(stmt, id).Scan(&exists)
+	err := m.DB.QueryRowContext(ctx, stmt, id).Scan(&exists)
 	return exists, err
 }
 
-func (m *UserModel) Get(id int) (*User, error) {
+func (m *UserModel) Get(ctx context.Context, id int) (*User, error) {
 	user := &User{}
-	stmt := "SELECT id, name, email, created FROM users WHERE id = ?"
-	err := m.DB.QueryRow(stmt, id).Scan(&user.ID, &user.Name, &user.Email, &user.Created)
+	stmt := "SELECT id, name, COALESCE(username, ''), avatar, email, created, activated, role, timezone, theme, unique_titles, totp_secret IS NOT NULL FROM users WHERE id = ?"
+	err := withRetry(ctx, m.Retries, func() error {
+		return m.DB.QueryRowContext(ctx, stmt, id).Scan(&user.ID, &user.Name, &user.Username, &user.Avatar, &user.Email, &user.Created, &user.Activated, &user.Role, &user.Timezone, &user.Theme, &user.UniqueTitles, &user.TOTPEnabled)
+	})
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrNoRecord
@@ -112,10 +306,10 @@ func (m *UserModel) Get(id int) (*User, error) {
 	return user, nil
 }
 
-func (m *UserModel) PasswordUpdate(id int, currentPassword, newPassword string) error {
+func (m *UserModel) PasswordUpdate(ctx context.Context, id int, currentPassword, newPassword string) error {
 	var currentHashedPassword []byte
 	stmt := "SELECT hashed_password FROM users WHERE id = ?"
-	err := m.DB.QueryRow(stmt, id).Scan(&currentHashedPassword)
+	err := m.DB.QueryRowContext(ctx, stmt, id).Scan(&currentHashedPassword)
 	if err != nil {
 		return err
 	}
@@ -123,12 +317,645 @@ func (m *UserModel) PasswordUpdate(id int, currentPassword, newPassword string)
 		return ErrInvalidCredentials
 	}
 
-	newHashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), 12)
+	newHashedPassword, err := m.hashPassword(newPassword)
 	if err != nil {
 		return err
 	}
 
 	stmt = "UPDATE users SET hashed_password = ? WHERE id = ?"
-	_, err = m.DB.Exec(stmt, string(newHashedPassword), id)
+	_, err = m.DB.ExecContext(ctx, stmt, string(newHashedPassword), id)
+	return err
+}
+
+func (m *UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
+	user := &User{}
+	stmt := "SELECT id, name, COALESCE(username, ''), avatar, email, created, activated, role, timezone, theme, unique_titles, totp_secret IS NOT NULL FROM users WHERE email = ?"
+	err := m.DB.QueryRowContext(ctx, stmt, email).Scan(&user.ID, &user.Name, &user.Username, &user.Avatar, &user.Email, &user.Created, &user.Activated, &user.Role, &user.Timezone, &user.Theme, &user.UniqueTitles, &user.TOTPEnabled)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
+
+// GetByUsername() returns the user with the given username. If there is no
+// such user then ErrNoRecord is returned.
+func (m *UserModel) GetByUsername(ctx context.Context, username string) (*User, error) {
+	user := &User{}
+	stmt := "SELECT id, name, COALESCE(username, ''), avatar, email, created, activated, role, timezone, theme, unique_titles, totp_secret IS NOT NULL FROM users WHERE username = ?"
+	err := m.DB.QueryRowContext(ctx, stmt, username).Scan(&user.ID, &user.Name, &user.Username, &user.Avatar, &user.Email, &user.Created, &user.Activated, &user.Role, &user.Timezone, &user.Theme, &user.UniqueTitles, &user.TOTPEnabled)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+// passwordResetTTL is how long a password reset token remains valid for.
+const passwordResetTTL = time.Hour
+
+// CreatePasswordReset() generates a new password reset token for a user and
+// stores its hash, along with an expiry time, in the password_resets table.
+// The plain-text token is returned so that it can be emailed to the user.
+func (m *UserModel) CreatePasswordReset(ctx context.Context, userID int) (string, error) {
+	token, hash, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+	stmt := `INSERT INTO password_resets (hash, user_id, expiry)
+	VALUES(?, ?, DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND))`
+	_, err = m.DB.ExecContext(ctx, stmt, hash, userID, int(passwordResetTTL.Seconds()))
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ResetPassword() sets a new password for the user that a password reset
+// token belongs to, and returns the user's ID. If the token doesn't exist or
+// has expired then ErrInvalidToken is returned. Once used, all outstanding
+// reset tokens for the user are deleted so that the token can't be used again.
+func (m *UserModel) ResetPassword(ctx context.Context, token, newPassword string) (int, error) {
+	newHashedPassword, err := m.hashPassword(newPassword)
+	if err != nil {
+		return 0, err
+	}
+
+	// The whole transaction is rolled back when it fails, so it's safe to
+	// try it again after a deadlock or a dropped connection.
+	var userID int
+	err = withRetry(ctx, m.Retries, func() error {
+		return WithTx(ctx, m.DB, func(tx *sql.Tx) error {
+			stmt := `SELECT user_id FROM password_resets
+			WHERE hash = ? AND expiry > UTC_TIMESTAMP() FOR UPDATE`
+			err := tx.QueryRowContext(ctx, stmt, hashToken(token)).Scan(&userID)
+			if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					return ErrInvalidToken
+				}
+				return err
+			}
+
+			stmt = "UPDATE users SET hashed_password = ? WHERE id = ?"
+			_, err = tx.ExecContext(ctx, stmt, string(newHashedPassword), userID)
+			if err != nil {
+				return err
+			}
+
+			stmt = "DELETE FROM password_resets WHERE user_id = ?"
+			_, err = tx.ExecContext(ctx, stmt, userID)
+			return err
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
+
+// Activate() marks the user that an activation token belongs to as activated.
+// If the token doesn't exist, has expired or has already been used then
+// ErrInvalidToken is returned.
+func (m *UserModel) Activate(ctx context.Context, token string) error {
+	// The whole transaction is rolled back when it fails, so it's safe to
+	// try it again after a deadlock or a dropped connection.
+	return withRetry(ctx, m.Retries, func() error {
+		return WithTx(ctx, m.DB, func(tx *sql.Tx) error {
+			var userID int
+			stmt := `SELECT user_id FROM tokens
+			WHERE hash = ? AND scope = ? AND expiry > UTC_TIMESTAMP() FOR UPDATE`
+			err := tx.QueryRowContext(ctx, stmt, hashToken(token), ScopeActivation).Scan(&userID)
+			if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					return ErrInvalidToken
+				}
+				return err
+			}
+
+			stmt = "UPDATE users SET activated = TRUE WHERE id = ?"
+			_, err = tx.ExecContext(ctx, stmt, userID)
+			if err != nil {
+				return err
+			}
+
+			// Delete all the user's activation tokens, so that they can't be used
+			// again now that the account is active.
+			stmt = "DELETE FROM tokens WHERE user_id = ? AND scope = ?"
+			_, err = tx.ExecContext(ctx, stmt, userID, ScopeActivation)
+			return err
+		})
+	})
+}
+
+// CreateAPIToken() generates a new bearer token for authenticating requests to
+// the JSON API. Only the SHA-256 hash of the token is stored in the database,
+// so the plain-text token returned here can't be recovered later.
+func (m *UserModel) CreateAPIToken(ctx context.Context, userID int) (string, error) {
+	token, hash, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+	stmt := `INSERT INTO api_tokens (hash, user_id, expiry)
+	VALUES(?, ?, DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND))`
+	_, err = m.DB.ExecContext(ctx, stmt, hash, userID, int(APITokenTTL.Seconds()))
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// GetForToken() returns the user that an unexpired API token belongs to. If
+// there is no such token, or the user has been deactivated, then ErrNoRecord
+// is returned.
+func (m *UserModel) GetForToken(ctx context.Context, token string) (*User, error) {
+	user := &User{}
+	stmt := `SELECT u.id, u.name, COALESCE(u.username, ''), u.avatar, u.email, u.created, u.activated, u.role, u.timezone, u.theme, u.unique_titles, u.totp_secret IS NOT NULL FROM users u
+	INNER JOIN api_tokens t ON t.user_id = u.id
+	WHERE t.hash = ? AND t.expiry > UTC_TIMESTAMP() AND u.activated = TRUE`
+	err := m.DB.QueryRowContext(ctx, stmt, hashToken(token)).Scan(&user.ID, &user.Name, &user.Username, &user.Avatar, &user.Email, &user.Created, &user.Activated, &user.Role, &user.Timezone, &user.Theme, &user.UniqueTitles, &user.TOTPEnabled)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
+
+// AddFavourite() saves a snippet to a user's favourites. Favouriting a snippet
+// which is already a favourite is not an error -- the duplicate insert is
+// simply ignored. If the snippet doesn't exist (or has expired) then
+// ErrNoRecord is returned.
+func (m *UserModel) AddFavourite(ctx context.Context, userID, snippetID int) error {
+	var exists bool
+	stmt := "SELECT EXISTS(SELECT true FROM snippets WHERE id = ? AND deleted_at IS NULL AND (expires IS NULL OR expires > UTC_TIMESTAMP()))"
+	err := m.DB.QueryRowContext(ctx, stmt, snippetID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNoRecord
+	}
+	stmt = `INSERT INTO snippet_favourites (user_id, snippet_id, created)
+	VALUES(?, ?, UTC_TIMESTAMP())
+	ON DUPLICATE KEY UPDATE user_id = user_id`
+	_, err = m.DB.ExecContext(ctx, stmt, userID, snippetID)
+	return err
+}
+
+// RemoveFavourite() removes a snippet from a user's favourites. Like
+// AddFavourite() it is idempotent, so removing a snippet which isn't a
+// favourite does nothing.
+func (m *UserModel) RemoveFavourite(ctx context.Context, userID, snippetID int) error {
+	stmt := "DELETE FROM snippet_favourites WHERE user_id = ? AND snippet_id = ?"
+	_, err := m.DB.ExecContext(ctx, stmt, userID, snippetID)
+	return err
+}
+
+// IsFavourite() reports whether the user has saved the snippet to their
+// favourites.
+func (m *UserModel) IsFavourite(ctx context.Context, userID, snippetID int) (bool, error) {
+	var exists bool
+	stmt := "SELECT EXISTS(SELECT true FROM snippet_favourites WHERE user_id = ? AND snippet_id = ?)"
+	err := m.DB.QueryRowContext(ctx, stmt, userID, snippetID).Scan(&exists)
+	return exists, err
+}
+
+// UpdateEmail() changes the email address of a user. If another user already
+// has the new address then ErrDuplicateEmail is returned.
+func (m *UserModel) UpdateEmail(ctx context.Context, userID int, newEmail string) error {
+	stmt := "UPDATE users SET email = ? WHERE id = ?"
+	_, err := m.DB.ExecContext(ctx, stmt, newEmail, userID)
+	if err != nil {
+		if isDuplicateEmail(err) {
+			return ErrDuplicateEmail
+		}
+		return err
+	}
+	return nil
+}
+
+// CreateEmailChange() starts changing a user's email address. The user's
+// current password must be given to confirm that it's really them, otherwise
+// ErrInvalidCredentials is returned. If the new address is already in use then
+// ErrDuplicateEmail is returned. The change isn't made yet -- instead we store
+// the new address alongside a token, and return the plain-text token so that
+// it can be emailed to the new address for confirmation.
+func (m *UserModel) CreateEmailChange(ctx context.Context, userID int, currentPassword, newEmail string) (string, error) {
+	var hashedPassword []byte
+	stmt := "SELECT hashed_password FROM users WHERE id = ?"
+	err := m.DB.QueryRowContext(ctx, stmt, userID).Scan(&hashedPassword)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrNoRecord
+		}
+		return "", err
+	}
+	if bcrypt.CompareHashAndPassword(hashedPassword, []byte(currentPassword)) != nil {
+		return "", ErrInvalidCredentials
+	}
+
+	var exists bool
+	stmt = "SELECT EXISTS(SELECT true FROM users WHERE email = ?)"
+	err = m.DB.QueryRowContext(ctx, stmt, newEmail).Scan(&exists)
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		return "", ErrDuplicateEmail
+	}
+
+	token, hash, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+	stmt = `INSERT INTO tokens (hash, user_id, expiry, scope, email)
+	VALUES(?, ?, DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND), ?, ?)`
+	_, err = m.DB.ExecContext(ctx, stmt, hash, userID, int(emailChangeTTL.Seconds()), ScopeEmailChange, newEmail)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ConfirmEmailChange() completes a change of email address using the token from
+// the confirmation email, and returns the user's ID. If the token doesn't
+// exist, has expired or has already been used then ErrInvalidToken is returned.
+// If somebody else has taken the new address in the meantime then
+// ErrDuplicateEmail is returned.
+func (m *UserModel) ConfirmEmailChange(ctx context.Context, token string) (int, error) {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var userID int
+	var newEmail string
+	stmt := `SELECT user_id, email FROM tokens
+	WHERE hash = ? AND scope = ? AND expiry > UTC_TIMESTAMP() FOR UPDATE`
+	err = tx.QueryRowContext(ctx, stmt, hashToken(token), ScopeEmailChange).Scan(&userID, &newEmail)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrInvalidToken
+		}
+		return 0, err
+	}
+
+	stmt = "UPDATE users SET email = ? WHERE id = ?"
+	_, err = tx.ExecContext(ctx, stmt, newEmail, userID)
+	if err != nil {
+		if isDuplicateEmail(err) {
+			return 0, ErrDuplicateEmail
+		}
+		return 0, err
+	}
+
+	// Delete all the user's outstanding email change tokens, so that an older
+	// request can't be used to switch the address back.
+	stmt = "DELETE FROM tokens WHERE user_id = ? AND scope = ?"
+	_, err = tx.ExecContext(ctx, stmt, userID, ScopeEmailChange)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
+
+// Delete() permanently removes a user and everything that belongs to them. We
+// delete the user's snippets outright rather than reassigning them to a
+// placeholder account, because a snippet's content is just as much personal
+// data as the user record itself. Their tags and favourites go with them via
+// ON DELETE CASCADE, as do the user's tokens, favourites, session records,
+// OAuth identities and recovery codes.
+// The user's rows in the scs sessions table aren't linked by a foreign key, so
+// we delete those explicitly to log out all of their devices. Everything
+// happens in one transaction, so a failure part-way through can't leave a
+// half-deleted account behind. If the user doesn't exist then ErrNoRecord is
+// returned.
+func (m *UserModel) Delete(ctx context.Context, userID int) error {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM snippets WHERE user_id = ?", userID)
+	if err != nil {
+		return err
+	}
+
+	stmt := "DELETE FROM sessions WHERE token IN (SELECT token FROM user_sessions WHERE user_id = ?)"
+	_, err = tx.ExecContext(ctx, stmt, userID)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM users WHERE id = ?", userID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNoRecord
+	}
+
+	return tx.Commit()
+}
+
+// SetActivated() activates or deactivates a user. Deactivated users can't log
+// in (Authenticate() returns ErrAccountNotActivated), and deactivating a user
+// also deletes their sessions and API tokens so that they're logged out of
+// every device, and locked out of the API, straight away. Note that MySQL
+// reports zero affected rows when an UPDATE doesn't change anything, so callers
+// should check that the user exists with Get() first.
+func (m *UserModel) SetActivated(ctx context.Context, userID int, active bool) error {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "UPDATE users SET activated = ? WHERE id = ?", active, userID)
+	if err != nil {
+		return err
+	}
+
+	if !active {
+		stmt := "DELETE FROM sessions WHERE token IN (SELECT token FROM user_sessions WHERE user_id = ?)"
+		_, err = tx.ExecContext(ctx, stmt, userID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "DELETE FROM user_sessions WHERE user_id = ?", userID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "DELETE FROM api_tokens WHERE user_id = ?", userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// SetRole() changes the role of a user to RoleUser or RoleAdmin. As with
+// SetActivated(), callers should check that the user exists first.
+func (m *UserModel) SetRole(ctx context.Context, userID int, role string) error {
+	_, err := m.DB.ExecContext(ctx, "UPDATE users SET role = ? WHERE id = ?", role, userID)
+	return err
+}
+
+// SetTimezone() changes the IANA time zone name (like "Europe/London") which
+// a user's dates are displayed in. It's up to the caller to check that the
+// name is one which time.LoadLocation() understands.
+func (m *UserModel) SetTimezone(ctx context.Context, userID int, timezone string) error {
+	_, err := m.DB.ExecContext(ctx, "UPDATE users SET timezone = ? WHERE id = ?", timezone, userID)
+	return err
+}
+
+// SetTheme() changes the user's UI theme, which is ThemeSystem, ThemeLight or
+// ThemeDark.
+func (m *UserModel) SetTheme(ctx context.Context, userID int, theme string) error {
+	_, err := m.DB.ExecContext(ctx, "UPDATE users SET theme = ? WHERE id = ?", theme, userID)
+	return err
+}
+
+// SetUniqueTitles() turns the user's preference for unique snippet titles on
+// or off. It only affects snippets created or renamed afterwards.
+func (m *UserModel) SetUniqueTitles(ctx context.Context, userID int, enabled bool) error {
+	_, err := m.DB.ExecContext(ctx, "UPDATE users SET unique_titles = ? WHERE id = ?", enabled, userID)
 	return err
 }
+
+// SetAvatar() records the file name of a user's avatar image. The image itself
+// is stored outside the database, so it's up to the caller to save it first.
+func (m *UserModel) SetAvatar(ctx context.Context, userID int, path string) error {
+	_, err := m.DB.ExecContext(ctx, "UPDATE users SET avatar = ? WHERE id = ?", path, userID)
+	return err
+}
+
+// UpsertOAuthUser() returns the ID of the user who logs in with the given
+// account at an OAuth provider (like "github" or "google"), creating the user
+// if necessary. The provider accounts which each user has logged in with are
+// recorded in the user_identities table, so one user can log in with several
+// providers:
+//
+//   - If the provider account has been used before, its user is returned.
+//   - Otherwise, if a user with the same email address exists, the provider
+//     account is linked to them. The email address must have been verified by
+//     the provider, or anyone could take over an account by registering its
+//     email address with the provider.
+//   - Otherwise, a new user is created. They're activated straight away, as
+//     the provider has already verified their email address, and are given a
+//     random password which they can change with a password reset.
+//
+// Note that linking to an existing user doesn't activate them, so callers must
+// still check that the user is activated before logging them in.
+func (m *UserModel) UpsertOAuthUser(ctx context.Context, provider, providerUserID, email, name string) (int, error) {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	stmt := "SELECT user_id FROM user_identities WHERE provider = ? AND provider_user_id = ?"
+	err = tx.QueryRowContext(ctx, stmt, provider, providerUserID).Scan(&id)
+	if err == nil {
+		return id, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+
+	err = tx.QueryRowContext(ctx, "SELECT id FROM users WHERE email = ?", email).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		password, _, err := generateToken()
+		if err != nil {
+			return 0, err
+		}
+		hashedPassword, err := m.hashPassword(password)
+		if err != nil {
+			return 0, err
+		}
+		stmt = `INSERT INTO users (name, email, hashed_password, created, activated)
+		VALUES(?, ?, ?, UTC_TIMESTAMP(), TRUE)`
+		result, err := tx.ExecContext(ctx, stmt, name, email, string(hashedPassword))
+		if err != nil {
+			if isDuplicateEmail(err) {
+				return 0, ErrDuplicateEmail
+			}
+			return 0, err
+		}
+		newID, err := result.LastInsertId()
+		if err != nil {
+			return 0, err
+		}
+		id = int(newID)
+	} else if err != nil {
+		return 0, err
+	}
+
+	stmt = `INSERT INTO user_identities (provider, provider_user_id, user_id, created)
+	VALUES(?, ?, ?, UTC_TIMESTAMP())`
+	_, err = tx.ExecContext(ctx, stmt, provider, providerUserID, id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+// recoveryCodeCount is the number of recovery codes which a user is given when
+// they turn on two-factor authentication.
+const recoveryCodeCount = 10
+
+// EnableTOTP() turns on two-factor authentication for a user, with the given
+// TOTP secret. It also replaces any recovery codes which the user already has
+// with a new set, which can each be used once to log in instead of a TOTP code
+// (if the user loses their phone, say). Only the hashes of the recovery codes
+// are stored, so the plain-text codes are returned for showing to the user.
+//
+// Unlike a password, the TOTP secret has to be stored as it is, because we
+// need it to work out the codes which the user's authenticator app generates.
+func (m *UserModel) EnableTOTP(ctx context.Context, userID int, secret string) ([]string, error) {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "UPDATE users SET totp_secret = ? WHERE id = ?", secret, userID)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, hash, err := generateToken()
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.ExecContext(ctx, "INSERT INTO recovery_codes (hash, user_id) VALUES (?, ?)", hash, userID)
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTOTP() turns off two-factor authentication for a user, and deletes
+// their TOTP secret and recovery codes.
+func (m *UserModel) DisableTOTP(ctx context.Context, userID int) error {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "UPDATE users SET totp_secret = NULL WHERE id = ?", userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = ?", userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// TOTPSecret() returns a user's TOTP secret, or the empty string if they
+// haven't turned on two-factor authentication. If the user doesn't exist then
+// ErrNoRecord is returned.
+func (m *UserModel) TOTPSecret(ctx context.Context, userID int) (string, error) {
+	var secret sql.NullString
+	err := m.DB.QueryRowContext(ctx, "SELECT totp_secret FROM users WHERE id = ?", userID).Scan(&secret)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrNoRecord
+		}
+		return "", err
+	}
+	return secret.String, nil
+}
+
+// UseRecoveryCode() checks a recovery code for a user and, if it's valid,
+// deletes it so that it can't be used again. Recovery codes are shown to users
+// in upper case, but we ignore case and any spaces or dashes in case the user
+// typed it in differently. If the code isn't valid then ErrInvalidCredentials
+// is returned.
+func (m *UserModel) UseRecoveryCode(ctx context.Context, userID int, code string) error {
+	code = strings.ToUpper(strings.NewReplacer(" ", "", "-", "").Replace(code))
+	stmt := "DELETE FROM recovery_codes WHERE hash = ? AND user_id = ?"
+	result, err := m.DB.ExecContext(ctx, stmt, hashToken(code), userID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+// All() returns a page of users, in the order that they signed up. The offset
+// and limit work in the same way as the SQL OFFSET and LIMIT clauses.
+func (m *UserModel) All(ctx context.Context, offset, limit int) ([]*User, error) {
+	stmt := `SELECT id, name, COALESCE(username, ''), avatar, email, created, activated, role, timezone, theme, unique_titles, totp_secret IS NOT NULL FROM users
+	ORDER BY id LIMIT ? OFFSET ?`
+	rows, err := m.DB.QueryContext(ctx, stmt, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	users := []*User{}
+	for rows.Next() {
+		user := &User{}
+		err = rows.Scan(&user.ID, &user.Name, &user.Username, &user.Avatar, &user.Email, &user.Created, &user.Activated, &user.Role, &user.Timezone, &user.Theme, &user.UniqueTitles, &user.TOTPEnabled)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// Count() returns the total number of users.
+func (m *UserModel) Count(ctx context.Context) (int, error) {
+	var count int
+	err := m.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM users").Scan(&count)
+	return count, err
+}
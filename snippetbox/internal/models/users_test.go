@@ -1,11 +1,19 @@
 package models
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
 	"snippetbox/internal/assert"
+	"strings"
 	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestUserModelExists(t *testing.T) {
+	ctx := context.Background()
 	// Skip the test if the "-short" flag is provided when running the test.
 	if testing.Short() {
 		t.Skip("models: skipping integration test")
@@ -41,12 +49,768 @@ func TestUserModelExists(t *testing.T) {
 			// for each sub-test.
 			db := newTestDB(t)
 			// Create a new instance of the UserModel.
-			m := UserModel{db}
+			m := UserModel{DB: db}
 			// Call the UserModel.Exists() method and check that the return
 			// value and error match the expected values for the sub-test.
-			exists, err := m.Exists(tt.userID)
+			exists, err := m.Exists(ctx, tt.userID)
 			assert.Equal(t, exists, tt.want)
 			assert.NilError(t, err)
 		})
 	}
 }
+
+func TestUserModelResetPassword(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+
+	t.Run("Valid token", func(t *testing.T) {
+		db := newTestDB(t)
+		m := UserModel{DB: db}
+
+		token, err := m.CreatePasswordReset(ctx, 1)
+		assert.NilError(t, err)
+		userID, err := m.ResetPassword(ctx, token, "newPa$$word")
+		assert.NilError(t, err)
+		assert.Equal(t, userID, 1)
+
+		// The user should now be able to log in with the new password.
+		id, err := m.Authenticate(ctx, "alice@example.com", "newPa$$word")
+		assert.NilError(t, err)
+		assert.Equal(t, id, 1)
+	})
+
+	t.Run("Single use", func(t *testing.T) {
+		db := newTestDB(t)
+		m := UserModel{DB: db}
+
+		token, err := m.CreatePasswordReset(ctx, 1)
+		assert.NilError(t, err)
+		_, err = m.ResetPassword(ctx, token, "newPa$$word")
+		assert.NilError(t, err)
+		_, err = m.ResetPassword(ctx, token, "otherPa$$word")
+		assert.Equal(t, err, ErrInvalidToken)
+	})
+
+	t.Run("Expired token", func(t *testing.T) {
+		db := newTestDB(t)
+		m := UserModel{DB: db}
+
+		token, err := m.CreatePasswordReset(ctx, 1)
+		assert.NilError(t, err)
+		_, err = db.Exec("UPDATE password_resets SET expiry = DATE_SUB(UTC_TIMESTAMP(), INTERVAL 1 SECOND)")
+		assert.NilError(t, err)
+		_, err = m.ResetPassword(ctx, token, "newPa$$word")
+		assert.Equal(t, err, ErrInvalidToken)
+	})
+
+	t.Run("Unknown token", func(t *testing.T) {
+		db := newTestDB(t)
+		m := UserModel{DB: db}
+
+		_, err := m.ResetPassword(ctx, "ABCDEFGHIJKLMNOPQRSTUVWXYZ", "newPa$$word")
+		assert.Equal(t, err, ErrInvalidToken)
+	})
+}
+
+func TestUserModelAuthenticateFailures(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+	db := newTestDB(t)
+	m := UserModel{DB: db, BcryptCost: bcrypt.MinCost}
+
+	// An unknown email address and a wrong password must fail in exactly the
+	// same way, so that the caller can't tell which accounts exist.
+	_, unknownErr := m.Authenticate(ctx, "nobody@example.com", "pa$$word")
+	_, wrongErr := m.Authenticate(ctx, "alice@example.com", "wrongPa$$word")
+	assert.Equal(t, unknownErr, ErrInvalidCredentials)
+	assert.Equal(t, wrongErr, ErrInvalidCredentials)
+
+	// The dummy hash compared against for an unknown email address is made
+	// with the model's cost, so that it takes as long as a real one.
+	cost, err := bcrypt.Cost(m.dummyHash())
+	assert.NilError(t, err)
+	assert.Equal(t, cost, bcrypt.MinCost)
+	assert.Equal(t, bytes.Equal(m.dummyHash(), m.dummyHash()), true)
+}
+
+func TestUserModelRehash(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+	// storedCost returns the cost of user 1's password hash.
+	storedCost := func(t *testing.T, db *sql.DB) int {
+		var hashedPassword []byte
+		err := db.QueryRow("SELECT hashed_password FROM users WHERE id = 1").Scan(&hashedPassword)
+		assert.NilError(t, err)
+		cost, err := bcrypt.Cost(hashedPassword)
+		assert.NilError(t, err)
+		return cost
+	}
+	// setup gives user 1 a hash of "pa$$word" with the given cost. We keep
+	// the costs low, so that the test doesn't take too long.
+	setup := func(t *testing.T, cost int) *sql.DB {
+		db := newTestDB(t)
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte("pa$$word"), cost)
+		assert.NilError(t, err)
+		_, err = db.Exec("UPDATE users SET hashed_password = ? WHERE id = 1", string(hashedPassword))
+		assert.NilError(t, err)
+		return db
+	}
+
+	t.Run("Upgraded", func(t *testing.T) {
+		db := setup(t, bcrypt.MinCost)
+		m := UserModel{DB: db, BcryptCost: bcrypt.MinCost + 1}
+
+		id, err := m.Authenticate(ctx, "alice@example.com", "pa$$word")
+		assert.NilError(t, err)
+		assert.Equal(t, id, 1)
+		assert.Equal(t, storedCost(t, db), bcrypt.MinCost+1)
+
+		// The new hash is still for the same password.
+		id, err = m.Authenticate(ctx, "alice@example.com", "pa$$word")
+		assert.NilError(t, err)
+		assert.Equal(t, id, 1)
+	})
+
+	t.Run("Wrong password", func(t *testing.T) {
+		db := setup(t, bcrypt.MinCost)
+		m := UserModel{DB: db, BcryptCost: bcrypt.MinCost + 1}
+
+		_, err := m.Authenticate(ctx, "alice@example.com", "wrongPa$$word")
+		assert.Equal(t, err, ErrInvalidCredentials)
+		assert.Equal(t, storedCost(t, db), bcrypt.MinCost)
+	})
+
+	t.Run("Not downgraded", func(t *testing.T) {
+		db := setup(t, bcrypt.MinCost+1)
+		m := UserModel{DB: db, BcryptCost: bcrypt.MinCost}
+
+		_, err := m.Authenticate(ctx, "alice@example.com", "pa$$word")
+		assert.NilError(t, err)
+		assert.Equal(t, storedCost(t, db), bcrypt.MinCost+1)
+	})
+}
+
+func TestUserModelActivate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+
+	t.Run("New users must activate", func(t *testing.T) {
+		db := newTestDB(t)
+		m := UserModel{DB: db}
+
+		token, err := m.Insert(ctx, "Bob", "bob", "bob@example.com", "pa$$word")
+		assert.NilError(t, err)
+		_, err = m.Authenticate(ctx, "bob@example.com", "pa$$word")
+		assert.Equal(t, err, ErrAccountNotActivated)
+
+		err = m.Activate(ctx, token)
+		assert.NilError(t, err)
+		_, err = m.Authenticate(ctx, "bob@example.com", "pa$$word")
+		assert.NilError(t, err)
+	})
+
+	t.Run("Token not found", func(t *testing.T) {
+		db := newTestDB(t)
+		m := UserModel{DB: db}
+
+		err := m.Activate(ctx, "ABCDEFGHIJKLMNOPQRSTUVWXYZ")
+		assert.Equal(t, err, ErrInvalidToken)
+	})
+
+	t.Run("Already activated", func(t *testing.T) {
+		db := newTestDB(t)
+		m := UserModel{DB: db}
+
+		token, err := m.Insert(ctx, "Bob", "bob", "bob@example.com", "pa$$word")
+		assert.NilError(t, err)
+		err = m.Activate(ctx, token)
+		assert.NilError(t, err)
+		err = m.Activate(ctx, token)
+		assert.Equal(t, err, ErrInvalidToken)
+	})
+}
+
+func TestUserModelUsername(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+
+	t.Run("Get by username", func(t *testing.T) {
+		m := UserModel{DB: newTestDB(t)}
+
+		user, err := m.GetByUsername(ctx, "alice")
+		assert.NilError(t, err)
+		assert.Equal(t, user.ID, 1)
+		assert.Equal(t, user.Username, "alice")
+
+		_, err = m.GetByUsername(ctx, "bob")
+		assert.Equal(t, err, ErrNoRecord)
+	})
+
+	t.Run("Usernames are unique", func(t *testing.T) {
+		m := UserModel{DB: newTestDB(t)}
+
+		_, err := m.Insert(ctx, "Another Alice", "alice", "another.alice@example.com", "pa$$word")
+		assert.Equal(t, err, ErrDuplicateUsername)
+
+		_, err = m.Insert(ctx, "Bob", "bob", "bob@example.com", "pa$$word")
+		assert.NilError(t, err)
+		user, err := m.GetByEmail(ctx, "bob@example.com")
+		assert.NilError(t, err)
+		assert.Equal(t, user.Username, "bob")
+	})
+
+	t.Run("OAuth users have no username", func(t *testing.T) {
+		m := UserModel{DB: newTestDB(t)}
+
+		id, err := m.UpsertOAuthUser(ctx, "github", "12345", "carol@example.com", "Carol")
+		assert.NilError(t, err)
+		user, err := m.Get(ctx, id)
+		assert.NilError(t, err)
+		assert.Equal(t, user.Username, "")
+		// And more than one user can be without one.
+		_, err = m.UpsertOAuthUser(ctx, "github", "67890", "dave@example.com", "Dave")
+		assert.NilError(t, err)
+	})
+}
+
+func TestUserModelAPIToken(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+
+	t.Run("Only the hash is stored", func(t *testing.T) {
+		db := newTestDB(t)
+		m := UserModel{DB: db}
+
+		token, err := m.CreateAPIToken(ctx, 1)
+		assert.NilError(t, err)
+
+		var hash []byte
+		err = db.QueryRow("SELECT hash FROM api_tokens WHERE user_id = 1").Scan(&hash)
+		assert.NilError(t, err)
+		assert.Equal(t, string(hash), string(hashToken(token)))
+		assert.Equal(t, bytes.Contains(hash, []byte(token)), false)
+	})
+
+	t.Run("Valid token", func(t *testing.T) {
+		db := newTestDB(t)
+		m := UserModel{DB: db}
+
+		token, err := m.CreateAPIToken(ctx, 1)
+		assert.NilError(t, err)
+		user, err := m.GetForToken(ctx, token)
+		assert.NilError(t, err)
+		assert.Equal(t, user.Email, "alice@example.com")
+	})
+
+	t.Run("Expired token", func(t *testing.T) {
+		db := newTestDB(t)
+		m := UserModel{DB: db}
+
+		token, err := m.CreateAPIToken(ctx, 1)
+		assert.NilError(t, err)
+		_, err = db.Exec("UPDATE api_tokens SET expiry = DATE_SUB(UTC_TIMESTAMP(), INTERVAL 1 SECOND)")
+		assert.NilError(t, err)
+		_, err = m.GetForToken(ctx, token)
+		assert.Equal(t, err, ErrNoRecord)
+	})
+
+	t.Run("Unknown token", func(t *testing.T) {
+		db := newTestDB(t)
+		m := UserModel{DB: db}
+
+		_, err := m.GetForToken(ctx, "ABCDEFGHIJKLMNOPQRSTUVWXYZ")
+		assert.Equal(t, err, ErrNoRecord)
+	})
+
+	// The API sends a 401 response when GetForToken() returns ErrNoRecord, so
+	// a deactivated user can't carry on using a token they made earlier.
+	t.Run("Deactivated user", func(t *testing.T) {
+		db := newTestDB(t)
+		m := UserModel{DB: db}
+
+		token, err := m.CreateAPIToken(ctx, 1)
+		assert.NilError(t, err)
+		_, err = db.Exec("UPDATE users SET activated = FALSE WHERE id = 1")
+		assert.NilError(t, err)
+		_, err = m.GetForToken(ctx, token)
+		assert.Equal(t, err, ErrNoRecord)
+	})
+
+	t.Run("Revoked by deactivation", func(t *testing.T) {
+		db := newTestDB(t)
+		m := UserModel{DB: db}
+
+		token, err := m.CreateAPIToken(ctx, 1)
+		assert.NilError(t, err)
+		assert.NilError(t, m.SetActivated(ctx, 1, false))
+		var count int
+		assert.NilError(t, db.QueryRow("SELECT COUNT(*) FROM api_tokens WHERE user_id = 1").Scan(&count))
+		assert.Equal(t, count, 0)
+
+		// Reactivating the user doesn't bring the token back.
+		assert.NilError(t, m.SetActivated(ctx, 1, true))
+		_, err = m.GetForToken(ctx, token)
+		assert.Equal(t, err, ErrNoRecord)
+	})
+}
+
+func TestUserModelFavourites(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+
+	db := newTestDB(t)
+	users := UserModel{DB: db}
+	snippets := SnippetModel{DB: db}
+
+	id, err := snippets.Insert(ctx, "An old silent pond", "A frog jumps into the pond", 7, 1)
+	assert.NilError(t, err)
+
+	// Favouriting the same snippet twice should not be an error, and should
+	// not produce a duplicate.
+	assert.NilError(t, users.AddFavourite(ctx, 1, id))
+	assert.NilError(t, users.AddFavourite(ctx, 1, id))
+
+	favourite, err := users.IsFavourite(ctx, 1, id)
+	assert.NilError(t, err)
+	assert.Equal(t, favourite, true)
+
+	favourites, err := snippets.FavouritesFor(ctx, 1)
+	assert.NilError(t, err)
+	assert.Equal(t, len(favourites), 1)
+	assert.Equal(t, favourites[0].ID, id)
+
+	err = users.AddFavourite(ctx, 1, id+100)
+	assert.Equal(t, err, ErrNoRecord)
+
+	assert.NilError(t, users.RemoveFavourite(ctx, 1, id))
+	assert.NilError(t, users.RemoveFavourite(ctx, 1, id))
+
+	favourites, err = snippets.FavouritesFor(ctx, 1)
+	assert.NilError(t, err)
+	assert.Equal(t, len(favourites), 0)
+}
+
+func TestUserModelEmailChange(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+
+	// Create a user with a known password to change the email address of.
+	newUser := func(t *testing.T, m UserModel) int {
+		_, err := m.Insert(ctx, "Bob", "bob", "bob@example.com", "pa$$word")
+		assert.NilError(t, err)
+		user, err := m.GetByEmail(ctx, "bob@example.com")
+		assert.NilError(t, err)
+		return user.ID
+	}
+
+	t.Run("Password required", func(t *testing.T) {
+		db := newTestDB(t)
+		m := UserModel{DB: db}
+		id := newUser(t, m)
+
+		_, err := m.CreateEmailChange(ctx, id, "wrongPa$$word", "bob.new@example.com")
+		assert.Equal(t, err, ErrInvalidCredentials)
+	})
+
+	t.Run("Duplicate email", func(t *testing.T) {
+		db := newTestDB(t)
+		m := UserModel{DB: db}
+		id := newUser(t, m)
+
+		_, err := m.CreateEmailChange(ctx, id, "pa$$word", "alice@example.com")
+		assert.Equal(t, err, ErrDuplicateEmail)
+	})
+
+	t.Run("Confirm", func(t *testing.T) {
+		db := newTestDB(t)
+		m := UserModel{DB: db}
+		id := newUser(t, m)
+
+		token, err := m.CreateEmailChange(ctx, id, "pa$$word", "bob.new@example.com")
+		assert.NilError(t, err)
+
+		// Nothing changes until the token is used.
+		user, err := m.Get(ctx, id)
+		assert.NilError(t, err)
+		assert.Equal(t, user.Email, "bob@example.com")
+
+		userID, err := m.ConfirmEmailChange(ctx, token)
+		assert.NilError(t, err)
+		assert.Equal(t, userID, id)
+		user, err = m.Get(ctx, id)
+		assert.NilError(t, err)
+		assert.Equal(t, user.Email, "bob.new@example.com")
+
+		// The token can only be used once.
+		_, err = m.ConfirmEmailChange(ctx, token)
+		assert.Equal(t, err, ErrInvalidToken)
+	})
+}
+
+func TestUserModelDelete(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+
+	db := newTestDB(t)
+	m := UserModel{DB: db}
+	snippets := SnippetModel{DB: db}
+	sessions := SessionModel{db}
+
+	// Give Alice a snippet, a favourite, an API token and a logged-in session.
+	id, err := snippets.InsertWithTags(ctx, "Title", "Content", 7, 1, []string{"go"}, VisibilityPublic, "", time.Time{})
+	assert.NilError(t, err)
+	assert.NilError(t, m.AddFavourite(ctx, 1, id))
+	_, err = m.CreateAPIToken(ctx, 1)
+	assert.NilError(t, err)
+	token := strings.Repeat("x", 43)
+	expiry := time.Now().Add(time.Hour)
+	_, err = db.Exec("INSERT INTO sessions (token, data, expiry) VALUES (?, '', ?)", token, expiry.UTC())
+	assert.NilError(t, err)
+	assert.NilError(t, sessions.Record(ctx, token, 1, expiry))
+
+	assert.NilError(t, m.Delete(ctx, 1))
+
+	_, err = m.Get(ctx, 1)
+	assert.Equal(t, err, ErrNoRecord)
+	_, err = snippets.Get(ctx, id, 0)
+	assert.Equal(t, err, ErrNoRecord)
+
+	// Nothing belonging to the user should be left in any table.
+	for _, stmt := range []string{
+		"SELECT COUNT(*) FROM snippets WHERE user_id = 1",
+		"SELECT COUNT(*) FROM snippet_favourites WHERE user_id = 1",
+		"SELECT COUNT(*) FROM api_tokens WHERE user_id = 1",
+		"SELECT COUNT(*) FROM user_sessions WHERE user_id = 1",
+		"SELECT COUNT(*) FROM sessions",
+	} {
+		var count int
+		assert.NilError(t, db.QueryRow(stmt).Scan(&count))
+		assert.Equal(t, count, 0)
+	}
+
+	// Deleting a user who doesn't exist is an error.
+	assert.Equal(t, m.Delete(ctx, 1), ErrNoRecord)
+}
+
+func TestUserModelAdmin(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+
+	db := newTestDB(t)
+	m := UserModel{DB: db}
+	sessions := SessionModel{db}
+
+	_, err := m.Insert(ctx, "Bob", "bob", "bob@example.com", "pa$$word")
+	assert.NilError(t, err)
+
+	t.Run("All", func(t *testing.T) {
+		users, err := m.All(ctx, 0, 10)
+		assert.NilError(t, err)
+		assert.Equal(t, len(users), 2)
+		assert.Equal(t, users[0].Email, "alice@example.com")
+		assert.Equal(t, users[0].Role, RoleUser)
+		assert.Equal(t, users[1].Email, "bob@example.com")
+
+		users, err = m.All(ctx, 1, 10)
+		assert.NilError(t, err)
+		assert.Equal(t, len(users), 1)
+		assert.Equal(t, users[0].Email, "bob@example.com")
+
+		users, err = m.All(ctx, 0, 1)
+		assert.NilError(t, err)
+		assert.Equal(t, len(users), 1)
+		assert.Equal(t, users[0].Email, "alice@example.com")
+	})
+
+	t.Run("Count", func(t *testing.T) {
+		count, err := m.Count(ctx)
+		assert.NilError(t, err)
+		assert.Equal(t, count, 2)
+	})
+
+	t.Run("SetRole", func(t *testing.T) {
+		assert.NilError(t, m.SetRole(ctx, 1, RoleAdmin))
+		user, err := m.Get(ctx, 1)
+		assert.NilError(t, err)
+		assert.Equal(t, user.Role, RoleAdmin)
+	})
+
+	t.Run("SetActivated", func(t *testing.T) {
+		// Give Alice a logged-in session, which deactivation should remove.
+		token := strings.Repeat("x", 43)
+		expiry := time.Now().Add(time.Hour)
+		_, err := db.Exec("INSERT INTO sessions (token, data, expiry) VALUES (?, '', ?)", token, expiry.UTC())
+		assert.NilError(t, err)
+		assert.NilError(t, sessions.Record(ctx, token, 1, expiry))
+
+		assert.NilError(t, m.SetActivated(ctx, 1, false))
+		user, err := m.Get(ctx, 1)
+		assert.NilError(t, err)
+		assert.Equal(t, user.Activated, false)
+		var count int
+		assert.NilError(t, db.QueryRow("SELECT COUNT(*) FROM sessions").Scan(&count))
+		assert.Equal(t, count, 0)
+
+		assert.NilError(t, m.SetActivated(ctx, 1, true))
+		user, err = m.Get(ctx, 1)
+		assert.NilError(t, err)
+		assert.Equal(t, user.Activated, true)
+	})
+}
+
+func TestUserModelSetTimezone(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+
+	m := UserModel{DB: newTestDB(t)}
+
+	// New users see their dates in UTC until they choose a time zone.
+	user, err := m.Get(ctx, 1)
+	assert.NilError(t, err)
+	assert.Equal(t, user.Timezone, "UTC")
+
+	assert.NilError(t, m.SetTimezone(ctx, 1, "Europe/London"))
+	user, err = m.Get(ctx, 1)
+	assert.NilError(t, err)
+	assert.Equal(t, user.Timezone, "Europe/London")
+
+	user, err = m.GetByEmail(ctx, "alice@example.com")
+	assert.NilError(t, err)
+	assert.Equal(t, user.Timezone, "Europe/London")
+}
+
+func TestUserModelSetTheme(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+
+	m := UserModel{DB: newTestDB(t)}
+
+	// Users follow their system's theme until they choose one.
+	user, err := m.Get(ctx, 1)
+	assert.NilError(t, err)
+	assert.Equal(t, user.Theme, ThemeSystem)
+
+	assert.NilError(t, m.SetTheme(ctx, 1, ThemeDark))
+	user, err = m.Get(ctx, 1)
+	assert.NilError(t, err)
+	assert.Equal(t, user.Theme, ThemeDark)
+
+	user, err = m.GetByEmail(ctx, "alice@example.com")
+	assert.NilError(t, err)
+	assert.Equal(t, user.Theme, ThemeDark)
+}
+
+func TestUserModelSetAvatar(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+
+	m := UserModel{DB: newTestDB(t)}
+
+	// Users don't have an avatar until they upload one.
+	user, err := m.Get(ctx, 1)
+	assert.NilError(t, err)
+	assert.Equal(t, user.Avatar, "")
+
+	assert.NilError(t, m.SetAvatar(ctx, 1, "1-abc.png"))
+	user, err = m.GetByUsername(ctx, "alice")
+	assert.NilError(t, err)
+	assert.Equal(t, user.Avatar, "1-abc.png")
+}
+
+func TestUserModelSetUniqueTitles(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+
+	m := UserModel{DB: newTestDB(t)}
+
+	// The preference is off until the user turns it on.
+	user, err := m.Get(ctx, 1)
+	assert.NilError(t, err)
+	assert.Equal(t, user.UniqueTitles, false)
+
+	assert.NilError(t, m.SetUniqueTitles(ctx, 1, true))
+	user, err = m.GetByEmail(ctx, "alice@example.com")
+	assert.NilError(t, err)
+	assert.Equal(t, user.UniqueTitles, true)
+
+	assert.NilError(t, m.SetUniqueTitles(ctx, 1, false))
+	user, err = m.Get(ctx, 1)
+	assert.NilError(t, err)
+	assert.Equal(t, user.UniqueTitles, false)
+}
+
+func TestUserModelUpsertOAuthUser(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+
+	db := newTestDB(t)
+	m := UserModel{DB: db}
+
+	countIdentities := func(t *testing.T, userID int) int {
+		var count int
+		err := db.QueryRow("SELECT COUNT(*) FROM user_identities WHERE user_id = ?", userID).Scan(&count)
+		assert.NilError(t, err)
+		return count
+	}
+
+	var carolID int
+
+	t.Run("New user", func(t *testing.T) {
+		id, err := m.UpsertOAuthUser(ctx, "github", "1001", "carol@example.com", "Carol")
+		assert.NilError(t, err)
+		carolID = id
+
+		user, err := m.Get(ctx, id)
+		assert.NilError(t, err)
+		assert.Equal(t, user.Name, "Carol")
+		assert.Equal(t, user.Email, "carol@example.com")
+		assert.Equal(t, user.Activated, true)
+		assert.Equal(t, countIdentities(t, id), 1)
+
+		// The random password can't be guessed.
+		_, err = m.Authenticate(ctx, "carol@example.com", "")
+		assert.Equal(t, err, ErrInvalidCredentials)
+	})
+
+	t.Run("Returning user", func(t *testing.T) {
+		// The email address at the provider may have changed since the
+		// user first logged in, but the provider's user ID doesn't.
+		id, err := m.UpsertOAuthUser(ctx, "github", "1001", "carol@example.org", "Carol")
+		assert.NilError(t, err)
+		assert.Equal(t, id, carolID)
+		count, err := m.Count(ctx)
+		assert.NilError(t, err)
+		assert.Equal(t, count, 2)
+	})
+
+	t.Run("Second provider", func(t *testing.T) {
+		id, err := m.UpsertOAuthUser(ctx, "google", "1001", "carol@example.com", "Carol")
+		assert.NilError(t, err)
+		assert.Equal(t, id, carolID)
+		assert.Equal(t, countIdentities(t, carolID), 2)
+	})
+
+	t.Run("Existing password user", func(t *testing.T) {
+		hashedPassword := func(t *testing.T) string {
+			var hash string
+			err := db.QueryRow("SELECT hashed_password FROM users WHERE id = 1").Scan(&hash)
+			assert.NilError(t, err)
+			return hash
+		}
+		before := hashedPassword(t)
+
+		id, err := m.UpsertOAuthUser(ctx, "github", "2002", "alice@example.com", "Alice")
+		assert.NilError(t, err)
+		assert.Equal(t, id, 1)
+		assert.Equal(t, countIdentities(t, 1), 1)
+
+		// Alice can still log in with her password.
+		assert.Equal(t, hashedPassword(t), before)
+	})
+
+	t.Run("Deleting the user", func(t *testing.T) {
+		assert.NilError(t, m.Delete(ctx, carolID))
+		assert.Equal(t, countIdentities(t, carolID), 0)
+	})
+}
+
+func TestUserModelTOTP(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+
+	m := UserModel{DB: newTestDB(t)}
+
+	secret, err := m.TOTPSecret(ctx, 1)
+	assert.NilError(t, err)
+	assert.Equal(t, secret, "")
+	_, err = m.TOTPSecret(ctx, 99)
+	assert.Equal(t, err, ErrNoRecord)
+
+	codes, err := m.EnableTOTP(ctx, 1, "JBSWY3DPEHPK3PXP")
+	assert.NilError(t, err)
+	assert.Equal(t, len(codes), recoveryCodeCount)
+
+	user, err := m.Get(ctx, 1)
+	assert.NilError(t, err)
+	assert.Equal(t, user.TOTPEnabled, true)
+	secret, err = m.TOTPSecret(ctx, 1)
+	assert.NilError(t, err)
+	assert.Equal(t, secret, "JBSWY3DPEHPK3PXP")
+
+	t.Run("Recovery codes", func(t *testing.T) {
+		// Each code works once, however it's typed.
+		code := strings.ToLower(codes[0][:5]) + "-" + codes[0][5:]
+		assert.NilError(t, m.UseRecoveryCode(ctx, 1, code))
+		assert.Equal(t, m.UseRecoveryCode(ctx, 1, codes[0]), ErrInvalidCredentials)
+		// Codes only work for the user they were issued to.
+		assert.Equal(t, m.UseRecoveryCode(ctx, 2, codes[1]), ErrInvalidCredentials)
+		assert.Equal(t, m.UseRecoveryCode(ctx, 1, "NOTAVALIDCODE"), ErrInvalidCredentials)
+	})
+
+	t.Run("Enabling again replaces the codes", func(t *testing.T) {
+		newCodes, err := m.EnableTOTP(ctx, 1, "KRSXG5CTMVRXEZLU")
+		assert.NilError(t, err)
+		assert.Equal(t, m.UseRecoveryCode(ctx, 1, codes[1]), ErrInvalidCredentials)
+		assert.NilError(t, m.UseRecoveryCode(ctx, 1, newCodes[1]))
+		codes = newCodes
+	})
+
+	t.Run("Disable", func(t *testing.T) {
+		assert.NilError(t, m.DisableTOTP(ctx, 1))
+		user, err := m.Get(ctx, 1)
+		assert.NilError(t, err)
+		assert.Equal(t, user.TOTPEnabled, false)
+		secret, err := m.TOTPSecret(ctx, 1)
+		assert.NilError(t, err)
+		assert.Equal(t, secret, "")
+		assert.Equal(t, m.UseRecoveryCode(ctx, 1, codes[2]), ErrInvalidCredentials)
+	})
+}
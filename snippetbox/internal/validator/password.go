@@ -0,0 +1,81 @@
+package validator
+
+import (
+	_ "embed"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// commonPasswordsFile holds a short list of well-known passwords, one per
+// line. Most of them would otherwise pass the StrongPassword() checks, which is
+// exactly why they're worth rejecting separately.
+//
+//go:embed common_passwords.txt
+var commonPasswordsFile string
+
+// commonPasswords is the contents of commonPasswordsFile, lowercased and
+// loaded into a map for quick lookups.
+var commonPasswords = func() map[string]bool {
+	m := make(map[string]bool)
+	for _, line := range strings.Split(commonPasswordsFile, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			m[strings.ToLower(line)] = true
+		}
+	}
+	return m
+}()
+
+// PasswordProblems() checks a password against each of our strength
+// requirements, and returns a description of every requirement which isn't
+// met (like "an uppercase letter"). If the slice is empty then the password
+// is strong enough. The descriptions are written so that they can be joined
+// together into an error message such as "This field must contain at least 8
+// characters, a digit and a symbol".
+func PasswordProblems(value string, minLength int) []string {
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range value {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			hasSymbol = true
+		}
+	}
+
+	var problems []string
+	if utf8.RuneCountInString(value) < minLength {
+		problems = append(problems, fmt.Sprintf("at least %d characters", minLength))
+	}
+	if !hasUpper {
+		problems = append(problems, "an uppercase letter")
+	}
+	if !hasLower {
+		problems = append(problems, "a lowercase letter")
+	}
+	if !hasDigit {
+		problems = append(problems, "a digit")
+	}
+	if !hasSymbol {
+		problems = append(problems, "a symbol")
+	}
+	return problems
+}
+
+// StrongPassword() returns true if a password is at least minLength
+// characters long and contains an uppercase letter, a lowercase letter, a
+// digit and a symbol.
+func StrongPassword(value string, minLength int) bool {
+	return len(PasswordProblems(value, minLength)) == 0
+}
+
+// CommonPassword() returns true if a password appears in our embedded list of
+// common passwords. The comparison is case-insensitive.
+func CommonPassword(value string) bool {
+	return commonPasswords[strings.ToLower(value)]
+}
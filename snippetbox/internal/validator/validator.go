@@ -1,8 +1,12 @@
 package validator
 
 import (
+	"cmp"
+	"fmt"
+	"net/url"
 	"regexp"
 	"strings"
+	"time"
 	"unicode/utf8"
 )
 
@@ -13,6 +17,24 @@ import (
 // variable is more performant than re-parsing the pattern each time we need it.
 var EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 
+// UsernameRX checks that a username is a slug which is safe to use in a URL
+// path: lowercase letters, digits, hyphens and underscores, starting and ending
+// with a letter or digit.
+var UsernameRX = regexp.MustCompile("^[a-z0-9](?:[a-z0-9_-]*[a-z0-9])?$")
+
+// SlugRX checks that a value is a slug: lowercase words made of letters and
+// digits, separated by single hyphens, like "my-first-snippet".
+var SlugRX = regexp.MustCompile("^[a-z0-9]+(?:-[a-z0-9]+)*$")
+
+// HexColorRX checks that a value is a CSS hex color, with either three or six
+// hex digits after the #, like "#fff" or "#34495E".
+var HexColorRX = regexp.MustCompile("^#(?:[0-9a-fA-F]{3}){1,2}$")
+
+// URLRX is a quick sanity check for http and https URLs. It only checks the
+// overall shape of the URL, so use IsURL() when you need to be sure that a URL
+// is well-formed.
+var URLRX = regexp.MustCompile(`(?i)^https?://[^\s/$.?#][^\s]*$`)
+
 // Add a new NonFieldErrors []string field to the struct, which we will use to
 // hold any validation errors which are not related to a specific form field.
 type Validator struct {
@@ -53,11 +75,68 @@ func (v *Validator) CheckField(ok bool, key, message string) {
 	}
 }
 
+// CheckFieldIf() is like CheckField(), but only when condition is true. When
+// it's false the check is skipped entirely and no error is added, whatever ok
+// is. This is for fields which only matter when another field has a certain
+// value, like the number of days for a custom expiry.
+func (v *Validator) CheckFieldIf(condition, ok bool, key, message string) {
+	if condition {
+		v.CheckField(ok, key, message)
+	}
+}
+
+// Check() is like CheckField(), but builds the error message from a format
+// string and its arguments in the same way as fmt.Sprintf(), so that a limit
+// only has to be written once. The message is only formatted if the check
+// fails.
+func (v *Validator) Check(ok bool, key, format string, args ...any) {
+	if !ok {
+		v.AddFieldError(key, fmt.Sprintf(format, args...))
+	}
+}
+
+// The messages added by the CheckMaxChars(), CheckMinChars() and
+// CheckBetween() functions. Using the same wording everywhere means that the
+// messages can't drift apart from one form to the next.
+const (
+	maxCharsMessage = "This field cannot be more than %d characters long"
+	minCharsMessage = "This field must be at least %d characters long"
+	betweenMessage  = "This field must be between %v and %v"
+)
+
+// CheckMaxChars() adds an error message for key to v if value contains more
+// than n characters. The message includes the limit.
+func CheckMaxChars(v *Validator, key, value string, n int) {
+	v.Check(MaxChars(value, n), key, maxCharsMessage, n)
+}
+
+// CheckMinChars() adds an error message for key to v if value contains fewer
+// than n characters. The message includes the limit.
+func CheckMinChars(v *Validator, key, value string, n int) {
+	v.Check(MinChars(value, n), key, minCharsMessage, n)
+}
+
+// CheckBetween() adds an error message for key to v if value isn't within the
+// range min to max, inclusive. The message includes both limits. It's a
+// function rather than a method on Validator because methods can't have type
+// parameters.
+func CheckBetween[T cmp.Ordered](v *Validator, key string, value, min, max T) {
+	v.Check(Between(value, min, max), key, betweenMessage, min, max)
+}
+
 // NotBlank() returns true if a value is not an empty string.
 func NotBlank(value string) bool {
 	return strings.TrimSpace(value) != ""
 }
 
+// RequiredIf() returns true if a value is not blank, or if condition is false,
+// in which case the value isn't required and isn't looked at at all. For
+// example, RequiredIf(form.Contact == "email", form.Email) only requires an
+// email address when the user has chosen to be contacted by email.
+func RequiredIf(condition bool, value string) bool {
+	return !condition || NotBlank(value)
+}
+
 // MaxChars() returns true if a value contains no more than n characters.
 func MaxChars(value string, n int) bool {
 	return utf8.RuneCountInString(value) <= n
@@ -75,6 +154,52 @@ func PermittedValue[T comparable](value T, permittedValues ...T) bool {
 	return false
 }
 
+// OneOf() returns true if a string equals one of the permitted values. It's
+// the same as PermittedValue(), but reads better in the common case of a form
+// field with a fixed set of options.
+func OneOf(value string, permitted ...string) bool {
+	return PermittedValue(value, permitted...)
+}
+
+// NoneOf() returns true if a string doesn't equal any of the forbidden values,
+// such as reserved usernames.
+func NoneOf(value string, forbidden ...string) bool {
+	return !OneOf(value, forbidden...)
+}
+
+// OneOfFold() is like OneOf(), but ignores case (using Unicode case folding),
+// so "Admin" is one of "admin" and "api".
+func OneOfFold(value string, permitted ...string) bool {
+	for _, p := range permitted {
+		if strings.EqualFold(value, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// NoneOfFold() is like NoneOf(), but ignores case.
+func NoneOfFold(value string, forbidden ...string) bool {
+	return !OneOfFold(value, forbidden...)
+}
+
+// Between() returns true if a value is within the range min to max, inclusive
+// of both boundaries. It works with any ordered type, so it can be used for
+// ints, floats and strings alike.
+func Between[T cmp.Ordered](value, min, max T) bool {
+	return value >= min && value <= max
+}
+
+// MinInt() returns true if a value is greater than or equal to min.
+func MinInt(value, min int) bool {
+	return value >= min
+}
+
+// MaxInt() returns true if a value is less than or equal to max.
+func MaxInt(value, max int) bool {
+	return value <= max
+}
+
 // MinChars() returns true if a value contains at least n characters.
 func MinChars(value string, n int) bool {
 	return utf8.RuneCountInString(value) >= n
@@ -89,3 +214,50 @@ func Matches(value string, rx *regexp.Regexp) bool {
 func Equal[T comparable](value T, other T) bool {
 	return value == other
 }
+
+// IsURL() returns true if a value is an absolute http or https URL with a
+// host. Other schemes (like javascript: or data:) are rejected, as are values
+// containing whitespace which url.Parse() would otherwise accept.
+func IsURL(value string) bool {
+	if !URLRX.MatchString(value) {
+		return false
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return false
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return false
+	}
+	return u.Hostname() != ""
+}
+
+// IsTimezone() returns true if a value is the name of a time zone in the IANA
+// database, like "UTC" or "America/New_York". time.LoadLocation() also
+// accepts "" and "Local", but those mean the server's own zone rather than a
+// real time zone name, so they're rejected.
+func IsTimezone(value string) bool {
+	if value == "" || value == "Local" {
+		return false
+	}
+	_, err := time.LoadLocation(value)
+	return err == nil
+}
+
+// IsDate() returns true if a value can be parsed as a date or time using the
+// given layout, such as time.DateOnly or "2006-01-02T15:04" (the format sent
+// by a datetime-local input). Invalid dates like February 30th are rejected.
+func IsDate(value, layout string) bool {
+	_, err := time.Parse(layout, value)
+	return err == nil
+}
+
+// AfterNow() returns true if t is in the future.
+func AfterNow(t time.Time) bool {
+	return t.After(time.Now())
+}
+
+// BeforeNow() returns true if t is in the past.
+func BeforeNow(t time.Time) bool {
+	return t.Before(time.Now())
+}
@@ -0,0 +1,403 @@
+package validator
+
+import (
+	"regexp"
+	"snippetbox/internal/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBetween(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    int
+		min, max int
+		want     bool
+	}{
+		{name: "Lower boundary", value: 1, min: 1, max: 3650, want: true},
+		{name: "Upper boundary", value: 3650, min: 1, max: 3650, want: true},
+		{name: "Within range", value: 365, min: 1, max: 3650, want: true},
+		{name: "Below range", value: 0, min: 1, max: 3650, want: false},
+		{name: "Above range", value: 3651, min: 1, max: 3650, want: false},
+		{name: "Negative lower boundary", value: -10, min: -10, max: -1, want: true},
+		{name: "Negative upper boundary", value: -1, min: -10, max: -1, want: true},
+		{name: "Negative below range", value: -11, min: -10, max: -1, want: false},
+		{name: "Negative above range", value: 0, min: -10, max: -1, want: false},
+		{name: "Range spanning zero", value: 0, min: -5, max: 5, want: true},
+		{name: "Single value range", value: 7, min: 7, max: 7, want: true},
+		{name: "Empty range", value: 5, min: 10, max: 1, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, Between(tt.value, tt.min, tt.max), tt.want)
+		})
+	}
+}
+
+func TestBetweenOtherTypes(t *testing.T) {
+	assert.Equal(t, Between(2.5, 1.0, 2.5), true)
+	assert.Equal(t, Between(2.51, 1.0, 2.5), false)
+	assert.Equal(t, Between("b", "a", "c"), true)
+	assert.Equal(t, Between("d", "a", "c"), false)
+}
+
+func TestRequiredIf(t *testing.T) {
+	tests := []struct {
+		name      string
+		condition bool
+		value     string
+		want      bool
+	}{
+		{name: "Required and given", condition: true, value: "7", want: true},
+		{name: "Required and blank", condition: true, value: "", want: false},
+		{name: "Required and whitespace", condition: true, value: "  ", want: false},
+		{name: "Not required and given", condition: false, value: "7", want: true},
+		{name: "Not required and blank", condition: false, value: "", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, RequiredIf(tt.condition, tt.value), tt.want)
+		})
+	}
+}
+
+func TestCheckFieldIf(t *testing.T) {
+	tests := []struct {
+		name      string
+		condition bool
+		ok        bool
+		wantValid bool
+	}{
+		{name: "Condition true, check passes", condition: true, ok: true, wantValid: true},
+		{name: "Condition true, check fails", condition: true, ok: false, wantValid: false},
+		{name: "Condition false, check passes", condition: false, ok: true, wantValid: true},
+		{name: "Condition false, check fails", condition: false, ok: false, wantValid: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v Validator
+			v.CheckFieldIf(tt.condition, tt.ok, "customDays", "This field cannot be blank")
+			assert.Equal(t, v.Valid(), tt.wantValid)
+			if !tt.wantValid {
+				assert.Equal(t, v.FieldErrors["customDays"], "This field cannot be blank")
+			}
+		})
+	}
+}
+
+func TestCheckMessages(t *testing.T) {
+	tests := []struct {
+		name    string
+		check   func(v *Validator)
+		wantMsg string
+	}{
+		{
+			name:    "MaxChars fails",
+			check:   func(v *Validator) { CheckMaxChars(v, "field", "ééééé", 4) },
+			wantMsg: "This field cannot be more than 4 characters long",
+		},
+		{
+			name:  "MaxChars passes",
+			check: func(v *Validator) { CheckMaxChars(v, "field", "éééé", 4) },
+		},
+		{
+			name:    "MinChars fails",
+			check:   func(v *Validator) { CheckMinChars(v, "field", "abc", 8) },
+			wantMsg: "This field must be at least 8 characters long",
+		},
+		{
+			name:  "MinChars passes",
+			check: func(v *Validator) { CheckMinChars(v, "field", "abcdefgh", 8) },
+		},
+		{
+			name:    "Between ints fails",
+			check:   func(v *Validator) { CheckBetween(v, "field", 0, 1, 3650) },
+			wantMsg: "This field must be between 1 and 3650",
+		},
+		{
+			name:    "Between floats fails",
+			check:   func(v *Validator) { CheckBetween(v, "field", 2.5, 0.5, 1.5) },
+			wantMsg: "This field must be between 0.5 and 1.5",
+		},
+		{
+			name:  "Between passes",
+			check: func(v *Validator) { CheckBetween(v, "field", "m", "a", "z") },
+		},
+		{
+			name:    "Check formats message",
+			check:   func(v *Validator) { v.Check(false, "field", "Pick %d of %s", 3, "these") },
+			wantMsg: "Pick 3 of these",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v Validator
+			tt.check(&v)
+			assert.Equal(t, v.Valid(), tt.wantMsg == "")
+			assert.Equal(t, v.FieldErrors["field"], tt.wantMsg)
+		})
+	}
+}
+
+func TestOneOfNoneOf(t *testing.T) {
+	reserved := []string{"admin", "api"}
+
+	tests := []struct {
+		name          string
+		value         string
+		values        []string
+		wantOneOf     bool
+		wantOneOfFold bool
+	}{
+		{name: "Exact match", value: "admin", values: reserved, wantOneOf: true, wantOneOfFold: true},
+		{name: "Last value", value: "api", values: reserved, wantOneOf: true, wantOneOfFold: true},
+		{name: "Different case", value: "Admin", values: reserved, wantOneOf: false, wantOneOfFold: true},
+		{name: "All caps", value: "API", values: reserved, wantOneOf: false, wantOneOfFold: true},
+		{name: "Unicode case folding", value: "STRASSE", values: []string{"straße", "strasse"}, wantOneOf: false, wantOneOfFold: true},
+		{name: "Prefix", value: "administrator", values: reserved, wantOneOf: false, wantOneOfFold: false},
+		{name: "Surrounding whitespace", value: " admin", values: reserved, wantOneOf: false, wantOneOfFold: false},
+		{name: "No match", value: "alice", values: reserved, wantOneOf: false, wantOneOfFold: false},
+		{name: "Empty value", value: "", values: reserved, wantOneOf: false, wantOneOfFold: false},
+		{name: "Empty permitted value", value: "", values: []string{""}, wantOneOf: true, wantOneOfFold: true},
+		{name: "No values", value: "admin", values: nil, wantOneOf: false, wantOneOfFold: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, OneOf(tt.value, tt.values...), tt.wantOneOf)
+			assert.Equal(t, NoneOf(tt.value, tt.values...), !tt.wantOneOf)
+			assert.Equal(t, OneOfFold(tt.value, tt.values...), tt.wantOneOfFold)
+			assert.Equal(t, NoneOfFold(tt.value, tt.values...), !tt.wantOneOfFold)
+		})
+	}
+}
+
+func TestMinInt(t *testing.T) {
+	tests := []struct {
+		name  string
+		value int
+		min   int
+		want  bool
+	}{
+		{name: "Equal to boundary", value: 5, min: 5, want: true},
+		{name: "Above", value: 6, min: 5, want: true},
+		{name: "Below", value: 4, min: 5, want: false},
+		{name: "Negative equal to boundary", value: -5, min: -5, want: true},
+		{name: "Negative above", value: -4, min: -5, want: true},
+		{name: "Negative below", value: -6, min: -5, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, MinInt(tt.value, tt.min), tt.want)
+		})
+	}
+}
+
+func TestMaxInt(t *testing.T) {
+	tests := []struct {
+		name  string
+		value int
+		max   int
+		want  bool
+	}{
+		{name: "Equal to boundary", value: 5, max: 5, want: true},
+		{name: "Above", value: 6, max: 5, want: false},
+		{name: "Below", value: 4, max: 5, want: true},
+		{name: "Negative equal to boundary", value: -5, max: -5, want: true},
+		{name: "Negative above", value: -4, max: -5, want: false},
+		{name: "Negative below", value: -6, max: -5, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, MaxInt(tt.value, tt.max), tt.want)
+		})
+	}
+}
+
+func TestPrebuiltRegexps(t *testing.T) {
+	tests := []struct {
+		name  string
+		rx    *regexp.Regexp
+		value string
+		want  bool
+	}{
+		{"Slug", SlugRX, "my-first-snippet", true},
+		{"Slug single word", SlugRX, "go123", true},
+		{"Slug uppercase", SlugRX, "My-Snippet", false},
+		{"Slug double hyphen", SlugRX, "my--snippet", false},
+		{"Slug leading hyphen", SlugRX, "-snippet", false},
+		{"Slug trailing hyphen", SlugRX, "snippet-", false},
+		{"Slug underscore", SlugRX, "my_snippet", false},
+		{"Slug empty", SlugRX, "", false},
+		{"Username", UsernameRX, "alice_99", true},
+		{"Username with hyphen", UsernameRX, "bob-smith", true},
+		{"Username single character", UsernameRX, "a", true},
+		{"Username uppercase", UsernameRX, "Alice", false},
+		{"Username leading underscore", UsernameRX, "_alice", false},
+		{"Username trailing hyphen", UsernameRX, "alice-", false},
+		{"Username space", UsernameRX, "alice smith", false},
+		{"Username empty", UsernameRX, "", false},
+		{"Hex color short", HexColorRX, "#fff", true},
+		{"Hex color long", HexColorRX, "#34495E", true},
+		{"Hex color no hash", HexColorRX, "34495E", false},
+		{"Hex color four digits", HexColorRX, "#abcd", false},
+		{"Hex color not hex", HexColorRX, "#ggg", false},
+		{"Hex color name", HexColorRX, "red", false},
+		{"Hex color trailing newline", HexColorRX, "#fff\n", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, Matches(tt.value, tt.rx), tt.want)
+		})
+	}
+}
+
+func TestIsURL(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  bool
+	}{
+		{name: "HTTPS", value: "https://example.com", want: true},
+		{name: "HTTP with path and query", value: "http://example.com/a/b?c=d#e", want: true},
+		{name: "Uppercase scheme", value: "HTTPS://example.com", want: true},
+		{name: "Port", value: "https://example.com:8080/", want: true},
+		{name: "IPv4 host", value: "http://192.168.0.1/snippets", want: true},
+		{name: "IPv6 host", value: "http://[::1]:4000/", want: true},
+		{name: "Missing scheme", value: "example.com", want: false},
+		{name: "Scheme-relative", value: "//example.com", want: false},
+		{name: "JavaScript", value: "javascript:alert(1)", want: false},
+		{name: "JavaScript with slashes", value: "javascript://example.com/%0Aalert(1)", want: false},
+		{name: "FTP", value: "ftp://example.com", want: false},
+		{name: "Missing host", value: "https://", want: false},
+		{name: "Missing host with path", value: "https:///path", want: false},
+		{name: "Whitespace", value: "https://example .com", want: false},
+		{name: "Bad escape", value: "https://example.com/%zz", want: false},
+		{name: "Empty", value: "", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, IsURL(tt.value), tt.want)
+		})
+	}
+}
+
+func TestIsTimezone(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  bool
+	}{
+		{name: "UTC", value: "UTC", want: true},
+		{name: "Region", value: "Europe/London", want: true},
+		{name: "Nested region", value: "America/Argentina/Buenos_Aires", want: true},
+		{name: "Unknown", value: "Mars/Olympus_Mons", want: false},
+		{name: "Path traversal", value: "../../etc/passwd", want: false},
+		{name: "Local", value: "Local", want: false},
+		{name: "Empty", value: "", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, IsTimezone(tt.value), tt.want)
+		})
+	}
+}
+
+func TestIsDate(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		layout string
+		want   bool
+	}{
+		{name: "Date", value: "2024-06-01", layout: time.DateOnly, want: true},
+		{name: "Leap day", value: "2024-02-29", layout: time.DateOnly, want: true},
+		{name: "Leap day in a common year", value: "2023-02-29", layout: time.DateOnly, want: false},
+		{name: "Leap day in a century year", value: "1900-02-29", layout: time.DateOnly, want: false},
+		{name: "Leap day in a 400th year", value: "2000-02-29", layout: time.DateOnly, want: true},
+		{name: "Day out of range", value: "2024-04-31", layout: time.DateOnly, want: false},
+		{name: "Month out of range", value: "2024-13-01", layout: time.DateOnly, want: false},
+		{name: "Wrong layout", value: "01/06/2024", layout: time.DateOnly, want: false},
+		{name: "Trailing text", value: "2024-06-01 extra", layout: time.DateOnly, want: false},
+		{name: "Empty", value: "", layout: time.DateOnly, want: false},
+		{name: "Timezone-naive datetime", value: "2024-06-01T09:30", layout: "2006-01-02T15:04", want: true},
+		{name: "Timezone-naive datetime with RFC 3339", value: "2024-06-01T09:30:00", layout: time.RFC3339, want: false},
+		{name: "RFC 3339 with offset", value: "2024-06-01T09:30:00+09:00", layout: time.RFC3339, want: true},
+		{name: "RFC 3339 in UTC", value: "2024-06-01T09:30:00Z", layout: time.RFC3339, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, IsDate(tt.value, tt.layout), tt.want)
+		})
+	}
+}
+
+func TestAfterNowBeforeNow(t *testing.T) {
+	tests := []struct {
+		name       string
+		t          time.Time
+		wantAfter  bool
+		wantBefore bool
+	}{
+		{name: "Future", t: time.Now().Add(time.Hour), wantAfter: true, wantBefore: false},
+		{name: "Past", t: time.Now().Add(-time.Hour), wantAfter: false, wantBefore: true},
+		{name: "Future in another zone", t: time.Now().Add(time.Minute).In(time.FixedZone("UTC-10", -10*60*60)), wantAfter: true, wantBefore: false},
+		{name: "Zero time", t: time.Time{}, wantAfter: false, wantBefore: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, AfterNow(tt.t), tt.wantAfter)
+			assert.Equal(t, BeforeNow(tt.t), tt.wantBefore)
+		})
+	}
+}
+
+func TestPasswordProblems(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		want     []string
+	}{
+		{name: "Strong", password: "validPa$$w0rd", want: nil},
+		{name: "Too short", password: "aB3$", want: []string{"at least 8 characters"}},
+		{name: "No uppercase", password: "validpa$$w0rd", want: []string{"an uppercase letter"}},
+		{name: "No lowercase", password: "VALIDPA$$W0RD", want: []string{"a lowercase letter"}},
+		{name: "No digit", password: "validPa$$word", want: []string{"a digit"}},
+		{name: "No symbol", password: "validPassw0rd", want: []string{"a symbol"}},
+		{name: "Exactly minimum length", password: "aB3$aB3$", want: nil},
+		{name: "Unicode letters", password: "ÉcoleÀ1!", want: nil},
+		{
+			name:     "Empty",
+			password: "",
+			want:     []string{"at least 8 characters", "an uppercase letter", "a lowercase letter", "a digit", "a symbol"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := PasswordProblems(tt.password, 8)
+			assert.Equal(t, strings.Join(got, "; "), strings.Join(tt.want, "; "))
+			assert.Equal(t, StrongPassword(tt.password, 8), len(tt.want) == 0)
+		})
+	}
+}
+
+func TestCommonPassword(t *testing.T) {
+	assert.Equal(t, CommonPassword("password1!"), true)
+	assert.Equal(t, CommonPassword("Password1!"), true)
+	assert.Equal(t, CommonPassword("P@ssw0rd1"), true)
+	assert.Equal(t, CommonPassword("validPa$$w0rd"), false)
+	assert.Equal(t, CommonPassword(""), false)
+}
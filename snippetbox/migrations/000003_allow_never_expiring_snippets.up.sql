@@ -0,0 +1 @@
+ALTER TABLE snippets MODIFY expires DATETIME NULL;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE tokens DROP COLUMN email;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE tokens ADD COLUMN email VARCHAR(255) NULL;
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE snippets DROP INDEX snippets_uc_public_id;
+
+ALTER TABLE snippets DROP COLUMN public_id;
\ No newline at end of file
@@ -0,0 +1,10 @@
+ALTER TABLE snippets ADD COLUMN public_id CHAR(22) NULL;
+
+-- Give every existing snippet a random URL-safe slug: 16 random bytes encoded
+-- as unpadded base-64, with the URL-unsafe characters swapped out (the same
+-- format that the application generates).
+UPDATE snippets SET public_id = REPLACE(REPLACE(TRIM(TRAILING '=' FROM TO_BASE64(RANDOM_BYTES(16))), '+', '-'), '/', '_');
+
+ALTER TABLE snippets MODIFY public_id CHAR(22) NOT NULL;
+
+ALTER TABLE snippets ADD CONSTRAINT snippets_uc_public_id UNIQUE (public_id);
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE snippets ADD COLUMN language VARCHAR(20) NOT NULL DEFAULT '';
\ No newline at end of file
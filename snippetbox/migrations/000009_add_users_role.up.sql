@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN role VARCHAR(10) NOT NULL DEFAULT 'user';
\ No newline at end of file
@@ -0,0 +1,9 @@
+ALTER TABLE users ADD COLUMN totp_secret VARCHAR(64) NULL;
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    hash BINARY(32) NOT NULL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_recovery_codes_user_id ON recovery_codes(user_id);
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE snippets DROP COLUMN publish_at;
\ No newline at end of file
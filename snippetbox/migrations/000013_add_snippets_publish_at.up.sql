@@ -0,0 +1 @@
+ALTER TABLE snippets ADD COLUMN publish_at DATETIME NULL;
\ No newline at end of file
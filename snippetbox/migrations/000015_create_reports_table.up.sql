@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS reports (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    snippet_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    reason VARCHAR(20) NOT NULL,
+    note VARCHAR(500) NOT NULL DEFAULT '',
+    status VARCHAR(10) NOT NULL DEFAULT 'open',
+    created DATETIME NOT NULL,
+    CONSTRAINT reports_uc_snippet_user UNIQUE (snippet_id, user_id),
+    FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_reports_status ON reports(status);
\ No newline at end of file
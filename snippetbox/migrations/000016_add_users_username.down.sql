@@ -0,0 +1,3 @@
+ALTER TABLE users DROP INDEX users_uc_username;
+
+ALTER TABLE users DROP COLUMN username;
\ No newline at end of file
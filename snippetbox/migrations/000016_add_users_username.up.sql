@@ -0,0 +1,6 @@
+-- Usernames are optional, as users who signed up before they were added (or
+-- with an OAuth provider) don't have one. MySQL allows any number of NULLs in
+-- a unique index.
+ALTER TABLE users ADD COLUMN username VARCHAR(30) NULL;
+
+ALTER TABLE users ADD CONSTRAINT users_uc_username UNIQUE (username);
\ No newline at end of file
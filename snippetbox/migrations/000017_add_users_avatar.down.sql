@@ -0,0 +1 @@
+ALTER TABLE users DROP COLUMN avatar;
\ No newline at end of file
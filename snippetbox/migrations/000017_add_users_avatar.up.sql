@@ -0,0 +1,3 @@
+-- The file name of the user's avatar in the avatar directory, or an empty
+-- string if they haven't uploaded one.
+ALTER TABLE users ADD COLUMN avatar VARCHAR(255) NOT NULL DEFAULT '';
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE users DROP COLUMN unique_titles;
\ No newline at end of file
@@ -0,0 +1,4 @@
+-- Whether the user has asked for the titles of their snippets to be unique.
+-- It's off by default, as most users don't mind having two snippets with the
+-- same title.
+ALTER TABLE users ADD COLUMN unique_titles BOOLEAN NOT NULL DEFAULT FALSE;
\ No newline at end of file
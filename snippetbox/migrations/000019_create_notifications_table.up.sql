@@ -0,0 +1,15 @@
+-- Notifications tell a user about something which happened while they were
+-- away, like a comment on one of their snippets. The message is written when
+-- the notification is created, and read_at stays NULL until the user marks it
+-- as read.
+CREATE TABLE IF NOT EXISTS notifications (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    user_id INTEGER NOT NULL,
+    message VARCHAR(255) NOT NULL,
+    link VARCHAR(255) NOT NULL DEFAULT '',
+    created DATETIME NOT NULL,
+    read_at DATETIME NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_notifications_user_read ON notifications(user_id, read_at);
\ No newline at end of file
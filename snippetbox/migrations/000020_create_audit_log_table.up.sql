@@ -0,0 +1,15 @@
+-- The audit log is a record of security-relevant events on a user's account,
+-- like logging in or changing their password. There's deliberately no foreign
+-- key on user_id: the trail has to outlive the account, so deleting a user
+-- leaves their events in place.
+CREATE TABLE IF NOT EXISTS audit_log (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    user_id INTEGER NOT NULL,
+    action VARCHAR(32) NOT NULL,
+    detail VARCHAR(255) NOT NULL DEFAULT '',
+    ip VARCHAR(45) NOT NULL DEFAULT '',
+    user_agent VARCHAR(255) NOT NULL DEFAULT '',
+    created DATETIME NOT NULL
+);
+
+CREATE INDEX idx_audit_log_user_created ON audit_log(user_id, created);
\ No newline at end of file
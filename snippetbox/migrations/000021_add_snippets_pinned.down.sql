@@ -0,0 +1 @@
+ALTER TABLE snippets DROP COLUMN pinned;
\ No newline at end of file
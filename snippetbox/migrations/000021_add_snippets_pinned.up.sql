@@ -0,0 +1,3 @@
+-- Users can pin their most important snippets, so that they're listed before
+-- the rest on their account page and public profile.
+ALTER TABLE snippets ADD COLUMN pinned BOOLEAN NOT NULL DEFAULT FALSE;
\ No newline at end of file
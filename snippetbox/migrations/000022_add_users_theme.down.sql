@@ -0,0 +1 @@
+ALTER TABLE users DROP COLUMN theme;
\ No newline at end of file
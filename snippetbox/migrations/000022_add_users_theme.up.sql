@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN theme VARCHAR(6) NOT NULL DEFAULT 'system';
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS snippet_revisions;
\ No newline at end of file
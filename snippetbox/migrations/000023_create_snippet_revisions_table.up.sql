@@ -0,0 +1,12 @@
+-- Each row is an earlier version of a snippet's title and content, saved when
+-- the snippet was edited. The revisions go when the snippet itself is purged.
+CREATE TABLE IF NOT EXISTS snippet_revisions (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    snippet_id INTEGER NOT NULL,
+    title VARCHAR(100) NOT NULL,
+    content TEXT NOT NULL,
+    created DATETIME NOT NULL,
+    FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_snippet_revisions_snippet_id ON snippet_revisions(snippet_id);
\ No newline at end of file
@@ -0,0 +1,11 @@
+package migrations
+
+import (
+	"embed"
+)
+
+// Files holds the SQL migration files, so that the schema can be migrated by
+// the binary itself without needing a copy of this directory on the server.
+//
+//go:embed "*.sql"
+var Files embed.FS
@@ -5,4 +5,67 @@ for (var i = 0; i < navLinks.length; i++) {
 		link.classList.add("live");
 		break;
 	}
-}
\ No newline at end of file
+}
+
+// Copy buttons start off hidden, as they need JavaScript to work. When one is
+// clicked we fetch the snippet's content from the URL in its data-copy-url
+// attribute and put it on the clipboard.
+var copyButtons = document.querySelectorAll("button[data-copy-url]");
+for (var i = 0; i < copyButtons.length; i++) {
+	var button = copyButtons[i];
+	button.hidden = false;
+	button.addEventListener("click", function (event) {
+		var button = event.currentTarget;
+		fetch(button.dataset.copyUrl, {credentials: "same-origin"})
+			.then(function (response) {
+				if (!response.ok) {
+					throw new Error(response.statusText);
+				}
+				return response.json();
+			})
+			.then(function (data) {
+				return navigator.clipboard.writeText(data.content);
+			})
+			.then(function () {
+				button.textContent = "Copied!";
+			}, function () {
+				button.textContent = "Copy failed";
+			});
+	});
+}
+
+// Preview buttons send the form which they're in to the URL in their
+// data-preview-url attribute, and show the HTML which comes back in the <pre>
+// element next to them. The server escapes the content, so the HTML is safe to
+// insert as it is.
+var previewButtons = document.querySelectorAll("button[data-preview-url]");
+for (var i = 0; i < previewButtons.length; i++) {
+	var button = previewButtons[i];
+	button.hidden = false;
+	button.addEventListener("click", function (event) {
+		var button = event.currentTarget;
+		var pre = button.parentElement.querySelector("pre.preview");
+		fetch(button.dataset.previewUrl, {
+			method: "POST",
+			credentials: "same-origin",
+			headers: {"Accept": "application/json"},
+			body: new URLSearchParams(new FormData(button.form))
+		})
+			.then(function (response) {
+				return response.json().then(function (data) {
+					if (!response.ok) {
+						throw new Error(typeof data.error == "string" ? data.error : Object.values(data.error).join(" "));
+					}
+					return data;
+				});
+			})
+			.then(function (data) {
+				pre.firstElementChild.innerHTML = data.html;
+			}, function (err) {
+				pre.firstElementChild.textContent = "Preview failed: " + err.message;
+			})
+			.then(function () {
+				pre.hidden = false;
+			});
+	});
+}